@@ -49,6 +49,9 @@ var apiRetryOpts = []retry.Option{
 
 const defaultMerkleRoot = "0x0000000000000000000000000000000000000000000000000000000000000000"
 
+// Max size of the body of the requests, a proof is just a few hashes
+const maxRequestBodyBytes = 64 * 1024
+
 // 30 days/month * 24 hours/day * 3600 seconds/day / 12 seconds/slot
 var SlotsInOneMonth = uint64(216000)
 
@@ -71,9 +74,15 @@ const (
 	pathMemoryWrongFeeBlocks         = "/memory/wrongfeeblocks"
 	pathMemoryDonations              = "/memory/donations"
 	pathMemoryPoolStatistics         = "/memory/statistics"
+	pathMemoryRelayDiscrepancies     = "/memory/relaydiscrepancies"
+	pathMemoryValidatorTransitions   = "/memory/validatortransitions"
+	pathMemoryBlsCredits             = "/memory/blscredits"
+	pathMemoryBuilders               = "/memory/builders"
+	pathMemoryCheckpointProof        = "/memory/proof/{checkpointSlot}/{withdrawalAddress}"
 
 	// Onchain endpoints: what is submitted to the contract
 	pathOnchainMerkleProof = "/onchain/proof/{withdrawalAddress}"
+	pathOnchainVerifyProof = "/onchain/verifyproof"
 )
 
 type ApiService struct {
@@ -206,9 +215,15 @@ func (m *ApiService) getRouter() http.Handler {
 	r.HandleFunc(pathMemoryMissedBlocks, m.handleMemoryMissedBlocks).Methods(http.MethodGet)
 	r.HandleFunc(pathMemoryWrongFeeBlocks, m.handleMemoryWrongFeeBlocks).Methods(http.MethodGet)
 	r.HandleFunc(pathMemoryDonations, m.handleMemoryDonations).Methods(http.MethodGet)
+	r.HandleFunc(pathMemoryRelayDiscrepancies, m.handleMemoryRelayDiscrepancies).Methods(http.MethodGet)
+	r.HandleFunc(pathMemoryValidatorTransitions, m.handleMemoryValidatorTransitions).Methods(http.MethodGet)
+	r.HandleFunc(pathMemoryBlsCredits, m.handleMemoryBlsCredits).Methods(http.MethodGet)
+	r.HandleFunc(pathMemoryBuilders, m.handleMemoryBuilders).Methods(http.MethodGet)
+	r.HandleFunc(pathMemoryCheckpointProof, m.handleMemoryCheckpointProof).Methods(http.MethodGet)
 
 	// Onchain endpoints
 	r.HandleFunc(pathOnchainMerkleProof, m.handleOnchainMerkleProof).Methods(http.MethodGet)
+	r.HandleFunc(pathOnchainVerifyProof, m.handleOnchainVerifyProof).Methods(http.MethodPost)
 
 	// Not strictly necessary but good to have
 	r.Use(mux.CORSMethodMiddleware(r))
@@ -373,7 +388,7 @@ func (m *ApiService) handleMemoryStatistics(w http.ResponseWriter, req *http.Req
 		TotalBanned:                  totalBanned,
 		TotalNotSubscribed:           totalNotSubscribed,
 		LatestCheckpointSlot:         m.oracle.State().LatestProcessedSlot,
-		NextCheckpointSlot:           m.oracle.State().LatestProcessedSlot + m.cfg.CheckPointSizeInSlots,
+		NextCheckpointSlot:           m.oracle.State().LatestProcessedSlot + m.oracle.State().CheckPointSizeInSlots,
 		TotalAccumulatedRewardsWei:   totalAccumulatedRewards.String(),
 		TotalPendingRewaradsWei:      totalPendingRewards.String(),
 		TotalRewardsSentWei:          totalRewardsSentWei.String(),
@@ -450,9 +465,10 @@ func (m *ApiService) handleStatus(w http.ResponseWriter, req *http.Request) {
 
 	// If the oracle is not in sync, we cant really calculate the slots till the next checkpoint
 	// because we are behind. So we just set it to 0
+	checkPointSizeInSlots := m.oracle.State().CheckPointSizeInSlots
 	nextCheckpointInSlots := uint64(0)
-	if finalizedSlot < (onchainSlot + m.cfg.CheckPointSizeInSlots) {
-		nextCheckpointInSlots = onchainSlot + m.cfg.CheckPointSizeInSlots - finalizedSlot
+	if finalizedSlot < (onchainSlot + checkPointSizeInSlots) {
+		nextCheckpointInSlots = onchainSlot + checkPointSizeInSlots - finalizedSlot
 	}
 
 	status := httpOkStatus{
@@ -464,7 +480,7 @@ func (m *ApiService) handleStatus(w http.ResponseWriter, req *http.Request) {
 		LatestFinalizedEpoch:        finalizedSlot / 32,
 		LatestFinalizedSlot:         finalizedSlot,
 		OracleHeadDistance:          finalizedSlot - m.oracle.State().LatestProcessedSlot,
-		NextCheckpointSlot:          onchainSlot + m.cfg.CheckPointSizeInSlots,
+		NextCheckpointSlot:          onchainSlot + checkPointSizeInSlots,
 		NextCheckpointTime:          "", // TODO:
 		NextCheckpointRemaining:     utils.SlotsToTime(nextCheckpointInSlots, constants.SecondsInSlot),
 		NextCheckpointRemainingUnix: nextCheckpointInSlots * constants.SecondsInSlot,
@@ -485,15 +501,20 @@ func (m *ApiService) handleConfig(w http.ResponseWriter, req *http.Request) {
 		m.respondError(w, http.StatusInternalServerError, "no config loaded, nil value")
 		return
 	}
+	// Pool parameters can be updated onchain, so the ones in use are in the state
+	state := m.oracle.State()
 	m.respondOK(w, httpOkConfig{
 		Network:                  m.cfg.Network,
 		PoolAddress:              m.cfg.PoolAddress,
 		DeployedSlot:             m.cfg.DeployedSlot,
-		CheckPointSizeInSlots:    m.cfg.CheckPointSizeInSlots,
-		PoolFeesPercentOver10000: m.cfg.PoolFeesPercentOver10000,
-		PoolFeesAddress:          m.cfg.PoolFeesAddress,
+		CheckPointSizeInSlots:    state.CheckPointSizeInSlots,
+		PoolFeesPercentOver10000: state.PoolFeesPercentOver10000,
+		PoolFeesAddress:          state.PoolFeesAddress,
 		DryRun:                   m.cfg.DryRun,
-		CollateralInWei:          m.cfg.CollateralInWei.String(),
+		CollateralInWei:          state.CollateralInWei.String(),
+		RewardOverrides:          state.RewardOverrides,
+		WhitelistedBuilders:      state.WhitelistedBuilders,
+		BlsCreditPenalty:         m.oracle.BlsCreditPenaltyOver10000(),
 	})
 }
 
@@ -873,6 +894,114 @@ func (m *ApiService) handleMemoryWrongFeeBlocks(w http.ResponseWriter, req *http
 	m.respondOK(w, wrongFeeBlocks)
 }
 
+func (m *ApiService) handleMemoryRelayDiscrepancies(w http.ResponseWriter, req *http.Request) {
+	discrepancies := make([]httpOkRewardDiscrepancy, 0)
+	for _, discrepancy := range m.oracle.RewardDiscrepancies() {
+		discrepancies = append(discrepancies, httpOkRewardDiscrepancy{
+			Slot:               discrepancy.Slot,
+			ValidatorIndex:     discrepancy.ValidatorIndex,
+			Relay:              discrepancy.Relay,
+			Kind:               discrepancy.Kind,
+			RelayBlockHash:     discrepancy.RelayBlockHash,
+			RelayValueWei:      discrepancy.RelayValueWei.String(),
+			OracleRewardWei:    discrepancy.OracleRewardWei.String(),
+			RelayFeeRecipient:  discrepancy.RelayFeeRecipient,
+			OracleFeeRecipient: discrepancy.OracleFeeRecipient,
+		})
+	}
+	m.respondOK(w, discrepancies)
+}
+
+func (m *ApiService) handleMemoryValidatorTransitions(w http.ResponseWriter, req *http.Request) {
+	transitions := make([]httpOkValidatorTransition, 0)
+	for _, transition := range m.oracle.State().ValidatorTransitions {
+		transitions = append(transitions, httpOkValidatorTransition{
+			Slot:             transition.Slot,
+			Block:            transition.Block,
+			Kind:             string(transition.Kind),
+			SourceIndex:      transition.SourceIndex,
+			TargetIndex:      transition.TargetIndex,
+			MovedPendingWei:  bigIntStringOrZero(transition.MovedPendingWei),
+			RedistributedWei: bigIntStringOrZero(transition.RedistributedWei),
+		})
+	}
+	m.respondOK(w, transitions)
+}
+
+// Returns the rewards held for validators with bls keys, ordered by index
+func (m *ApiService) handleMemoryBlsCredits(w http.ResponseWriter, req *http.Request) {
+	credits := maps.Values(m.oracle.State().BlsCredits)
+	sort.Slice(credits, func(i, j int) bool {
+		return credits[i].ValidatorIndex < credits[j].ValidatorIndex
+	})
+
+	response := make([]httpOkBlsCredit, 0, len(credits))
+	for _, credit := range credits {
+		response = append(response, httpOkBlsCredit{
+			ValidatorIndex:    credit.ValidatorIndex,
+			ValidatorKey:      credit.ValidatorKey,
+			Slots:             credit.Slots,
+			HeldWei:           bigIntStringOrZero(credit.HeldWei),
+			Settled:           credit.SettledSlot != 0,
+			SettledSlot:       credit.SettledSlot,
+			WithdrawalAddress: credit.WithdrawalAddress,
+			CreditedWei:       bigIntStringOrZero(credit.CreditedWei),
+			PoolWei:           bigIntStringOrZero(credit.PoolWei),
+		})
+	}
+	m.respondOK(w, response)
+}
+
+// Amounts that are not set are zero
+func bigIntStringOrZero(amount *big.Int) string {
+	if amount == nil {
+		return "0"
+	}
+	return amount.String()
+}
+
+// Returns the builders that paid mev rewards to the pool, the ones that paid
+// the most first
+func (m *ApiService) handleMemoryBuilders(w http.ResponseWriter, req *http.Request) {
+	state := m.oracle.State()
+	builders := make(map[string]*httpOkBuilder)
+	totalRewards := make(map[string]*big.Int)
+
+	for _, block := range state.ProposedBlocks {
+		if block.RewardType != oracle.MevBlock || block.Builder == "" {
+			continue
+		}
+		builder, found := builders[block.Builder]
+		if !found {
+			builder = &httpOkBuilder{Address: block.Builder}
+			for _, whitelisted := range state.WhitelistedBuilders {
+				if strings.EqualFold(whitelisted.Address, block.Builder) {
+					builder.Whitelisted = true
+				}
+			}
+			builders[block.Builder] = builder
+			totalRewards[block.Builder] = big.NewInt(0)
+		}
+		builder.ProposedBlocks++
+		builder.LatestSlot = block.Slot
+		totalRewards[block.Builder].Add(totalRewards[block.Builder], block.Reward)
+	}
+
+	buildersList := make([]*httpOkBuilder, 0)
+	for address, builder := range builders {
+		builder.TotalRewardsWei = totalRewards[address].String()
+		buildersList = append(buildersList, builder)
+	}
+	sort.Slice(buildersList, func(i, j int) bool {
+		cmp := totalRewards[buildersList[i].Address].Cmp(totalRewards[buildersList[j].Address])
+		if cmp != 0 {
+			return cmp > 0
+		}
+		return buildersList[i].Address < buildersList[j].Address
+	})
+	m.respondOK(w, buildersList)
+}
+
 func (m *ApiService) handleMemoryDonations(w http.ResponseWriter, req *http.Request) {
 	donations := make([]httpOkDonation, 0)
 	for _, donation := range m.oracle.State().Donations {
@@ -886,6 +1015,33 @@ func (m *ApiService) handleMemoryDonations(w http.ResponseWriter, req *http.Requ
 	m.respondOK(w, donations)
 }
 
+// Returns the merkle proof of a withdrawal address at any checkpoint, even if it
+// was pruned. Unlike /onchain/proof, the claimed rewards are not included
+func (m *ApiService) handleMemoryCheckpointProof(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	checkpointSlotStr := vars["checkpointSlot"]
+	withdrawalAddress := vars["withdrawalAddress"]
+
+	checkpointSlot, err := strconv.ParseUint(checkpointSlotStr, 10, 64)
+	if err != nil {
+		m.respondError(w, http.StatusBadRequest, "invalid checkpoint slot: "+checkpointSlotStr)
+		return
+	}
+
+	if !IsValidAddress(withdrawalAddress) {
+		m.respondError(w, http.StatusBadRequest, "invalid WithdrawalAddress: "+withdrawalAddress)
+		return
+	}
+
+	proof, err := m.oracle.GetCheckpointProof(checkpointSlot, withdrawalAddress)
+	if err != nil {
+		m.respondError(w, http.StatusBadRequest, "could not get proof: "+err.Error())
+		return
+	}
+
+	m.respondOK(w, proof)
+}
+
 func (m *ApiService) handleOnchainMerkleProof(w http.ResponseWriter, req *http.Request) {
 	if !m.OracleReady(MaxSlotsBehind) {
 		m.respondError(w, http.StatusServiceUnavailable, "Oracle node is currently syncing and not serving requests")
@@ -923,13 +1079,6 @@ func (m *ApiService) handleOnchainMerkleProof(w http.ResponseWriter, req *http.R
 		return
 	}
 
-	// Get the proofs of this withdrawal address (to be used onchain to claim rewards)
-	proofs, proofFound := m.oracle.State().CommitedStates[contractSlot].Proofs[withdrawalAddress]
-	if !proofFound {
-		m.respondError(w, http.StatusBadRequest, "could not find proof for WithdrawalAddress: "+withdrawalAddress)
-		return
-	}
-
 	// Get the leafs of this withdrawal address (to be used onchain to claim rewards)
 	leafs, leafsFound := m.oracle.State().CommitedStates[contractSlot].Leafs[withdrawalAddress]
 	if !leafsFound {
@@ -937,6 +1086,13 @@ func (m *ApiService) handleOnchainMerkleProof(w http.ResponseWriter, req *http.R
 		return
 	}
 
+	// Get the proofs of this withdrawal address (to be used onchain to claim rewards)
+	proofs, err := m.oracle.GetMerkleProof(contractSlot, withdrawalAddress)
+	if err != nil {
+		m.respondError(w, http.StatusInternalServerError, "could not get proof for WithdrawalAddress: "+err.Error())
+		return
+	}
+
 	// Get validators that are registered to this withdrawal address in the pool
 	registeredValidators := make([]uint64, 0)
 	for valIndex, validator := range m.oracle.State().CommitedStates[contractSlot].Validators {
@@ -1063,6 +1219,40 @@ func IsValidIndex(v string) (uint64, bool) {
 	return val, true
 }
 
+// Verifies the merkle proof of a leaf sent in the body, against the given merkle root
+// or the one onchain if none, so that a claim can be checked before sending it
+func (m *ApiService) handleOnchainVerifyProof(w http.ResponseWriter, req *http.Request) {
+	var request httpVerifyProofRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRequestBodyBytes)).Decode(&request)
+	if err != nil {
+		m.respondError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if !IsValidAddress(request.LeafWithdrawalAddress) {
+		m.respondError(w, http.StatusBadRequest, "invalid WithdrawalAddress: "+request.LeafWithdrawalAddress)
+		return
+	}
+
+	merkleRoot, checkpointSlot := request.MerkleRoot, uint64(0)
+	if merkleRoot == "" {
+		merkleRoot, checkpointSlot, err = m.Onchain.GetOnchainSlotAndRoot(apiRetryOpts...)
+		if err != nil {
+			m.respondError(w, http.StatusInternalServerError, "could not get onchain slot and root: "+err.Error())
+			return
+		}
+	}
+
+	verification, err := oracle.VerifyLeafProof(merkleRoot, request.LeafWithdrawalAddress, request.LeafAccumulatedBalance, request.Proofs)
+	if err != nil {
+		m.respondError(w, http.StatusBadRequest, "could not verify proof: "+err.Error())
+		return
+	}
+	verification.CheckpointSlot = checkpointSlot
+
+	m.respondOK(w, verification)
+}
+
 func IsValidAddress(v string) bool {
 	re := regexp.MustCompile("^0x[0-9a-fA-F]{40}$")
 	return re.MatchString(v)
@@ -1184,7 +1374,7 @@ func (m *ApiService) ApplyNonFinalizedState(
 				valWithdrawalAddress := val.WithdrawalAddress
 				eventAddress := subInBlock.Event.Sender.String()
 				if AreAddressEqual(valWithdrawalAddress, eventAddress) {
-					if subInBlock.Event.SubscriptionCollateral.Cmp(m.cfg.CollateralInWei) >= 0 {
+					if subInBlock.Event.SubscriptionCollateral.Cmp(m.oracle.State().CollateralInWei) >= 0 {
 						if oracle.CanValidatorSubscribeToPool(subInBlock.Validator) {
 							if val.ValidatorStatus == oracle.Untracked || val.ValidatorStatus == oracle.NotSubscribed {
 								validators[valIndex].ValidatorStatus = oracle.Active
@@ -17,9 +17,10 @@ import (
 
 func Test_ApplyNonFinalizedState_Subscription(t *testing.T) {
 
-	api := NewApiService(&oracle.Config{
+	cfg := &oracle.Config{
 		CollateralInWei: big.NewInt(1000),
-	}, &config.CliConfig{ApiPort: 7300}, nil, nil)
+	}
+	api := NewApiService(cfg, &config.CliConfig{ApiPort: 7300}, oracle.NewOracle(cfg), nil)
 
 	type test struct {
 		Collateral          *big.Int
@@ -102,9 +103,10 @@ func Test_ApplyNonFinalizedState_Subscription(t *testing.T) {
 }
 
 func Test_ApplyNonFinalizedState_Unsubscribe(t *testing.T) {
-	api := NewApiService(&oracle.Config{
+	cfg := &oracle.Config{
 		CollateralInWei: big.NewInt(1000),
-	}, &config.CliConfig{ApiPort: 7300}, nil, nil)
+	}
+	api := NewApiService(cfg, &config.CliConfig{ApiPort: 7300}, oracle.NewOracle(cfg), nil)
 
 	type test struct {
 		OracleState         oracle.ValidatorStatus
@@ -159,9 +161,10 @@ func Test_ApplyNonFinalizedState_Unsubscribe(t *testing.T) {
 }
 
 func Test_ApplyNonFinalizedState_MultipleEvents(t *testing.T) {
-	api := NewApiService(&oracle.Config{
+	cfg := &oracle.Config{
 		CollateralInWei: big.NewInt(1000),
-	}, &config.CliConfig{ApiPort: 7300}, nil, nil)
+	}
+	api := NewApiService(cfg, &config.CliConfig{ApiPort: 7300}, oracle.NewOracle(cfg), nil)
 
 	validators := map[uint64]*oracle.ValidatorInfo{
 		1: {
@@ -3,6 +3,7 @@ package api
 import (
 	v1 "github.com/attestantio/go-eth2-client/api/v1"
 	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/dappnode/mev-sp-oracle/oracle"
 )
 
 type httpErrorResp struct {
@@ -94,15 +95,39 @@ type httpOkProofs struct {
 	PendingRewardsWei          string   `json:"pending_rewards_wei"`
 }
 
+// Same fields as the served proofs, so they can be sent back to be verified.
+// Without merkle root, the one onchain is used
+type httpVerifyProofRequest struct {
+	LeafWithdrawalAddress  string   `json:"leaf_withdrawal_address"`
+	LeafAccumulatedBalance string   `json:"leaf_accumulated_balance"`
+	MerkleRoot             string   `json:"merkleroot"`
+	Proofs                 []string `json:"merkle_proofs"`
+}
+
 type httpOkConfig struct {
-	Network                  string `json:"network"`
-	PoolAddress              string `json:"pool_address"`
-	DeployedSlot             uint64 `json:"deployed_slot"`
-	CheckPointSizeInSlots    uint64 `json:"checkpoint_size"`
-	PoolFeesPercentOver10000 int    `json:"pool_fees_percent"`
-	PoolFeesAddress          string `json:"pool_fees_address"`
-	DryRun                   bool   `json:"dry_run"`
-	CollateralInWei          string `json:"collateral_in_wei"`
+	Network                  string                       `json:"network"`
+	PoolAddress              string                       `json:"pool_address"`
+	DeployedSlot             uint64                       `json:"deployed_slot"`
+	CheckPointSizeInSlots    uint64                       `json:"checkpoint_size"`
+	PoolFeesPercentOver10000 int                          `json:"pool_fees_percent"`
+	PoolFeesAddress          string                       `json:"pool_fees_address"`
+	DryRun                   bool                         `json:"dry_run"`
+	CollateralInWei          string                       `json:"collateral_in_wei"`
+	RewardOverrides          []*oracle.RewardOverride     `json:"reward_overrides"`
+	WhitelistedBuilders      []*oracle.WhitelistedBuilder `json:"whitelisted_builders"`
+	BlsCreditPenalty         int                          `json:"bls_credit_penalty"`
+}
+
+type httpOkBlsCredit struct {
+	ValidatorIndex    uint64   `json:"validator_index"`
+	ValidatorKey      string   `json:"validator_key"`
+	Slots             []uint64 `json:"slots"`
+	HeldWei           string   `json:"held_wei"`
+	Settled           bool     `json:"settled"`
+	SettledSlot       uint64   `json:"settled_slot"`
+	WithdrawalAddress string   `json:"withdrawal_address"`
+	CreditedWei       string   `json:"credited_wei"`
+	PoolWei           string   `json:"pool_wei"`
 }
 
 type httpOkMemoryFeesInfo struct {
@@ -111,6 +136,36 @@ type httpOkMemoryFeesInfo struct {
 	PoolAccumulatedFees      string `json:"pool_accumulated_fees"`
 }
 
+type httpOkRewardDiscrepancy struct {
+	Slot               uint64 `json:"slot"`
+	ValidatorIndex     uint64 `json:"validator_index"`
+	Relay              string `json:"relay"`
+	Kind               string `json:"kind"`
+	RelayBlockHash     string `json:"relay_block_hash"`
+	RelayValueWei      string `json:"relay_value_wei"`
+	OracleRewardWei    string `json:"oracle_reward_wei"`
+	RelayFeeRecipient  string `json:"relay_fee_recipient"`
+	OracleFeeRecipient string `json:"oracle_fee_recipient"`
+}
+
+type httpOkValidatorTransition struct {
+	Slot             uint64  `json:"slot"`
+	Block            uint64  `json:"block"`
+	Kind             string  `json:"kind"`
+	SourceIndex      uint64  `json:"source_index"`
+	TargetIndex      *uint64 `json:"target_index"`
+	MovedPendingWei  string  `json:"moved_pending_wei"`
+	RedistributedWei string  `json:"redistributed_wei"`
+}
+
+type httpOkBuilder struct {
+	Address         string `json:"address"`
+	Whitelisted     bool   `json:"whitelisted"`
+	ProposedBlocks  uint64 `json:"proposed_blocks"`
+	TotalRewardsWei string `json:"total_rewards_wei"`
+	LatestSlot      uint64 `json:"latest_slot"`
+}
+
 type httpOkDonation struct {
 	AmountWei string `json:"amount_wei"`
 	Block     uint64 `json:"block_number"`
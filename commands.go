@@ -0,0 +1,476 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dappnode/mev-sp-oracle/config"
+	"github.com/dappnode/mev-sp-oracle/oracle"
+	"github.com/dappnode/mev-sp-oracle/utils"
+	"github.com/ethereum/go-ethereum/common"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const usage = `Usage: mev-sp-oracle <command> [flags]
+
+Commands:
+  run           Syncs the state with the chain and updates the contract roots (default)
+  replay        Runs the oracle over recorded blocks, without any endpoint
+  verify        Verifies the checkpoints of a state against the contract
+  export        Writes a persisted state as a json file
+  proof         Prints the merkle proof of a withdrawal address
+  verify-proof  Verifies a merkle proof as the contract does when claiming
+  state inspect Prints a summary of a persisted state
+  keystore      Decrypts the updater keystore and checks it can update the contract
+  version       Prints the release version
+
+Run 'mev-sp-oracle <command> --help' to see the flags of each command.
+`
+
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, usage)
+}
+
+// Exits without an error if the help of the command was requested, since the
+// flag set already printed it
+func exitOnConfigError(command string, err error) {
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	log.Fatal("error parsing the ", command, " config: ", err)
+}
+
+func setLogLevel(level string) {
+	logLevel, err := log.ParseLevel(level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetLevel(logLevel)
+}
+
+// Prints the value as indented json to stdout, so that it can be piped
+// while logs still go to stderr
+func printJson(value interface{}) {
+	jsonData, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		log.Fatal("Could not marshal output: ", err)
+	}
+	fmt.Println(string(jsonData))
+}
+
+// Loads the state either from a json file or from the store the run command
+// persists it in. The store is opened without removing any snapshot.
+func loadState(source *config.StateSource) *oracle.OracleState {
+	if source.StateFile != "" {
+		state, err := oracle.LoadStateFile(source.StateFile)
+		if err != nil {
+			log.Fatal("Could not load state file: ", err)
+		}
+		return state
+	}
+
+	if _, err := os.Stat(source.StateFolder); err != nil {
+		log.Fatal("Could not find state folder: ", err)
+	}
+	stateStore, err := oracle.NewStateStore(source.StateBackend, source.StateFolder, oracle.SnapshotRetention{})
+	if err != nil {
+		log.Fatal("Could not open state store, note that the bolt backend can't be read while the oracle is running: ", err)
+	}
+	defer stateStore.Close()
+
+	var state *oracle.OracleState
+	var found bool
+	if source.Snapshot != 0 {
+		state, found, err = stateStore.LoadCheckpoint(source.Snapshot)
+	} else {
+		state, found, err = stateStore.Load()
+	}
+	if err != nil {
+		log.Fatal("Could not load state: ", err)
+	}
+	if !found {
+		log.Fatal("No state found in ", source.StateFolder, " with the ", source.StateBackend, " backend")
+	}
+	return state
+}
+
+// Runs the oracle over a folder or archive of recorded blocks, starting from
+// the given state or from the deployment of the pool with the recorded config,
+// without any consensus or execution endpoint. The resulting state and its
+// checkpoints are stored as json in the output folder.
+func runReplay(args []string) {
+	setLogFormatter()
+
+	replayCfg, err := config.NewReplayConfig(args)
+	if err != nil {
+		exitOnConfigError("replay", err)
+	}
+
+	setLogLevel(replayCfg.LogLevel)
+
+	source, err := oracle.NewBlockSource(replayCfg.BlocksPath)
+	if err != nil {
+		log.Fatal("Could not open recorded blocks: ", err)
+	}
+	defer source.Close()
+
+	slots := source.Slots()
+	if len(slots) == 0 {
+		log.Fatal("No recorded blocks found in ", replayCfg.BlocksPath)
+	}
+	toSlot := replayCfg.ToSlot
+	if toSlot == 0 {
+		toSlot = slots[len(slots)-1]
+	}
+
+	var oracleInstance *oracle.Oracle
+	if replayCfg.StatePath != "" {
+		oracleInstance = loadOracleFromStateFile(replayCfg.StatePath)
+	} else {
+		cfg, found, err := source.Config()
+		if err != nil {
+			log.Fatal("Could not read recorded config: ", err)
+		}
+		if !found {
+			log.Fatal("No state given and no oracle_config.json recorded in ", replayCfg.BlocksPath, " to start from the deployment")
+		}
+		log.Info("No state given, replaying from the deployment of the pool at slot ", cfg.DeployedSlot)
+		oracleInstance = oracle.NewOracle(cfg)
+	}
+	oracleInstance.SetGetSetOfValidatorsFunc(oracle.RecordedValidatorsFunc(source))
+	oracleInstance.SetStateStore(oracle.NewJsonStateStore(replayCfg.OutputFolder))
+
+	log.WithFields(log.Fields{
+		"FromSlot":       oracleInstance.State().NextSlotToProcess,
+		"ToSlot":         toSlot,
+		"RecordedBlocks": len(slots),
+	}).Info("Replaying recorded blocks")
+
+	err = oracleInstance.Replay(source, toSlot, replayCfg.SkipGaps, func(commited *oracle.OnchainState) error {
+		// As the running oracle, that always keeps the latest one in full
+		oracleInstance.PruneCommitedStates(replayCfg.KeepCommitedStates, commited.Slot)
+		return oracleInstance.SaveState(true)
+	})
+	if err != nil {
+		// Keep what was replayed so far, useful to inspect where it stopped
+		saved, saveErr := oracleInstance.TrySaveState()
+		if saveErr != nil {
+			log.Error("Could not save the replayed state: ", saveErr)
+		} else if saved {
+			log.Info("Replayed state saved until slot ", oracleInstance.State().LatestProcessedSlot)
+		}
+		log.Fatal("Replay stopped: ", err)
+	}
+
+	err = oracleInstance.SaveState(false)
+	if err != nil {
+		log.Fatal("Could not save the replayed state: ", err)
+	}
+
+	latestRoot := ""
+	if latestCommited := oracleInstance.LatestCommitedState(); latestCommited != nil {
+		latestRoot = latestCommited.MerkleRoot
+	}
+	log.WithFields(log.Fields{
+		"LatestProcessedSlot": oracleInstance.State().LatestProcessedSlot,
+		"LatestMerkleRoot":    latestRoot,
+		"OutputFolder":        replayCfg.OutputFolder,
+	}).Info("Replay finished")
+}
+
+// Returns an oracle with the state loaded from the given file, configured as
+// the oracle that created it
+func loadOracleFromStateFile(path string) *oracle.Oracle {
+	state, err := oracle.LoadStateFile(path)
+	if err != nil {
+		log.Fatal("Could not load state file: ", err)
+	}
+
+	oracleInstance := oracle.NewOracle(oracle.ConfigFromState(state))
+	_, err = oracleInstance.LoadFromState(state)
+	if err != nil {
+		log.Fatal("Critical error loading state: ", err)
+	}
+	return oracleInstance
+}
+
+// Verifies every checkpoint of a state against the pool contract: recomputes
+// its merkle root, and compares it with the roots the oracle members submitted
+// and the one that was consolidated. Exits with an error if they diverge.
+func runVerify(args []string) {
+	setLogFormatter()
+
+	verifyCfg, err := config.NewVerifyConfig(args)
+	if err != nil {
+		exitOnConfigError("verify", err)
+	}
+
+	setLogLevel(verifyCfg.LogLevel)
+
+	oracleInstance := loadOracleFromStateFile(verifyCfg.StatePath)
+
+	// Checkpoints of the recorded blocks are verified as well
+	if verifyCfg.BlocksPath != "" {
+		source, err := oracle.NewBlockSource(verifyCfg.BlocksPath)
+		if err != nil {
+			log.Fatal("Could not open recorded blocks: ", err)
+		}
+		defer source.Close()
+
+		slots := source.Slots()
+		if len(slots) == 0 {
+			log.Fatal("No recorded blocks found in ", verifyCfg.BlocksPath)
+		}
+		oracleInstance.SetGetSetOfValidatorsFunc(oracle.RecordedValidatorsFunc(source))
+		err = oracleInstance.Replay(source, slots[len(slots)-1], false, nil)
+		if err != nil {
+			log.Fatal("Could not replay recorded blocks: ", err)
+		}
+	}
+	state := oracleInstance.State()
+
+	onchain, err := oracle.NewExecutionOnchain(verifyCfg.ExecutionEndpoint, state.PoolAddress, verifyCfg.NumRetries)
+	if err != nil {
+		log.Fatal("Could not create new onchain object: ", err)
+	}
+
+	fromBlock := verifyCfg.FromBlock
+	if fromBlock == 0 {
+		fromBlock = state.DeployedBlock
+	}
+	toBlock := verifyCfg.ToBlock
+	if toBlock == 0 {
+		toBlock, err = onchain.GetFinalizedBlockNumber()
+		if err != nil {
+			log.Fatal("Could not get finalized block: ", err)
+		}
+	}
+
+	log.Info("Fetching reports from block ", fromBlock, " to ", toBlock)
+	submitted, consolidated, err := onchain.GetReportEvents(fromBlock, toBlock, verifyCfg.EventsRangeSize)
+	if err != nil {
+		log.Fatal("Could not get report events: ", err)
+	}
+
+	verifications := oracle.VerifyCheckpoints(state, submitted, consolidated)
+	for _, verification := range verifications {
+		matchingVotes := 0
+		for _, vote := range verification.Votes {
+			if vote.Matches {
+				matchingVotes++
+			}
+		}
+		fields := log.Fields{
+			"Slot":             verification.Slot,
+			"Status":           verification.Status,
+			"StoredRoot":       verification.StoredRoot,
+			"RecomputedRoot":   verification.RecomputedRoot,
+			"ConsolidatedRoot": verification.ConsolidatedRoot,
+			"MatchingVotes":    fmt.Sprintf("%d/%d", matchingVotes, len(verification.Votes)),
+		}
+		if verification.Diverges() {
+			log.WithFields(fields).Warn("Checkpoint verified")
+		} else {
+			log.WithFields(fields).Info("Checkpoint verified")
+		}
+
+		for _, vote := range verification.Votes {
+			log.WithFields(log.Fields{
+				"Slot":         verification.Slot,
+				"OracleMember": vote.OracleMember,
+				"MerkleRoot":   vote.MerkleRoot,
+				"Matches":      vote.Matches,
+				"Block":        vote.Block,
+				"TxHash":       vote.TxHash,
+			}).Debug("Oracle member vote")
+		}
+	}
+
+	onchainRoot, onchainSlot, err := onchain.GetOnchainSlotAndRoot()
+	if err != nil {
+		log.Fatal("Could not get onchain slot and root: ", err)
+	}
+	_, err = oracleInstance.IsOracleInSyncWithChain(onchainRoot, onchainSlot)
+	if err != nil {
+		log.Error("Latest checkpoint does not match the contract: ", err)
+	}
+
+	divergence, diverges := oracle.FirstDivergence(verifications)
+	if diverges {
+		log.WithFields(log.Fields{
+			"Slot":   divergence.Slot,
+			"Status": divergence.Status,
+		}).Error("State diverges from the contract starting at this checkpoint")
+		os.Exit(1)
+	}
+	log.Info("Verified ", len(verifications), " checkpoints, no divergence found")
+}
+
+// Writes the persisted state, or one of its snapshots, as a json file that
+// can be used by the offline commands or served as a checkpoint sync state
+func runExport(args []string) {
+	setLogFormatter()
+
+	exportCfg, err := config.NewExportConfig(args)
+	if err != nil {
+		exitOnConfigError("export", err)
+	}
+	setLogLevel(exportCfg.LogLevel)
+
+	state := loadState(exportCfg.Source)
+	err = oracle.WriteStateFile(state, exportCfg.OutputFile)
+	if err != nil {
+		log.Fatal("Could not export state: ", err)
+	}
+	log.WithFields(log.Fields{
+		"LatestProcessedSlot": state.LatestProcessedSlot,
+		"OutputFile":          exportCfg.OutputFile,
+	}).Info("State exported")
+}
+
+// Prints the merkle proof of a withdrawal address at a checkpoint of the state,
+// to claim the rewards without relying on the api
+func runProof(args []string) {
+	setLogFormatter()
+
+	proofCfg, err := config.NewProofConfig(args)
+	if err != nil {
+		exitOnConfigError("proof", err)
+	}
+	setLogLevel(proofCfg.LogLevel)
+
+	state := loadState(proofCfg.Source)
+	proof, err := oracle.GetCheckpointProof(state, proofCfg.Checkpoint, proofCfg.WithdrawalAddress)
+	if err != nil {
+		log.Fatal("Could not get proof: ", err)
+	}
+	printJson(proof)
+}
+
+// Verifies a merkle proof against the given root, the one onchain or the one of
+// the proof file, in this order. Exits with an error if it's not valid
+func runVerifyProof(args []string) {
+	setLogFormatter()
+
+	verifyProofCfg, err := config.NewVerifyProofConfig(args)
+	if err != nil {
+		exitOnConfigError("verify-proof", err)
+	}
+	setLogLevel(verifyProofCfg.LogLevel)
+
+	proof := &oracle.CheckpointProof{
+		LeafWithdrawalAddress:  verifyProofCfg.WithdrawalAddress,
+		LeafAccumulatedBalance: verifyProofCfg.AccumulatedBalance,
+		Proofs:                 verifyProofCfg.Proofs,
+	}
+	if verifyProofCfg.ProofFile != "" {
+		rawBytes, err := os.ReadFile(verifyProofCfg.ProofFile)
+		if err != nil {
+			log.Fatal("Could not read proof file: ", err)
+		}
+		if err := json.Unmarshal(rawBytes, proof); err != nil {
+			log.Fatal("Could not unmarshal proof file: ", err)
+		}
+	}
+
+	merkleRoot, checkpointSlot := proof.MerkleRoot, proof.CheckpointSlot
+	if verifyProofCfg.MerkleRoot != "" {
+		merkleRoot, checkpointSlot = verifyProofCfg.MerkleRoot, 0
+	}
+	if verifyProofCfg.ExecutionEndpoint != "" {
+		onchain, err := oracle.NewExecutionOnchain(verifyProofCfg.ExecutionEndpoint, verifyProofCfg.PoolAddress, verifyProofCfg.NumRetries)
+		if err != nil {
+			log.Fatal("Could not create new onchain object: ", err)
+		}
+		merkleRoot, checkpointSlot, err = onchain.GetOnchainSlotAndRoot()
+		if err != nil {
+			log.Fatal("Could not get onchain slot and root: ", err)
+		}
+	}
+
+	verification, err := oracle.VerifyLeafProof(merkleRoot, proof.LeafWithdrawalAddress, proof.LeafAccumulatedBalance, proof.Proofs)
+	if err != nil {
+		log.Fatal("Could not verify proof: ", err)
+	}
+	verification.CheckpointSlot = checkpointSlot
+	printJson(verification)
+
+	if !verification.Valid {
+		log.Fatal("Proof is not valid for merkle root ", merkleRoot)
+	}
+}
+
+// Subcommands that operate on a persisted state
+func runState(args []string) {
+	if len(args) == 0 || args[0] != "inspect" {
+		fmt.Fprintln(os.Stderr, "Usage: mev-sp-oracle state inspect [flags]")
+		os.Exit(2)
+	}
+	runStateInspect(args[1:])
+}
+
+func runStateInspect(args []string) {
+	setLogFormatter()
+
+	inspectCfg, err := config.NewInspectConfig(args)
+	if err != nil {
+		exitOnConfigError("state inspect", err)
+	}
+	setLogLevel(inspectCfg.LogLevel)
+
+	printJson(oracle.SummarizeState(loadState(inspectCfg.Source)))
+}
+
+// Decrypts the updater keystore and prints its address. With an execution
+// endpoint, also checks that the address is whitelisted and has balance.
+func runKeystore(args []string) {
+	setLogFormatter()
+
+	keystoreCfg, err := config.NewKeystoreConfig(args)
+	if err != nil {
+		exitOnConfigError("keystore", err)
+	}
+	setLogLevel(keystoreCfg.LogLevel)
+
+	keystore, err := utils.DecryptKey(keystoreCfg.UpdaterKeyFile, keystoreCfg.UpdaterKeyPass)
+	if err != nil {
+		log.Fatal("Could not decrypt updater key: ", err)
+	}
+	updaterAddress := keystore.Address
+	log.Info("Updater keystore decrypted, address: ", updaterAddress.String())
+
+	if keystoreCfg.ExecutionEndpoint == "" {
+		return
+	}
+
+	onchain, err := oracle.NewExecutionOnchain(keystoreCfg.ExecutionEndpoint, keystoreCfg.PoolAddress, keystoreCfg.NumRetries)
+	if err != nil {
+		log.Fatal("Could not create new onchain object: ", err)
+	}
+	checkUpdaterAddress(onchain, updaterAddress)
+}
+
+func checkUpdaterAddress(onchain *oracle.Onchain, updaterAddress common.Address) {
+	isWhitelisted, err := onchain.IsAddressWhitelisted(updaterAddress)
+	if err != nil {
+		log.Fatal("Could not get whitelist status: " + err.Error())
+	}
+	if !isWhitelisted {
+		log.Fatal("Address ", updaterAddress.String(), " is not whitelisted to update the contract")
+	}
+	log.Info("Ok ", updaterAddress.String(), " is whitelisted")
+
+	balance, err := onchain.GetAddressEthBalance(updaterAddress)
+	if err != nil {
+		log.Fatal("Could not get updater address balance: ", err)
+	}
+	if balance.Sign() == 0 {
+		log.Fatal("Updater address: ", updaterAddress.String(), " has no balance, please send some Eth to it")
+	}
+	log.Info("Updater address: ", updaterAddress.String(), " has balance: ", utils.WeiToEther(balance), "Eth, ensure its enough to cover txs during some time")
+}
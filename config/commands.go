@@ -0,0 +1,470 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// Default folder and backend where the run command persists the state
+const (
+	DefaultStateFolder  = "oracle-data"
+	DefaultStateBackend = "bolt"
+)
+
+// Where the commands that don't run the oracle read the state from: either a
+// state json file, or the store the run command persists it in. Snapshot
+// selects the copy of the state kept at that checkpoint slot, 0 the latest.
+type StateSource struct {
+	StateFile    string
+	StateFolder  string
+	StateBackend string
+	Snapshot     uint64
+}
+
+func addStateSourceFlags(flags *flag.FlagSet) *StateSource {
+	source := &StateSource{}
+	flags.StringVar(&source.StateFile, "state", "", "State json file to read, instead of the persisted state")
+	flags.StringVar(&source.StateFolder, "state-folder", DefaultStateFolder, "Folder where the state is persisted")
+	flags.StringVar(&source.StateBackend, "state-backend", DefaultStateBackend, "Backend the state is persisted with (bolt=default, json)")
+	flags.Uint64Var(&source.Snapshot, "snapshot", 0, "Read the snapshot of the persisted state at this checkpoint slot: 0 reads the latest state")
+	return source
+}
+
+func (s *StateSource) validate() error {
+	if s.StateBackend != "bolt" && s.StateBackend != "json" {
+		return errors.New("state-backend: " + s.StateBackend + " is not supported, use bolt or json")
+	}
+	if s.StateFile != "" && s.Snapshot != 0 {
+		return errors.New("snapshot can't be used together with a state file")
+	}
+	return nil
+}
+
+func (s *StateSource) logFields(fields log.Fields) log.Fields {
+	fields["StateFile"] = s.StateFile
+	fields["StateFolder"] = s.StateFolder
+	fields["StateBackend"] = s.StateBackend
+	fields["Snapshot"] = s.Snapshot
+	return fields
+}
+
+// Config of the replay command, that runs the oracle over recorded blocks
+// without any consensus or execution endpoint
+type ReplayConfig struct {
+	BlocksPath         string
+	StatePath          string
+	ToSlot             uint64
+	OutputFolder       string
+	SkipGaps           bool
+	KeepCommitedStates int
+	LogLevel           string
+}
+
+func NewReplayConfig(args []string) (*ReplayConfig, error) {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+
+	// Optional flags:
+	var toSlot = flags.Uint64("to-slot", 0, "Last slot to replay: 0 replays until the last recorded slot")
+	var outputFolder = flags.String("output-folder", "replay-data", "Folder where the replayed state and its checkpoints are stored as json")
+	var skipGaps = flags.Bool("skip-gaps", false, "Jump over slots that were not recorded instead of stopping. The state will differ from the chain")
+	var statePath = flags.String("state", "", "State json file to start replaying from, its next slot is the first one replayed. If empty, starts from the deployment of the pool with the recorded config")
+	var keepCommitedStates = flags.Int("keep-commited-states", 30, "Number of latest commited states kept in full, as the running oracle does: 0 keeps all")
+	var logLevel = flags.String("log-level", "info", "Logging verbosity (trace, debug, info=default, warn, error, fatal, panic)")
+
+	// Mandatory flags:
+	var blocksPath = flags.String("blocks", "", "Folder or .zip archive with the recorded fullblock_slot_<slot>*.json files")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *blocksPath == "" {
+		return nil, errors.New("blocks is a mandatory flag and cant be empty")
+	}
+
+	if *keepCommitedStates < 0 {
+		return nil, errors.New("keep-commited-states can't be negative")
+	}
+
+	if *outputFolder == "" {
+		return nil, errors.New("output-folder cant be empty")
+	}
+
+	replayConf := &ReplayConfig{
+		BlocksPath:         *blocksPath,
+		StatePath:          *statePath,
+		ToSlot:             *toSlot,
+		OutputFolder:       *outputFolder,
+		SkipGaps:           *skipGaps,
+		KeepCommitedStates: *keepCommitedStates,
+		LogLevel:           *logLevel,
+	}
+	log.WithFields(log.Fields{
+		"BlocksPath":         replayConf.BlocksPath,
+		"StatePath":          replayConf.StatePath,
+		"ToSlot":             replayConf.ToSlot,
+		"OutputFolder":       replayConf.OutputFolder,
+		"SkipGaps":           replayConf.SkipGaps,
+		"KeepCommitedStates": replayConf.KeepCommitedStates,
+		"LogLevel":           replayConf.LogLevel,
+	}).Info("Replay Config:")
+	return replayConf, nil
+}
+
+// Config of the verify command, that checks the checkpoints of a state against
+// the roots submitted and consolidated in the pool contract
+type VerifyConfig struct {
+	StatePath         string
+	BlocksPath        string
+	ExecutionEndpoint string
+	FromBlock         uint64
+	ToBlock           uint64
+	EventsRangeSize   uint64
+	NumRetries        int
+	LogLevel          string
+}
+
+func NewVerifyConfig(args []string) (*VerifyConfig, error) {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+
+	// Optional flags:
+	var blocksPath = flags.String("blocks", "", "Folder or .zip archive with recorded blocks, replayed on top of the state before verifying")
+	var fromBlock = flags.Uint64("from-block", 0, "First block to fetch reports from: 0 uses the block the pool was deployed at")
+	var toBlock = flags.Uint64("to-block", 0, "Last block to fetch reports from: 0 uses the latest finalized block")
+	var eventsRangeSize = flags.Uint64("events-range-size", 10000, "Number of blocks whose reports are fetched in a single call")
+	var numRetries = flags.Int("num-retries", 0, "Number of retries for each interaction with the execution client: 0 infinite")
+	var logLevel = flags.String("log-level", "info", "Logging verbosity (trace, debug, info=default, warn, error, fatal, panic)")
+
+	// Mandatory flags:
+	var statePath = flags.String("state", "", "State json file whose checkpoints are verified")
+	var executionEndpoint = flags.String("execution-endpoint", "", "Ethereum execution endpoint")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *statePath == "" {
+		return nil, errors.New("state is a mandatory flag and cant be empty")
+	}
+
+	if *executionEndpoint == "" {
+		return nil, errors.New("execution-endpoint is a mandatory flag and cant be empty")
+	}
+
+	if *eventsRangeSize == 0 {
+		return nil, errors.New("events-range-size must be greater than 0")
+	}
+
+	if *toBlock != 0 && *toBlock < *fromBlock {
+		return nil, errors.New("to-block can't be lower than from-block")
+	}
+
+	verifyConf := &VerifyConfig{
+		StatePath:         *statePath,
+		BlocksPath:        *blocksPath,
+		ExecutionEndpoint: *executionEndpoint,
+		FromBlock:         *fromBlock,
+		ToBlock:           *toBlock,
+		EventsRangeSize:   *eventsRangeSize,
+		NumRetries:        *numRetries,
+		LogLevel:          *logLevel,
+	}
+	log.WithFields(log.Fields{
+		"StatePath":         verifyConf.StatePath,
+		"BlocksPath":        verifyConf.BlocksPath,
+		"ExecutionEndpoint": verifyConf.ExecutionEndpoint,
+		"FromBlock":         verifyConf.FromBlock,
+		"ToBlock":           verifyConf.ToBlock,
+		"EventsRangeSize":   verifyConf.EventsRangeSize,
+		"NumRetries":        verifyConf.NumRetries,
+		"LogLevel":          verifyConf.LogLevel,
+	}).Info("Verify Config:")
+	return verifyConf, nil
+}
+
+// Config of the export command, that writes a persisted state as a json file
+type ExportConfig struct {
+	Source     *StateSource
+	OutputFile string
+	LogLevel   string
+}
+
+func NewExportConfig(args []string) (*ExportConfig, error) {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	source := addStateSourceFlags(flags)
+
+	// Optional flags:
+	var logLevel = flags.String("log-level", "info", "Logging verbosity (trace, debug, info=default, warn, error, fatal, panic)")
+
+	// Mandatory flags:
+	var outputFile = flags.String("output", "", "Path of the json file the state is exported to")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = source.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if *outputFile == "" {
+		return nil, errors.New("output is a mandatory flag and cant be empty")
+	}
+
+	exportConf := &ExportConfig{
+		Source:     source,
+		OutputFile: *outputFile,
+		LogLevel:   *logLevel,
+	}
+	log.WithFields(source.logFields(log.Fields{
+		"OutputFile": exportConf.OutputFile,
+		"LogLevel":   exportConf.LogLevel,
+	})).Info("Export Config:")
+	return exportConf, nil
+}
+
+// Config of the proof command, that prints the merkle proof of a withdrawal
+// address at a checkpoint of the state
+type ProofConfig struct {
+	Source            *StateSource
+	WithdrawalAddress string
+	Checkpoint        uint64
+	LogLevel          string
+}
+
+func NewProofConfig(args []string) (*ProofConfig, error) {
+	flags := flag.NewFlagSet("proof", flag.ContinueOnError)
+	source := addStateSourceFlags(flags)
+
+	// Optional flags:
+	var checkpoint = flags.Uint64("checkpoint", 0, "Slot of the checkpoint to get the proof from: 0 uses the latest one")
+	var logLevel = flags.String("log-level", "warn", "Logging verbosity (trace, debug, info, warn=default, error, fatal, panic)")
+
+	// Mandatory flags:
+	var withdrawalAddress = flags.String("withdrawal-address", "", "Withdrawal address to get the proof of")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = source.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if !common.IsHexAddress(*withdrawalAddress) {
+		return nil, errors.New("withdrawal-address: " + *withdrawalAddress + " is not a valid address")
+	}
+
+	return &ProofConfig{
+		Source:            source,
+		WithdrawalAddress: *withdrawalAddress,
+		Checkpoint:        *checkpoint,
+		LogLevel:          *logLevel,
+	}, nil
+}
+
+// Config of the verify-proof command, that verifies the merkle proof of a leaf
+// either from a proof file or given with flags, against the given merkle root,
+// the one onchain or the one of the proof file
+type VerifyProofConfig struct {
+	ProofFile          string
+	WithdrawalAddress  string
+	AccumulatedBalance string
+	Proofs             []string
+	MerkleRoot         string
+	ExecutionEndpoint  string
+	PoolAddress        string
+	NumRetries         int
+	LogLevel           string
+}
+
+func NewVerifyProofConfig(args []string) (*VerifyProofConfig, error) {
+	flags := flag.NewFlagSet("verify-proof", flag.ContinueOnError)
+
+	// Optional flags:
+	var proofFile = flags.String("proof-file", "", "Json file with the proof, as printed by the proof command or served by the api")
+	var withdrawalAddress = flags.String("withdrawal-address", "", "Withdrawal address of the leaf, instead of a proof file")
+	var accumulatedBalance = flags.String("accumulated-balance", "", "Accumulated balance in wei of the leaf, instead of a proof file")
+	var proofsStr = flags.String("merkle-proofs", "", "Comma-separated list of the merkle proofs, instead of a proof file")
+	var merkleRoot = flags.String("merkle-root", "", "Merkle root to verify the proof against")
+	var executionEndpoint = flags.String("execution-endpoint", "", "Ethereum execution endpoint, to verify the proof against the root onchain")
+	var poolAddress = flags.String("pool-address", "", "Address of the smoothing pool contract, mandatory with execution-endpoint")
+	var numRetries = flags.Int("num-retries", 3, "Number of retries for each interaction with the execution client: 0 infinite")
+	var logLevel = flags.String("log-level", "warn", "Logging verbosity (trace, debug, info, warn=default, error, fatal, panic)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *proofFile != "" && (*withdrawalAddress != "" || *accumulatedBalance != "" || *proofsStr != "") {
+		return nil, errors.New("proof-file can't be used together with withdrawal-address, accumulated-balance or merkle-proofs")
+	}
+
+	if *proofFile == "" {
+		if !common.IsHexAddress(*withdrawalAddress) {
+			return nil, errors.New("withdrawal-address: " + *withdrawalAddress + " is not a valid address")
+		}
+		if *accumulatedBalance == "" {
+			return nil, errors.New("accumulated-balance is mandatory without proof-file")
+		}
+		if *merkleRoot == "" && *executionEndpoint == "" {
+			return nil, errors.New("merkle-root or execution-endpoint is mandatory without proof-file")
+		}
+	}
+
+	if *merkleRoot != "" && *executionEndpoint != "" {
+		return nil, errors.New("merkle-root and execution-endpoint can't be used together")
+	}
+
+	if *executionEndpoint != "" && !common.IsHexAddress(*poolAddress) {
+		return nil, errors.New("pool-address: " + *poolAddress + " is not a valid address")
+	}
+
+	proofs := make([]string, 0)
+	if *proofsStr != "" {
+		proofs = strings.Split(*proofsStr, ",")
+	}
+
+	verifyProofConf := &VerifyProofConfig{
+		ProofFile:          *proofFile,
+		WithdrawalAddress:  *withdrawalAddress,
+		AccumulatedBalance: *accumulatedBalance,
+		Proofs:             proofs,
+		MerkleRoot:         *merkleRoot,
+		ExecutionEndpoint:  *executionEndpoint,
+		PoolAddress:        *poolAddress,
+		NumRetries:         *numRetries,
+		LogLevel:           *logLevel,
+	}
+	log.WithFields(log.Fields{
+		"ProofFile":          verifyProofConf.ProofFile,
+		"WithdrawalAddress":  verifyProofConf.WithdrawalAddress,
+		"AccumulatedBalance": verifyProofConf.AccumulatedBalance,
+		"MerkleProofs":       verifyProofConf.Proofs,
+		"MerkleRoot":         verifyProofConf.MerkleRoot,
+		"ExecutionEndpoint":  verifyProofConf.ExecutionEndpoint,
+		"PoolAddress":        verifyProofConf.PoolAddress,
+		"NumRetries":         verifyProofConf.NumRetries,
+		"LogLevel":           verifyProofConf.LogLevel,
+	}).Info("Verify Proof Config:")
+	return verifyProofConf, nil
+}
+
+// Config of the state inspect command, that prints a summary of the state
+type InspectConfig struct {
+	Source   *StateSource
+	LogLevel string
+}
+
+func NewInspectConfig(args []string) (*InspectConfig, error) {
+	flags := flag.NewFlagSet("state inspect", flag.ContinueOnError)
+	source := addStateSourceFlags(flags)
+
+	// Optional flags:
+	var logLevel = flags.String("log-level", "warn", "Logging verbosity (trace, debug, info, warn=default, error, fatal, panic)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = source.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &InspectConfig{
+		Source:   source,
+		LogLevel: *logLevel,
+	}, nil
+}
+
+// Config of the keystore command, that decrypts the updater keystore and
+// optionally checks that it can update the pool contract
+type KeystoreConfig struct {
+	UpdaterKeyFile    string
+	UpdaterKeyPass    string
+	ExecutionEndpoint string
+	PoolAddress       string
+	NumRetries        int
+	LogLevel          string
+}
+
+func NewKeystoreConfig(args []string) (*KeystoreConfig, error) {
+	flags := flag.NewFlagSet("keystore", flag.ContinueOnError)
+
+	// Optional flags:
+	var executionEndpoint = flags.String("execution-endpoint", "", "Ethereum execution endpoint, to check the updater is whitelisted and has balance")
+	var poolAddress = flags.String("pool-address", "", "Address of the smoothing pool contract, mandatory with execution-endpoint")
+	var numRetries = flags.Int("num-retries", 3, "Number of retries for each interaction with the execution client: 0 infinite")
+	var logLevel = flags.String("log-level", "info", "Logging verbosity (trace, debug, info=default, warn, error, fatal, panic)")
+
+	// Mandatory flags:
+	var updaterKeystoreFile = flags.String("updater-keystore-file", "", "Password protected keystore file of the updater")
+	var updaterKeystorePass = flags.String("updater-keystore-pass", "", "Password of the updater keystore file")
+	var updaterKeystorePassFile = flags.String("updater-keystore-pass-file", "", "File containing the password of the updater keystore file")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Same environment variables as the run command
+	problems := applyFlagSources(flags, "")
+	if len(problems) != 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+
+	if *updaterKeystoreFile == "" {
+		return nil, errors.New("updater-keystore-file is a mandatory flag and cant be empty")
+	}
+
+	if *updaterKeystorePass != "" && *updaterKeystorePassFile != "" {
+		return nil, errors.New("updater-keystore-pass and updater-keystore-pass-file can't be used together")
+	}
+
+	updaterKeyPass := *updaterKeystorePass
+	if *updaterKeystorePassFile != "" {
+		updaterKeyPass, err = readSecretFile(*updaterKeystorePassFile)
+		if err != nil {
+			return nil, errors.New("could not read updater-keystore-pass-file: " + err.Error())
+		}
+	}
+
+	if updaterKeyPass == "" {
+		return nil, errors.New("updater-keystore-pass is a mandatory flag and cant be empty")
+	}
+
+	if *executionEndpoint != "" && !common.IsHexAddress(*poolAddress) {
+		return nil, errors.New("pool-address: " + *poolAddress + " is not a valid address")
+	}
+
+	keystoreConf := &KeystoreConfig{
+		UpdaterKeyFile:    *updaterKeystoreFile,
+		UpdaterKeyPass:    updaterKeyPass,
+		ExecutionEndpoint: *executionEndpoint,
+		PoolAddress:       *poolAddress,
+		NumRetries:        *numRetries,
+		LogLevel:          *logLevel,
+	}
+	log.WithFields(log.Fields{
+		"UpdaterKeyFile":    keystoreConf.UpdaterKeyFile,
+		"UpdaterKeyPass":    "hidden",
+		"ExecutionEndpoint": keystoreConf.ExecutionEndpoint,
+		"PoolAddress":       keystoreConf.PoolAddress,
+		"NumRetries":        keystoreConf.NumRetries,
+		"LogLevel":          keystoreConf.LogLevel,
+	}).Info("Keystore Config:")
+	return keystoreConf, nil
+}
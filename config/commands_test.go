@@ -0,0 +1,125 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewReplayConfig(t *testing.T) {
+	_, err := NewReplayConfig([]string{"--state=state.json"})
+	require.Error(t, err)
+
+	_, err = NewReplayConfig([]string{"--blocks=mock", "--keep-commited-states=-1"})
+	require.Error(t, err)
+
+	// Without a state it starts from the deployment
+	replayConf, err := NewReplayConfig([]string{"--blocks=mock"})
+	require.NoError(t, err)
+	require.Equal(t, "", replayConf.StatePath)
+	require.Equal(t, 30, replayConf.KeepCommitedStates)
+
+	replayConf, err = NewReplayConfig([]string{"--blocks=mock", "--state=state.json", "--to-slot=100", "--skip-gaps"})
+	require.NoError(t, err)
+	require.Equal(t, "mock", replayConf.BlocksPath)
+	require.Equal(t, "state.json", replayConf.StatePath)
+	require.Equal(t, uint64(100), replayConf.ToSlot)
+	require.Equal(t, "replay-data", replayConf.OutputFolder)
+	require.True(t, replayConf.SkipGaps)
+}
+
+func Test_NewVerifyConfig(t *testing.T) {
+	_, err := NewVerifyConfig([]string{"--execution-endpoint=http://127.0.0.1:8545"})
+	require.Error(t, err)
+
+	_, err = NewVerifyConfig([]string{"--state=state.json"})
+	require.Error(t, err)
+
+	_, err = NewVerifyConfig([]string{"--state=state.json", "--execution-endpoint=http://127.0.0.1:8545", "--from-block=10", "--to-block=5"})
+	require.Error(t, err)
+
+	verifyConf, err := NewVerifyConfig([]string{"--state=state.json", "--execution-endpoint=http://127.0.0.1:8545"})
+	require.NoError(t, err)
+	require.Equal(t, "state.json", verifyConf.StatePath)
+	require.Equal(t, "", verifyConf.BlocksPath)
+	require.Equal(t, uint64(0), verifyConf.FromBlock)
+	require.Equal(t, uint64(10000), verifyConf.EventsRangeSize)
+}
+
+func Test_NewExportConfig(t *testing.T) {
+	_, err := NewExportConfig([]string{})
+	require.Error(t, err)
+
+	_, err = NewExportConfig([]string{"--output=state.json", "--state-backend=sqlite"})
+	require.Error(t, err)
+
+	_, err = NewExportConfig([]string{"--output=state.json", "--state=other.json", "--snapshot=100"})
+	require.Error(t, err)
+
+	exportConf, err := NewExportConfig([]string{"--output=state.json", "--snapshot=100"})
+	require.NoError(t, err)
+	require.Equal(t, "state.json", exportConf.OutputFile)
+	require.Equal(t, DefaultStateFolder, exportConf.Source.StateFolder)
+	require.Equal(t, DefaultStateBackend, exportConf.Source.StateBackend)
+	require.Equal(t, uint64(100), exportConf.Source.Snapshot)
+}
+
+func Test_NewProofConfig(t *testing.T) {
+	_, err := NewProofConfig([]string{"--withdrawal-address=0x123"})
+	require.Error(t, err)
+
+	proofConf, err := NewProofConfig([]string{"--withdrawal-address=0x1000000000000000000000000000000000000000", "--state=state.json", "--checkpoint=7200"})
+	require.NoError(t, err)
+	require.Equal(t, "0x1000000000000000000000000000000000000000", proofConf.WithdrawalAddress)
+	require.Equal(t, "state.json", proofConf.Source.StateFile)
+	require.Equal(t, uint64(7200), proofConf.Checkpoint)
+}
+
+func Test_NewVerifyProofConfig(t *testing.T) {
+	for _, invalid := range [][]string{
+		{},
+		{"--withdrawal-address=0x1000000000000000000000000000000000000000", "--merkle-root=0x01"},
+		{"--withdrawal-address=0x1000000000000000000000000000000000000000", "--accumulated-balance=10"},
+		{"--proof-file=proof.json", "--withdrawal-address=0x1000000000000000000000000000000000000000"},
+		{"--proof-file=proof.json", "--merkle-root=0x01", "--execution-endpoint=http://127.0.0.1:8545"},
+		{"--proof-file=proof.json", "--execution-endpoint=http://127.0.0.1:8545"},
+	} {
+		_, err := NewVerifyProofConfig(invalid)
+		require.Error(t, err)
+	}
+
+	verifyProofConf, err := NewVerifyProofConfig([]string{
+		"--withdrawal-address=0x1000000000000000000000000000000000000000",
+		"--accumulated-balance=10",
+		"--merkle-proofs=0x01,0x02",
+		"--merkle-root=0x03",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"0x01", "0x02"}, verifyProofConf.Proofs)
+	require.Equal(t, "0x03", verifyProofConf.MerkleRoot)
+
+	verifyProofConf, err = NewVerifyProofConfig([]string{"--proof-file=proof.json"})
+	require.NoError(t, err)
+	require.Equal(t, "proof.json", verifyProofConf.ProofFile)
+	require.Equal(t, 0, len(verifyProofConf.Proofs))
+}
+
+func Test_NewInspectConfig(t *testing.T) {
+	inspectConf, err := NewInspectConfig([]string{"--state-backend=json", "--state-folder=data"})
+	require.NoError(t, err)
+	require.Equal(t, "json", inspectConf.Source.StateBackend)
+	require.Equal(t, "data", inspectConf.Source.StateFolder)
+}
+
+func Test_NewKeystoreConfig(t *testing.T) {
+	_, err := NewKeystoreConfig([]string{"--updater-keystore-file=keystore.json"})
+	require.Error(t, err)
+
+	_, err = NewKeystoreConfig([]string{"--updater-keystore-file=keystore.json", "--updater-keystore-pass=pass", "--execution-endpoint=http://127.0.0.1:8545"})
+	require.Error(t, err)
+
+	keystoreConf, err := NewKeystoreConfig([]string{"--updater-keystore-file=keystore.json", "--updater-keystore-pass=pass"})
+	require.NoError(t, err)
+	require.Equal(t, "keystore.json", keystoreConf.UpdaterKeyFile)
+	require.Equal(t, "", keystoreConf.ExecutionEndpoint)
+}
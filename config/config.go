@@ -12,104 +12,194 @@ import (
 )
 
 type CliConfig struct {
-	DryRun            bool
-	UpdaterKeyFile    string
-	UpdaterKeyPass    string
-	NumRetries        int
-	ConsensusEndpoint string
-	ExecutionEndpoint string
-	PoolAddress       string
-	LogLevel          string
-	ApiPort           int
-	MetricsPort       int
-	CheckPointSyncUrl string
-	RelayersEndpoints []string
+	ConfigFile         string
+	DryRun             bool
+	UpdaterKeyFile     string
+	UpdaterKeyPass     string
+	NumRetries         int
+	ConsensusEndpoint  string
+	ExecutionEndpoint  string
+	PoolAddress        string
+	LogLevel           string
+	ApiPort            int
+	MetricsPort        int
+	CheckPointSyncUrl  string
+	RelayersEndpoints  []string
+	StateBackend       string
+	KeepSnapshots      int
+	KeepCommitedStates int
+	ThinSnapshots      uint64
+	SaveEverySlots     uint64
+	SaveEveryMinutes   uint64
+	PrefetchWorkers    int
+	PrefetchAhead      int
+	EventsRangeSize    uint64
+	RecordDir          string
+	BuildersFile       string
+	OverridesFile      string
+	CheckRelayRewards  bool
 }
 
 // By default the release is a custom build. CI takes care of upgrading it with
 // go build -v -ldflags="-X 'github.com/dappnode/mev-sp-oracle/config.ReleaseVersion=x.y.z'"
 var ReleaseVersion = "custom-build-your-own-risk"
 
-func NewCliConfig() (*CliConfig, error) {
+// Parses the flags of the run command, that syncs the oracle and updates the
+// contract root. Flags not given in the command line are taken from their
+// environment variable or the config file, see applyFlagSources.
+func NewCliConfig(args []string) (*CliConfig, error) {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+
 	// Optional flags:
-	var version = flag.Bool("version", false, "Prints the release version and exits")
-	var dryRun = flag.Bool("dry-run", false, "If enabled, the pool contract will not be updated")
-	var updaterKeystoreFile = flag.String("updater-keystore-file", "", "Password protected keystore file of the updater")
-	var updaterKeystorePass = flag.String("updater-keystore-pass", "", "Password of the updater keystore file")
-	var numRetries = flag.Int("num-retries", 0, "Number of retries for each interaction (consensus, execution): 0 infinite")
-	var logLevel = flag.String("log-level", "info", "Logging verbosity (trace, debug, info=default, warn, error, fatal, panic)")
-	var apiPort = flag.Int("api-port", 7300, "Port for the API server")
-	var metricsPort = flag.Int("metrics-port", 8008, "Port for the metrics server")
-	var checkPointSyncUrl = flag.String("checkpoint-sync-url", "", "URL for the checkpoint sync server: http://url:port/state")
+	var version = flags.Bool("version", false, "Prints the release version and exits")
+	var configFile = flags.String("config", "", "Yaml config file, with the flag names as keys. Also set with "+EnvName("config"))
+	var dryRun = flags.Bool("dry-run", false, "If enabled, the pool contract will not be updated")
+	var updaterKeystoreFile = flags.String("updater-keystore-file", "", "Password protected keystore file of the updater")
+	var updaterKeystorePass = flags.String("updater-keystore-pass", "", "Password of the updater keystore file. Prefer updater-keystore-pass-file or "+EnvName("updater-keystore-pass"))
+	var updaterKeystorePassFile = flags.String("updater-keystore-pass-file", "", "File containing the password of the updater keystore file")
+	var numRetries = flags.Int("num-retries", 0, "Number of retries for each interaction (consensus, execution): 0 infinite")
+	var logLevel = flags.String("log-level", "info", "Logging verbosity (trace, debug, info=default, warn, error, fatal, panic)")
+	var apiPort = flags.Int("api-port", 7300, "Port for the API server")
+	var metricsPort = flags.Int("metrics-port", 8008, "Port for the metrics server")
+	var checkPointSyncUrl = flags.String("checkpoint-sync-url", "", "URL for the checkpoint sync server: http://url:port/state")
+	var stateBackend = flags.String("state-backend", DefaultStateBackend, "Backend used to persist the oracle state (bolt=default, json)")
+	var keepSnapshots = flags.Int("keep-snapshots", 30, "Number of latest checkpoint snapshots of the state to keep: 0 keeps all")
+	var keepCommitedStates = flags.Int("keep-commited-states", 30, "Number of latest commited states kept in full, older ones only keep their root and leafs: 0 keeps all")
+	var saveEverySlots = flags.Uint64("save-every-slots", 300, "Persist the state every this many processed slots between checkpoints: 0 disables it")
+	var saveEveryMinutes = flags.Uint64("save-every-minutes", 10, "Persist the state every this many minutes between checkpoints if new slots were processed: 0 disables it")
+	var prefetchWorkers = flags.Int("prefetch-workers", 4, "Number of slots fetched concurrently ahead of the one being processed: 0 disables prefetching")
+	var prefetchAhead = flags.Int("prefetch-ahead", 64, "Max number of prefetched slots waiting to be processed")
+	var eventsRangeSize = flags.Uint64("events-range-size", 1000, "Number of blocks whose pool events are fetched in a single call: 0 fetches them block by block")
+	var recordDir = flags.String("record-dir", "", "If set, every processed block is recorded compressed in this folder, to be replayed later on")
+	var buildersFile = flags.String("whitelisted-builders-file", "", "Json file with whitelisted builders, whose payments are mev rewards, in addition to the known ones of the network. All oracles must use the same ones")
+	var overridesFile = flags.String("reward-overrides-file", "", "Json file with reward overrides of slots whose mev reward can't be detected, replacing the known ones of the network of the same slot. All oracles must use the same ones")
+	var checkRelayRewards = flags.Bool("check-relay-rewards", false, "Cross-check the rewards of subscribed validators with the payloads delivered by the relays of relayers-endpoints")
+	var thinSnapshots = flags.Uint64("thin-snapshots-every", 30, "Snapshots older than the kept ones are only kept every this many checkpoints: 0 removes them")
 
 	// Mandatory flags:
-	var consensusEndpoint = flag.String("consensus-endpoint", "", "Ethereum consensus endpoint")
-	var executionEndpoint = flag.String("execution-endpoint", "", "Ethereum execution endpoint")
-	var poolAddress = flag.String("pool-address", "", "Address of the smoothing pool contract")
-	var relayersEndpointsStr = flag.String("relayers-endpoints", "", "Comma-separated list of relayers endpoints")
-
-	flag.Parse()
+	var consensusEndpoint = flags.String("consensus-endpoint", "", "Ethereum consensus endpoint")
+	var executionEndpoint = flags.String("execution-endpoint", "", "Ethereum execution endpoint")
+	var poolAddress = flags.String("pool-address", "", "Address of the smoothing pool contract")
+	var relayersEndpointsStr = flags.String("relayers-endpoints", "", "Comma-separated list of relayers endpoints")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
 
 	if *version {
 		log.Info("Version: ", ReleaseVersion)
 		os.Exit(0)
 	}
 
-	// Some simple cli argument validation
+	if *configFile == "" {
+		*configFile = os.Getenv(EnvName("config"))
+	}
+	problems := applyFlagSources(flags, *configFile)
+
+	// Some simple cli argument validation, reporting all problems at once
+
+	updaterKeyPass := *updaterKeystorePass
+	passFileFailed := false
+	if *updaterKeystorePass != "" && *updaterKeystorePassFile != "" {
+		problems = append(problems, "updater-keystore-pass and updater-keystore-pass-file can't be used together")
+	} else if *updaterKeystorePassFile != "" {
+		updaterKeyPass, err = readSecretFile(*updaterKeystorePassFile)
+		if err != nil {
+			problems = append(problems, "could not read updater-keystore-pass-file: "+err.Error())
+			passFileFailed = true
+		}
+	}
 
 	if !*dryRun && *updaterKeystoreFile == "" {
-		return nil, errors.New("you must provide a keystore file to update the contract root")
+		problems = append(problems, "you must provide a keystore file to update the contract root")
 	}
 
-	if !*dryRun && *updaterKeystorePass == "" {
-		return nil, errors.New("you must provide a password for the keystore file")
+	if !*dryRun && updaterKeyPass == "" && !passFileFailed {
+		problems = append(problems, "you must provide a password for the keystore file")
 	}
 
 	if *dryRun && *updaterKeystoreFile != "" {
-		return nil, errors.New("you can't provide a keystore file in dry run mode")
+		problems = append(problems, "you can't provide a keystore file in dry run mode")
 	}
 
-	if *dryRun && *updaterKeystorePass != "" {
-		return nil, errors.New("you can't provide a password for the keystore file in dry run mode")
+	if *dryRun && (*updaterKeystorePass != "" || *updaterKeystorePassFile != "") {
+		problems = append(problems, "you can't provide a password for the keystore file in dry run mode")
 	}
 
 	if !common.IsHexAddress(*poolAddress) {
-		return nil, errors.New("pool-address: " + *poolAddress + " is not a valid address")
+		problems = append(problems, "pool-address: "+*poolAddress+" is not a valid address")
+	}
+
+	if *stateBackend != "bolt" && *stateBackend != "json" {
+		problems = append(problems, "state-backend: "+*stateBackend+" is not supported, use bolt or json")
+	}
+
+	if *prefetchWorkers < 0 {
+		problems = append(problems, "prefetch-workers can't be negative")
+	}
+
+	if *prefetchAhead < *prefetchWorkers {
+		problems = append(problems, "prefetch-ahead can't be lower than prefetch-workers")
+	}
+
+	if *keepSnapshots < 0 {
+		problems = append(problems, "keep-snapshots can't be negative")
+	}
+
+	if *keepCommitedStates < 0 {
+		problems = append(problems, "keep-commited-states can't be negative")
 	}
 
 	// Post process the relayers endpoints, make it a slice
 	relayersEndpoints := strings.Split(*relayersEndpointsStr, ",")
 
 	if len(relayersEndpoints) == 0 || (len(relayersEndpoints) == 1 && relayersEndpoints[0] == "") {
-		return nil, errors.New("relayers-endpoints is a mandatory flag and cant be empty")
+		problems = append(problems, "relayers-endpoints is a mandatory flag and cant be empty")
+	} else {
+		// Validate the relayers endpoints, they must be valid URLs, not empty and start with https://.
+		for _, endpoint := range relayersEndpoints {
+			if endpoint == "" {
+				problems = append(problems, "relayer endpoint URL cannot be empty")
+			} else if !strings.HasPrefix(endpoint, "https://") {
+				problems = append(problems, "relayer endpoint URL must start with 'https://': "+endpoint)
+			} else if _, err := url.Parse(endpoint); err != nil {
+				problems = append(problems, "invalid relayer endpoint URL: "+endpoint)
+			}
+		}
 	}
 
-	// Validate the relayers endpoints, they must be valid URLs, not empty and start with https://.
-	for _, endpoint := range relayersEndpoints {
-		if endpoint == "" {
-			return nil, errors.New("relayer endpoint URL cannot be empty")
-		}
-		if !strings.HasPrefix(endpoint, "https://") {
-			return nil, errors.New("relayer endpoint URL must start with 'https://'")
-		}
-		if _, err := url.Parse(endpoint); err != nil {
-			return nil, errors.New("invalid relayer endpoint URL: " + endpoint)
-		}
+	if len(problems) != 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
 	}
 
 	cliConf := &CliConfig{
-		DryRun:            *dryRun,
-		UpdaterKeyFile:    *updaterKeystoreFile,
-		UpdaterKeyPass:    *updaterKeystorePass,
-		NumRetries:        *numRetries,
-		ConsensusEndpoint: *consensusEndpoint,
-		ExecutionEndpoint: *executionEndpoint,
-		PoolAddress:       *poolAddress,
-		LogLevel:          *logLevel,
-		ApiPort:           *apiPort,
-		MetricsPort:       *metricsPort,
-		CheckPointSyncUrl: *checkPointSyncUrl,
-		RelayersEndpoints: relayersEndpoints,
+		ConfigFile:         *configFile,
+		DryRun:             *dryRun,
+		UpdaterKeyFile:     *updaterKeystoreFile,
+		UpdaterKeyPass:     updaterKeyPass,
+		NumRetries:         *numRetries,
+		ConsensusEndpoint:  *consensusEndpoint,
+		ExecutionEndpoint:  *executionEndpoint,
+		PoolAddress:        *poolAddress,
+		LogLevel:           *logLevel,
+		ApiPort:            *apiPort,
+		MetricsPort:        *metricsPort,
+		CheckPointSyncUrl:  *checkPointSyncUrl,
+		RelayersEndpoints:  relayersEndpoints,
+		StateBackend:       *stateBackend,
+		KeepSnapshots:      *keepSnapshots,
+		KeepCommitedStates: *keepCommitedStates,
+		ThinSnapshots:      *thinSnapshots,
+		SaveEverySlots:     *saveEverySlots,
+		SaveEveryMinutes:   *saveEveryMinutes,
+		PrefetchWorkers:    *prefetchWorkers,
+		PrefetchAhead:      *prefetchAhead,
+		EventsRangeSize:    *eventsRangeSize,
+		RecordDir:          *recordDir,
+		BuildersFile:       *buildersFile,
+		OverridesFile:      *overridesFile,
+		CheckRelayRewards:  *checkRelayRewards,
 	}
 	logConfig(cliConf)
 	return cliConf, nil
@@ -117,17 +207,31 @@ func NewCliConfig() (*CliConfig, error) {
 
 func logConfig(cfg *CliConfig) {
 	log.WithFields(log.Fields{
-		"DryRun":            cfg.DryRun,
-		"UpdaterKeyFile":    cfg.UpdaterKeyFile,
-		"UpdaterKeyPass":    "hidden",
-		"NumRetries":        cfg.NumRetries,
-		"ConsensusEndpoint": cfg.ConsensusEndpoint,
-		"ExecutionEndpoint": cfg.ExecutionEndpoint,
-		"PoolAddress":       cfg.PoolAddress,
-		"LogLevel":          cfg.LogLevel,
-		"ApiPort":           cfg.ApiPort,
-		"MetricsPort":       cfg.MetricsPort,
-		"CheckPointSyncUrl": cfg.CheckPointSyncUrl,
-		"RelayersEndpoints": cfg.RelayersEndpoints,
+		"ConfigFile":         cfg.ConfigFile,
+		"DryRun":             cfg.DryRun,
+		"UpdaterKeyFile":     cfg.UpdaterKeyFile,
+		"UpdaterKeyPass":     "hidden",
+		"NumRetries":         cfg.NumRetries,
+		"ConsensusEndpoint":  cfg.ConsensusEndpoint,
+		"ExecutionEndpoint":  cfg.ExecutionEndpoint,
+		"PoolAddress":        cfg.PoolAddress,
+		"LogLevel":           cfg.LogLevel,
+		"ApiPort":            cfg.ApiPort,
+		"MetricsPort":        cfg.MetricsPort,
+		"CheckPointSyncUrl":  cfg.CheckPointSyncUrl,
+		"RelayersEndpoints":  cfg.RelayersEndpoints,
+		"StateBackend":       cfg.StateBackend,
+		"KeepSnapshots":      cfg.KeepSnapshots,
+		"KeepCommitedStates": cfg.KeepCommitedStates,
+		"ThinSnapshots":      cfg.ThinSnapshots,
+		"SaveEverySlots":     cfg.SaveEverySlots,
+		"SaveEveryMinutes":   cfg.SaveEveryMinutes,
+		"PrefetchWorkers":    cfg.PrefetchWorkers,
+		"PrefetchAhead":      cfg.PrefetchAhead,
+		"EventsRangeSize":    cfg.EventsRangeSize,
+		"RecordDir":          cfg.RecordDir,
+		"BuildersFile":       cfg.BuildersFile,
+		"OverridesFile":      cfg.OverridesFile,
+		"CheckRelayRewards":  cfg.CheckRelayRewards,
 	}).Info("Cli Config:")
 }
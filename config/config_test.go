@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -8,7 +10,81 @@ import (
 
 func Test_NewCliConfig(t *testing.T) {
 
-	cliConf, err := NewCliConfig()
+	cliConf, err := NewCliConfig([]string{})
 	_ = cliConf
 	require.Error(t, err)
 }
+
+func Test_NewCliConfig_Sources(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	passFile := filepath.Join(dir, "pass.txt")
+	require.NoError(t, os.WriteFile(passFile, []byte("secret\n"), 0600))
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+consensus-endpoint: http://127.0.0.1:3500
+execution-endpoint: http://127.0.0.1:8545
+pool-address: "0xAdFb8D27671F14f297eE94135e266aAFf8752e35"
+relayers-endpoints:
+  - https://relay1
+  - https://relay2
+updater-keystore-file: keystore.json
+api-port: 7400
+log-level: debug
+`), 0644))
+
+	// Flags take precedence over the environment, and it over the config file
+	t.Setenv(EnvName("updater-keystore-pass-file"), passFile)
+	t.Setenv(EnvName("log-level"), "warn")
+	t.Setenv(EnvName("api-port"), "7500")
+	cliConf, err := NewCliConfig([]string{"--config=" + configFile, "--api-port=7600"})
+	require.NoError(t, err)
+	require.Equal(t, configFile, cliConf.ConfigFile)
+	require.Equal(t, "http://127.0.0.1:3500", cliConf.ConsensusEndpoint)
+	require.Equal(t, []string{"https://relay1", "https://relay2"}, cliConf.RelayersEndpoints)
+	require.Equal(t, "keystore.json", cliConf.UpdaterKeyFile)
+	require.Equal(t, "secret", cliConf.UpdaterKeyPass)
+	require.Equal(t, "warn", cliConf.LogLevel)
+	require.Equal(t, 7600, cliConf.ApiPort)
+	require.Equal(t, 8008, cliConf.MetricsPort)
+
+	// The config file can also be given with its environment variable
+	t.Setenv(EnvName("config"), configFile)
+	cliConf, err = NewCliConfig([]string{})
+	require.NoError(t, err)
+	require.Equal(t, 7500, cliConf.ApiPort)
+}
+
+func Test_NewCliConfig_AllProblems(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte("unknown-key: 1\napi-port: notanumber\n"), 0644))
+
+	_, err := NewCliConfig([]string{
+		"--config=" + configFile,
+		"--dry-run",
+		"--updater-keystore-pass=pass",
+		"--pool-address=invalid",
+		"--prefetch-workers=-1",
+		"--relayers-endpoints=http://relay",
+	})
+	require.Error(t, err)
+	for _, problem := range []string{
+		"unknown key unknown-key",
+		"invalid value \"notanumber\" for api-port",
+		"password for the keystore file in dry run mode",
+		"pool-address: invalid",
+		"prefetch-workers can't be negative",
+		"must start with 'https://'",
+	} {
+		require.Contains(t, err.Error(), problem)
+	}
+
+	_, err = NewCliConfig([]string{
+		"--updater-keystore-file=keystore.json",
+		"--updater-keystore-pass=pass",
+		"--updater-keystore-pass-file=pass.txt",
+		"--pool-address=0xAdFb8D27671F14f297eE94135e266aAFf8752e35",
+		"--relayers-endpoints=https://relay",
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "can't be used together")
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of the environment variables that set the flags of the run command,
+// eg MEV_SP_ORACLE_UPDATER_KEYSTORE_PASS sets --updater-keystore-pass
+const EnvPrefix = "MEV_SP_ORACLE_"
+
+// Returns the environment variable that sets the given flag
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Sets the flags that were not given in the command line, first from their
+// environment variable and then from the yaml config file, if any. So the
+// precedence is flags > environment > config file > defaults. The config file
+// uses the flag names as keys. Returns every problem found, not only the first.
+func applyFlagSources(flags *flag.FlagSet, configFile string) []string {
+	problems := make([]string, 0)
+
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	fileValues := make(map[string]string)
+	if configFile != "" {
+		var err error
+		fileValues, err = readConfigFile(configFile)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	// Sorted so that problems are always reported in the same order
+	keys := make([]string, 0, len(fileValues))
+	for key := range fileValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if flags.Lookup(key) == nil || key == "config" {
+			problems = append(problems, fmt.Sprintf("config file: unknown key %s", key))
+		}
+	}
+
+	flags.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] || f.Name == "config" {
+			return
+		}
+		envName := EnvName(f.Name)
+		if value := os.Getenv(envName); value != "" {
+			if err := flags.Set(f.Name, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid value %q: %s", envName, value, err))
+			}
+			return
+		}
+		if value, found := fileValues[f.Name]; found {
+			if err := flags.Set(f.Name, value); err != nil {
+				problems = append(problems, fmt.Sprintf("config file: invalid value %q for %s: %s", value, f.Name, err))
+			}
+		}
+	})
+	return problems
+}
+
+// Reads a yaml file of flag names to values. Lists are joined with commas, as
+// the flags that take several values expect them.
+func readConfigFile(path string) (map[string]string, error) {
+	rawBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read config file")
+	}
+
+	var rawValues map[string]interface{}
+	err = yaml.Unmarshal(rawBytes, &rawValues)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse config file "+path)
+	}
+
+	values := make(map[string]string, len(rawValues))
+	for key, rawValue := range rawValues {
+		switch value := rawValue.(type) {
+		case []interface{}:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+// Reads a secret from a file, ignoring the trailing newline most editors add
+func readSecretFile(path string) (string, error) {
+	rawBytes, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(rawBytes), "\r\n"), nil
+}
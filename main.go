@@ -37,18 +37,50 @@ const LogsFolder = "oracle-logs"
 const ReconciliationEveryHours = int64(3)
 
 func main() {
+	// Running without a command runs the oracle, as older versions did
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		runOracle(args)
+	case "replay":
+		runReplay(args)
+	case "verify":
+		runVerify(args)
+	case "export":
+		runExport(args)
+	case "proof":
+		runProof(args)
+	case "verify-proof":
+		runVerifyProof(args)
+	case "state":
+		runState(args)
+	case "keystore":
+		runKeystore(args)
+	case "version":
+		fmt.Println(config.ReleaseVersion)
+	case "help":
+		printUsage(os.Stdout)
+	default:
+		fmt.Fprintln(os.Stderr, "unknown command: "+command)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+}
+
+// Runs the oracle, syncing the state with the chain and updating the contract
+// with a new merkle root at every checkpoint
+func runOracle(args []string) {
 	// Load config from cli
-	cliCfg, err := config.NewCliConfig()
+	cliCfg, err := config.NewCliConfig(args)
 	if err != nil {
-		log.Fatal("error parsing the cli config: ", err)
+		exitOnConfigError("run", err)
 	}
 
-	customFormatter := new(log.TextFormatter)
-	customFormatter.TimestampFormat = "2006-01-02 15:04:05"
-	customFormatter.FullTimestamp = true
-	customFormatter.DisableColors = true
-	log.SetFormatter(customFormatter)
-	//log.SetReportCaller(true)
+	setLogFormatter()
 
 	//file is created if not exists, otherwise it appends errors to the existing file
 	//0666 means permisions to read and write to all users, but not execute
@@ -83,7 +115,7 @@ func main() {
 	var updaterKey *ecdsa.PrivateKey
 	var updaterAddress common.Address
 	if !cliCfg.DryRun {
-		keystore, err := utils.DecryptKey(cliCfg)
+		keystore, err := utils.DecryptKey(cliCfg.UpdaterKeyFile, cliCfg.UpdaterKeyPass)
 		if err != nil {
 			log.Fatal("Could not decrypt updater key: ", err)
 		}
@@ -100,36 +132,80 @@ func main() {
 
 	if !cliCfg.DryRun {
 		log.Info("Checking if configured address ", updaterAddress.String(), " is whitelisted to update the contract")
-		isWhitelisted, err := onchain.IsAddressWhitelisted(updaterAddress)
-		if err != nil {
-			log.Fatal("Could not get whitelist status: " + err.Error())
-		}
-		if !isWhitelisted {
-			log.Fatal("Pool address is not whitelisted, please run the 'whitelist' command first")
-		}
-		log.Info("Ok ", updaterAddress.String(), " is whitelisted")
-
-		// Check the updater address has some Eth balance
-		balance, err := onchain.GetAddressEthBalance(updaterAddress)
-		if err != nil {
-			log.Fatal("Could not get updater address balance: ", err)
-		}
-
-		// Ensure it has balance, otherwise it wont be able to pay tx fees
-		if balance.Cmp(big.NewInt(0)) == 0 {
-			log.Fatal("Updater address: ", updaterAddress.String(), " has no balance, please send some Eth to it")
-		} else {
-			log.Info("Updater address: ", updaterAddress.String(), " has balance: ", utils.WeiToEther(balance), "Eth, ensure its enough to cover txs during some time")
-		}
+		checkUpdaterAddress(onchain, updaterAddress)
 	}
 
 	// Populate config, most of the parameters are loaded from the smart contract
 	cfg := onchain.GetConfigFromContract(cliCfg)
 
+	// Mev rewards that can't be detected, known ones merged with the optional file
+	cfg.RewardOverrides, err = oracle.LoadRewardOverrides(cfg.Network, cliCfg.OverridesFile)
+	if err != nil {
+		log.Fatal("Could not load reward overrides: ", err)
+	}
+	for _, override := range cfg.RewardOverrides {
+		log.WithFields(log.Fields{
+			"Slot":             override.Slot,
+			"RewardWei":        override.RewardWei,
+			"Recipient":        override.Recipient,
+			"JustificationUrl": override.JustificationUrl,
+		}).Info("Loaded reward override")
+	}
+
+	// Builders whose payments are mev rewards, known ones plus the optional file
+	cfg.WhitelistedBuilders, err = oracle.LoadWhitelistedBuilders(cfg.Network, cliCfg.BuildersFile)
+	if err != nil {
+		log.Fatal("Could not load whitelisted builders: ", err)
+	}
+	for _, builder := range cfg.WhitelistedBuilders {
+		log.WithFields(log.Fields{
+			"Address":          builder.Address,
+			"ActivationSlot":   builder.ActivationSlot,
+			"DeactivationSlot": builder.DeactivationSlot,
+			"JustificationUrl": builder.JustificationUrl,
+		}).Info("Loaded whitelisted builder")
+	}
+	onchain.SetWhitelistedBuilders(cfg.WhitelistedBuilders)
+
 	// Create the oracle instance
 	oracleInstance := oracle.NewOracle(cfg)
 	oracleInstance.SetGetSetOfValidatorsFunc(onchain.GetSetOfValidators)
 
+	// Record the processed blocks and validators so that they can be replayed
+	var recorder *oracle.BlockRecorder
+	if cliCfg.RecordDir != "" {
+		recorder, err = oracle.NewBlockRecorder(cliCfg.RecordDir)
+		if err != nil {
+			log.Fatal("Could not create block recorder: ", err)
+		}
+		err = recorder.RecordConfig(cfg)
+		if err != nil {
+			log.Fatal("Could not record config: ", err)
+		}
+		oracleInstance.SetGetSetOfValidatorsFunc(recorder.ValidatorsFunc(onchain.GetSetOfValidators))
+		log.Info("Recording processed blocks in ", cliCfg.RecordDir)
+	}
+
+	// Rewards of subscribed validators are cross-checked with the relays if enabled.
+	// Relays are not critical, so a failing one is retried just a few times
+	var relayChecker *oracle.RelayChecker
+	if cliCfg.CheckRelayRewards {
+		relayChecker = oracle.NewRelayChecker(cliCfg.RelayersEndpoints,
+			retry.Attempts(3), retry.Delay(5*time.Second), retry.LastErrorOnly(true))
+		oracleInstance.SetRelayChecker(relayChecker)
+	}
+
+	// Backend where the state is persisted
+	stateStore, err := oracle.NewStateStore(cliCfg.StateBackend, oracle.StateFolder, oracle.SnapshotRetention{
+		KeepLatest: cliCfg.KeepSnapshots,
+		ThinEvery:  cliCfg.ThinSnapshots,
+	})
+	if err != nil {
+		log.Fatal("Could not open state store: ", err)
+	}
+	defer stateStore.Close()
+	oracleInstance.SetStateStore(stateStore)
+
 	// If checkpoint sync url is provided, load state from it
 	if cliCfg.CheckPointSyncUrl != "" {
 		log.Warn("Checkpoint sync url provided, loading state from it. Ensure you trust the provider: ", cliCfg.CheckPointSyncUrl)
@@ -154,9 +230,20 @@ func main() {
 		}
 
 	} else {
-		found, err := oracleInstance.LoadFromJson()
+		found, err := oracleInstance.LoadState()
 		if err != nil {
-			log.Fatal("Critical error loading state from json: ", err)
+			log.Fatal("Critical error loading state: ", err)
+		}
+
+		// Migrate from a previous json state if the store is empty
+		if !found && cliCfg.StateBackend != oracle.JsonBackend {
+			found, err = oracleInstance.LoadFromJson()
+			if err != nil {
+				log.Fatal("Critical error loading state from json: ", err)
+			}
+			if found {
+				log.Info("Loaded previous json state, it will be migrated to the ", cliCfg.StateBackend, " state store")
+			}
 		}
 		if !found {
 			log.Warn("Previous state not found or could not be loaded, syncing from the begining slot=", oracleInstance.State().DeployedSlot)
@@ -181,7 +268,7 @@ func main() {
 				onchainSlot, " latestCommited=", latestCommited)
 			found, err := oracleInstance.LoadGivenState(onchainSlot)
 			if err != nil {
-				log.Fatal("Critical error loading state from json: ", err)
+				log.Fatal("Critical error loading given state: ", err)
 			}
 			if !found {
 				log.Fatal("Could not find a save state for slot ", onchainSlot)
@@ -189,11 +276,25 @@ func main() {
 		}
 	}
 
+	// Before exiting on any fatal error, persist the state if its consistent. Only
+	// registered once the state was loaded, so that an error loading it never
+	// overwrites the stored one with an empty state
+	log.RegisterExitHandler(func() {
+		saved, err := oracleInstance.TrySaveState()
+		if err != nil {
+			log.Error("Could not save state before exiting: ", err)
+		} else if saved {
+			log.Info("State saved before exiting, slot=", oracleInstance.State().LatestProcessedSlot)
+		} else {
+			log.Warn("State was being modified, not saved before exiting")
+		}
+	})
+
 	api := api.NewApiService(cfg, cliCfg, oracleInstance, onchain)
 
 	metrics.RunMetrics(cliCfg.MetricsPort)
 	go api.StartHTTPServer()
-	go mainLoop(oracleInstance, onchain, cfg)
+	go mainLoop(oracleInstance, onchain, recorder, relayChecker, cfg, cliCfg)
 
 	// Wait for signal.
 	sigCh := make(chan os.Signal, 1)
@@ -203,11 +304,11 @@ func main() {
 
 		// Save state in SIGINT or SIGTERM
 		if sig == syscall.SIGINT || sig == syscall.SIGTERM {
-			err := oracleInstance.SaveToJson(false)
+			err := oracleInstance.SaveState(false)
 			if err != nil {
-				log.Error("Could not save state to json: ", err)
+				log.Error("Could not save state: ", err)
 			} else {
-				log.Info("State saved to json")
+				log.Info("State saved")
 			}
 		}
 
@@ -219,9 +320,76 @@ func main() {
 	log.Info("Oracle gracefully stopped")
 }
 
-func mainLoop(oracleInstance *oracle.Oracle, onchain *oracle.Onchain, cfg *oracle.Config) {
+func setLogFormatter() {
+	customFormatter := new(log.TextFormatter)
+	customFormatter.TimestampFormat = "2006-01-02 15:04:05"
+	customFormatter.FullTimestamp = true
+	customFormatter.DisableColors = true
+	log.SetFormatter(customFormatter)
+	//log.SetReportCaller(true)
+}
+
+// Persists the state every some slots or minutes between checkpoints, so that
+// if the oracle is restarted, it resumes close to where it stopped
+type statePersister struct {
+	oracle     *oracle.Oracle
+	everySlots uint64
+	every      time.Duration
+	lastSlot   uint64
+	lastTime   time.Time
+}
+
+func newStatePersister(oracleInstance *oracle.Oracle, cliCfg *config.CliConfig) *statePersister {
+	return &statePersister{
+		oracle:     oracleInstance,
+		everySlots: cliCfg.SaveEverySlots,
+		every:      time.Duration(cliCfg.SaveEveryMinutes) * time.Minute,
+		lastSlot:   oracleInstance.State().LatestProcessedSlot,
+		lastTime:   time.Now(),
+	}
+}
+
+// Saves the state if new slots were processed and enough slots or time passed
+func (p *statePersister) SaveIfDue() {
+	latestSlot := p.oracle.State().LatestProcessedSlot
+	if latestSlot <= p.lastSlot {
+		return
+	}
+	slotsDue := p.everySlots != 0 && latestSlot-p.lastSlot >= p.everySlots
+	timeDue := p.every != 0 && time.Since(p.lastTime) >= p.every
+	if !slotsDue && !timeDue {
+		return
+	}
+
+	err := p.oracle.SaveState(false)
+	if err != nil {
+		log.Error("Could not save in-progress state: ", err)
+		return
+	}
+	p.Saved()
+}
+
+// Resets the counters, to be called when the state was saved elsewhere
+func (p *statePersister) Saved() {
+	p.lastSlot = p.oracle.State().LatestProcessedSlot
+	p.lastTime = time.Now()
+}
+
+func mainLoop(oracleInstance *oracle.Oracle, onchain *oracle.Onchain, recorder *oracle.BlockRecorder, relayChecker *oracle.RelayChecker, cfg *oracle.Config, cliCfg *config.CliConfig) {
 
 	lastReconciliationTime := int64(0)
+	persister := newStatePersister(oracleInstance, cliCfg)
+
+	// Blocks ahead of the one being processed are fetched concurrently
+	var prefetcher *oracle.BlockPrefetcher
+	if cliCfg.PrefetchWorkers > 0 {
+		prefetcher = oracle.NewBlockPrefetcher(
+			onchain.PrefetchFullBlock,
+			oracleInstance.State().NextSlotToProcess,
+			cliCfg.PrefetchWorkers,
+			cliCfg.PrefetchAhead)
+		defer prefetcher.Stop()
+	}
 
 	// Load all the validators from the beacon chain
 	onchain.RefreshBeaconValidators()
@@ -252,10 +420,36 @@ func mainLoop(oracleInstance *oracle.Oracle, onchain *oracle.Onchain, cfg *oracl
 
 		finalizedSlot := uint64(finalizedBlockHeader.Header.Message.Slot)
 
+		// Blocks far from the finalized one are not checked with the relays
+		if relayChecker != nil {
+			relayChecker.SetFinalizedSlot(finalizedSlot)
+		}
+
 		if finalizedSlot >= oracleInstance.State().NextSlotToProcess {
 
 			// Fetch block information
-			fullBlock := onchain.FetchFullBlock(oracleInstance.State().NextSlotToProcess, oracleInstance)
+			var fullBlock *oracle.FullBlock
+			if prefetcher != nil {
+				prefetcher.SetLimit(finalizedSlot)
+				fullBlock, err = prefetcher.Next(oracleInstance.State().NextSlotToProcess)
+				if err != nil {
+					log.Fatal("Could not fetch block: ", err)
+				}
+				err = onchain.CompleteFullBlock(fullBlock, oracleInstance, false)
+				if err != nil {
+					log.Fatal("Could not complete block: ", err)
+				}
+			} else {
+				fullBlock = onchain.FetchFullBlock(oracleInstance.State().NextSlotToProcess, oracleInstance)
+			}
+
+			// Record the block exactly as it is processed
+			if recorder != nil {
+				err = recorder.Record(fullBlock)
+				if err != nil {
+					log.Fatal("Could not record block: ", err)
+				}
+			}
 
 			// Process the block
 			processedSlot, err := oracleInstance.AdvanceStateToNextSlot(fullBlock)
@@ -272,6 +466,12 @@ func mainLoop(oracleInstance *oracle.Oracle, onchain *oracle.Onchain, cfg *oracl
 			log.Debug("[", processedSlot, "/", finalizedSlot, "] Processed until slot, remaining: ",
 				slotToLatestFinalized, " (", utils.SlotsToTime(slotToLatestFinalized, constants.SecondsInSlot), " ago)")
 
+			// Checkpoints are persisted below once the root is submitted
+			isCheckpoint, err := oracleInstance.IsCheckpoint()
+			if err == nil && !isCheckpoint {
+				persister.SaveIfDue()
+			}
+
 		} else {
 			// We are in sync, no new finalized slot, wait a bit
 			log.WithFields(log.Fields{
@@ -323,7 +523,7 @@ func mainLoop(oracleInstance *oracle.Oracle, onchain *oracle.Onchain, cfg *oracl
 		if isCheckpoint {
 			log.WithFields(log.Fields{
 				"LatestProcessedSlot":   oracleInstance.State().LatestProcessedSlot,
-				"CheckPointSizeInSlots": cfg.CheckPointSizeInSlots,
+				"CheckPointSizeInSlots": oracleInstance.State().CheckPointSizeInSlots,
 				"DeployedSlot":          oracleInstance.State().DeployedSlot,
 			}).Info("Checkpoint reached")
 
@@ -406,7 +606,7 @@ func mainLoop(oracleInstance *oracle.Oracle, onchain *oracle.Onchain, cfg *oracl
 				if err != nil {
 					log.Fatal("Could not get onchain slot and root: ", err)
 				}
-				if newState.Slot == (onchainSlot + cfg.CheckPointSizeInSlots) {
+				if newState.Slot == (onchainSlot + oracleInstance.State().CheckPointSizeInSlots) {
 					r := rand.Intn(16 * 60)
 					log.Info("Waiting ", r, " seconds before updating the contract")
 					time.Sleep(time.Duration(r) * time.Second)
@@ -432,7 +632,7 @@ func mainLoop(oracleInstance *oracle.Oracle, onchain *oracle.Onchain, cfg *oracl
 				// If the new state is the one onchain + checkpoint size then its time to update the root
 				// Then we can update the new merkle root. onchainSlot == 0 is an special case when the
 				// contract was just initialized and there is no root yet.
-				if newState.Slot == onchainSlot+cfg.CheckPointSizeInSlots {
+				if newState.Slot == onchainSlot+oracleInstance.State().CheckPointSizeInSlots {
 					log.WithFields(log.Fields{
 						"Root": newState.MerkleRoot,
 						"Slot": newState.Slot,
@@ -483,12 +683,16 @@ func mainLoop(oracleInstance *oracle.Oracle, onchain *oracle.Onchain, cfg *oracl
 				}
 			}
 
+			// Old commited states are compacted, but never the one onchain
+			oracleInstance.PruneCommitedStates(cliCfg.KeepCommitedStates, onchainSlot)
+
 			// Persist new state in file only if everything went fine
-			err = oracleInstance.SaveToJson(true)
+			err = oracleInstance.SaveState(true)
 			if err != nil {
-				log.Error("Could not save state to json: ", err)
+				log.Error("Could not save state: ", err)
 			} else {
-				log.Info("State saved to json")
+				log.Info("State saved")
+				persister.Saved()
 			}
 		}
 	}
@@ -59,6 +59,15 @@ var (
 		},
 	)
 
+	RelayRewardDiscrepancies = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "oracle",
+			Name:      "relay_reward_discrepancies_total",
+			Help:      "Rewards of subscribed validators not matching the payload delivered by the relay, partitioned by relay and kind of discrepancy",
+		},
+		[]string{"relay", "kind"},
+	)
+
 	HttpRequestsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "oracle",
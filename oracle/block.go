@@ -9,28 +9,15 @@ import (
 	v1 "github.com/attestantio/go-eth2-client/api/v1"
 	"github.com/attestantio/go-eth2-client/spec"
 	"github.com/attestantio/go-eth2-client/spec/bellatrix"
+	"github.com/attestantio/go-eth2-client/spec/capella"
 	"github.com/attestantio/go-eth2-client/spec/phase0"
 	"github.com/dappnode/mev-sp-oracle/contract"
 	"github.com/dappnode/mev-sp-oracle/utils"
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-// Whitelisted builders for each chain. A transactions that comes from any
-// of those AND if its last tx in the block, its considered MEV reward.
-var WhitelistedBuilders = map[uint64][]string{
-	MainnetChainId: {
-		"0xae0A3D884E746599BD6C893a674E556C36a47f1e",
-	},
-	HoleskyChainId: {},
-}
-
-// See MevRewardInWei for more info
-// https://beaconcha.in/slot/10400574
-var ExceptionSlotMainnet1 = uint64(10400574)
-
 // Create a new block with the bare minimum information
 func NewFullBlock(
 	consensusDuty *api.ProposerDuty,
@@ -84,34 +71,20 @@ func (b *FullBlock) SetConsensusBlock(consensusBlock *spec.VersionedSignedBeacon
 		log.Fatal("consensus block can't be nil")
 	}
 
-	cBlockSlot, err := consensusBlock.Slot()
+	versioned, err := getVersionedBlock(consensusBlock)
 	if err != nil {
-		log.Fatal("failed to get slot from consensus block: ", err)
+		log.Fatal("failed to get fields from consensus block: ", err)
 	}
 
-	if b.ConsensusDuty.Slot != cBlockSlot {
+	if b.ConsensusDuty.Slot != versioned.Slot {
 		log.Fatal("Slot mismatch between consensus duty and consensus block: ",
-			b.ConsensusDuty.Slot, " vs ", cBlockSlot)
-	}
-
-	// Expand for upcoming forks
-	var proposerIndex uint64
-	if consensusBlock.Altair != nil {
-		proposerIndex = uint64(consensusBlock.Altair.Message.ProposerIndex)
-	} else if consensusBlock.Bellatrix != nil {
-		proposerIndex = uint64(consensusBlock.Bellatrix.Message.ProposerIndex)
-	} else if consensusBlock.Capella != nil {
-		proposerIndex = uint64(consensusBlock.Capella.Message.ProposerIndex)
-	} else if consensusBlock.Deneb != nil {
-		proposerIndex = uint64(consensusBlock.Deneb.Message.ProposerIndex)
-	} else {
-		log.Fatal("Block was empty, cant get proposer index")
+			b.ConsensusDuty.Slot, " vs ", versioned.Slot)
 	}
 
 	// Sanity check
-	if uint64(b.ConsensusDuty.ValidatorIndex) != proposerIndex {
+	if b.ConsensusDuty.ValidatorIndex != versioned.ProposerIndex {
 		log.Fatal("Proposer index mismatch between consensus duty and consensus block: ",
-			b.ConsensusDuty.ValidatorIndex, " vs ", proposerIndex)
+			b.ConsensusDuty.ValidatorIndex, " vs ", versioned.ProposerIndex)
 	}
 
 	b.ConsensusBlock = consensusBlock
@@ -280,34 +253,17 @@ func (b *FullBlock) SetEvents(events *Events) {
 	}
 
 	b.Events = events
-
-	// Special case. Temporal fix. We artificially add the mev reward for this block.
-	// See: https://github.com/dappnode/mev-sp-oracle/pull/230
-	// See: https://beaconcha.in/slot/10400574
-	if b.ChainId == MainnetChainId && b.GetSlotUint64() == ExceptionSlotMainnet1 {
-		log.Warn("Special case: MEV reward hardcoded. See MevRewardInWei for more info")
-		b.Events.EtherReceived = append(b.Events.EtherReceived, &contract.ContractEtherReceived{
-			Sender:         common.Address{},
-			DonationAmount: big.NewInt(177043568463114308),
-			Raw: types.Log{
-				Address:     common.Address{},
-				Topics:      []common.Hash{},
-				Data:        []byte{},
-				BlockNumber: 0,
-				TxHash:      common.Hash{},
-				TxIndex:     0,
-				BlockHash:   common.Hash{},
-				Index:       0,
-				Removed:     false,
-			},
-		})
-	}
 }
 
 // Returns if there was an mev reward and its amount and fee recipient if any
 // Example: https://prater.beaconcha.in/slot/5307417 (0.53166 Eth)
 func (b *FullBlock) MevRewardInWei() (*big.Int, bool, string) {
 
+	// Mev rewards that can't be detected from the block, see RewardOverride
+	if b.RewardOverride != nil {
+		return new(big.Int).Set(b.RewardOverride.RewardWei), true, b.RewardOverride.Recipient
+	}
+
 	txs := b.GetBlockTransactions()
 
 	// Check if block is empty (no txs)
@@ -333,46 +289,30 @@ func (b *FullBlock) MevRewardInWei() (*big.Int, bool, string) {
 		log.Fatal("could not get tx sender: ", err)
 	}
 
-	whitelistedBuilders, found := WhitelistedBuilders[b.ChainId]
-	if !found {
-		log.Fatal("Chain not found in whitelisted builders: ", b.ChainId)
-	}
-
-	// Special case. To be fixed.
-	// This block has a mev but the last contains a self destruct which
-	// makes the EtherReceived event to not be triggered.
-	// It could be solved with debug_traceTransaction but this call is expensive
-	// and requires an archival execution node running with debug.
-	// Since this is rare, we just hardcode the mev reward for this block by now.
-	// https://beaconcha.in/slot/10400574
-	if b.ChainId == MainnetChainId && b.GetSlotUint64() == ExceptionSlotMainnet1 {
-		hardcodedMevReward := big.NewInt(177043568463114308)
-		hardcodedMevRecipient := "0xAdFb8D27671F14f297eE94135e266aAFf8752e35"
-		log.WithFields(log.Fields{
-			"Network":            MainnetChainId,
-			"Slot":               ExceptionSlotMainnet1,
-			"HardcodedMevReward": hardcodedMevReward,
-			"MevRecipient":       hardcodedMevRecipient,
-		}).Info("Special case: MEV reward hardcoded")
-
-		return hardcodedMevReward,
-			true,
-			hardcodedMevRecipient
-	}
-
 	// Mev rewards are sent in the last tx. This tx sender
 	// matches the fee recipient of the protocol.
 	// We also consider a MEV reward if the tx comes from a whitelisted builder. This
 	// is rare, but has happened: https://beaconcha.in/slot/9444748
 	if utils.Equals(b.GetFeeRecipient(), sender.String()) ||
-		utils.IsIn(sender.String(), whitelistedBuilders) {
+		utils.IsIn(sender.String(), b.WhitelistedBuilders) {
 
-		if utils.IsIn(sender.String(), whitelistedBuilders) {
+		if utils.IsIn(sender.String(), b.WhitelistedBuilders) {
 			log.WithFields(log.Fields{
 				"LastTxSender":       sender.String(),
-				"WhitelistedAddress": whitelistedBuilders,
+				"WhitelistedAddress": b.WhitelistedBuilders,
 			}).Info("Last block tx was sent by whitelisted builder")
 		}
+		// If the last tx was traced, the value is followed through the contracts
+		// that forward it. This also detects rewards that don't trigger the
+		// EtherReceived event, eg sent with a self destruct
+		if b.LastTxTrace != nil {
+			destination := b.LastTxTrace.valueDestination()
+			if destination == nil {
+				return big.NewInt(0), false, ""
+			}
+			return new(big.Int).Set(destination.value()), true, strings.ToLower(destination.To)
+		}
+
 		// MEV reward can also be sent via a smart contract, in which case the
 		// receiver is the pool address. Example:
 		// https://etherscan.io/tx/0x6c9adaa16946d1279e0db0fc9348201c48b2f70a62ac5edfe06dc0ba2b4f3e3c
@@ -392,6 +332,24 @@ func (b *FullBlock) MevRewardInWei() (*big.Int, bool, string) {
 	return big.NewInt(0), false, ""
 }
 
+// Returns the sender of the last tx of the block, which for mev rewards is the
+// builder that paid them. Empty if the block has no txs.
+func (b *FullBlock) lastTxSender() string {
+	txs := b.GetBlockTransactions()
+	if len(txs) == 0 {
+		return ""
+	}
+	tx, err := utils.DecodeTx(txs[len(txs)-1])
+	if err != nil {
+		log.Fatal("could not decode tx: ", err)
+	}
+	sender, err := utils.GetTxSender(tx)
+	if err != nil {
+		log.Fatal("could not get tx sender: ", err)
+	}
+	return sender.String()
+}
+
 // Returns if the address received any reward, its amount and its type. A reward
 // can be i) mev (MEV reward) or ii) vanila (just fees as per EIP1559)
 // For the oracle, a reward is either one type or the other. It cannot be both
@@ -567,6 +525,11 @@ func (b *FullBlock) GetDonations(poolAddress string) []*contract.ContractEtherRe
 		return b.Events.EtherReceived
 	}
 
+	// Rewards sent with a self destruct don't trigger any event
+	if !b.mevRewardTriggersEvent() {
+		return b.Events.EtherReceived
+	}
+
 	// If the pool got an mev reward, we must filter the mev reward
 	// from the event, as thats not considered a donation
 	filteredEvents := make([]*contract.ContractEtherReceived, 0)
@@ -588,6 +551,26 @@ func (b *FullBlock) GetDonations(poolAddress string) []*contract.ContractEtherRe
 	return filteredEvents
 }
 
+// Returns true if the block sends an mev reward that doesn't go directly to the
+// pool and wasn't traced yet, since a contract could forward it to the pool
+func (b *FullBlock) needsMevTrace(poolAddress string) bool {
+	if b.ConsensusBlock == nil || b.RewardOverride != nil || b.LastTxTrace != nil {
+		return false
+	}
+	_, isMev, mevRecipient := b.MevRewardInWei()
+	return isMev && !strings.EqualFold(mevRecipient, poolAddress)
+}
+
+// Returns false if the mev reward reached its recipient with a self destruct,
+// which unlike a transfer doesn't trigger the EtherReceived event of the pool
+func (b *FullBlock) mevRewardTriggersEvent() bool {
+	if b.RewardOverride != nil || b.LastTxTrace == nil {
+		return true
+	}
+	destination := b.LastTxTrace.valueDestination()
+	return destination == nil || destination.Type != "SELFDESTRUCT"
+}
+
 // Since storing the full block is expensive, we store a summarized version of it
 func (b *FullBlock) SummarizedBlock(oracle *Oracle, poolAddress string) SummarizedBlock {
 
@@ -619,13 +602,16 @@ func (b *FullBlock) SummarizedBlock(oracle *Oracle, poolAddress string) Summariz
 		poolBlock.Reward = reward
 		poolBlock.RewardType = rewardType
 		poolBlock.Block = b.GetBlockNumber()
+		if rewardType == MevBlock {
+			poolBlock.Builder = b.lastTxSender()
+		}
 
 		if correctFeeRec {
 			// If the fee recipient was correct
 			poolBlock.BlockType = OkPoolProposal
 			if withdrawalType == BlsWithdrawal {
 				poolBlock.BlockType = OkPoolProposalBlsKeys
-			} else if withdrawalType == Eth1Withdrawal {
+			} else if withdrawalType.HasEth1Address() {
 				poolBlock.BlockType = OkPoolProposal
 			} else {
 				log.Fatal("Unknown withdrawal type: ", withdrawalType)
@@ -639,58 +625,51 @@ func (b *FullBlock) SummarizedBlock(oracle *Oracle, poolAddress string) Summariz
 	return poolBlock
 }
 
+// Returns the fields of the consensus block, depending on the fork version
+func (b *FullBlock) versionedBlock() *versionedBlock {
+	versioned, err := getVersionedBlock(b.ConsensusBlock)
+	if err != nil {
+		log.Fatal("Could not get consensus block fields: ", err)
+	}
+	return versioned
+}
+
+// Returns the execution payload of the block, depending on the fork version
+func (b *FullBlock) executionPayload() *versionedExecutionPayload {
+	payload, err := getVersionedExecutionPayload(b.ConsensusBlock)
+	if err != nil {
+		log.Fatal("Could not get execution payload: ", err)
+	}
+	return payload
+}
+
 // Returns the fee recipient of the block, depending on the fork version
 func (b *FullBlock) GetFeeRecipient() string {
-	var feeRecipient string
-
-	if b.ConsensusBlock.Altair != nil {
-		log.Fatal("Altair block has no fee recipient")
-	} else if b.ConsensusBlock.Bellatrix != nil {
-		feeRecipient = b.ConsensusBlock.Bellatrix.Message.Body.ExecutionPayload.FeeRecipient.String()
-	} else if b.ConsensusBlock.Capella != nil {
-		feeRecipient = b.ConsensusBlock.Capella.Message.Body.ExecutionPayload.FeeRecipient.String()
-	} else if b.ConsensusBlock.Deneb != nil {
-		feeRecipient = b.ConsensusBlock.Deneb.Message.Body.ExecutionPayload.FeeRecipient.String()
-	} else {
-		log.Fatal("Block was empty, cant get fee recipient")
+	return b.executionPayload().FeeRecipient.String()
+}
+
+// Returns the changes from bls to eth1 withdrawal credentials included in the
+// block, none if it was missed
+func (b *FullBlock) GetBlsToExecutionChanges() []*capella.SignedBLSToExecutionChange {
+	if b.ConsensusBlock == nil {
+		return []*capella.SignedBLSToExecutionChange{}
 	}
-	return feeRecipient
+	return b.versionedBlock().BLSToExecutionChanges
 }
 
 // Returns the transactions of the block depending on the fork version
 func (b *FullBlock) GetBlockTransactions() []bellatrix.Transaction {
-
-	var transactions []bellatrix.Transaction
-	if b.ConsensusBlock.Altair != nil {
-		log.Fatal("Altair block has no transactions in the beacon block")
-	} else if b.ConsensusBlock.Bellatrix != nil {
-		transactions = b.ConsensusBlock.Bellatrix.Message.Body.ExecutionPayload.Transactions
-	} else if b.ConsensusBlock.Capella != nil {
-		transactions = b.ConsensusBlock.Capella.Message.Body.ExecutionPayload.Transactions
-	} else if b.ConsensusBlock.Deneb != nil {
-		transactions = b.ConsensusBlock.Deneb.Message.Body.ExecutionPayload.Transactions
-	} else {
-		log.Fatal("Block was empty, cant get transactions")
-	}
-	return transactions
+	return b.executionPayload().Transactions
 }
 
 // Returns the block number depending on the fork version (as uint64)
 func (b *FullBlock) GetBlockNumber() uint64 {
-	var blockNumber uint64
-
-	if b.ConsensusBlock.Altair != nil {
-		log.Fatal("Altair block has no block number")
-	} else if b.ConsensusBlock.Bellatrix != nil {
-		blockNumber = b.ConsensusBlock.Bellatrix.Message.Body.ExecutionPayload.BlockNumber
-	} else if b.ConsensusBlock.Capella != nil {
-		blockNumber = b.ConsensusBlock.Capella.Message.Body.ExecutionPayload.BlockNumber
-	} else if b.ConsensusBlock.Deneb != nil {
-		blockNumber = b.ConsensusBlock.Deneb.Message.Body.ExecutionPayload.BlockNumber
-	} else {
-		log.Fatal("Block was empty, cant get block number")
-	}
-	return blockNumber
+	return b.executionPayload().BlockNumber
+}
+
+// Returns the execution block hash depending on the fork version
+func (b *FullBlock) GetBlockHash() string {
+	return b.executionPayload().BlockHash.String()
 }
 
 // Returns the block number depending on the fork version (as big.Int)
@@ -700,20 +679,7 @@ func (b *FullBlock) GetBlockNumberBigInt() *big.Int {
 
 // Returns the slot depending on the fork version
 func (b *FullBlock) GetSlot() phase0.Slot {
-	var slot phase0.Slot
-
-	if b.ConsensusBlock.Altair != nil {
-		slot = b.ConsensusBlock.Altair.Message.Slot
-	} else if b.ConsensusBlock.Bellatrix != nil {
-		slot = b.ConsensusBlock.Bellatrix.Message.Slot
-	} else if b.ConsensusBlock.Capella != nil {
-		slot = b.ConsensusBlock.Capella.Message.Slot
-	} else if b.ConsensusBlock.Deneb != nil {
-		slot = b.ConsensusBlock.Deneb.Message.Slot
-	} else {
-		log.Fatal("Block was empty, cant get slot")
-	}
-	return slot
+	return b.versionedBlock().Slot
 }
 
 func (b *FullBlock) GetSlotUint64() uint64 {
@@ -722,20 +688,7 @@ func (b *FullBlock) GetSlotUint64() uint64 {
 
 // Returns the proposed index depending on the fork version
 func (b *FullBlock) GetProposerIndex() phase0.ValidatorIndex {
-	var proposerIndex phase0.ValidatorIndex
-
-	if b.ConsensusBlock.Altair != nil {
-		proposerIndex = b.ConsensusBlock.Altair.Message.ProposerIndex
-	} else if b.ConsensusBlock.Bellatrix != nil {
-		proposerIndex = b.ConsensusBlock.Bellatrix.Message.ProposerIndex
-	} else if b.ConsensusBlock.Capella != nil {
-		proposerIndex = b.ConsensusBlock.Capella.Message.ProposerIndex
-	} else if b.ConsensusBlock.Deneb != nil {
-		proposerIndex = b.ConsensusBlock.Deneb.Message.ProposerIndex
-	} else {
-		log.Fatal("Block was empty, cant get proposer index")
-	}
-	return proposerIndex
+	return b.versionedBlock().ProposerIndex
 }
 
 func (b *FullBlock) GetProposerIndexUint64() uint64 {
@@ -744,45 +697,10 @@ func (b *FullBlock) GetProposerIndexUint64() uint64 {
 
 // Returns the gas used depending on the fork version
 func (b *FullBlock) GetGasUsed() uint64 {
-	var gasUsed uint64
-
-	if b.ConsensusBlock.Altair != nil {
-		log.Fatal("Altair block has no gas used")
-	} else if b.ConsensusBlock.Bellatrix != nil {
-		gasUsed = b.ConsensusBlock.Bellatrix.Message.Body.ExecutionPayload.GasUsed
-	} else if b.ConsensusBlock.Capella != nil {
-		gasUsed = b.ConsensusBlock.Capella.Message.Body.ExecutionPayload.GasUsed
-	} else if b.ConsensusBlock.Deneb != nil {
-		gasUsed = b.ConsensusBlock.Deneb.Message.Body.ExecutionPayload.GasUsed
-	} else {
-		log.Fatal("Block was empty, cant get gas used")
-	}
-	return gasUsed
+	return b.executionPayload().GasUsed
 }
 
-// Returns the base fee per gas depending on the fork version
+// Returns the base fee per gas depending on the fork version, as little endian
 func (b *FullBlock) GetBaseFeePerGas() [32]byte {
-	var baseFeePerGas [32]byte
-
-	if b.ConsensusBlock.Altair != nil {
-		log.Fatal("Altair block has no base fee per gas")
-	} else if b.ConsensusBlock.Bellatrix != nil {
-		baseFeePerGas = b.ConsensusBlock.Bellatrix.Message.Body.ExecutionPayload.BaseFeePerGas
-	} else if b.ConsensusBlock.Capella != nil {
-		baseFeePerGas = b.ConsensusBlock.Capella.Message.Body.ExecutionPayload.BaseFeePerGas
-	} else if b.ConsensusBlock.Deneb != nil {
-		// Due to this change: https://github.com/attestantio/go-eth2-client/commit/acadd726168dac047ab3b13b4aceaf2a6103dab5
-		// the base fee is no longer stored as a [32]byte little endian, but as a big endian. To avoid considering is as an special
-		// case, we convert it to little endian, so that the interface is respected.
-		baseFeePerGasBigEndian := b.ConsensusBlock.Deneb.Message.Body.ExecutionPayload.BaseFeePerGas.Bytes32()
-
-		// big-endian to little-endian
-		for i := 0; i < 32; i++ {
-			baseFeePerGas[i] = baseFeePerGasBigEndian[32-1-i]
-		}
-
-	} else {
-		log.Fatal("Block was empty, cant get base fee per gas")
-	}
-	return baseFeePerGas
+	return b.executionPayload().BaseFeePerGas
 }
@@ -14,10 +14,13 @@ import (
 	"github.com/attestantio/go-eth2-client/spec/altair"
 	"github.com/attestantio/go-eth2-client/spec/bellatrix"
 	"github.com/attestantio/go-eth2-client/spec/capella"
+	"github.com/attestantio/go-eth2-client/spec/deneb"
+	"github.com/attestantio/go-eth2-client/spec/electra"
 	"github.com/attestantio/go-eth2-client/spec/phase0"
 	"github.com/dappnode/mev-sp-oracle/contract"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/holiman/uint256"
 	"github.com/pkg/errors"
 	"github.com/prysmaticlabs/go-bitfield"
 	"github.com/stretchr/testify/require"
@@ -105,6 +108,146 @@ func Test_Getters_Capella(t *testing.T) {
 	require.Equal(t, "0x388C818CA8B9251b393131C08a736A67ccB19297", fullBlock.GetFeeRecipient())
 }
 
+func Test_Getters_Deneb(t *testing.T) {
+
+	block := &spec.VersionedSignedBeaconBlock{
+		Version: spec.DataVersionDeneb,
+		Deneb: &deneb.SignedBeaconBlock{
+			Message: &deneb.BeaconBlock{
+				Slot:          5214140,
+				ProposerIndex: 12,
+				Body: &deneb.BeaconBlockBody{
+					ExecutionPayload: &deneb.ExecutionPayload{
+						FeeRecipient:  [20]byte{56, 140, 129, 140, 168, 185, 37, 27, 57, 49, 49, 192, 138, 115, 106, 103, 204, 177, 146, 151},
+						BlockNumber:   1000,
+						GasUsed:       21000,
+						BaseFeePerGas: uint256.NewInt(258),
+						Transactions: []bellatrix.Transaction{
+							{9, 10}},
+					},
+				},
+			},
+		}}
+
+	fullBlock := NewFullBlock(&v1.ProposerDuty{
+		Slot:           5214140,
+		ValidatorIndex: phase0.ValidatorIndex(12)},
+		&v1.Validator{
+			Index: 12,
+		},
+		uint64(0))
+	fullBlock.SetConsensusBlock(block)
+
+	// Base fee is little endian, as in previous forks
+	require.Equal(t, [32]uint8{0x2, 0x1}, fullBlock.GetBaseFeePerGas())
+	require.Equal(t, uint64(21000), fullBlock.GetGasUsed())
+	require.Equal(t, phase0.ValidatorIndex(12), fullBlock.GetProposerIndex())
+	require.Equal(t, phase0.Slot(5214140), fullBlock.GetSlot())
+	require.Equal(t, uint64(1000), fullBlock.GetBlockNumber())
+	require.Equal(t, []bellatrix.Transaction{{0x9, 0xa}}, fullBlock.GetBlockTransactions())
+	require.Equal(t, "0x388C818CA8B9251b393131C08a736A67ccB19297", fullBlock.GetFeeRecipient())
+}
+
+func Test_Getters_Electra(t *testing.T) {
+	block, err := LoadConsensusBlock(3710977, "17000")
+	require.NoError(t, err)
+	require.Equal(t, spec.DataVersionElectra, block.Version)
+
+	fullBlock := NewFullBlock(&v1.ProposerDuty{
+		Slot:           3710977,
+		ValidatorIndex: phase0.ValidatorIndex(12)},
+		&v1.Validator{
+			Index: 12,
+		},
+		uint64(0))
+	fullBlock.SetConsensusBlock(block)
+
+	// Same payload as deneb, base fee is little endian
+	require.Equal(t, [32]uint8{0x2, 0x1}, fullBlock.GetBaseFeePerGas())
+	require.Equal(t, uint64(42000), fullBlock.GetGasUsed())
+	require.Equal(t, phase0.ValidatorIndex(12), fullBlock.GetProposerIndex())
+	require.Equal(t, phase0.Slot(3710977), fullBlock.GetSlot())
+	require.Equal(t, uint64(3419705), fullBlock.GetBlockNumber())
+	require.Equal(t, []bellatrix.Transaction{{0x2, 0x1}, {0x2, 0x2}}, fullBlock.GetBlockTransactions())
+	require.Equal(t, "0x388C818CA8B9251b393131C08a736A67ccB19297", fullBlock.GetFeeRecipient())
+
+	versioned, err := getVersionedBlock(block)
+	require.NoError(t, err)
+	owner := bellatrix.ExecutionAddress(common.HexToAddress("0x2000000000000000000000000000000000000000"))
+	require.Equal(t, []*electra.WithdrawalRequest{
+		{SourceAddress: owner, ValidatorPubkey: phase0.BLSPubKey{0x01}, Amount: 0},
+	}, versioned.ExecutionRequests.Withdrawals)
+	require.Equal(t, []*electra.ConsolidationRequest{
+		{SourceAddress: owner, SourcePubkey: phase0.BLSPubKey{0x02}, TargetPubkey: phase0.BLSPubKey{0x03}},
+	}, versioned.ExecutionRequests.Consolidations)
+}
+
+func Test_Getters_Fulu(t *testing.T) {
+	block, err := LoadConsensusBlock(5283841, "17000")
+	require.NoError(t, err)
+	require.Equal(t, spec.DataVersionFulu, block.Version)
+
+	fullBlock := NewFullBlock(&v1.ProposerDuty{
+		Slot:           5283841,
+		ValidatorIndex: phase0.ValidatorIndex(13)},
+		&v1.Validator{
+			Index: 13,
+		},
+		uint64(0))
+	fullBlock.SetConsensusBlock(block)
+
+	require.Equal(t, [32]uint8{0x2, 0x1}, fullBlock.GetBaseFeePerGas())
+	require.Equal(t, uint64(42000), fullBlock.GetGasUsed())
+	require.Equal(t, phase0.ValidatorIndex(13), fullBlock.GetProposerIndex())
+	require.Equal(t, phase0.Slot(5283841), fullBlock.GetSlot())
+	require.Equal(t, uint64(4930001), fullBlock.GetBlockNumber())
+	require.Equal(t, "0x388C818CA8B9251b393131C08a736A67ccB19297", fullBlock.GetFeeRecipient())
+
+	versioned, err := getVersionedBlock(block)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(versioned.ExecutionRequests.Withdrawals))
+	require.Equal(t, 0, len(versioned.ExecutionRequests.Consolidations))
+}
+
+func Test_VersionedBlock_AllVersions(t *testing.T) {
+	// Fails when go-eth2-client adds a fork that the oracle doesn't read yet
+	numVersions := 0
+	for version := spec.DataVersionPhase0; version.String() != "unknown"; version++ {
+		_, found := versionedBlockGetters[version]
+		require.True(t, found, "fork version %s is not supported by the oracle", version.String())
+		numVersions++
+	}
+	require.Equal(t, numVersions, len(versionedBlockGetters))
+
+	// Blocks without execution payload
+	altairBlock := &spec.VersionedSignedBeaconBlock{
+		Version: spec.DataVersionAltair,
+		Altair: &altair.SignedBeaconBlock{
+			Message: &altair.BeaconBlock{Slot: 10, ProposerIndex: 2},
+		},
+	}
+	versioned, err := getVersionedBlock(altairBlock)
+	require.NoError(t, err)
+	require.Equal(t, phase0.Slot(10), versioned.Slot)
+	require.Equal(t, phase0.ValidatorIndex(2), versioned.ProposerIndex)
+	_, err = getVersionedExecutionPayload(altairBlock)
+	require.Error(t, err)
+
+	// Version that doesn't match the block, unknown versions and empty blocks
+	for _, invalid := range []*spec.VersionedSignedBeaconBlock{
+		nil,
+		{Version: spec.DataVersionCapella, Altair: altairBlock.Altair},
+		{Version: spec.DataVersionUnknown, Altair: altairBlock.Altair},
+		{Version: spec.DataVersion(numVersions + 1)},
+		{Version: spec.DataVersionDeneb, Deneb: &deneb.SignedBeaconBlock{}},
+		{Version: spec.DataVersionElectra, Electra: &electra.SignedBeaconBlock{}},
+		{Version: spec.DataVersionFulu, Deneb: &deneb.SignedBeaconBlock{}},
+	} {
+		_, err := getVersionedBlock(invalid)
+		require.Error(t, err)
+	}
+}
+
 // This test uses real mocked blocks that can be fetched and stores with this util:
 // Test_GetFullBlockAtSlot (see onchain_test.go)
 func Test_FullBlock_All(t *testing.T) {
@@ -426,6 +569,40 @@ func LoadFullBlock(slotNumber uint64, chainId string, hasHeaders bool) (*FullBlo
 	return &fullBlock, nil
 }
 
+// Loads a consensus block stored as returned by the beacon node api, only for
+// the forks that are stored this way
+func LoadConsensusBlock(slotNumber uint64, chainId string) (*spec.VersionedSignedBeaconBlock, error) {
+	fileName := fmt.Sprintf("consensusblock_slot_%d_chainid_%s.json", slotNumber, chainId)
+	byteValue, err := os.ReadFile(filepath.Join("../mock", fileName))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read json file")
+	}
+
+	var response struct {
+		Version spec.DataVersion `json:"version"`
+		Data    json.RawMessage  `json:"data"`
+	}
+	if err := json.Unmarshal(byteValue, &response); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal json file")
+	}
+
+	block := &spec.VersionedSignedBeaconBlock{Version: response.Version}
+	switch response.Version {
+	case spec.DataVersionElectra:
+		block.Electra = &electra.SignedBeaconBlock{}
+		err = json.Unmarshal(response.Data, block.Electra)
+	case spec.DataVersionFulu:
+		block.Fulu = &electra.SignedBeaconBlock{}
+		err = json.Unmarshal(response.Data, block.Fulu)
+	default:
+		return nil, errors.New("unsupported version " + response.Version.String())
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal block")
+	}
+	return block, nil
+}
+
 func LoadValidators() (map[phase0.ValidatorIndex]*v1.Validator, error) {
 	path := filepath.Join("../mock", "validators.json")
 	jsonFile, err := os.Open(path)
@@ -0,0 +1,191 @@
+package oracle
+
+import (
+	"fmt"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/bellatrix"
+	"github.com/attestantio/go-eth2-client/spec/capella"
+	"github.com/attestantio/go-eth2-client/spec/deneb"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/pkg/errors"
+)
+
+// Fields of a consensus block used by the oracle, which are in a different
+// struct in each fork version
+type versionedBlock struct {
+	Slot          phase0.Slot
+	ProposerIndex phase0.ValidatorIndex
+	// Nil before bellatrix, blocks had no execution payload
+	ExecutionPayload *versionedExecutionPayload
+	// Empty before capella, credentials could not be changed
+	BLSToExecutionChanges []*capella.SignedBLSToExecutionChange
+	// Nil before electra, validators could not be exited or consolidated
+	// from the execution layer
+	ExecutionRequests *electra.ExecutionRequests
+	// Only read from electra, to tell the exits requested from the execution
+	// layer from the voluntary ones of the same block
+	VoluntaryExits []*phase0.SignedVoluntaryExit
+}
+
+type versionedExecutionPayload struct {
+	FeeRecipient bellatrix.ExecutionAddress
+	Transactions []bellatrix.Transaction
+	BlockNumber  uint64
+	BlockHash    phase0.Hash32
+	GasUsed      uint64
+	// Little endian, as it was stored before deneb
+	BaseFeePerGas [32]byte
+}
+
+// Gets the fields of the block of each fork version. Blocks of a version that
+// is not here can't be processed, so every new fork supported by go-eth2-client
+// must be added.
+var versionedBlockGetters = map[spec.DataVersion]func(*spec.VersionedSignedBeaconBlock) (*versionedBlock, error){
+	spec.DataVersionPhase0: func(block *spec.VersionedSignedBeaconBlock) (*versionedBlock, error) {
+		if block.Phase0 == nil || block.Phase0.Message == nil {
+			return nil, errors.New("phase0 block was empty")
+		}
+		return &versionedBlock{
+			Slot:          block.Phase0.Message.Slot,
+			ProposerIndex: block.Phase0.Message.ProposerIndex,
+		}, nil
+	},
+	spec.DataVersionAltair: func(block *spec.VersionedSignedBeaconBlock) (*versionedBlock, error) {
+		if block.Altair == nil || block.Altair.Message == nil {
+			return nil, errors.New("altair block was empty")
+		}
+		return &versionedBlock{
+			Slot:          block.Altair.Message.Slot,
+			ProposerIndex: block.Altair.Message.ProposerIndex,
+		}, nil
+	},
+	spec.DataVersionBellatrix: func(block *spec.VersionedSignedBeaconBlock) (*versionedBlock, error) {
+		if block.Bellatrix == nil || block.Bellatrix.Message == nil ||
+			block.Bellatrix.Message.Body == nil || block.Bellatrix.Message.Body.ExecutionPayload == nil {
+			return nil, errors.New("bellatrix block was empty")
+		}
+		payload := block.Bellatrix.Message.Body.ExecutionPayload
+		return &versionedBlock{
+			Slot:          block.Bellatrix.Message.Slot,
+			ProposerIndex: block.Bellatrix.Message.ProposerIndex,
+			ExecutionPayload: &versionedExecutionPayload{
+				FeeRecipient:  payload.FeeRecipient,
+				Transactions:  payload.Transactions,
+				BlockNumber:   payload.BlockNumber,
+				BlockHash:     payload.BlockHash,
+				GasUsed:       payload.GasUsed,
+				BaseFeePerGas: payload.BaseFeePerGas,
+			},
+		}, nil
+	},
+	spec.DataVersionCapella: func(block *spec.VersionedSignedBeaconBlock) (*versionedBlock, error) {
+		if block.Capella == nil || block.Capella.Message == nil ||
+			block.Capella.Message.Body == nil || block.Capella.Message.Body.ExecutionPayload == nil {
+			return nil, errors.New("capella block was empty")
+		}
+		payload := block.Capella.Message.Body.ExecutionPayload
+		return &versionedBlock{
+			Slot:                  block.Capella.Message.Slot,
+			ProposerIndex:         block.Capella.Message.ProposerIndex,
+			BLSToExecutionChanges: block.Capella.Message.Body.BLSToExecutionChanges,
+			ExecutionPayload: &versionedExecutionPayload{
+				FeeRecipient:  payload.FeeRecipient,
+				Transactions:  payload.Transactions,
+				BlockNumber:   payload.BlockNumber,
+				BlockHash:     payload.BlockHash,
+				GasUsed:       payload.GasUsed,
+				BaseFeePerGas: payload.BaseFeePerGas,
+			},
+		}, nil
+	},
+	spec.DataVersionDeneb: func(block *spec.VersionedSignedBeaconBlock) (*versionedBlock, error) {
+		if block.Deneb == nil || block.Deneb.Message == nil ||
+			block.Deneb.Message.Body == nil || block.Deneb.Message.Body.ExecutionPayload == nil {
+			return nil, errors.New("deneb block was empty")
+		}
+		return &versionedBlock{
+			Slot:                  block.Deneb.Message.Slot,
+			ProposerIndex:         block.Deneb.Message.ProposerIndex,
+			BLSToExecutionChanges: block.Deneb.Message.Body.BLSToExecutionChanges,
+			ExecutionPayload:      getDenebExecutionPayload(block.Deneb.Message.Body.ExecutionPayload),
+		}, nil
+	},
+	spec.DataVersionElectra: func(block *spec.VersionedSignedBeaconBlock) (*versionedBlock, error) {
+		if block.Electra == nil {
+			return nil, errors.New("electra block was empty")
+		}
+		return getElectraBlock(block.Electra, "electra")
+	},
+	// Fulu didn't change the block, it reuses the electra types
+	spec.DataVersionFulu: func(block *spec.VersionedSignedBeaconBlock) (*versionedBlock, error) {
+		if block.Fulu == nil {
+			return nil, errors.New("fulu block was empty")
+		}
+		return getElectraBlock(block.Fulu, "fulu")
+	},
+}
+
+// Returns the fields of a block with the electra types, which later forks reuse
+func getElectraBlock(block *electra.SignedBeaconBlock, name string) (*versionedBlock, error) {
+	if block.Message == nil || block.Message.Body == nil || block.Message.Body.ExecutionPayload == nil {
+		return nil, errors.New(name + " block was empty")
+	}
+	return &versionedBlock{
+		Slot:                  block.Message.Slot,
+		ProposerIndex:         block.Message.ProposerIndex,
+		BLSToExecutionChanges: block.Message.Body.BLSToExecutionChanges,
+		ExecutionPayload:      getDenebExecutionPayload(block.Message.Body.ExecutionPayload),
+		ExecutionRequests:     block.Message.Body.ExecutionRequests,
+		VoluntaryExits:        block.Message.Body.VoluntaryExits,
+	}, nil
+}
+
+// Returns the fields of an execution payload from deneb onwards
+func getDenebExecutionPayload(payload *deneb.ExecutionPayload) *versionedExecutionPayload {
+	// Due to this change: https://github.com/attestantio/go-eth2-client/commit/acadd726168dac047ab3b13b4aceaf2a6103dab5
+	// the base fee is no longer stored as a [32]byte little endian, but as a big endian. To avoid considering is as an special
+	// case, we convert it to little endian, so that the interface is respected.
+	var baseFeePerGas [32]byte
+	if payload.BaseFeePerGas != nil {
+		baseFeePerGasBigEndian := payload.BaseFeePerGas.Bytes32()
+		for i := 0; i < 32; i++ {
+			baseFeePerGas[i] = baseFeePerGasBigEndian[32-1-i]
+		}
+	}
+
+	return &versionedExecutionPayload{
+		FeeRecipient:  payload.FeeRecipient,
+		Transactions:  payload.Transactions,
+		BlockNumber:   payload.BlockNumber,
+		BlockHash:     payload.BlockHash,
+		GasUsed:       payload.GasUsed,
+		BaseFeePerGas: baseFeePerGas,
+	}
+}
+
+// Returns the fields of the block, or an error if its fork version is not supported
+func getVersionedBlock(block *spec.VersionedSignedBeaconBlock) (*versionedBlock, error) {
+	if block == nil {
+		return nil, errors.New("consensus block is nil")
+	}
+	getter, found := versionedBlockGetters[block.Version]
+	if !found {
+		return nil, errors.New(fmt.Sprintf("unsupported fork version %s (%d) of consensus block, the oracle must be updated",
+			block.Version.String(), block.Version))
+	}
+	return getter(block)
+}
+
+// Returns the execution payload of the block, or an error if it has none
+func getVersionedExecutionPayload(block *spec.VersionedSignedBeaconBlock) (*versionedExecutionPayload, error) {
+	versioned, err := getVersionedBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	if versioned.ExecutionPayload == nil {
+		return nil, errors.New(block.Version.String() + " block has no execution payload")
+	}
+	return versioned.ExecutionPayload, nil
+}
@@ -0,0 +1,150 @@
+package oracle
+
+import (
+	"encoding/hex"
+	"math/big"
+
+	v1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/capella"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	log "github.com/sirupsen/logrus"
+)
+
+// Rewards of the proposals to the pool of a validator with bls withdrawal
+// credentials, that can't be subscribed until it changes them. Settled once
+// the credentials change, or if the validator exits before changing them.
+type BlsCredit struct {
+	ValidatorIndex uint64   `json:"validator_index"`
+	ValidatorKey   string   `json:"validator_key"`
+	Slots          []uint64 `json:"slots"`
+	HeldWei        *big.Int `json:"held_wei"`
+
+	SettledSlot       uint64   `json:"settled_slot,omitempty"`
+	WithdrawalAddress string   `json:"withdrawal_address,omitempty"`
+	CreditedWei       *big.Int `json:"credited_wei,omitempty"`
+	PoolWei           *big.Int `json:"pool_wei,omitempty"`
+}
+
+func (c *BlsCredit) isSettled() bool {
+	return c.SettledSlot != 0
+}
+
+func (c *BlsCredit) settle(slot uint64, credited *big.Int, pool *big.Int) {
+	c.SettledSlot = slot
+	c.CreditedWei = credited
+	c.PoolWei = pool
+	c.HeldWei = big.NewInt(0)
+}
+
+// Holds the reward of a proposal with bls withdrawal credentials, so that it
+// can be credited once the validator changes them
+func (or *Oracle) holdBlsReward(block SummarizedBlock) {
+	if or.state.BlsCredits == nil {
+		or.state.BlsCredits = make(map[uint64]*BlsCredit)
+	}
+	credit, found := or.state.BlsCredits[block.ValidatorIndex]
+	if !found {
+		credit = &BlsCredit{
+			ValidatorIndex: block.ValidatorIndex,
+			ValidatorKey:   block.ValidatorKey,
+			Slots:          make([]uint64, 0),
+			HeldWei:        big.NewInt(0),
+		}
+		or.state.BlsCredits[block.ValidatorIndex] = credit
+	}
+	credit.Slots = append(credit.Slots, block.Slot)
+	credit.HeldWei.Add(credit.HeldWei, block.Reward)
+
+	log.WithFields(log.Fields{
+		"Slot":           block.Slot,
+		"ValidatorIndex": block.ValidatorIndex,
+		"RewardWei":      block.Reward,
+		"HeldWei":        credit.HeldWei,
+	}).Info("[Reward] Holding reward of validator with bls keys until it changes its credentials")
+}
+
+// Credits the held rewards of the validators whose credentials changed to an eth1
+// address in this block. They are subscribed as if their proposals were done now,
+// so the rewards are shared among the pool and their pending is consolidated.
+// The penalty goes to the pool.
+func (or *Oracle) handleBlsToExecutionChanges(changes []*capella.SignedBLSToExecutionChange) {
+	if len(or.state.BlsCredits) == 0 {
+		return
+	}
+	for _, change := range changes {
+		if change == nil || change.Message == nil {
+			continue
+		}
+		valIndex := uint64(change.Message.ValidatorIndex)
+		credit, found := or.state.BlsCredits[valIndex]
+		if !found || credit.isSettled() {
+			continue
+		}
+
+		withdrawalAddress := "0x" + hex.EncodeToString(change.Message.ToExecutionAddress[:])
+		toPool := new(big.Int).Mul(credit.HeldWei, big.NewInt(int64(or.blsCreditPenaltyOver10000())))
+		toPool.Div(toPool, big.NewInt(100*100))
+		toCredit := new(big.Int).Sub(credit.HeldWei, toPool)
+
+		or.addSubscription(valIndex, withdrawalAddress, credit.ValidatorKey)
+		or.advanceStateMachine(valIndex, ProposalOk)
+		or.sendRewardToPool(toPool)
+		or.increaseAllPendingRewards(toCredit)
+		or.consolidateBalance(valIndex)
+
+		credit.settle(or.state.NextSlotToProcess, toCredit, toPool)
+		credit.WithdrawalAddress = withdrawalAddress
+
+		log.WithFields(log.Fields{
+			"ValidatorIndex":    valIndex,
+			"WithdrawalAddress": withdrawalAddress,
+			"Proposals":         len(credit.Slots),
+			"CreditedWei":       toCredit,
+			"PoolWei":           toPool,
+		}).Info("[Bls credit] Credentials changed, validator subscribed with its held rewards")
+	}
+}
+
+// Sends to the pool the held rewards of the validators that exited without
+// changing their credentials
+func (or *Oracle) releaseExitedBlsCredits(validators map[phase0.ValidatorIndex]*v1.Validator, slot uint64) {
+	for valIndex, credit := range or.state.BlsCredits {
+		if credit.isSettled() {
+			continue
+		}
+		validator, found := validators[phase0.ValidatorIndex(valIndex)]
+		if !found || validator.Status.IsActive() {
+			continue
+		}
+
+		toPool := new(big.Int).Set(credit.HeldWei)
+		or.sendRewardToPool(toPool)
+		credit.settle(slot, big.NewInt(0), toPool)
+
+		log.WithFields(log.Fields{
+			"ValidatorIndex":       valIndex,
+			"BeaconValidatorState": validator.Status,
+			"PoolWei":              toPool,
+		}).Info("[Bls credit] Validator exited with bls keys, held rewards sent to the pool")
+	}
+}
+
+// Returns the indices of the validators with held rewards
+func (or *Oracle) unsettledBlsCredits() []uint64 {
+	indices := make([]uint64, 0)
+	for valIndex, credit := range or.state.BlsCredits {
+		if !credit.isSettled() {
+			indices = append(indices, valIndex)
+		}
+	}
+	return indices
+}
+
+// Returns the total of the rewards that are held, owed by the pool
+func (or *Oracle) heldBlsRewardsWei() *big.Int {
+	held := big.NewInt(0)
+	for _, credit := range or.state.BlsCredits {
+		held.Add(held, credit.HeldWei)
+	}
+	return held
+}
@@ -0,0 +1,162 @@
+package oracle
+
+import (
+	"math/big"
+	"testing"
+
+	v1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/bellatrix"
+	"github.com/attestantio/go-eth2-client/spec/capella"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/avast/retry-go/v4"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func newBlsCreditsTestOracle(t *testing.T, penalty int) *Oracle {
+	oracle := newOracleWithRules(t, 100, RetroactiveBlsCredits)
+	ruleFork(oracle.forks, RetroactiveBlsCredits).BlsCreditPenaltiesOver10000 = map[string]int{"mainnet": penalty}
+	return oracle
+}
+
+func newBlsProposal(slot uint64, valIndex uint64, reward int64) SummarizedBlock {
+	return SummarizedBlock{
+		Slot:           slot,
+		ValidatorIndex: valIndex,
+		ValidatorKey:   "0xaa",
+		BlockType:      OkPoolProposalBlsKeys,
+		Reward:         big.NewInt(reward),
+		RewardType:     VanilaBlock,
+	}
+}
+
+func newBlsToExecutionChange(valIndex uint64, address string) *capella.SignedBLSToExecutionChange {
+	return &capella.SignedBLSToExecutionChange{
+		Message: &capella.BLSToExecutionChange{
+			ValidatorIndex:     phase0.ValidatorIndex(valIndex),
+			ToExecutionAddress: bellatrix.ExecutionAddress(common.HexToAddress(address)),
+		},
+	}
+}
+
+func Test_BlsCredits_BeforeFork(t *testing.T) {
+	oracle := newBlsCreditsTestOracle(t, 0)
+	oracle.handleBlsCorrectBlockProposal(newBlsProposal(99, 5, 1000))
+	require.Equal(t, big.NewInt(1000), oracle.state.PoolAccumulatedFees)
+	require.Nil(t, oracle.state.BlsCredits)
+
+	// The credentials change has nothing to credit
+	oracle.handleBlsToExecutionChanges([]*capella.SignedBLSToExecutionChange{
+		newBlsToExecutionChange(5, "0x2000000000000000000000000000000000000000"),
+	})
+	require.False(t, oracle.isTracked(5))
+	require.NoError(t, oracle.RunOffchainReconciliation())
+}
+
+func Test_BlsCredits_CreditedOnCredentialsChange(t *testing.T) {
+	oracle := newBlsCreditsTestOracle(t, 1000)
+	oracle.handleBlsCorrectBlockProposal(newBlsProposal(100, 5, 600))
+	oracle.handleBlsCorrectBlockProposal(newBlsProposal(150, 5, 400))
+
+	// Held, not sent to the pool, but still owed
+	require.Equal(t, big.NewInt(0), oracle.state.PoolAccumulatedFees)
+	require.Equal(t, []uint64{100, 150}, oracle.state.BlsCredits[5].Slots)
+	require.Equal(t, big.NewInt(1000), oracle.state.BlsCredits[5].HeldWei)
+	require.Equal(t, []uint64{5}, oracle.unsettledBlsCredits())
+	require.False(t, oracle.isTracked(5))
+	require.NoError(t, oracle.RunOffchainReconciliation())
+
+	// Changes of other validators are ignored
+	oracle.state.NextSlotToProcess = 200
+	oracle.handleBlsToExecutionChanges([]*capella.SignedBLSToExecutionChange{
+		newBlsToExecutionChange(6, "0x2000000000000000000000000000000000000000"),
+		newBlsToExecutionChange(5, "0x2000000000000000000000000000000000000000"),
+	})
+	require.False(t, oracle.isTracked(6))
+
+	// Subscribed as if it proposed now, the penalty goes to the pool
+	validator := oracle.state.Validators[5]
+	require.Equal(t, Active, validator.ValidatorStatus)
+	require.Equal(t, Auto, validator.SubscriptionType)
+	require.Equal(t, "0x2000000000000000000000000000000000000000", validator.WithdrawalAddress)
+	require.Equal(t, "0xaa", validator.ValidatorKey)
+
+	// 100 penalty, and a pool cut of 90 of the 900 credited
+	require.Equal(t, big.NewInt(810), validator.AccumulatedRewardsWei)
+	require.Equal(t, big.NewInt(0), validator.PendingRewardsWei)
+	require.Equal(t, big.NewInt(100+90), oracle.state.PoolAccumulatedFees)
+
+	credit := oracle.state.BlsCredits[5]
+	require.Equal(t, uint64(200), credit.SettledSlot)
+	require.Equal(t, big.NewInt(900), credit.CreditedWei)
+	require.Equal(t, big.NewInt(100), credit.PoolWei)
+	require.Equal(t, big.NewInt(0), credit.HeldWei)
+	require.Equal(t, 0, len(oracle.unsettledBlsCredits()))
+	require.NoError(t, oracle.RunOffchainReconciliation())
+
+	// Settled only once
+	oracle.handleBlsToExecutionChanges([]*capella.SignedBLSToExecutionChange{
+		newBlsToExecutionChange(5, "0x2000000000000000000000000000000000000000"),
+	})
+	require.Equal(t, big.NewInt(810), validator.AccumulatedRewardsWei)
+}
+
+func Test_BlsCredits_ReleasedOnExit(t *testing.T) {
+	oracle := newBlsCreditsTestOracle(t, 0)
+	oracle.handleBlsCorrectBlockProposal(newBlsProposal(100, 5, 1000))
+	oracle.handleBlsCorrectBlockProposal(newBlsProposal(110, 6, 500))
+
+	status := map[phase0.ValidatorIndex]v1.ValidatorState{
+		5: v1.ValidatorStateActiveOngoing,
+		6: v1.ValidatorStateExitedUnslashed,
+	}
+	requested := make([]phase0.ValidatorIndex, 0)
+	oracle.SetGetSetOfValidatorsFunc(func(valIndices []phase0.ValidatorIndex, slot string, opts ...retry.Option) (map[phase0.ValidatorIndex]*v1.Validator, error) {
+		requested = valIndices
+		validators := make(map[phase0.ValidatorIndex]*v1.Validator)
+		for _, index := range valIndices {
+			validators[index] = &v1.Validator{
+				Index:     index,
+				Status:    status[index],
+				Validator: &phase0.Validator{WithdrawalCredentials: make([]byte, 32)},
+			}
+		}
+		return validators, nil
+	})
+
+	// The validators with held rewards are checked, even if not tracked
+	cleanupSlot := Fork1.ActivationSlot("mainnet")
+	require.NoError(t, oracle.ValidatorCleanup(cleanupSlot))
+	require.ElementsMatch(t, []phase0.ValidatorIndex{5, 6}, requested)
+	require.Equal(t, big.NewInt(1000), oracle.state.BlsCredits[5].HeldWei)
+	require.Equal(t, cleanupSlot, oracle.state.BlsCredits[6].SettledSlot)
+	require.Equal(t, big.NewInt(500), oracle.state.BlsCredits[6].PoolWei)
+	require.Equal(t, big.NewInt(500), oracle.state.PoolAccumulatedFees)
+	require.NoError(t, oracle.RunOffchainReconciliation())
+
+	// Settled ones are not checked anymore
+	require.NoError(t, oracle.ValidatorCleanup(cleanupSlot+1200))
+	require.Equal(t, []phase0.ValidatorIndex{5}, requested)
+}
+
+func Test_GetBlsToExecutionChanges(t *testing.T) {
+	block := newReplayTestBlock(100)
+	require.Equal(t, 0, len(block.GetBlsToExecutionChanges()))
+
+	change := newBlsToExecutionChange(5, "0x2000000000000000000000000000000000000000")
+	block.SetConsensusBlock(&spec.VersionedSignedBeaconBlock{
+		Version: spec.DataVersionCapella,
+		Capella: &capella.SignedBeaconBlock{
+			Message: &capella.BeaconBlock{
+				Slot:          100,
+				ProposerIndex: 12,
+				Body: &capella.BeaconBlockBody{
+					ExecutionPayload:      &capella.ExecutionPayload{},
+					BLSToExecutionChanges: []*capella.SignedBLSToExecutionChange{change},
+				},
+			},
+		},
+	})
+	require.Equal(t, []*capella.SignedBLSToExecutionChange{change}, block.GetBlsToExecutionChanges())
+}
@@ -0,0 +1,169 @@
+package oracle
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// Known whitelisted builders of each network, in builders/<network>.json
+//
+//go:embed builders/*.json
+var embeddedWhitelistedBuilders embed.FS
+
+// Builder whose payments in the last tx of a block are considered mev rewards,
+// even if it's not the fee recipient of the block. Active from ActivationSlot
+// until DeactivationSlot (not included), 0 meaning it's never deactivated.
+type WhitelistedBuilder struct {
+	Address          string `json:"address"`
+	ActivationSlot   uint64 `json:"activation_slot"`
+	DeactivationSlot uint64 `json:"deactivation_slot,omitempty"`
+	JustificationUrl string `json:"justification_url"`
+}
+
+// Loads the whitelisted builders of the network, embedded in the binary, plus
+// the ones in the given json file, if any. Since they are part of the state, the
+// builders that applied to its processed slots can't change, so the ones of the
+// file must be activated at a future slot. Returns them sorted by address and
+// activation slot.
+func LoadWhitelistedBuilders(network string, path string) ([]*WhitelistedBuilder, error) {
+	builders := make([]*WhitelistedBuilder, 0)
+
+	rawBytes, err := embeddedWhitelistedBuilders.ReadFile("builders/" + network + ".json")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.Wrap(err, "could not read embedded whitelisted builders")
+	}
+	if err == nil {
+		embedded, err := parseWhitelistedBuilders(rawBytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid embedded whitelisted builders of "+network)
+		}
+		builders = append(builders, embedded...)
+	}
+
+	if path != "" {
+		rawBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read whitelisted builders file")
+		}
+		external, err := parseWhitelistedBuilders(rawBytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid whitelisted builders file "+path)
+		}
+		builders = append(builders, external...)
+	}
+
+	err = sortWhitelistedBuilders(builders)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid whitelisted builders")
+	}
+	return builders, nil
+}
+
+func parseWhitelistedBuilders(rawBytes []byte) ([]*WhitelistedBuilder, error) {
+	var builders []*WhitelistedBuilder
+	err := json.Unmarshal(rawBytes, &builders)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal whitelisted builders")
+	}
+
+	for _, builder := range builders {
+		if !common.IsHexAddress(builder.Address) {
+			return nil, errors.New("builder is not a valid address: " + builder.Address)
+		}
+		// Same format no matter how it was written, so they can be compared
+		builder.Address = common.HexToAddress(builder.Address).String()
+		if builder.DeactivationSlot != 0 && builder.DeactivationSlot <= builder.ActivationSlot {
+			return nil, errors.New(fmt.Sprintf("builder %s must be deactivated after its activation slot %d",
+				builder.Address, builder.ActivationSlot))
+		}
+		if builder.JustificationUrl == "" {
+			return nil, errors.New(fmt.Sprintf("builder %s must have a justification url", builder.Address))
+		}
+	}
+
+	err = sortWhitelistedBuilders(builders)
+	if err != nil {
+		return nil, err
+	}
+	return builders, nil
+}
+
+// Sorts the builders by address and activation slot, ensuring the same builder
+// is not whitelisted twice at the same time
+func sortWhitelistedBuilders(builders []*WhitelistedBuilder) error {
+	sort.Slice(builders, func(i, j int) bool {
+		if builders[i].Address != builders[j].Address {
+			return builders[i].Address < builders[j].Address
+		}
+		return builders[i].ActivationSlot < builders[j].ActivationSlot
+	})
+
+	// A builder can be whitelisted again after being deactivated, but not twice
+	for i := 1; i < len(builders); i++ {
+		previous := builders[i-1]
+		if builders[i].Address == previous.Address &&
+			(previous.DeactivationSlot == 0 || previous.DeactivationSlot > builders[i].ActivationSlot) {
+			return errors.New(fmt.Sprintf("builder %s is whitelisted more than once at slot %d",
+				builders[i].Address, builders[i].ActivationSlot))
+		}
+	}
+	return nil
+}
+
+// Returns if the builder is whitelisted at the given slot
+func (w *WhitelistedBuilder) IsActive(slot uint64) bool {
+	return slot >= w.ActivationSlot && (w.DeactivationSlot == 0 || slot < w.DeactivationSlot)
+}
+
+// Returns the addresses of the builders that are whitelisted at the given slot
+func activeBuilders(builders []*WhitelistedBuilder, slot uint64) []string {
+	addresses := make([]string, 0)
+	for _, builder := range builders {
+		if builder.IsActive(slot) {
+			addresses = append(addresses, builder.Address)
+		}
+	}
+	return addresses
+}
+
+// Returns how the builders apply to the slots up to the given one, included:
+// the ones activated by then, still active after it if they are deactivated
+// later. Justification urls are left out, since they don't change which
+// rewards are detected.
+func whitelistedBuildersUntil(builders []*WhitelistedBuilder, slot uint64) []*WhitelistedBuilder {
+	until := make([]*WhitelistedBuilder, 0)
+	for _, builder := range builders {
+		if builder.ActivationSlot > slot {
+			continue
+		}
+		deactivationSlot := builder.DeactivationSlot
+		if deactivationSlot > slot {
+			deactivationSlot = 0
+		}
+		until = append(until, &WhitelistedBuilder{
+			Address:          builder.Address,
+			ActivationSlot:   builder.ActivationSlot,
+			DeactivationSlot: deactivationSlot,
+		})
+	}
+	return until
+}
+
+func whitelistedBuildersEqual(a []*WhitelistedBuilder, b []*WhitelistedBuilder) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if *a[i] != *b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package oracle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/bellatrix"
+	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+// Address of the key that signs the last tx of newTracerTestBlock
+var builderTestAddress = "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23"
+
+func Test_LoadWhitelistedBuilders(t *testing.T) {
+	// Known builders are embedded
+	builders, err := LoadWhitelistedBuilders("mainnet", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(builders))
+	require.Equal(t, "0xae0A3D884E746599BD6C893a674E556C36a47f1e", builders[0].Address)
+	require.Equal(t, uint64(0), builders[0].ActivationSlot)
+	require.Equal(t, uint64(0), builders[0].DeactivationSlot)
+
+	builders, err = LoadWhitelistedBuilders("devnet", "")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(builders))
+
+	// The ones of the file are added to the known ones
+	path := filepath.Join(t.TempDir(), "builders.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"address": "0x2c7536e3605d9c16a7a3d7b1898e529396a65c23", "activation_slot": 200, "justification_url": "https://example.com/2"}
+	]`), 0644))
+	builders, err = LoadWhitelistedBuilders("mainnet", path)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(builders))
+	require.Equal(t, builderTestAddress, builders[0].Address)
+	require.Equal(t, uint64(200), builders[0].ActivationSlot)
+	require.Equal(t, "0xae0A3D884E746599BD6C893a674E556C36a47f1e", builders[1].Address)
+
+	// But can't whitelist a known builder twice
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"address": "0xae0A3D884E746599BD6C893a674E556C36a47f1e", "activation_slot": 100, "justification_url": "https://example.com"}
+	]`), 0644))
+	_, err = LoadWhitelistedBuilders("mainnet", path)
+	require.ErrorContains(t, err, "whitelisted more than once")
+
+	_, err = LoadWhitelistedBuilders("mainnet", filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err)
+}
+
+func Test_ParseWhitelistedBuilders(t *testing.T) {
+	// Sorted by address and activation slot, and addresses are checksummed
+	builders, err := parseWhitelistedBuilders([]byte(`[
+		{"address": "0x2c7536e3605d9c16a7a3d7b1898e529396a65c23", "activation_slot": 200, "justification_url": "https://example.com/2"},
+		{"address": "0xae0A3D884E746599BD6C893a674E556C36a47f1e", "activation_slot": 0, "justification_url": "https://example.com/3"},
+		{"address": "0x2c7536e3605d9c16a7a3d7b1898e529396a65c23", "activation_slot": 100, "deactivation_slot": 200, "justification_url": "https://example.com/1"}
+	]`))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(builders))
+	require.Equal(t, builderTestAddress, builders[0].Address)
+	require.Equal(t, uint64(100), builders[0].ActivationSlot)
+	require.Equal(t, uint64(200), builders[1].ActivationSlot)
+
+	for _, invalid := range []string{
+		`[{"address": "0x1000000000000000000000000000000000000000", "activation_slot": 100, "deactivation_slot": 100, "justification_url": "https://example.com"}]`,
+		`[{"address": "0x1000000000000000000000000000000000000000", "activation_slot": 100, "deactivation_slot": 300, "justification_url": "https://example.com"},
+		  {"address": "0x1000000000000000000000000000000000000000", "activation_slot": 200, "justification_url": "https://example.com"}]`,
+		`[{"address": "0x1000000000000000000000000000000000000000", "activation_slot": 100, "justification_url": "https://example.com"},
+		  {"address": "0x1000000000000000000000000000000000000000", "activation_slot": 100, "justification_url": "https://example.com"}]`,
+		`[{"address": "invalid", "activation_slot": 100, "justification_url": "https://example.com"}]`,
+		`[{"address": "0x1000000000000000000000000000000000000000", "activation_slot": 100}]`,
+		`{"address": "0x1000000000000000000000000000000000000000"}`,
+	} {
+		_, err = parseWhitelistedBuilders([]byte(invalid))
+		require.Error(t, err)
+	}
+}
+
+func Test_WhitelistedBuilder_IsActive(t *testing.T) {
+	builder := &WhitelistedBuilder{Address: builderTestAddress, ActivationSlot: 100, DeactivationSlot: 200}
+	require.False(t, builder.IsActive(99))
+	require.True(t, builder.IsActive(100))
+	require.True(t, builder.IsActive(199))
+	require.False(t, builder.IsActive(200))
+
+	builder.DeactivationSlot = 0
+	require.True(t, builder.IsActive(1000000))
+
+	require.Equal(t, []string{builderTestAddress}, activeBuilders([]*WhitelistedBuilder{builder}, 100))
+	require.Equal(t, 0, len(activeBuilders([]*WhitelistedBuilder{builder}, 99)))
+}
+
+func Test_WhitelistedBuildersInState(t *testing.T) {
+	// Block whose last tx sends the reward to the pool, but not from its fee recipient
+	newBuilderBlock := func() *FullBlock {
+		block := newTracerTestBlock(t, common.HexToAddress(tracerTestPool), tracerTestReward)
+		block.ConsensusBlock.Capella.Message.Body.ExecutionPayload.FeeRecipient =
+			bellatrix.ExecutionAddress(common.HexToAddress("0x1000000000000000000000000000000000000000"))
+		block.Events.EtherReceived = append(block.Events.EtherReceived, &contract.ContractEtherReceived{
+			DonationAmount: tracerTestReward,
+			Raw:            types.Log{Address: common.HexToAddress(tracerTestPool)},
+		})
+		return block
+	}
+
+	cfg := newReplayTestConfig()
+	cfg.WhitelistedBuilders = []*WhitelistedBuilder{{
+		Address:          builderTestAddress,
+		ActivationSlot:   1001,
+		DeactivationSlot: 1002,
+		JustificationUrl: "https://example.com",
+	}}
+	oracle := NewOracle(cfg)
+	oracle.state.NextSlotToProcess = 1001
+	oracle.state.LatestProcessedSlot = 1000
+
+	// Not whitelisted when the block was fetched, but it is at its slot
+	block := newBuilderBlock()
+	_, isMev, _ := block.MevRewardInWei()
+	require.False(t, isMev)
+	_, err := oracle.AdvanceStateToNextSlot(block)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(oracle.state.ProposedBlocks))
+	require.Equal(t, MevBlock, oracle.state.ProposedBlocks[0].RewardType)
+	require.Equal(t, tracerTestReward, oracle.state.ProposedBlocks[0].Reward)
+	require.Equal(t, builderTestAddress, oracle.state.ProposedBlocks[0].Builder)
+
+	// Once deactivated, its payments are not mev rewards
+	block = newBuilderBlock()
+	block.WhitelistedBuilders = activeBuilders(oracle.state.WhitelistedBuilders, 1002)
+	_, isMev, _ = block.MevRewardInWei()
+	require.False(t, isMev)
+
+	// Builders are part of the state hash
+	require.NoError(t, oracle.hashStateLockFree())
+	hashWithBuilders := oracle.state.StateHash
+	oracle.state.WhitelistedBuilders = nil
+	require.NoError(t, oracle.hashStateLockFree())
+	require.NotEqual(t, hashWithBuilders, oracle.state.StateHash)
+
+	stateBuilders := cfg.WhitelistedBuilders
+	oracle.state.WhitelistedBuilders = stateBuilders
+
+	// A loaded state takes the changes of the config that apply after the
+	// processed slots, and replays use the builders of the state
+	require.Equal(t, uint64(1001), oracle.state.LatestProcessedSlot)
+	cfg.WhitelistedBuilders = []*WhitelistedBuilder{
+		{Address: builderTestAddress, ActivationSlot: 1001, DeactivationSlot: 1003, JustificationUrl: "https://example.com/new"},
+		{Address: builderTestAddress, ActivationSlot: 1005, JustificationUrl: "https://example.com"},
+	}
+	loaded := NewOracle(cfg)
+	_, err = loaded.LoadFromState(oracle.state)
+	require.NoError(t, err)
+	require.Equal(t, cfg.WhitelistedBuilders, loaded.state.WhitelistedBuilders)
+	require.Equal(t, cfg.WhitelistedBuilders, ConfigFromState(loaded.state).WhitelistedBuilders)
+
+	// But builders that applied to processed slots can't be changed
+	for _, builders := range [][]*WhitelistedBuilder{
+		{},
+		{{Address: builderTestAddress, ActivationSlot: 1000, DeactivationSlot: 1002, JustificationUrl: "https://example.com"}},
+		{stateBuilders[0], {Address: "0x1000000000000000000000000000000000000000", ActivationSlot: 10, JustificationUrl: "https://example.com"}},
+	} {
+		oracle.state.WhitelistedBuilders = stateBuilders
+		cfg.WhitelistedBuilders = builders
+		_, err = NewOracle(cfg).LoadFromState(oracle.state)
+		require.ErrorContains(t, err, "whitelisted builders mismatch")
+	}
+}
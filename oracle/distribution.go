@@ -0,0 +1,138 @@
+package oracle
+
+import (
+	"math/big"
+	"strconv"
+
+	v1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/pkg/errors"
+)
+
+// Effective balance of a validator whose balance is not known yet, eg because it
+// was subscribed before the distribution by effective balance was active
+var DefaultEffectiveBalanceGwei = uint64(32_000_000_000)
+
+// Splits the rewards left after the pool cut among the eligible validators
+type RewardsDistribution interface {
+	Name() string
+	// Returns the share of each validator, in the same order, and the remainder
+	// that can't be split, which goes to the pool. The shares plus the remainder
+	// add up to toShare, to the wei
+	Split(toShare *big.Int, validators []*ValidatorInfo) ([]*big.Int, *big.Int)
+}
+
+// Every validator gets the same share, no matter its balance
+type EqualDistribution struct{}
+
+func (d EqualDistribution) Name() string {
+	return "equal"
+}
+
+func (d EqualDistribution) Split(toShare *big.Int, validators []*ValidatorInfo) ([]*big.Int, *big.Int) {
+	numValidators := big.NewInt(int64(len(validators)))
+	perValidator, remainder := new(big.Int).DivMod(toShare, numValidators, new(big.Int))
+
+	// Shares are only read, so all validators can use the same one
+	shares := make([]*big.Int, len(validators))
+	for i := range shares {
+		shares[i] = perValidator
+	}
+	return shares, remainder
+}
+
+// Each validator gets a share proportional to its effective balance, so that
+// validators with up to 2048 Ether get as much as the 32 Ether ones combined
+type EffectiveBalanceDistribution struct{}
+
+func (d EffectiveBalanceDistribution) Name() string {
+	return "effectivebalance"
+}
+
+func (d EffectiveBalanceDistribution) Split(toShare *big.Int, validators []*ValidatorInfo) ([]*big.Int, *big.Int) {
+	weights := make([]*big.Int, len(validators))
+	totalWeight := big.NewInt(0)
+	for i, validator := range validators {
+		weights[i] = new(big.Int).SetUint64(validatorEffectiveBalanceGwei(validator))
+		totalWeight.Add(totalWeight, weights[i])
+	}
+
+	// Rounded down, what is left is the remainder
+	shares := make([]*big.Int, len(validators))
+	remainder := new(big.Int).Set(toShare)
+	for i, weight := range weights {
+		shares[i] = new(big.Int).Mul(toShare, weight)
+		shares[i].Div(shares[i], totalWeight)
+		remainder.Sub(remainder, shares[i])
+	}
+	return shares, remainder
+}
+
+// Returns the effective balance used to weight the rewards of the validator
+func validatorEffectiveBalanceGwei(validator *ValidatorInfo) uint64 {
+	if validator.EffectiveBalanceGwei == 0 {
+		return DefaultEffectiveBalanceGwei
+	}
+	return validator.EffectiveBalanceGwei
+}
+
+// Returns how rewards are split at the slot being processed
+func (or *Oracle) rewardsDistribution() RewardsDistribution {
+	if or.isRuleActive(EffectiveBalanceWeightedRewards, or.state.NextSlotToProcess) {
+		return EffectiveBalanceDistribution{}
+	}
+	return EqualDistribution{}
+}
+
+// Stores the effective balance of the validators that are tracked, to weight
+// their rewards. Only done once the distribution by effective balance is active,
+// so that the state doesn't change before
+func (or *Oracle) updateEffectiveBalances(validators []*v1.Validator, slot uint64) {
+	if !or.isRuleActive(EffectiveBalanceWeightedRewards, slot) {
+		return
+	}
+	for _, validator := range validators {
+		if validator == nil || validator.Validator == nil {
+			continue
+		}
+		if tracked, found := or.state.Validators[uint64(validator.Index)]; found {
+			tracked.EffectiveBalanceGwei = uint64(validator.Validator.EffectiveBalance)
+		}
+	}
+}
+
+// Returns true if the effective balances of all the tracked validators have to
+// be refreshed at the slot: when the distribution by effective balance activates,
+// so that no validator is weighted with the default balance, and at every
+// checkpoint, so that all oracles freeze the same balances
+func (or *Oracle) isEffectiveBalancesRefreshSlot(slot uint64) bool {
+	if !or.isRuleActive(EffectiveBalanceWeightedRewards, slot) {
+		return false
+	}
+	fork := ruleFork(or.forks, EffectiveBalanceWeightedRewards)
+	return slot == fork.ActivationSlot(or.cfg.Network) || or.state.isCheckpointSlot(slot)
+}
+
+// Refreshes the effective balance of all the tracked validators at the slot,
+// since the ones that are not seen in any block keep the balance they had when
+// they were last seen
+func (or *Oracle) refreshEffectiveBalances(slot uint64) error {
+	indices := make([]phase0.ValidatorIndex, 0, len(or.state.Validators))
+	for idx := range or.state.Validators {
+		indices = append(indices, phase0.ValidatorIndex(idx))
+	}
+	if len(indices) == 0 {
+		return nil
+	}
+
+	validatorInfo, err := or.getSetOfValidators(indices, strconv.FormatUint(slot, 10))
+	if err != nil {
+		return errors.Wrap(err, "could not get validators info")
+	}
+	validators := make([]*v1.Validator, 0, len(validatorInfo))
+	for _, validator := range validatorInfo {
+		validators = append(validators, validator)
+	}
+	or.updateEffectiveBalances(validators, slot)
+	return nil
+}
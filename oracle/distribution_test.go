@@ -0,0 +1,155 @@
+package oracle
+
+import (
+	"math/big"
+	"strconv"
+	"testing"
+
+	v1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/avast/retry-go/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func requireSharesAddUp(t *testing.T, toShare *big.Int, shares []*big.Int, remainder *big.Int) {
+	total := new(big.Int).Set(remainder)
+	for _, share := range shares {
+		total.Add(total, share)
+	}
+	require.Equal(t, toShare, total)
+}
+
+func Test_EqualDistribution(t *testing.T) {
+	validators := []*ValidatorInfo{
+		{EffectiveBalanceGwei: 32_000_000_000},
+		{EffectiveBalanceGwei: 2048_000_000_000},
+		{},
+	}
+
+	shares, remainder := EqualDistribution{}.Split(big.NewInt(1000), validators)
+	require.Equal(t, []*big.Int{big.NewInt(333), big.NewInt(333), big.NewInt(333)}, shares)
+	require.Equal(t, big.NewInt(1), remainder)
+
+	shares, remainder = EqualDistribution{}.Split(big.NewInt(2), validators)
+	for _, share := range shares {
+		require.Equal(t, int64(0), share.Int64())
+	}
+	require.Equal(t, big.NewInt(2), remainder)
+}
+
+func Test_EffectiveBalanceDistribution(t *testing.T) {
+	validators := []*ValidatorInfo{
+		{EffectiveBalanceGwei: 32_000_000_000},
+		{EffectiveBalanceGwei: 2048_000_000_000},
+		// Unknown balance counts as 32 Ether
+		{},
+		{EffectiveBalanceGwei: 63_000_000_000},
+	}
+
+	// 32 + 2048 + 32 + 63 = 2175 Ether
+	toShare := big.NewInt(2175 * 1000)
+	shares, remainder := EffectiveBalanceDistribution{}.Split(toShare, validators)
+	require.Equal(t, []*big.Int{big.NewInt(32000), big.NewInt(2048000), big.NewInt(32000), big.NewInt(63000)}, shares)
+	require.Equal(t, int64(0), remainder.Int64())
+
+	// Rounded down, the remainder makes up for it
+	for _, reward := range []int64{1, 7, 2174, 2176, 1_000_000_000_000_000_007} {
+		toShare = big.NewInt(reward)
+		shares, remainder = EffectiveBalanceDistribution{}.Split(toShare, validators)
+		requireSharesAddUp(t, toShare, shares, remainder)
+		require.True(t, remainder.Sign() >= 0)
+		require.True(t, remainder.Cmp(big.NewInt(int64(len(validators)))) < 0)
+	}
+
+	// Same balances get the same as the equal distribution
+	sameBalance := []*ValidatorInfo{{}, {}, {EffectiveBalanceGwei: DefaultEffectiveBalanceGwei}}
+	weightedShares, weightedRemainder := EffectiveBalanceDistribution{}.Split(big.NewInt(1000), sameBalance)
+	equalShares, equalRemainder := EqualDistribution{}.Split(big.NewInt(1000), sameBalance)
+	require.Equal(t, equalShares, weightedShares)
+	require.Equal(t, equalRemainder, weightedRemainder)
+}
+
+func Test_EffectiveBalanceWeightedRewards(t *testing.T) {
+	forkSlot := Fork1.ActivationSlot("mainnet") + 100
+	oracle := newOracleWithRules(t, forkSlot, EffectiveBalanceWeightedRewards)
+
+	oracle.state.NextSlotToProcess = forkSlot - 1
+	oracle.addSubscription(1, "0x2000000000000000000000000000000000000000", "0x")
+	oracle.addSubscription(2, "0x3000000000000000000000000000000000000000", "0x")
+	validators := []*v1.Validator{
+		{Index: 1, Validator: &phase0.Validator{EffectiveBalance: 32_000_000_000}},
+		{Index: 2, Validator: &phase0.Validator{EffectiveBalance: 96_000_000_000}},
+		// Not tracked, ignored
+		{Index: 3, Validator: &phase0.Validator{EffectiveBalance: 32_000_000_000}},
+	}
+
+	// Before the fork balances are not stored and rewards are split equally
+	oracle.updateEffectiveBalances(validators, oracle.state.NextSlotToProcess)
+	require.Equal(t, uint64(0), oracle.state.Validators[1].EffectiveBalanceGwei)
+	require.Equal(t, EqualDistribution{}, oracle.rewardsDistribution())
+	oracle.handleCorrectBlockProposal(SummarizedBlock{
+		ValidatorIndex: 1,
+		Reward:         big.NewInt(1000),
+		RewardType:     VanilaBlock,
+	})
+	require.Equal(t, big.NewInt(450), oracle.state.Validators[1].AccumulatedRewardsWei)
+	require.Equal(t, big.NewInt(450), oracle.state.Validators[2].PendingRewardsWei)
+
+	// After it, by effective balance
+	oracle.state.NextSlotToProcess = forkSlot
+	oracle.updateEffectiveBalances(validators, oracle.state.NextSlotToProcess)
+	require.Equal(t, uint64(96_000_000_000), oracle.state.Validators[2].EffectiveBalanceGwei)
+	require.Equal(t, 2, len(oracle.state.Validators))
+	require.Equal(t, EffectiveBalanceDistribution{}, oracle.rewardsDistribution())
+	oracle.handleCorrectBlockProposal(SummarizedBlock{
+		ValidatorIndex: 2,
+		Reward:         big.NewInt(1003),
+		RewardType:     VanilaBlock,
+	})
+	// Pool cut is 100, and the remainder of splitting 903 goes to the pool
+	require.Equal(t, big.NewInt(225), oracle.state.Validators[1].PendingRewardsWei)
+	require.Equal(t, big.NewInt(450+677), oracle.state.Validators[2].AccumulatedRewardsWei)
+	require.Equal(t, big.NewInt(100+100+1), oracle.state.PoolAccumulatedFees)
+
+	// Still balances to the wei
+	require.NoError(t, oracle.RunOffchainReconciliation())
+}
+
+func Test_RefreshEffectiveBalances(t *testing.T) {
+	forkSlot := Fork1.ActivationSlot("mainnet") + 100
+	oracle := newOracleWithRules(t, forkSlot, EffectiveBalanceWeightedRewards)
+
+	// Checkpoints at forkSlot-1 and forkSlot+3
+	oracle.state.DeployedSlot = forkSlot - 5
+	oracle.state.CheckPointSizeInSlots = 4
+	oracle.state.LatestProcessedSlot = forkSlot - 2
+	oracle.state.NextSlotToProcess = forkSlot - 1
+	oracle.addSubscription(1, "0x2000000000000000000000000000000000000000", "0x")
+	oracle.addSubscription(2, "0x3000000000000000000000000000000000000000", "0x")
+
+	refreshedAt := make([]uint64, 0)
+	balance := phase0.Gwei(64_000_000_000)
+	oracle.SetGetSetOfValidatorsFunc(func(indices []phase0.ValidatorIndex, slot string, opts ...retry.Option) (map[phase0.ValidatorIndex]*v1.Validator, error) {
+		slotNumber, err := strconv.ParseUint(slot, 10, 64)
+		require.NoError(t, err)
+		refreshedAt = append(refreshedAt, slotNumber)
+		require.Equal(t, 2, len(indices))
+		validators := make(map[phase0.ValidatorIndex]*v1.Validator)
+		for _, index := range indices {
+			validators[index] = &v1.Validator{Index: index, Validator: &phase0.Validator{EffectiveBalance: balance}}
+		}
+		return validators, nil
+	})
+
+	// Balances are refreshed when the rule activates, and at every checkpoint from then on
+	for slot := forkSlot - 1; slot <= forkSlot+3; slot++ {
+		if slot == forkSlot+3 {
+			balance = 96_000_000_000
+		}
+		_, err := oracle.AdvanceStateToNextSlot(newReplayTestBlock(slot))
+		require.NoError(t, err)
+	}
+	require.Equal(t, []uint64{forkSlot, forkSlot + 3}, refreshedAt)
+	require.Equal(t, uint64(96_000_000_000), oracle.state.Validators[1].EffectiveBalanceGwei)
+	require.Equal(t, uint64(96_000_000_000), oracle.state.Validators[2].EffectiveBalanceGwei)
+}
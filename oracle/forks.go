@@ -0,0 +1,180 @@
+package oracle
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Rule changes that a fork can switch on from its activation slot
+type ForkRule int
+
+const (
+	// Fixes a minor bug in rewards calculation. It just affects a few wei, but
+	// the remainder of the pool cut is no longer scaled over 100
+	RewardsRoundingFix ForkRule = iota
+	// Exited and slashed validators are unsubscribed and no longer get fees
+	CleanupInactiveValidators
+	// Rewards are split by the effective balance of each validator, not equally
+	EffectiveBalanceWeightedRewards
+	// Exits and consolidations requested from the execution layer are handled
+	// in the slot they are requested, not in the next cleanup
+	ExecutionLayerRequests
+	// Rewards of proposals with bls withdrawal credentials are held until the
+	// credentials change, instead of going to the pool
+	RetroactiveBlsCredits
+	// Mev rewards are followed through the calls of the last tx, so the ones a
+	// contract forwards to the pool are detected. Only if the execution client
+	// supports tracing, otherwise the last tx is used as before
+	TracedMevRewards
+)
+
+func (r ForkRule) String() string {
+	if r == RewardsRoundingFix {
+		return "rewardsroundingfix"
+	} else if r == CleanupInactiveValidators {
+		return "cleanupinactivevalidators"
+	} else if r == EffectiveBalanceWeightedRewards {
+		return "effectivebalanceweightedrewards"
+	} else if r == ExecutionLayerRequests {
+		return "executionlayerrequests"
+	} else if r == RetroactiveBlsCredits {
+		return "retroactiveblscredits"
+	} else if r == TracedMevRewards {
+		return "tracedmevrewards"
+	}
+	return ""
+}
+
+// Activation slot of a fork in the networks where it is not scheduled yet
+const NotScheduled = ^uint64(0)
+
+// A named set of rule changes, activated at a given slot in each network
+type Fork struct {
+	Name string
+	// Slot the fork activates at, per network. In networks that are not
+	// listed (eg devnets) the fork is active from genesis
+	ActivationSlots map[string]uint64
+	Rules           []ForkRule
+	// Share of the held rewards of validators with bls keys that goes to the
+	// pool once they change their credentials, over 10000 (eg 1.5% = 150), per
+	// network. Only used by the fork that activates RetroactiveBlsCredits
+	BlsCreditPenaltiesOver10000 map[string]int
+}
+
+// Fork 1 changes two things:
+// - minor fix in rewards calculation (some wei rouding)
+// - exited and slahed validators no longer get fees
+var Fork1 = &Fork{
+	Name: "fork1",
+	ActivationSlots: map[string]uint64{
+		"mainnet": uint64(10188220),
+		"holesky": uint64(2720632),
+	},
+	Rules: []ForkRule{RewardsRoundingFix, CleanupInactiveValidators},
+}
+
+// Fork 2 changes four things:
+// - rewards are split by the effective balance of each validator
+// - exits and consolidations requested from the execution layer are handled
+// - validators proposing with bls credentials are credited once they change them
+// - mev rewards forwarded to the pool by a contract are detected by tracing them
+// Not scheduled in mainnet nor holesky until the oracle members agree on a
+// future slot, since activating it at a past slot changes the roots that are
+// already consolidated onchain. Execution layer requests need electra.
+var Fork2 = &Fork{
+	Name: "fork2",
+	ActivationSlots: map[string]uint64{
+		"mainnet": NotScheduled,
+		"holesky": NotScheduled,
+	},
+	Rules: []ForkRule{EffectiveBalanceWeightedRewards, ExecutionLayerRequests, RetroactiveBlsCredits, TracedMevRewards},
+	BlsCreditPenaltiesOver10000: map[string]int{
+		"mainnet": 0,
+		"holesky": 0,
+	},
+}
+
+// All the forks, in activation order. New rules are added by registering a
+// new fork here
+var Forks = []*Fork{
+	Fork1,
+	Fork2,
+}
+
+// Returns the slot the fork activates at in the given network
+func (f *Fork) ActivationSlot(network string) uint64 {
+	if slot, found := f.ActivationSlots[network]; found {
+		return slot
+	}
+	return 0
+}
+
+// Returns true if the fork is active at the given slot of the network
+func (f *Fork) IsActive(network string, slot uint64) bool {
+	activationSlot := f.ActivationSlot(network)
+	return activationSlot != NotScheduled && slot >= activationSlot
+}
+
+func (f *Fork) hasRule(rule ForkRule) bool {
+	for _, forkRule := range f.Rules {
+		if forkRule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the latest fork that switches the rule, nil if none does
+func ruleFork(forks []*Fork, rule ForkRule) *Fork {
+	for i := len(forks) - 1; i >= 0; i-- {
+		if forks[i].hasRule(rule) {
+			return forks[i]
+		}
+	}
+	return nil
+}
+
+// Returns true if the rule is active at the given slot of the network. Rules
+// that no fork switches are never active
+func IsRuleActive(forks []*Fork, network string, slot uint64, rule ForkRule) bool {
+	fork := ruleFork(forks, rule)
+	if fork == nil {
+		return false
+	}
+	return fork.IsActive(network, slot)
+}
+
+// Returns true if the rule is active at the given slot, logging which fork
+// activated it
+func (or *Oracle) isRuleActive(rule ForkRule, slot uint64) bool {
+	active := IsRuleActive(or.forks, or.cfg.Network, slot, rule)
+
+	fields := log.Fields{
+		"Rule":    rule.String(),
+		"Slot":    slot,
+		"Network": or.cfg.Network,
+		"Active":  active,
+	}
+	if fork := ruleFork(or.forks, rule); fork != nil {
+		fields["Fork"] = fork.Name
+		fields["SlotFork"] = fork.ActivationSlot(or.cfg.Network)
+	}
+	log.WithFields(fields).Debug("Checking fork rule")
+	return active
+}
+
+// Returns the penalty of the bls credits in the network, set by the fork that
+// activates RetroactiveBlsCredits. Networks that are not listed have none
+func (or *Oracle) blsCreditPenaltyOver10000() int {
+	fork := ruleFork(or.forks, RetroactiveBlsCredits)
+	if fork == nil {
+		return 0
+	}
+	return fork.BlsCreditPenaltiesOver10000[or.cfg.Network]
+}
+
+// Same as blsCreditPenaltyOver10000, safe to be called concurrently
+func (or *Oracle) BlsCreditPenaltyOver10000() int {
+	or.mutex.RLock()
+	defer or.mutex.RUnlock()
+	return or.blsCreditPenaltyOver10000()
+}
@@ -0,0 +1,122 @@
+package oracle
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Mainnet oracle where, besides the ones of fork1, only the given rules are
+// active from the given slot, which is the next one to process
+func newOracleWithRules(t *testing.T, slot uint64, rules ...ForkRule) *Oracle {
+	t.Helper()
+	oracle := NewOracle(&Config{
+		PoolFeesPercentOver10000: 1000,
+		PoolFeesAddress:          "0x1000000000000000000000000000000000000000",
+		Network:                  "mainnet",
+		CollateralInWei:          big.NewInt(1000),
+	})
+	oracle.SetForks([]*Fork{Fork1, {
+		Name:            "test",
+		ActivationSlots: map[string]uint64{"mainnet": slot},
+		Rules:           rules,
+	}})
+	oracle.state.NextSlotToProcess = slot
+	return oracle
+}
+
+func Test_IsRuleActive(t *testing.T) {
+	mainnetFork1 := Fork1.ActivationSlot("mainnet")
+
+	require.False(t, IsRuleActive(Forks, "mainnet", mainnetFork1-1, RewardsRoundingFix))
+	require.True(t, IsRuleActive(Forks, "mainnet", mainnetFork1, RewardsRoundingFix))
+	require.False(t, IsRuleActive(Forks, "holesky", Fork1.ActivationSlot("holesky")-1, CleanupInactiveValidators))
+	require.True(t, IsRuleActive(Forks, "holesky", Fork1.ActivationSlot("holesky"), CleanupInactiveValidators))
+
+	// Networks without an activation slot get every fork from genesis
+	require.Equal(t, uint64(0), Fork1.ActivationSlot("devnet"))
+	require.True(t, IsRuleActive(Forks, "devnet", 0, RewardsRoundingFix))
+	require.True(t, IsRuleActive(Forks, "devnet", 0, CleanupInactiveValidators))
+
+	// Fork2 is not scheduled in mainnet nor holesky yet
+	require.Equal(t, NotScheduled, Fork2.ActivationSlot("mainnet"))
+	require.Equal(t, NotScheduled, Fork2.ActivationSlot("holesky"))
+	require.False(t, Fork2.IsActive("mainnet", NotScheduled))
+	require.False(t, IsRuleActive(Forks, "holesky", ^uint64(0), EffectiveBalanceWeightedRewards))
+	require.False(t, IsRuleActive(Forks, "mainnet", ^uint64(0), EffectiveBalanceWeightedRewards))
+	require.True(t, IsRuleActive([]*Fork{Fork1, Fork2}, "devnet", 0, EffectiveBalanceWeightedRewards))
+	require.False(t, IsRuleActive(Forks, "mainnet", ^uint64(0), ExecutionLayerRequests))
+	require.True(t, IsRuleActive([]*Fork{Fork1, Fork2}, "devnet", 0, ExecutionLayerRequests))
+	require.False(t, IsRuleActive(Forks, "mainnet", ^uint64(0), RetroactiveBlsCredits))
+	require.True(t, IsRuleActive([]*Fork{Fork1, Fork2}, "devnet", 0, RetroactiveBlsCredits))
+	require.False(t, IsRuleActive(Forks, "mainnet", ^uint64(0), TracedMevRewards))
+	require.True(t, IsRuleActive([]*Fork{Fork1, Fork2}, "devnet", 0, TracedMevRewards))
+
+	// Rules that no fork switches are not active
+	require.False(t, IsRuleActive([]*Fork{}, "mainnet", mainnetFork1, RewardsRoundingFix))
+
+	// The latest fork that switches a rule decides when it activates
+	forks := []*Fork{
+		Fork1,
+		{
+			Name:            "test",
+			ActivationSlots: map[string]uint64{"mainnet": mainnetFork1 + 100},
+			Rules:           []ForkRule{RewardsRoundingFix},
+		},
+	}
+	require.False(t, IsRuleActive(forks, "mainnet", mainnetFork1, RewardsRoundingFix))
+	require.True(t, IsRuleActive(forks, "mainnet", mainnetFork1+100, RewardsRoundingFix))
+	require.True(t, IsRuleActive(forks, "mainnet", mainnetFork1, CleanupInactiveValidators))
+}
+
+func Test_BlsCreditPenalty(t *testing.T) {
+	// Consensus constants of the fork, in the valid range
+	for _, fork := range Forks {
+		for _, penalty := range fork.BlsCreditPenaltiesOver10000 {
+			require.True(t, penalty >= 0 && penalty <= 100*100)
+		}
+	}
+
+	oracle := newOracleWithRules(t, 100, RetroactiveBlsCredits)
+	require.Equal(t, 0, oracle.BlsCreditPenaltyOver10000())
+	oracle.SetForks([]*Fork{Fork1, {
+		Name:                        "test",
+		Rules:                       []ForkRule{RetroactiveBlsCredits},
+		BlsCreditPenaltiesOver10000: map[string]int{"mainnet": 150, "holesky": 10},
+	}})
+	require.Equal(t, 150, oracle.BlsCreditPenaltyOver10000())
+
+	// Without the rule there is no penalty
+	oracle.SetForks([]*Fork{Fork1})
+	require.Equal(t, 0, oracle.BlsCreditPenaltyOver10000())
+}
+
+func Test_SetForks(t *testing.T) {
+	rewardWithForks := func(network string, forks []*Fork) (*big.Int, *big.Int) {
+		oracle := NewOracle(&Config{
+			PoolFeesPercentOver10000: 700,
+			PoolFeesAddress:          "0x",
+			Network:                  network,
+		})
+		if forks != nil {
+			oracle.SetForks(forks)
+		}
+		for i := 0; i < 1670; i++ {
+			oracle.addSubscription(uint64(i), "0x", "0x")
+		}
+		oracle.state.NextSlotToProcess = 10
+		oracle.increaseAllPendingRewards(big.NewInt(1))
+		return oracle.state.Validators[0].PendingRewardsWei, oracle.state.PoolAccumulatedFees
+	}
+
+	// Unknown networks use the fixed rounding instead of halting
+	validatorReward, poolReward := rewardWithForks("devnet", nil)
+	require.Equal(t, big.NewInt(0), validatorReward)
+	require.Equal(t, big.NewInt(1), poolReward)
+
+	// Without forks the legacy rounding is used
+	validatorReward, poolReward = rewardWithForks("devnet", []*Fork{})
+	require.Equal(t, big.NewInt(-1), validatorReward)
+	require.Equal(t, big.NewInt(1671), poolReward)
+}
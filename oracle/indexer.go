@@ -0,0 +1,360 @@
+package oracle
+
+import (
+	"context"
+	"math/big"
+	"strconv"
+	"sync"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Execution client calls needed by the event indexer
+type EventsBackend interface {
+	ethereum.LogFilterer
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// Returned when the block requested is beyond the latest finalized block, so
+// its events can't be indexed yet
+var ErrBlockNotFinalized = errors.New("block not finalized yet, events are not available")
+
+// Events of all the blocks of a range that was indexed
+type indexedRange struct {
+	from   uint64
+	to     uint64
+	events map[uint64]*Events
+}
+
+// A range being fetched, done is closed once its finished
+type pendingRange struct {
+	from uint64
+	to   uint64
+	done chan struct{}
+}
+
+// Fetches all the logs emitted by the pool contract over ranges of blocks with
+// a single call, instead of one call per event type and block. Since the pool
+// emits events in a tiny fraction of the blocks, most blocks are served from
+// the cache with no events at all. Ranges never go beyond the latest finalized
+// block, so what is cached can't change.
+type EventIndexer struct {
+	backend     EventsBackend
+	filterer    *contract.ContractFilterer
+	poolAddress common.Address
+	rangeSize   uint64
+	retryOpts   []retry.Option
+	contractAbi *abi.ABI
+
+	// Protects the ranges, but its not held while fetching them
+	mutex   sync.Mutex
+	ranges  []*indexedRange
+	pending []*pendingRange
+}
+
+func NewEventIndexer(
+	backend EventsBackend,
+	filterer *contract.ContractFilterer,
+	poolAddress common.Address,
+	rangeSize uint64,
+	retryOpts ...retry.Option) (*EventIndexer, error) {
+
+	if rangeSize == 0 {
+		return nil, errors.New("range size must be greater than 0")
+	}
+
+	contractAbi, err := contract.ContractMetaData.GetAbi()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse contract abi")
+	}
+
+	return &EventIndexer{
+		backend:     backend,
+		filterer:    filterer,
+		poolAddress: poolAddress,
+		rangeSize:   rangeSize,
+		retryOpts:   retryOpts,
+		contractAbi: contractAbi,
+		ranges:      make([]*indexedRange, 0),
+	}, nil
+}
+
+// Returns all the pool events emitted in the given block. If the block was not
+// indexed yet, a new range starting at it is fetched. Safe to be called
+// concurrently, callers of blocks in a range being fetched wait for it instead
+// of fetching it again. Returns ErrBlockNotFinalized for blocks beyond the
+// latest finalized one.
+func (i *EventIndexer) GetEvents(blockNumber uint64) (*Events, error) {
+	for {
+		i.mutex.Lock()
+		for _, indexed := range i.ranges {
+			if blockNumber >= indexed.from && blockNumber <= indexed.to {
+				i.mutex.Unlock()
+				return indexed.eventsAt(blockNumber), nil
+			}
+		}
+
+		// Wait for the range being fetched and check again, it can end before the block
+		var waitFor *pendingRange
+		for _, pending := range i.pending {
+			if blockNumber >= pending.from && blockNumber <= pending.to {
+				waitFor = pending
+				break
+			}
+		}
+		if waitFor != nil {
+			i.mutex.Unlock()
+			<-waitFor.done
+			continue
+		}
+
+		pending := &pendingRange{
+			from: blockNumber,
+			to:   blockNumber + i.rangeSize - 1,
+			done: make(chan struct{}),
+		}
+		i.pending = append(i.pending, pending)
+		i.mutex.Unlock()
+
+		// Fetched without holding the lock, so other blocks can be served meanwhile
+		indexed, err := i.indexRange(blockNumber)
+
+		i.mutex.Lock()
+		for idx, other := range i.pending {
+			if other == pending {
+				i.pending = append(i.pending[:idx], i.pending[idx+1:]...)
+				break
+			}
+		}
+		close(pending.done)
+		if err == nil {
+			// Keep the ranges around it, blocks are requested almost in order
+			kept := make([]*indexedRange, 0, 2)
+			for _, old := range i.ranges {
+				if old.to+i.rangeSize >= blockNumber && old.from <= blockNumber+i.rangeSize {
+					kept = append(kept, old)
+				}
+			}
+			i.ranges = append(kept, indexed)
+		}
+		i.mutex.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		return indexed.eventsAt(blockNumber), nil
+	}
+}
+
+func (r *indexedRange) eventsAt(blockNumber uint64) *Events {
+	events, found := r.events[blockNumber]
+	if !found {
+		return &Events{}
+	}
+	return events
+}
+
+func (i *EventIndexer) indexRange(from uint64) (*indexedRange, error) {
+	to := from + i.rangeSize - 1
+
+	// Never cache beyond the finalized block, these blocks may not exist yet
+	var finalized *types.Header
+	var err error
+	err = retry.Do(func() error {
+		finalized, err = i.backend.HeaderByNumber(context.Background(), big.NewInt(int64(rpc.FinalizedBlockNumber)))
+		if err != nil {
+			log.Warn("Failed attempt to fetch finalized header for indexing events: ", err.Error(), " Retrying...")
+			return errors.New("Error fetching finalized header: " + err.Error())
+		}
+		return nil
+	}, i.retryOpts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get finalized header")
+	}
+	finalizedBlock := finalized.Number.Uint64()
+	if from > finalizedBlock {
+		return nil, ErrBlockNotFinalized
+	}
+	if to > finalizedBlock {
+		to = finalizedBlock
+	}
+
+	query := ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: []common.Address{i.poolAddress},
+	}
+
+	var logs []types.Log
+	err = retry.Do(func() error {
+		logs, err = i.backend.FilterLogs(context.Background(), query)
+		if err != nil {
+			log.Warn("Failed attempt to filter pool logs for blocks ",
+				strconv.FormatUint(from, 10), "-", strconv.FormatUint(to, 10), ": ", err.Error(), " Retrying...")
+			return errors.New("Error filtering pool logs: " + err.Error())
+		}
+		return nil
+	}, i.retryOpts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not filter pool logs")
+	}
+
+	indexed := &indexedRange{
+		from:   from,
+		to:     to,
+		events: make(map[uint64]*Events),
+	}
+	for _, rawLog := range logs {
+		if rawLog.BlockNumber < from || rawLog.BlockNumber > to {
+			return nil, errors.New("log out of the requested range at block " + strconv.FormatUint(rawLog.BlockNumber, 10))
+		}
+		events, found := indexed.events[rawLog.BlockNumber]
+		if !found {
+			events = &Events{}
+			indexed.events[rawLog.BlockNumber] = events
+		}
+		err := i.decodeLog(rawLog, events)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"FromBlock": from,
+		"ToBlock":   to,
+		"Logs":      len(logs),
+	}).Debug("Indexed pool events")
+
+	return indexed, nil
+}
+
+// Decodes a log of the pool contract using the generated bindings, appending
+// it to the events of its type
+func (i *EventIndexer) decodeLog(rawLog types.Log, events *Events) error {
+	if len(rawLog.Topics) == 0 {
+		return errors.New("pool log without topics in tx " + rawLog.TxHash.String())
+	}
+	event, err := i.contractAbi.EventByID(rawLog.Topics[0])
+	if err != nil {
+		// Events not in the bindings, eg from the proxy, are not relevant
+		log.Debug("Skipping unknown pool log in tx ", rawLog.TxHash.String())
+		return nil
+	}
+
+	f := i.filterer
+	switch event.Name {
+	case "EtherReceived":
+		e, err := f.ParseEtherReceived(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse EtherReceived event")
+		}
+		events.EtherReceived = append(events.EtherReceived, e)
+	case "SubscribeValidator":
+		e, err := f.ParseSubscribeValidator(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse SubscribeValidator event")
+		}
+		events.SubscribeValidator = append(events.SubscribeValidator, e)
+	case "ClaimRewards":
+		e, err := f.ParseClaimRewards(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse ClaimRewards event")
+		}
+		events.ClaimRewards = append(events.ClaimRewards, e)
+	case "SetRewardRecipient":
+		e, err := f.ParseSetRewardRecipient(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse SetRewardRecipient event")
+		}
+		events.SetRewardRecipient = append(events.SetRewardRecipient, e)
+	case "UnsubscribeValidator":
+		e, err := f.ParseUnsubscribeValidator(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse UnsubscribeValidator event")
+		}
+		events.UnsubscribeValidator = append(events.UnsubscribeValidator, e)
+	case "InitSmoothingPool":
+		e, err := f.ParseInitSmoothingPool(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse InitSmoothingPool event")
+		}
+		events.InitSmoothingPool = append(events.InitSmoothingPool, e)
+	case "UpdatePoolFee":
+		e, err := f.ParseUpdatePoolFee(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse UpdatePoolFee event")
+		}
+		events.UpdatePoolFee = append(events.UpdatePoolFee, e)
+	case "UpdatePoolFeeRecipient":
+		e, err := f.ParseUpdatePoolFeeRecipient(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse UpdatePoolFeeRecipient event")
+		}
+		events.PoolFeeRecipient = append(events.PoolFeeRecipient, e)
+	case "UpdateCheckpointSlotSize":
+		e, err := f.ParseUpdateCheckpointSlotSize(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse UpdateCheckpointSlotSize event")
+		}
+		events.CheckpointSlotSize = append(events.CheckpointSlotSize, e)
+	case "UpdateSubscriptionCollateral":
+		e, err := f.ParseUpdateSubscriptionCollateral(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse UpdateSubscriptionCollateral event")
+		}
+		events.UpdateSubscriptionCollateral = append(events.UpdateSubscriptionCollateral, e)
+	case "SubmitReport":
+		e, err := f.ParseSubmitReport(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse SubmitReport event")
+		}
+		events.SubmitReport = append(events.SubmitReport, e)
+	case "ReportConsolidated":
+		e, err := f.ParseReportConsolidated(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse ReportConsolidated event")
+		}
+		events.ReportConsolidated = append(events.ReportConsolidated, e)
+	case "UpdateQuorum":
+		e, err := f.ParseUpdateQuorum(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse UpdateQuorum event")
+		}
+		events.UpdateQuorum = append(events.UpdateQuorum, e)
+	case "AddOracleMember":
+		e, err := f.ParseAddOracleMember(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse AddOracleMember event")
+		}
+		events.AddOracleMember = append(events.AddOracleMember, e)
+	case "RemoveOracleMember":
+		e, err := f.ParseRemoveOracleMember(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse RemoveOracleMember event")
+		}
+		events.RemoveOracleMember = append(events.RemoveOracleMember, e)
+	case "TransferGovernance":
+		e, err := f.ParseTransferGovernance(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse TransferGovernance event")
+		}
+		events.TransferGovernance = append(events.TransferGovernance, e)
+	case "AcceptGovernance":
+		e, err := f.ParseAcceptGovernance(rawLog)
+		if err != nil {
+			return errors.Wrap(err, "could not parse AcceptGovernance event")
+		}
+		events.AcceptGovernance = append(events.AcceptGovernance, e)
+	default:
+		log.Debug("Skipping pool log ", event.Name, " in tx ", rawLog.TxHash.String())
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package oracle
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// Execution client serving a fixed set of logs
+type mockEventsBackend struct {
+	mutex     sync.Mutex
+	logs      []types.Log
+	finalized uint64
+	queries   []ethereum.FilterQuery
+}
+
+func (m *mockEventsBackend) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.queries = append(m.queries, q)
+	logs := make([]types.Log, 0)
+	for _, l := range m.logs {
+		if l.BlockNumber >= q.FromBlock.Uint64() && l.BlockNumber <= q.ToBlock.Uint64() {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
+func (m *mockEventsBackend) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	return nil, errors.New("not supported")
+}
+
+func (m *mockEventsBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{Number: new(big.Int).SetUint64(m.finalized)}, nil
+}
+
+func packPoolLog(t *testing.T, name string, blockNumber uint64, index uint, args ...interface{}) types.Log {
+	contractAbi, err := contract.ContractMetaData.GetAbi()
+	require.NoError(t, err)
+	event := contractAbi.Events[name]
+	data, err := event.Inputs.NonIndexed().Pack(args...)
+	require.NoError(t, err)
+	return types.Log{
+		Address:     common.HexToAddress("0xAdFb8D27671F14f297eE94135e266aAFf8752e35"),
+		Topics:      []common.Hash{event.ID},
+		Data:        data,
+		BlockNumber: blockNumber,
+		Index:       index,
+	}
+}
+
+func Test_EventIndexer_GetEvents(t *testing.T) {
+	poolAddress := common.HexToAddress("0xAdFb8D27671F14f297eE94135e266aAFf8752e35")
+	sender := common.HexToAddress("0x9427a30991170f917d7b83def6e44d26577871ed")
+
+	backend := &mockEventsBackend{
+		finalized: 1150,
+		logs: []types.Log{
+			packPoolLog(t, "SubscribeValidator", 1005, 0, sender, big.NewInt(1000), uint64(33)),
+			packPoolLog(t, "EtherReceived", 1005, 1, sender, big.NewInt(500)),
+			packPoolLog(t, "EtherReceived", 1005, 2, sender, big.NewInt(600)),
+			packPoolLog(t, "UnsubscribeValidator", 1099, 0, sender, uint64(33)),
+			packPoolLog(t, "ReportConsolidated", 1120, 0, big.NewInt(7000), [32]byte{0x1}),
+			{Address: poolAddress, Topics: []common.Hash{{0xff}}, BlockNumber: 1120, Index: 1},
+		},
+	}
+
+	filterer, err := contract.NewContractFilterer(poolAddress, backend)
+	require.NoError(t, err)
+	indexer, err := NewEventIndexer(backend, filterer, poolAddress, 100)
+	require.NoError(t, err)
+
+	events, err := indexer.GetEvents(1000)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(events.EtherReceived))
+
+	events, err = indexer.GetEvents(1005)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(events.SubscribeValidator))
+	require.Equal(t, uint64(33), events.SubscribeValidator[0].ValidatorID)
+	require.Equal(t, big.NewInt(1000), events.SubscribeValidator[0].SubscriptionCollateral)
+	require.Equal(t, uint64(1005), events.SubscribeValidator[0].Raw.BlockNumber)
+	require.Equal(t, 2, len(events.EtherReceived))
+	require.Equal(t, big.NewInt(500), events.EtherReceived[0].DonationAmount)
+	require.Equal(t, big.NewInt(600), events.EtherReceived[1].DonationAmount)
+
+	events, err = indexer.GetEvents(1099)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(events.UnsubscribeValidator))
+	require.Equal(t, sender, events.UnsubscribeValidator[0].Sender)
+
+	// All served with a single call
+	require.Equal(t, 1, len(backend.queries))
+	require.Equal(t, uint64(1000), backend.queries[0].FromBlock.Uint64())
+	require.Equal(t, uint64(1099), backend.queries[0].ToBlock.Uint64())
+	require.Equal(t, []common.Address{poolAddress}, backend.queries[0].Addresses)
+
+	// Next range is capped at the finalized block. Unknown logs are skipped
+	events, err = indexer.GetEvents(1120)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(events.ReportConsolidated))
+	require.Equal(t, big.NewInt(7000), events.ReportConsolidated[0].SlotNumber)
+	require.Equal(t, 2, len(backend.queries))
+	require.Equal(t, uint64(1150), backend.queries[1].ToBlock.Uint64())
+
+	// The previous range is still cached
+	events, err = indexer.GetEvents(1005)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(events.EtherReceived))
+	require.Equal(t, 2, len(backend.queries))
+
+	// Beyond the finalized block, a new range is fetched
+	backend.finalized = 1300
+	_, err = indexer.GetEvents(1151)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(backend.queries))
+	require.Equal(t, uint64(1250), backend.queries[2].ToBlock.Uint64())
+
+	// Not available beyond the finalized block, and nothing is cached
+	_, err = indexer.GetEvents(1301)
+	require.Equal(t, ErrBlockNotFinalized, err)
+	require.Equal(t, 3, len(backend.queries))
+	backend.finalized = 1310
+	_, err = indexer.GetEvents(1301)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(backend.queries))
+	require.Equal(t, uint64(1301), backend.queries[3].FromBlock.Uint64())
+	require.Equal(t, uint64(1310), backend.queries[3].ToBlock.Uint64())
+}
+
+// Backend whose log queries block until released
+type blockingEventsBackend struct {
+	mockEventsBackend
+	release chan struct{}
+}
+
+func (m *blockingEventsBackend) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	<-m.release
+	return m.mockEventsBackend.FilterLogs(ctx, q)
+}
+
+func Test_EventIndexer_Concurrent(t *testing.T) {
+	poolAddress := common.HexToAddress("0xAdFb8D27671F14f297eE94135e266aAFf8752e35")
+	backend := &blockingEventsBackend{
+		mockEventsBackend: mockEventsBackend{finalized: 10000},
+		release:           make(chan struct{}),
+	}
+	filterer, err := contract.NewContractFilterer(poolAddress, backend)
+	require.NoError(t, err)
+	indexer, err := NewEventIndexer(backend, filterer, poolAddress, 100)
+	require.NoError(t, err)
+
+	// A first range is indexed
+	go func() { backend.release <- struct{}{} }()
+	_, err = indexer.GetEvents(1000)
+	require.NoError(t, err)
+
+	// While the next one is being fetched, and another caller waits for it, the
+	// cached one is still served
+	results := make(chan error, 2)
+	getEvents := func(blockNumber uint64) {
+		_, err := indexer.GetEvents(blockNumber)
+		results <- err
+	}
+	go getEvents(1100)
+	require.Eventually(t, func() bool {
+		indexer.mutex.Lock()
+		defer indexer.mutex.Unlock()
+		return len(indexer.pending) == 1
+	}, time.Second, time.Millisecond)
+	go getEvents(1150)
+	_, err = indexer.GetEvents(1050)
+	require.NoError(t, err)
+
+	// Only fetched once
+	backend.release <- struct{}{}
+	require.NoError(t, <-results)
+	require.NoError(t, <-results)
+	require.Equal(t, 2, len(backend.queries))
+}
+
+func Test_NewEventIndexer(t *testing.T) {
+	_, err := NewEventIndexer(&mockEventsBackend{}, nil, common.Address{}, 0)
+	require.Error(t, err)
+}
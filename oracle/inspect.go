@@ -0,0 +1,209 @@
+package oracle
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Merkle proof of a withdrawal address at a checkpoint, with the same fields
+// the api serves, except the ones that require reading the contract. Pruned
+// checkpoints don't know the validators of the withdrawal address
+type CheckpointProof struct {
+	LeafWithdrawalAddress  string   `json:"leaf_withdrawal_address"`
+	LeafAccumulatedBalance string   `json:"leaf_accumulated_balance"`
+	MerkleRoot             string   `json:"merkleroot"`
+	CheckpointSlot         uint64   `json:"checkpoint_slot"`
+	Proofs                 []string `json:"merkle_proofs"`
+	RegisteredValidators   []uint64 `json:"registered_validators"`
+	PendingRewardsWei      string   `json:"pending_rewards_wei"`
+	Pruned                 bool     `json:"pruned,omitempty"`
+}
+
+// Result of verifying the merkle proof of a leaf against a root, as the contract
+// does before paying the rewards of a claim
+type ProofVerification struct {
+	LeafWithdrawalAddress  string   `json:"leaf_withdrawal_address"`
+	LeafAccumulatedBalance string   `json:"leaf_accumulated_balance"`
+	MerkleRoot             string   `json:"merkleroot"`
+	CheckpointSlot         uint64   `json:"checkpoint_slot,omitempty"`
+	Proofs                 []string `json:"merkle_proofs"`
+	Valid                  bool     `json:"valid"`
+}
+
+// Summary of a state, to inspect it without loading it in a running oracle
+type StateSummary struct {
+	Network              string         `json:"network"`
+	PoolAddress          string         `json:"pool_address"`
+	DeployedSlot         uint64         `json:"deployed_slot"`
+	LatestProcessedSlot  uint64         `json:"latest_processed_slot"`
+	LatestProcessedBlock uint64         `json:"latest_processed_block"`
+	NextSlotToProcess    uint64         `json:"next_slot_to_process"`
+	PoolAccumulatedFees  string         `json:"pool_accumulated_fees"`
+	Validators           int            `json:"validators"`
+	ValidatorsByStatus   map[string]int `json:"validators_by_status"`
+	Checkpoints          []uint64       `json:"checkpoints"`
+	LatestCheckpoint     uint64         `json:"latest_checkpoint"`
+	LatestMerkleRoot     string         `json:"latest_merkle_root"`
+	Events               map[string]int `json:"events"`
+	Blocks               map[string]int `json:"blocks"`
+	StateHash            string         `json:"state_hash"`
+}
+
+// Returns the slot of the latest checkpoint of the state, false if there is none
+func LatestCheckpointSlot(state *OracleState) (uint64, bool) {
+	if len(state.CommitedStates) == 0 {
+		return 0, false
+	}
+	latestSlot := uint64(0)
+	for slot := range state.CommitedStates {
+		if slot > latestSlot {
+			latestSlot = slot
+		}
+	}
+	return latestSlot, true
+}
+
+// Returns the merkle proof of the withdrawal address at the checkpoint of the
+// given slot, or at the latest checkpoint if the slot is 0
+func GetCheckpointProof(state *OracleState, slot uint64, withdrawalAddress string) (*CheckpointProof, error) {
+	return getCheckpointProof(state, slot, withdrawalAddress, func(checkpoint *OnchainState) ([]string, error) {
+		return CheckpointMerkleProof(state, checkpoint, withdrawalAddress)
+	})
+}
+
+func getCheckpointProof(
+	state *OracleState,
+	slot uint64,
+	withdrawalAddress string,
+	merkleProof func(checkpoint *OnchainState) ([]string, error)) (*CheckpointProof, error) {
+
+	if slot == 0 {
+		latestSlot, found := LatestCheckpointSlot(state)
+		if !found {
+			return nil, errors.New("state has no checkpoints")
+		}
+		slot = latestSlot
+	}
+
+	checkpoint, found := state.CommitedStates[slot]
+	if !found {
+		return nil, errors.New(fmt.Sprintf("could not find checkpoint at slot %d", slot))
+	}
+
+	// Leafs are stored with lowercase addresses
+	withdrawalAddress = strings.ToLower(withdrawalAddress)
+
+	leaf, found := checkpoint.Leafs[withdrawalAddress]
+	if !found {
+		return nil, errors.New("could not find leafs for withdrawal address: " + withdrawalAddress)
+	}
+	proofs, err := merkleProof(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	registeredValidators := make([]uint64, 0)
+	totalPending := big.NewInt(0)
+	for valIndex, validator := range checkpoint.Validators {
+		if strings.ToLower(validator.WithdrawalAddress) == withdrawalAddress {
+			registeredValidators = append(registeredValidators, valIndex)
+			totalPending.Add(totalPending, validator.PendingRewardsWei)
+		}
+	}
+	sort.Slice(registeredValidators, func(i, j int) bool { return registeredValidators[i] < registeredValidators[j] })
+
+	return &CheckpointProof{
+		LeafWithdrawalAddress:  leaf.WithdrawalAddress,
+		LeafAccumulatedBalance: leaf.AccumulatedBalanceWei.String(),
+		MerkleRoot:             checkpoint.MerkleRoot,
+		CheckpointSlot:         checkpoint.Slot,
+		Proofs:                 proofs,
+		RegisteredValidators:   registeredValidators,
+		PendingRewardsWei:      totalPending.String(),
+		Pruned:                 checkpoint.Pruned,
+	}, nil
+}
+
+// Summarizes the progress, validators, checkpoints and events of the state
+func SummarizeState(state *OracleState) *StateSummary {
+	validatorsByStatus := make(map[string]int)
+	for _, validator := range state.Validators {
+		validatorsByStatus[validator.ValidatorStatus.String()]++
+	}
+
+	checkpoints := make([]uint64, 0, len(state.CommitedStates))
+	for slot := range state.CommitedStates {
+		checkpoints = append(checkpoints, slot)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i] < checkpoints[j] })
+
+	latestCheckpoint, latestRoot := uint64(0), ""
+	if len(checkpoints) != 0 {
+		latestCheckpoint = checkpoints[len(checkpoints)-1]
+		latestRoot = state.CommitedStates[latestCheckpoint].MerkleRoot
+	}
+
+	poolFees := "0"
+	if state.PoolAccumulatedFees != nil {
+		poolFees = state.PoolAccumulatedFees.String()
+	}
+
+	return &StateSummary{
+		Network:              state.Network,
+		PoolAddress:          state.PoolAddress,
+		DeployedSlot:         state.DeployedSlot,
+		LatestProcessedSlot:  state.LatestProcessedSlot,
+		LatestProcessedBlock: state.LatestProcessedBlock,
+		NextSlotToProcess:    state.NextSlotToProcess,
+		PoolAccumulatedFees:  poolFees,
+		Validators:           len(state.Validators),
+		ValidatorsByStatus:   validatorsByStatus,
+		Checkpoints:          checkpoints,
+		LatestCheckpoint:     latestCheckpoint,
+		LatestMerkleRoot:     latestRoot,
+		Events: map[string]int{
+			"subscriptions":   len(state.SubscriptionEvents),
+			"unsubscriptions": len(state.UnsubscriptionEvents),
+			"ether_received":  len(state.EtherReceivedEvents),
+			"donations":       len(state.Donations),
+		},
+		Blocks: map[string]int{
+			"proposed":  len(state.ProposedBlocks),
+			"missed":    len(state.MissedBlocks),
+			"wrong_fee": len(state.WrongFeeBlocks),
+		},
+		StateHash: state.StateHash,
+	}
+}
+
+// Verifies the merkle proof of the leaf of a withdrawal address, with its accumulated
+// balance in wei as a base 10 string, against the given root
+func VerifyLeafProof(root string, withdrawalAddress string, accumulatedBalance string, proofs []string) (*ProofVerification, error) {
+	balance, ok := new(big.Int).SetString(accumulatedBalance, 10)
+	if !ok {
+		return nil, errors.New("leaf accumulated balance is not a base 10 number: " + accumulatedBalance)
+	}
+
+	valid, err := NewMerklelizer().VerifyProof(root, RawLeaf{
+		WithdrawalAddress:     withdrawalAddress,
+		AccumulatedBalanceWei: balance,
+	}, proofs)
+	if err != nil {
+		return nil, err
+	}
+
+	if proofs == nil {
+		proofs = make([]string, 0)
+	}
+	return &ProofVerification{
+		LeafWithdrawalAddress:  strings.ToLower(withdrawalAddress),
+		LeafAccumulatedBalance: balance.String(),
+		MerkleRoot:             root,
+		Proofs:                 proofs,
+		Valid:                  valid,
+	}, nil
+}
@@ -0,0 +1,74 @@
+package oracle
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GetCheckpointProof(t *testing.T) {
+	oracle := newVerifyTestOracle(t, []uint64{100, 200})
+	state := oracle.state
+
+	// Defaults to the latest checkpoint, and addresses are case insensitive
+	proof, err := GetCheckpointProof(state, 0, "0x1000000000000000000000000000000000000000")
+	require.NoError(t, err)
+	require.Equal(t, uint64(200), proof.CheckpointSlot)
+	require.Equal(t, state.CommitedStates[200].MerkleRoot, proof.MerkleRoot)
+	require.Equal(t, "2000", proof.LeafAccumulatedBalance)
+	require.Equal(t, []uint64{1}, proof.RegisteredValidators)
+	require.Equal(t, "0", proof.PendingRewardsWei)
+	proofs, err := oracle.GetMerkleProof(200, "0x1000000000000000000000000000000000000000")
+	require.NoError(t, err)
+	require.Equal(t, proofs, proof.Proofs)
+
+	proof, err = GetCheckpointProof(state, 100, "0x1000000000000000000000000000000000000000")
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), proof.CheckpointSlot)
+	require.Equal(t, "1000", proof.LeafAccumulatedBalance)
+	proofs, err = oracle.GetMerkleProof(100, "0x1000000000000000000000000000000000000000")
+	require.NoError(t, err)
+	require.Equal(t, proofs, proof.Proofs)
+
+	_, err = GetCheckpointProof(state, 150, "0x1000000000000000000000000000000000000000")
+	require.Error(t, err)
+
+	_, err = GetCheckpointProof(state, 0, "0x2000000000000000000000000000000000000000")
+	require.Error(t, err)
+
+	_, err = GetCheckpointProof(NewOracle(&Config{}).state, 0, "0x1000000000000000000000000000000000000000")
+	require.Error(t, err)
+}
+
+func Test_SummarizeState(t *testing.T) {
+	oracle := newVerifyTestOracle(t, []uint64{100, 200})
+	oracle.state.Validators[2] = &ValidatorInfo{
+		ValidatorStatus: YellowCard,
+		ValidatorIndex:  2,
+	}
+
+	summary := SummarizeState(oracle.state)
+	require.Equal(t, 2, summary.Validators)
+	require.Equal(t, map[string]int{"active": 1, "yellowcard": 1}, summary.ValidatorsByStatus)
+	require.Equal(t, []uint64{100, 200}, summary.Checkpoints)
+	require.Equal(t, uint64(200), summary.LatestCheckpoint)
+	require.Equal(t, oracle.state.CommitedStates[200].MerkleRoot, summary.LatestMerkleRoot)
+	require.Equal(t, "300", summary.PoolAccumulatedFees)
+}
+
+func Test_WriteStateFile(t *testing.T) {
+	oracle := newVerifyTestOracle(t, []uint64{100})
+	path := filepath.Join(t.TempDir(), "exported.json")
+
+	// Sets the hash of the state
+	store := NewJsonStateStore(t.TempDir())
+	oracle.SetStateStore(store)
+	require.NoError(t, oracle.SaveState(false))
+
+	require.NoError(t, WriteStateFile(oracle.state, path))
+	loaded, err := LoadStateFile(path)
+	require.NoError(t, err)
+	require.Equal(t, oracle.state.StateHash, loaded.StateHash)
+	require.Equal(t, oracle.state.CommitedStates[100].MerkleRoot, loaded.CommitedStates[100].MerkleRoot)
+}
@@ -1,15 +1,19 @@
 package oracle
 
 import (
+	"bytes"
 	"encoding/hex"
+	"fmt"
 	"math/big"
 	"sort"
 	"strings"
 
-	"github.com/dappnode/mev-sp-oracle/utils"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/dappnode/mev-sp-oracle/utils"
 	solsha3 "github.com/miguelmota/go-solidity-sha3"
 	mt "github.com/txaty/go-merkletree"
 	"golang.org/x/crypto/sha3"
@@ -29,7 +33,10 @@ func KeccakHash(data []byte) ([]byte, error) {
 	return hash.Sum(nil), nil
 }
 
+// Merklelizer keeps the tree of the latest leafs it was given, so that the next
+// tree only hashes again the leafs whose balance changed
 type Merklelizer struct {
+	tree *LeafsTree
 }
 
 func NewMerklelizer() *Merklelizer {
@@ -40,49 +47,31 @@ func NewMerklelizer() *Merklelizer {
 // Aggregates all validators indexes that belong to the same withdrawal address. This
 // allows the merkle tree to hold all validators balance belonging to the same set
 // of validators, that makes claiming cheaper since only one proof is needed for n validators
-// belonging to the same withdrawal address
+// belonging to the same withdrawal address. Addresses are compared in lowercase, using
+// a map so that it scales to many validators: O(n) plus sorting the leafs
 func (merklelizer *Merklelizer) AggregateValidatorsIndexes(state *OracleState) []RawLeaf {
 
-	// Creates an array of leaf. Each leaf contains the withdrawal address and the accumulated balance
-	// for all the validators belonging to the same withdrawal address
-	allLeafs := make([]RawLeaf, 0)
+	// Accumulated balance of all the validators belonging to each withdrawal address
+	balances := make(map[string]*big.Int, len(state.Validators))
+	allAccumulatedFromValidators := big.NewInt(0)
 
-	// Iterate all validators
 	for _, validator := range state.Validators {
-
-		// If the withdrawal addresd was found before
-		found := false
-
-		// If the leaf already exists, add the balance to the existing leaf (by withdrawal address)
-		for _, leaf := range allLeafs {
-			if utils.Equals(leaf.WithdrawalAddress, validator.WithdrawalAddress) {
-				leaf.AccumulatedBalanceWei.Add(leaf.AccumulatedBalanceWei, validator.AccumulatedRewardsWei)
-				found = true
-				continue
-			}
-		}
-
-		// If the leaf does not exist, create a new one, initing the balance to the current validator balance
+		// In lowercase to avoid confusion when claiming
+		withdrawalAddress := strings.ToLower(validator.WithdrawalAddress)
+		balance, found := balances[withdrawalAddress]
 		if !found {
-			allLeafs = append(allLeafs, RawLeaf{
-				// In lowercase to avoid confusion when claiming
-				WithdrawalAddress: strings.ToLower(validator.WithdrawalAddress),
-				// Copy the value
-				AccumulatedBalanceWei: new(big.Int).Set(validator.AccumulatedRewardsWei),
-			})
+			balance = big.NewInt(0)
+			balances[withdrawalAddress] = balance
 		}
+		balance.Add(balance, validator.AccumulatedRewardsWei)
+		allAccumulatedFromValidators.Add(allAccumulatedFromValidators, validator.AccumulatedRewardsWei)
 	}
 
 	// Run a sanity check to make sure the after the transformations we are distributing
 	// the same amount of rewards as the total accumulated rewards
-	allAccumulatedFromValidators := big.NewInt(0)
-	for _, validator := range state.Validators {
-		allAccumulatedFromValidators.Add(allAccumulatedFromValidators, validator.AccumulatedRewardsWei)
-	}
-
 	allAccumulatedFromwithdrawals := big.NewInt(0)
-	for _, WithdrawalAddressAccumulated := range allLeafs {
-		allAccumulatedFromwithdrawals.Add(allAccumulatedFromwithdrawals, WithdrawalAddressAccumulated.AccumulatedBalanceWei)
+	for _, balance := range balances {
+		allAccumulatedFromwithdrawals.Add(allAccumulatedFromwithdrawals, balance)
 	}
 
 	if allAccumulatedFromValidators.Cmp(allAccumulatedFromwithdrawals) != 0 {
@@ -90,6 +79,25 @@ func (merklelizer *Merklelizer) AggregateValidatorsIndexes(state *OracleState) [
 			allAccumulatedFromValidators, " vs ", allAccumulatedFromwithdrawals)
 	}
 
+	// Former pool fee recipients keep the fees they accumulated
+	for formerAddress, fees := range state.FormerPoolFees {
+		formerAddress = strings.ToLower(formerAddress)
+		balance, found := balances[formerAddress]
+		if !found {
+			balance = big.NewInt(0)
+			balances[formerAddress] = balance
+		}
+		balance.Add(balance, fees)
+	}
+
+	allLeafs := make([]RawLeaf, 0, len(balances))
+	for withdrawalAddress, balance := range balances {
+		allLeafs = append(allLeafs, RawLeaf{
+			WithdrawalAddress:     withdrawalAddress,
+			AccumulatedBalanceWei: balance,
+		})
+	}
+
 	// Order the leafs by withdrawal address
 	orderedByWithdrawalAddress := merklelizer.OrderByWithdrawalAddress(allLeafs)
 
@@ -191,3 +199,263 @@ func (merklelizer *Merklelizer) GenerateTreeFromState(state *OracleState) (map[s
 
 	return withdrawalToLeaf, withdrawalToRawLeaf, tree, true
 }
+
+// Merkle tree of the leafs of a state, with the same root and proofs as the tree
+// of GenerateTreeFromState. All its nodes are kept, so that when the balance of
+// some leafs changes only them and their path to the root are hashed again.
+type LeafsTree struct {
+	leafs   []RawLeaf
+	indexes map[string]int
+
+	// Hashes of each level, from the leafs to the root. Levels with an odd number
+	// of nodes are not padded, the last node is paired with itself instead
+	nodes [][][]byte
+}
+
+// Creates the tree of the given leafs, in the same order as AggregateValidatorsIndexes
+// returns them. Returns false if there are not enough leafs to create a tree
+func NewLeafsTree(leafs []RawLeaf) (*LeafsTree, bool) {
+	if len(leafs) < 2 {
+		return nil, false
+	}
+
+	tree := &LeafsTree{
+		leafs:   leafs,
+		indexes: make(map[string]int, len(leafs)),
+	}
+	hashedLeafs := make([][]byte, len(leafs))
+	for i, leaf := range leafs {
+		tree.indexes[leaf.WithdrawalAddress] = i
+		hashedLeafs[i] = hashLeaf(leaf)
+	}
+
+	tree.nodes = [][][]byte{hashedLeafs}
+	for level := hashedLeafs; len(level) > 1; {
+		parents := make([][]byte, (len(level)+1)/2)
+		for i := range parents {
+			parents[i] = hashPair(level, 2*i)
+		}
+		tree.nodes = append(tree.nodes, parents)
+		level = parents
+	}
+	return tree, true
+}
+
+// Same leaf as the ones hashed in GenerateTreeFromState
+func hashLeaf(leaf RawLeaf) []byte {
+	return solsha3.SoliditySHA3(
+		solsha3.Address(leaf.WithdrawalAddress),
+		solsha3.Uint256(leaf.AccumulatedBalanceWei),
+	)
+}
+
+// Hashes the node at the given index with its sibling, sorting the pair and
+// duplicating the last node of odd levels, as the merkle tree library does
+func hashPair(level [][]byte, index int) []byte {
+	left, right := level[index], level[index]
+	if index+1 < len(level) {
+		right = level[index+1]
+	}
+	if bytes.Compare(left, right) > 0 {
+		left, right = right, left
+	}
+	pair := make([]byte, 0, len(left)+len(right))
+	pair = append(pair, left...)
+	pair = append(pair, right...)
+	hash, _ := KeccakHash(pair)
+	return hash
+}
+
+// Returns true if the tree has the same withdrawal addresses in the same order
+func (t *LeafsTree) sameAddresses(leafs []RawLeaf) bool {
+	if len(t.leafs) != len(leafs) {
+		return false
+	}
+	for i := range leafs {
+		if t.leafs[i].WithdrawalAddress != leafs[i].WithdrawalAddress {
+			return false
+		}
+	}
+	return true
+}
+
+// Updates the balances of the leafs, that must have the same addresses as the tree,
+// hashing again only the path to the root of the ones that changed. Returns the
+// number of leafs that changed
+func (t *LeafsTree) updateBalances(leafs []RawLeaf) int {
+	changed := make([]int, 0)
+	for i, leaf := range leafs {
+		if t.leafs[i].AccumulatedBalanceWei.Cmp(leaf.AccumulatedBalanceWei) != 0 {
+			changed = append(changed, i)
+			t.nodes[0][i] = hashLeaf(leaf)
+		}
+	}
+	t.leafs = leafs
+	numChanged := len(changed)
+
+	// Indexes are ascending, so the parents of consecutive ones are consecutive too
+	for level := 1; level < len(t.nodes) && len(changed) > 0; level++ {
+		parents := changed[:0]
+		for _, index := range changed {
+			parent := index / 2
+			if len(parents) != 0 && parents[len(parents)-1] == parent {
+				continue
+			}
+			parents = append(parents, parent)
+			t.nodes[level][parent] = hashPair(t.nodes[level-1], 2*parent)
+		}
+		changed = parents
+	}
+	return numChanged
+}
+
+// Returns the 0x prefixed merkle root of the tree
+func (t *LeafsTree) Root() string {
+	return hexutil.Encode(t.nodes[len(t.nodes)-1][0])
+}
+
+// Returns the leafs of the tree by withdrawal address
+func (t *LeafsTree) Leafs() map[string]RawLeaf {
+	leafs := make(map[string]RawLeaf, len(t.leafs))
+	for _, leaf := range t.leafs {
+		leafs[leaf.WithdrawalAddress] = leaf
+	}
+	return leafs
+}
+
+// Returns the merkle proof of the leaf of the withdrawal address, to be used onchain,
+// or false if the address has no leaf in the tree
+func (t *LeafsTree) Proof(withdrawalAddress string) ([]string, bool) {
+	index, found := t.indexes[strings.ToLower(withdrawalAddress)]
+	if !found {
+		return nil, false
+	}
+	siblings := make([][]byte, 0, len(t.nodes)-1)
+	for level := 0; level < len(t.nodes)-1; level++ {
+		sibling := index ^ 1
+		if sibling >= len(t.nodes[level]) {
+			sibling = index
+		}
+		siblings = append(siblings, t.nodes[level][sibling])
+		index /= 2
+	}
+	return utils.ByteArrayToArray(siblings), true
+}
+
+// Updates the tree kept by the merklelizer with the leafs of the state. Only the
+// leafs whose balance changed are hashed again, unless the withdrawal addresses
+// changed, since then the leafs are reordered and the whole tree is created again.
+// Returns false if there was not enough information to create a tree
+func (merklelizer *Merklelizer) UpdateTree(state *OracleState) (*LeafsTree, bool) {
+	leafs := merklelizer.AggregateValidatorsIndexes(state)
+
+	if merklelizer.tree != nil && merklelizer.tree.sameAddresses(leafs) {
+		changed := merklelizer.tree.updateBalances(leafs)
+		log.WithFields(log.Fields{
+			"Leafs":        len(leafs),
+			"ChangedLeafs": changed,
+		}).Info("Updating tree")
+		return merklelizer.tree, true
+	}
+
+	tree, enoughData := NewLeafsTree(leafs)
+	if !enoughData {
+		return nil, false
+	}
+	log.WithFields(log.Fields{
+		"Leafs": len(leafs),
+	}).Info("Generating tree")
+	merklelizer.tree = tree
+	return tree, true
+}
+
+// Creates the tree of a checkpoint from its leafs. Fails if it doesn't match the
+// merkle root of the checkpoint.
+func NewCheckpointTree(state *OracleState, checkpoint *OnchainState) (*LeafsTree, error) {
+	tree, err := checkpointLeafsTree(state, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	if tree.Root() != checkpoint.MerkleRoot {
+		return nil, errors.New(fmt.Sprintf("merkle root of the leafs of checkpoint at slot %d does not match: %s vs %s",
+			checkpoint.Slot, tree.Root(), checkpoint.MerkleRoot))
+	}
+	return tree, nil
+}
+
+// Creates the tree of the leafs of a checkpoint, with the pool fees leaf of that slot first
+func checkpointLeafsTree(state *OracleState, checkpoint *OnchainState) (*LeafsTree, error) {
+	poolFeesAddress := strings.ToLower(state.PoolParamsAt(checkpoint.Slot).PoolFeesAddress)
+	poolFeesLeaf, found := checkpoint.Leafs[poolFeesAddress]
+	if !found {
+		return nil, errors.New("could not find the pool fees leaf of checkpoint: " + poolFeesAddress)
+	}
+
+	leafs := make([]RawLeaf, 0, len(checkpoint.Leafs))
+	for withdrawalAddress, leaf := range checkpoint.Leafs {
+		if withdrawalAddress != poolFeesAddress {
+			leafs = append(leafs, leaf)
+		}
+	}
+	leafs = append([]RawLeaf{poolFeesLeaf}, NewMerklelizer().OrderByWithdrawalAddress(leafs)...)
+
+	tree, enoughData := NewLeafsTree(leafs)
+	if !enoughData {
+		return nil, errors.New(fmt.Sprintf("checkpoint at slot %d has not enough leafs to create a tree", checkpoint.Slot))
+	}
+	return tree, nil
+}
+
+// Returns the merkle proof of the withdrawal address at a checkpoint. Checkpoints
+// frozen by older versions have their proofs stored, otherwise it's generated
+// from the leafs of the checkpoint
+func CheckpointMerkleProof(state *OracleState, checkpoint *OnchainState, withdrawalAddress string) ([]string, error) {
+	withdrawalAddress = strings.ToLower(withdrawalAddress)
+	if checkpoint.Proofs != nil {
+		proofs, found := checkpoint.Proofs[withdrawalAddress]
+		if !found {
+			return nil, errors.New("could not find proof for withdrawal address: " + withdrawalAddress)
+		}
+		return proofs, nil
+	}
+
+	tree, err := NewCheckpointTree(state, checkpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create tree of checkpoint")
+	}
+	proofs, found := tree.Proof(withdrawalAddress)
+	if !found {
+		return nil, errors.New("could not find proof for withdrawal address: " + withdrawalAddress)
+	}
+	return proofs, nil
+}
+
+// Verifies a merkle proof of a leaf the same way the contract does when claiming:
+// hashing the leaf as solsha3(address, uint256) and then each sorted pair of nodes
+// with keccak, up to the given 0x prefixed root. Returns an error if any of the
+// inputs is malformed, and false if they are well formed but the proof is not valid
+func (merklelizer *Merklelizer) VerifyProof(root string, leaf RawLeaf, proof []string) (bool, error) {
+	rootBytes, err := hexutil.Decode(root)
+	if err != nil || len(rootBytes) != 32 {
+		return false, errors.New("merkle root is not a 0x prefixed 32 bytes hash: " + root)
+	}
+	if !common.IsHexAddress(leaf.WithdrawalAddress) {
+		return false, errors.New("leaf withdrawal address is not a valid address: " + leaf.WithdrawalAddress)
+	}
+	if leaf.AccumulatedBalanceWei == nil || leaf.AccumulatedBalanceWei.Sign() < 0 || leaf.AccumulatedBalanceWei.BitLen() > 256 {
+		return false, errors.New("leaf accumulated balance must be an uint256")
+	}
+
+	node := hashLeaf(RawLeaf{
+		WithdrawalAddress:     strings.ToLower(leaf.WithdrawalAddress),
+		AccumulatedBalanceWei: leaf.AccumulatedBalanceWei,
+	})
+	for i, sibling := range proof {
+		siblingBytes, err := hexutil.Decode(sibling)
+		if err != nil || len(siblingBytes) != 32 {
+			return false, errors.New(fmt.Sprintf("proof %d is not a 0x prefixed 32 bytes hash: %s", i, sibling))
+		}
+		node = hashPair([][]byte{node, siblingBytes}, 0)
+	}
+	return bytes.Equal(node, rootBytes), nil
+}
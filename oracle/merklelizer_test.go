@@ -4,8 +4,12 @@ import (
 	"encoding/hex"
 	"fmt"
 	"math/big"
+	"strings"
 	"testing"
 
+	"github.com/dappnode/mev-sp-oracle/utils"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,6 +55,149 @@ func Test_GenerateTreeFromState(t *testing.T) {
 	require.Equal(t, 7, len(addressToLeaf))
 }
 
+// Checks that the root and proofs of the kept tree match the ones of the library
+func requireTreeMatchesLibrary(t *testing.T, state *OracleState, tree *LeafsTree) {
+	withdrawalToLeaf, _, libraryTree, enoughData := NewMerklelizer().GenerateTreeFromState(state)
+	require.True(t, enoughData)
+	require.Equal(t, "0x"+hex.EncodeToString(libraryTree.Root), tree.Root())
+	require.Equal(t, len(withdrawalToLeaf), len(tree.Leafs()))
+	for withdrawalAddress, block := range withdrawalToLeaf {
+		libraryProof, err := libraryTree.Proof(block)
+		require.NoError(t, err)
+		proof, found := tree.Proof(withdrawalAddress)
+		require.True(t, found)
+		require.Equal(t, utils.ByteArrayToArray(libraryProof.Siblings), proof)
+	}
+}
+
+func Test_LeafsTree_MatchesLibrary(t *testing.T) {
+	// Odd and even number of leafs at every level
+	for numValidators := 1; numValidators <= 33; numValidators++ {
+		state := newBenchmarkState(numValidators * 4)
+		tree, enoughData := NewLeafsTree(NewMerklelizer().AggregateValidatorsIndexes(state))
+		require.True(t, enoughData)
+		requireTreeMatchesLibrary(t, state, tree)
+	}
+
+	_, enoughData := NewLeafsTree([]RawLeaf{{"0x1000000000000000000000000000000000000000", big.NewInt(1)}})
+	require.False(t, enoughData)
+}
+
+func Test_Merklelizer_UpdateTree(t *testing.T) {
+	merklelizer := NewMerklelizer()
+	state := newBenchmarkState(101)
+
+	tree, enoughData := merklelizer.UpdateTree(state)
+	require.True(t, enoughData)
+	requireTreeMatchesLibrary(t, state, tree)
+
+	// Only some balances change, the same tree is updated
+	state.Validators[0].AccumulatedRewardsWei = big.NewInt(5000)
+	state.Validators[57].AccumulatedRewardsWei = big.NewInt(6000)
+	state.Validators[100].AccumulatedRewardsWei = big.NewInt(7000)
+	state.PoolAccumulatedFees = big.NewInt(2000)
+	updated, enoughData := merklelizer.UpdateTree(state)
+	require.True(t, enoughData)
+	require.Same(t, tree, updated)
+	requireTreeMatchesLibrary(t, state, updated)
+
+	// Nothing changed
+	updated, _ = merklelizer.UpdateTree(state)
+	require.Same(t, tree, updated)
+	requireTreeMatchesLibrary(t, state, updated)
+
+	// A new withdrawal address reorders the leafs, so the tree is created again
+	state.Validators[101] = &ValidatorInfo{
+		ValidatorStatus:       Active,
+		AccumulatedRewardsWei: big.NewInt(1),
+		PendingRewardsWei:     big.NewInt(0),
+		WithdrawalAddress:     "0x0000000000000000000000000000000000000abc",
+		ValidatorIndex:        101,
+	}
+	updated, _ = merklelizer.UpdateTree(state)
+	require.NotSame(t, tree, updated)
+	requireTreeMatchesLibrary(t, state, updated)
+
+	_, found := updated.Proof("0x9000000000000000000000000000000000000000")
+	require.False(t, found)
+}
+
+func Test_VerifyProof(t *testing.T) {
+	merklelizer := NewMerklelizer()
+	state := newBenchmarkState(41)
+	tree, enoughData := merklelizer.UpdateTree(state)
+	require.True(t, enoughData)
+
+	for _, leaf := range tree.Leafs() {
+		proof, found := tree.Proof(leaf.WithdrawalAddress)
+		require.True(t, found)
+		valid, err := merklelizer.VerifyProof(tree.Root(), leaf, proof)
+		require.NoError(t, err)
+		require.True(t, valid)
+
+		// Addresses are verified no matter their case
+		valid, err = merklelizer.VerifyProof(tree.Root(), RawLeaf{
+			WithdrawalAddress:     common.HexToAddress(leaf.WithdrawalAddress).String(),
+			AccumulatedBalanceWei: leaf.AccumulatedBalanceWei,
+		}, proof)
+		require.NoError(t, err)
+		require.True(t, valid)
+
+		// Another balance or proof is not valid
+		valid, err = merklelizer.VerifyProof(tree.Root(), RawLeaf{
+			WithdrawalAddress:     leaf.WithdrawalAddress,
+			AccumulatedBalanceWei: new(big.Int).Add(leaf.AccumulatedBalanceWei, big.NewInt(1)),
+		}, proof)
+		require.NoError(t, err)
+		require.False(t, valid)
+		valid, err = merklelizer.VerifyProof(tree.Root(), leaf, proof[1:])
+		require.NoError(t, err)
+		require.False(t, valid)
+	}
+
+	leaf := tree.Leafs()[strings.ToLower(state.PoolFeesAddress)]
+	proof, found := tree.Proof(leaf.WithdrawalAddress)
+	require.True(t, found)
+	for _, invalid := range []struct {
+		root  string
+		leaf  RawLeaf
+		proof []string
+	}{
+		{"0x01", leaf, proof},
+		{tree.Root(), RawLeaf{"invalid", leaf.AccumulatedBalanceWei}, proof},
+		{tree.Root(), RawLeaf{leaf.WithdrawalAddress, nil}, proof},
+		{tree.Root(), RawLeaf{leaf.WithdrawalAddress, big.NewInt(-1)}, proof},
+		{tree.Root(), RawLeaf{leaf.WithdrawalAddress, new(big.Int).Lsh(big.NewInt(1), 256)}, proof},
+		{tree.Root(), leaf, append([]string{"0x01"}, proof...)},
+	} {
+		_, err := merklelizer.VerifyProof(invalid.root, invalid.leaf, invalid.proof)
+		require.Error(t, err)
+	}
+}
+
+func Test_NewCheckpointTree(t *testing.T) {
+	oracle := newVerifyTestOracle(t, []uint64{100, 200})
+	state := oracle.state
+
+	tree, err := NewCheckpointTree(state, state.CommitedStates[100])
+	require.NoError(t, err)
+	require.Equal(t, state.CommitedStates[100].MerkleRoot, tree.Root())
+
+	// Leafs that don't match the root
+	corrupted := *state.CommitedStates[100]
+	corrupted.MerkleRoot = state.CommitedStates[200].MerkleRoot
+	_, err = NewCheckpointTree(state, &corrupted)
+	require.Error(t, err)
+
+	// Proofs stored by older versions are used as they are
+	corrupted.Proofs = map[string][]string{"0x1000000000000000000000000000000000000000": {"0x01"}}
+	proofs, err := CheckpointMerkleProof(state, &corrupted, "0x1000000000000000000000000000000000000000")
+	require.NoError(t, err)
+	require.Equal(t, []string{"0x01"}, proofs)
+	_, err = CheckpointMerkleProof(state, &corrupted, "0x2000000000000000000000000000000000000000")
+	require.Error(t, err)
+}
+
 func Test_NotEnoughData(t *testing.T) {
 	merklelizer := NewMerklelizer()
 	oracle := NewOracle(&Config{
@@ -435,3 +582,133 @@ func Test_OrderByWithdrawalAddress(t *testing.T) {
 	ordered := merklelizer.OrderByWithdrawalAddress(leafs)
 	require.Equal(t, expected, ordered)
 }
+
+func Test_AggregateValidatorsIndexes_MixedCase(t *testing.T) {
+	merklelizer := NewMerklelizer()
+	oracle := NewOracle(&Config{
+		PoolFeesAddress: "0x0000000000000000000000000000000000000000",
+	})
+	state := oracle.state
+
+	state.Validators[0] = &ValidatorInfo{
+		WithdrawalAddress:     "0xabcd000000000000000000000000000000000000",
+		AccumulatedRewardsWei: big.NewInt(1),
+	}
+	state.Validators[1] = &ValidatorInfo{
+		WithdrawalAddress:     "0xABCD000000000000000000000000000000000000",
+		AccumulatedRewardsWei: big.NewInt(2),
+	}
+	state.Validators[2] = &ValidatorInfo{
+		WithdrawalAddress:     "0x1000000000000000000000000000000000000000",
+		AccumulatedRewardsWei: big.NewInt(4),
+	}
+	state.FormerPoolFees = map[string]*big.Int{
+		"0xabcd000000000000000000000000000000000000": big.NewInt(8),
+		"0x2000000000000000000000000000000000000000": big.NewInt(16),
+	}
+
+	leafs := merklelizer.AggregateValidatorsIndexes(state)
+	require.Equal(t, []RawLeaf{
+		{WithdrawalAddress: "0x0000000000000000000000000000000000000000", AccumulatedBalanceWei: big.NewInt(0)},
+		{WithdrawalAddress: "0x1000000000000000000000000000000000000000", AccumulatedBalanceWei: big.NewInt(4)},
+		{WithdrawalAddress: "0x2000000000000000000000000000000000000000", AccumulatedBalanceWei: big.NewInt(16)},
+		{WithdrawalAddress: "0xabcd000000000000000000000000000000000000", AccumulatedBalanceWei: big.NewInt(11)},
+	}, leafs)
+
+	// The balances of the state are not modified
+	require.Equal(t, big.NewInt(1), state.Validators[0].AccumulatedRewardsWei)
+	require.Equal(t, big.NewInt(8), state.FormerPoolFees["0xabcd000000000000000000000000000000000000"])
+
+	unique := oracle.GetUniqueWithdrawalAddresses()
+	require.Equal(t, 4, len(unique))
+	require.Equal(t, "0x0000000000000000000000000000000000000000", unique[2])
+	require.Equal(t, "0x2000000000000000000000000000000000000000", unique[3])
+}
+
+// Sizes of the synthetic states used in the benchmarks
+var benchmarkValidators = []int{10000, 50000, 100000, 200000}
+
+// State with the given number of validators with some rewards, where most
+// withdrawal addresses have a few validators, written in mixed case
+func newBenchmarkState(numValidators int) *OracleState {
+	oracle := NewOracle(&Config{
+		PoolFeesAddress: "0x0000000000000000000000000000000000000000",
+	})
+	state := oracle.state
+	state.PoolAccumulatedFees = big.NewInt(1000)
+	for i := 0; i < numValidators; i++ {
+		address := common.BigToAddress(big.NewInt(int64(i/4 + 1))).String()
+		if i%2 == 0 {
+			address = strings.ToLower(address)
+		}
+		state.Validators[uint64(i)] = &ValidatorInfo{
+			ValidatorStatus:       Active,
+			AccumulatedRewardsWei: big.NewInt(int64(i)),
+			PendingRewardsWei:     big.NewInt(0),
+			WithdrawalAddress:     address,
+			ValidatorIndex:        uint64(i),
+		}
+	}
+	return state
+}
+
+func BenchmarkAggregateValidatorsIndexes(b *testing.B) {
+	merklelizer := NewMerklelizer()
+	for _, numValidators := range benchmarkValidators {
+		state := newBenchmarkState(numValidators)
+		b.Run(fmt.Sprintf("validators_%d", numValidators), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				merklelizer.AggregateValidatorsIndexes(state)
+			}
+		})
+	}
+}
+
+func BenchmarkGetUniqueWithdrawalAddresses(b *testing.B) {
+	for _, numValidators := range benchmarkValidators {
+		oracle := &Oracle{state: newBenchmarkState(numValidators)}
+		b.Run(fmt.Sprintf("validators_%d", numValidators), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				oracle.GetUniqueWithdrawalAddresses()
+			}
+		})
+	}
+}
+
+func BenchmarkUpdateTree(b *testing.B) {
+	level := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(level)
+
+	for _, numValidators := range benchmarkValidators {
+		state := newBenchmarkState(numValidators)
+		merklelizer := NewMerklelizer()
+		merklelizer.UpdateTree(state)
+		b.Run(fmt.Sprintf("validators_%d", numValidators), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// Some validators get rewards between checkpoints
+				for index := uint64(0); index < 100; index++ {
+					state.Validators[index*7].AccumulatedRewardsWei.Add(state.Validators[index*7].AccumulatedRewardsWei, big.NewInt(1))
+				}
+				merklelizer.UpdateTree(state)
+			}
+		})
+	}
+}
+
+func BenchmarkGenerateTreeFromState(b *testing.B) {
+	// Every leaf is logged, which is not what is measured
+	level := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(level)
+
+	merklelizer := NewMerklelizer()
+	for _, numValidators := range benchmarkValidators {
+		state := newBenchmarkState(numValidators)
+		b.Run(fmt.Sprintf("validators_%d", numValidators), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				merklelizer.GenerateTreeFromState(state)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"math/big"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -31,6 +32,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/rs/zerolog"
 	log "github.com/sirupsen/logrus"
 )
@@ -41,16 +43,65 @@ var HoleskyChainId = uint64(17000)
 
 // This file provides different functions to access the blockchain state from both consensus and
 // execution layer and modifying the its state via smart contract calls.
-type EpochDuties struct {
-	Epoch  uint64
-	Duties []*v1.ProposerDuty
-}
+
+// Number of epochs whose proposer duties are cached. Blocks are prefetched
+// concurrently, so the workers can be fetching slots of different epochs
+var ProposalDutyCacheEpochs = 4
 
 // Simple cache storing epoch -> proposer duties
 // This is useful to not query the beacon node for each slot
-// since ProposerDuties returns the duties for the whole epoch
-// Note that the cache is meant to store only one epoch's duties
-var ProposalDutyCache EpochDuties
+// since ProposerDuties returns the duties for the whole epoch.
+// The least recently used epoch is evicted when its full
+var ProposalDutyCache = newEpochDutiesCache(ProposalDutyCacheEpochs)
+
+// Proposer duties by epoch, safe to be used concurrently
+type epochDutiesCache struct {
+	mutex  sync.Mutex
+	size   int
+	duties map[uint64][]*v1.ProposerDuty
+	// Epochs from least to most recently used
+	order []uint64
+}
+
+func newEpochDutiesCache(size int) *epochDutiesCache {
+	return &epochDutiesCache{
+		size:   size,
+		duties: make(map[uint64][]*v1.ProposerDuty),
+		order:  make([]uint64, 0, size),
+	}
+}
+
+func (c *epochDutiesCache) get(epoch uint64) ([]*v1.ProposerDuty, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	duties, found := c.duties[epoch]
+	if found {
+		c.touch(epoch)
+	}
+	return duties, found
+}
+
+func (c *epochDutiesCache) add(epoch uint64, duties []*v1.ProposerDuty) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, found := c.duties[epoch]; !found && len(c.duties) >= c.size {
+		delete(c.duties, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.duties[epoch] = duties
+	c.touch(epoch)
+}
+
+// Moves the epoch to the most recently used position
+func (c *epochDutiesCache) touch(epoch uint64) {
+	for i, cached := range c.order {
+		if cached == epoch {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	c.order = append(c.order, epoch)
+}
 
 type Onchain struct {
 	ConsensusClient *http.Service
@@ -62,6 +113,11 @@ type Onchain struct {
 	PoolAddress     string
 	ChainId         uint64
 	validators      map[phase0.ValidatorIndex]*v1.Validator
+	eventIndexer    *EventIndexer
+	mevTracer       *MevTracer
+
+	// Used to detect the mev rewards of fetched blocks, see WhitelistedBuilder
+	whitelistedBuilders []*WhitelistedBuilder
 }
 
 func NewOnchain(cliCfg *config.CliConfig, updaterKey *ecdsa.PrivateKey) (*Onchain, error) {
@@ -143,7 +199,7 @@ func NewOnchain(cliCfg *config.CliConfig, updaterKey *ecdsa.PrivateKey) (*Onchai
 		updaterAddress = crypto.PubkeyToAddress(updaterKey.PublicKey)
 	}
 
-	return &Onchain{
+	onchain := &Onchain{
 		ConsensusClient: consensusClient,
 		ExecutionClient: executionClient,
 		PoolAddress:     cliCfg.PoolAddress,
@@ -152,6 +208,55 @@ func NewOnchain(cliCfg *config.CliConfig, updaterKey *ecdsa.PrivateKey) (*Onchai
 		ChainId:         uint64(chainId.Int64()),
 		updaterKey:      updaterKey,
 		UpdaterAddress:  updaterAddress,
+	}
+
+	// Pool events are fetched over ranges of blocks unless disabled
+	if cliCfg.EventsRangeSize > 0 {
+		onchain.eventIndexer, err = NewEventIndexer(
+			executionClient, &contract.ContractFilterer, address, cliCfg.EventsRangeSize, onchain.GetRetryOpts(nil)...)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error creating event indexer")
+		}
+	}
+
+	// Mev rewards are traced through the contracts that forward them, which is
+	// required from the fork that activates TracedMevRewards
+	onchain.mevTracer = NewMevTracer(executionClient.Client(), onchain.GetRetryOpts(nil)...)
+
+	return onchain, nil
+}
+
+// Sets the whitelisted builders used to detect the mev rewards of the fetched
+// blocks. Must be the same ones the oracle uses, and set before fetching any.
+func (o *Onchain) SetWhitelistedBuilders(builders []*WhitelistedBuilder) {
+	o.whitelistedBuilders = builders
+}
+
+// Returns an onchain object that only talks to the execution client, enough to
+// read the pool contract and its events without a consensus client
+func NewExecutionOnchain(executionEndpoint string, poolAddress string, numRetries int) (*Onchain, error) {
+	executionClient, err := ethclient.Dial(executionEndpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error dialing execution client")
+	}
+
+	chainId, err := executionClient.ChainID(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "Error fetching chainid from execution client")
+	}
+	log.Info("Connected succesfully to execution client. ChainId: ", chainId)
+
+	contract, err := contract.NewContract(common.HexToAddress(poolAddress), executionClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error instantiating contract")
+	}
+
+	return &Onchain{
+		ExecutionClient: executionClient,
+		PoolAddress:     poolAddress,
+		Contract:        contract,
+		NumRetries:      numRetries,
+		ChainId:         uint64(chainId.Int64()),
 	}, nil
 }
 
@@ -231,7 +336,14 @@ func (o *Onchain) GetConsensusBlockAtSlot(slot uint64, opts ...retry.Option) (*s
 	if err != nil {
 		return nil, errors.New("Could not fetch block at slot " + slotStr + ": " + err.Error())
 	}
-	return signedBeaconBlock.Data, err
+
+	// Fail before processing it if its fork is not supported yet
+	if signedBeaconBlock.Data != nil {
+		if _, err := getVersionedBlock(signedBeaconBlock.Data); err != nil {
+			return nil, errors.Wrap(err, "could not read block at slot "+slotStr)
+		}
+	}
+	return signedBeaconBlock.Data, nil
 }
 
 // Gets active validators by asking the chain. It does not get current Onchain object validators
@@ -322,6 +434,50 @@ func (o *Onchain) GetSingleValidator(valIndex phase0.ValidatorIndex, slot string
 	}
 	return validator, err
 }
+
+// Returns the validator with the given public key, nil if there is none
+func (o *Onchain) GetValidatorByPubkey(pubkey phase0.BLSPubKey, slot string, opts ...retry.Option) (*v1.Validator, error) {
+	var validators *api.Response[map[phase0.ValidatorIndex]*v1.Validator]
+	var err error
+
+	err = retry.Do(func() error {
+		validators, err = o.ConsensusClient.Validators(context.Background(), &api.ValidatorsOpts{
+			State:   slot,
+			PubKeys: []phase0.BLSPubKey{pubkey},
+		})
+
+		if err != nil {
+			log.Warn("Failed attempt to fetch validator by pubkey: ", err.Error(), " Retrying...")
+			return errors.New("Error fetching validator by pubkey: " + err.Error())
+		}
+
+		if len(validators.Data) > 1 {
+			return errors.New("Error fetching validator by pubkey: Requested one but got many")
+		}
+
+		return nil
+	}, o.GetRetryOpts(opts)...)
+
+	if err != nil {
+		return nil, errors.New("Could not fetch validator by pubkey: " + err.Error())
+	}
+
+	// If empty, it means the validator doesnt exist
+	if len(validators.Data) == 0 {
+		return nil, nil
+	}
+
+	// Some sanity checks
+	for _, validator := range validators.Data {
+		if validator.Validator == nil || validator.Validator.PublicKey != pubkey {
+			return nil, errors.New(fmt.Sprintf("Error fetching validator by pubkey: Pubkey mismatch in response: %s",
+				pubkey.String()))
+		}
+		return validator, nil
+	}
+	return nil, nil
+}
+
 func (o *Onchain) GetSetOfValidators(valIndices []phase0.ValidatorIndex, slot string, opts ...retry.Option) (map[phase0.ValidatorIndex]*v1.Validator, error) {
 	// If empty, return an error. We do this to avoid fetching all validators. GetSetOfValidators should be used with a set of indices
 	if len(valIndices) == 0 {
@@ -394,12 +550,12 @@ func (o *Onchain) GetProposalDuty(slot uint64, opts ...retry.Option) (*v1.Propos
 	slotStr := strconv.FormatUint(slot, 10)
 
 	// If cache hit, return the result
-	if ProposalDutyCache.Epoch == epoch {
+	if cached, found := ProposalDutyCache.get(epoch); found {
 		// Sanity check that should never happen
-		if ProposalDutyCache.Epoch != uint64(ProposalDutyCache.Duties[slotWithinEpoch].Slot/phase0.Slot(constants.SlotsInEpoch)) {
+		if epoch != uint64(cached[slotWithinEpoch].Slot/phase0.Slot(constants.SlotsInEpoch)) {
 			return nil, errors.New("Proposal duty epoch does not match when converting slot to epoch")
 		}
-		return ProposalDutyCache.Duties[slotWithinEpoch], nil
+		return cached[slotWithinEpoch], nil
 	}
 
 	// Empty indexes to force fetching all duties
@@ -425,7 +581,7 @@ func (o *Onchain) GetProposalDuty(slot uint64, opts ...retry.Option) (*v1.Propos
 	}
 
 	// If success, store result in cache
-	ProposalDutyCache = EpochDuties{epoch, duties.Data}
+	ProposalDutyCache.add(epoch, duties.Data)
 
 	return duties.Data[slotWithinEpoch], nil
 }
@@ -457,7 +613,7 @@ func (o *Onchain) GetExecHeaderAndReceipts(
 		// This should never happen
 		tx, err := utils.DecodeTx(rawTx)
 		if err != nil {
-			log.Fatal(err)
+			return nil, nil, errors.Wrap(err, "could not decode tx")
 		}
 		var receipt *types.Receipt
 
@@ -585,11 +741,12 @@ func (o *Onchain) GetQuorum(opts ...retry.Option) (uint64, error) {
 	return quorum, nil
 }
 
-func (o *Onchain) GetContractCollateral(opts ...retry.Option) (*big.Int, error) {
+func (o *Onchain) GetContractCollateral(blockNumber *big.Int, opts ...retry.Option) (*big.Int, error) {
 	subscriptionCollateral := new(big.Int)
 	err := retry.Do(
 		func() error {
-			callOpts := &bind.CallOpts{Context: context.Background(), Pending: false}
+			// If block number is nil latest known block is used
+			callOpts := &bind.CallOpts{Context: context.Background(), Pending: false, BlockNumber: blockNumber}
 			var err error
 			subscriptionCollateral, err = o.Contract.SubscriptionCollateral(callOpts)
 			if err != nil {
@@ -605,13 +762,14 @@ func (o *Onchain) GetContractCollateral(opts ...retry.Option) (*big.Int, error)
 	return subscriptionCollateral, nil
 }
 
-func (o *Onchain) GetSlotCheckpointSize(opts ...retry.Option) (uint64, error) {
+func (o *Onchain) GetSlotCheckpointSize(blockNumber *big.Int, opts ...retry.Option) (uint64, error) {
 	var slotCheckpointSize uint64
 	var err error
 
 	err = retry.Do(
 		func() error {
-			callOpts := &bind.CallOpts{Context: context.Background(), Pending: false}
+			// If block number is nil latest known block is used
+			callOpts := &bind.CallOpts{Context: context.Background(), Pending: false, BlockNumber: blockNumber}
 			slotCheckpointSize, err = o.Contract.CheckpointSlotSize(callOpts)
 			if err != nil {
 				log.Warn("Failed attempt to get slot checkpoint size from contract: ", err.Error(), " Retrying...")
@@ -649,13 +807,14 @@ func (o *Onchain) GetContractDeploymentBlock(opts ...retry.Option) (*big.Int, er
 	return deploymentBlock, nil
 }
 
-func (o *Onchain) GetPoolFee(opts ...retry.Option) (*big.Int, error) {
+func (o *Onchain) GetPoolFee(blockNumber *big.Int, opts ...retry.Option) (*big.Int, error) {
 	var poolFee *big.Int
 	var err error
 
 	err = retry.Do(
 		func() error {
-			callOpts := &bind.CallOpts{Context: context.Background(), Pending: false}
+			// If block number is nil latest known block is used
+			callOpts := &bind.CallOpts{Context: context.Background(), Pending: false, BlockNumber: blockNumber}
 			poolFee, err = o.Contract.PoolFee(callOpts)
 			if err != nil {
 				log.Warn("Failed attempt to get pool fee from contract: ", err.Error(), " Retrying...")
@@ -671,13 +830,14 @@ func (o *Onchain) GetPoolFee(opts ...retry.Option) (*big.Int, error) {
 	return poolFee, nil
 }
 
-func (o *Onchain) GetPoolFeeAddress(opts ...retry.Option) (string, error) {
+func (o *Onchain) GetPoolFeeAddress(blockNumber *big.Int, opts ...retry.Option) (string, error) {
 	var poolFeeAddress common.Address
 	var err error
 
 	err = retry.Do(
 		func() error {
-			callOpts := &bind.CallOpts{Context: context.Background(), Pending: false}
+			// If block number is nil latest known block is used
+			callOpts := &bind.CallOpts{Context: context.Background(), Pending: false, BlockNumber: blockNumber}
 			poolFeeAddress, err = o.Contract.PoolFeeRecipient(callOpts)
 			if err != nil {
 				log.Warn("Failed attempt to get pool fee address from contract: ", err.Error(), " Retrying...")
@@ -846,31 +1006,49 @@ func (o *Onchain) FetchFullBlock(slot uint64, oracle *Oracle, opt ...bool) *Full
 		fetchAll = false
 	}
 
+	fullBlock, err := o.PrefetchFullBlock(slot)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = o.CompleteFullBlock(fullBlock, oracle, fetchAll)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return fullBlock
+}
+
+// Fetches all the information of the block at the given slot that does not
+// depend on the oracle state, so it can be called ahead of time and concurrently
+// for multiple slots. Receipts are only fetched if the reward went to the pool,
+// CompleteFullBlock must be called before processing it. Errors are returned
+// instead of exiting, since its called from the prefetch workers.
+func (o *Onchain) PrefetchFullBlock(slot uint64) (*FullBlock, error) {
 	// Get who should propose the block
 	slotDuty, err := o.GetProposalDuty(slot)
 	if err != nil {
-		log.Fatal("could not get proposal duty: ", err)
+		return nil, errors.Wrap(err, "could not get proposal duty")
 	}
 
 	// Sanity check to ensure the slot duty is the one we requested
 	if uint64(slotDuty.Slot) != slot {
-		log.Fatal("slot duty slot does not match requested slot: ", slotDuty.Slot, " vs ", slot)
+		return nil, errors.New(fmt.Sprintf("slot duty slot does not match requested slot: %d vs %d", slotDuty.Slot, slot))
 	}
 
 	// Get the validator info that proposed (or should have proposed) the block
 	currentSlotStr := strconv.FormatUint(slot, 10)
 	validator, err := o.GetSingleValidator(slotDuty.ValidatorIndex, currentSlotStr)
 	if err != nil {
-		log.Fatal("could not get single validator: ", err)
+		return nil, errors.Wrap(err, "could not get single validator")
 	}
 
 	// Create the full block with the duty, which is the minimum info it can have
 	fullBlock := NewFullBlock(slotDuty, validator, o.ChainId)
+	fullBlock.WhitelistedBuilders = activeBuilders(o.whitelistedBuilders, slot)
 
 	// Fetch the whole consensus block
 	proposedBlock, err := o.GetConsensusBlockAtSlot(slot)
 	if err != nil {
-		log.Fatal("could not get block at slot:", err)
+		return nil, errors.Wrap(err, "could not get block at slot")
 	}
 
 	if proposedBlock == nil {
@@ -881,63 +1059,12 @@ func (o *Onchain) FetchFullBlock(slot uint64, oracle *Oracle, opt ...bool) *Full
 
 		// Sanity check to ensure the block is the one we requested
 		if fullBlock.GetSlotUint64() != slot {
-			log.Fatal("slot does not match requested slot: ", fullBlock.GetSlotUint64(), " vs ", slot)
+			return nil, errors.New(fmt.Sprintf("slot does not match requested slot: %d vs %d", fullBlock.GetSlotUint64(), slot))
 		}
 
-		etherReceived, err := o.GetEtherReceivedEvents(fullBlock.GetBlockNumber())
+		events, err := o.GetBlockEvents(fullBlock.GetBlockNumber())
 		if err != nil {
-			log.Fatal("failed getting ether received events: ", err)
-		}
-
-		subscribeValidator, err := o.GetSubscribeValidatorEvents(fullBlock.GetBlockNumber())
-		if err != nil {
-			log.Fatal("failed getting subscribe validator events: ", err)
-		}
-
-		unsubscribeValidator, err := o.GetUnsubscribeValidatorEvents(fullBlock.GetBlockNumber())
-		if err != nil {
-			log.Fatal("failed getting unsubscribe validator events: ", err)
-		}
-
-		updatePoolFee, err := o.GetUpdatePoolFeeEvents(fullBlock.GetBlockNumber())
-		if err != nil {
-			log.Fatal("failed getting update pool fee events: ", err)
-		}
-
-		poolFeeRecipient, err := o.GetPoolFeeRecipientEvents(fullBlock.GetBlockNumber())
-		if err != nil {
-			log.Fatal("failed getting pool fee recipient events: ", err)
-		}
-
-		checkpointSlotSize, err := o.GetCheckpointSlotSizeEvents(fullBlock.GetBlockNumber())
-		if err != nil {
-			log.Fatal("failed getting checkpoint slot size events: ", err)
-		}
-
-		updateSubscriptionCollateral, err := o.GetUpdateSubscriptionCollateralEvents(fullBlock.GetBlockNumber())
-		if err != nil {
-			log.Fatal("failed getting update subscription collateral events: ", err)
-		}
-
-		// Not all events are fetched as they are not needed
-		events := &Events{
-			EtherReceived:      etherReceived,
-			SubscribeValidator: subscribeValidator,
-			//ClaimRewards: claimRewards,
-			//SetRewardRecipient: setRewardRecipient,
-			UnsubscribeValidator: unsubscribeValidator,
-			//InitSmoothingPool: initSmoothingPool,
-			UpdatePoolFee:                updatePoolFee,
-			PoolFeeRecipient:             poolFeeRecipient,
-			CheckpointSlotSize:           checkpointSlotSize,
-			UpdateSubscriptionCollateral: updateSubscriptionCollateral,
-			//SubmitReport: submitReport,
-			//ReportConsolidated: reportConsolidated,
-			//UpdateQuorum: updateQuorum,
-			//AddOracleMember: addOracleMember,
-			//RemoveOracleMember: removeOracleMember,
-			//TransferGovernance: transferGovernance,
-			//AcceptGovernance: acceptGovernance,
+			return nil, errors.Wrap(err, "failed getting block events")
 		}
 
 		// Add the events to the block
@@ -950,7 +1077,7 @@ func (o *Onchain) FetchFullBlock(slot uint64, oracle *Oracle, opt ...bool) *Full
 		for _, sub := range fullBlock.Events.SubscribeValidator {
 			validatorSub, err := o.GetSingleValidator(phase0.ValidatorIndex(sub.ValidatorID), currentSlotStr)
 			if err != nil {
-				log.Fatal("could not get validator subscriptions: ", err)
+				return nil, errors.Wrap(err, "could not get validator subscriptions")
 			}
 			validatorsSubs = append(validatorsSubs, validatorSub)
 		}
@@ -958,7 +1085,7 @@ func (o *Onchain) FetchFullBlock(slot uint64, oracle *Oracle, opt ...bool) *Full
 		for _, unsub := range fullBlock.Events.UnsubscribeValidator {
 			validatorsUnsub, err := o.GetSingleValidator(phase0.ValidatorIndex(unsub.ValidatorID), currentSlotStr)
 			if err != nil {
-				log.Fatal("could not get validator unsubscriptions: ", err)
+				return nil, errors.Wrap(err, "could not get validator unsubscriptions")
 			}
 			validatorsUnsubs = append(validatorsUnsubs, validatorsUnsub)
 		}
@@ -966,24 +1093,239 @@ func (o *Onchain) FetchFullBlock(slot uint64, oracle *Oracle, opt ...bool) *Full
 		fullBlock.ValidatorsSubs = validatorsSubs
 		fullBlock.ValidatorsUnsubs = validatorsUnsubs
 
-		// Check if the proposal is from a subscribed validator
-		isFromSubscriber := oracle.isSubscribed(fullBlock.GetProposerIndexUint64())
-
-		// Check if the reward was sent to the pool
-		isPoolRewarded := fullBlock.isAddressRewarded(o.PoolAddress)
+		// Check if the reward was sent to the pool. This calculation is expensive, so
+		// its only done here if the reward went to the pool.
+		if fullBlock.isAddressRewarded(o.PoolAddress) {
+			if err := o.setHeaderAndReceipts(fullBlock); err != nil {
+				return nil, err
+			}
+		}
 
-		// This calculation is expensive, do it only if the reward went to the pool or
-		// if the block is from a subscribed validator.
-		if fetchAll || (isFromSubscriber || isPoolRewarded) {
-			header, receipts, err := o.GetExecHeaderAndReceipts(fullBlock.GetBlockNumberBigInt(), fullBlock.GetBlockTransactions())
-			if err != nil {
-				log.Fatal("failed getting header and receipts: ", err)
+		// Exits and consolidations requested from the execution layer
+		if fullBlock.hasExecutionRequests() {
+			if err := o.setExecutionRequests(fullBlock); err != nil {
+				return nil, err
 			}
-			fullBlock.SetHeaderAndReceipts(header, receipts)
 		}
 	}
 
-	return fullBlock
+	return fullBlock, nil
+}
+
+// Fetches the remaining information of a prefetched block that depends on the
+// oracle state, which must be up to date with the slot before the block. The
+// receipts are needed if the block is from a subscribed validator, or if the
+// traced mev reward went to the pool.
+func (o *Onchain) CompleteFullBlock(fullBlock *FullBlock, oracle *Oracle, fetchAll bool) error {
+	// Missed block, nothing to do
+	if fullBlock.ConsensusBlock == nil {
+		return nil
+	}
+
+	// A contract could forward the mev reward to the pool
+	isCandidate, err := o.isMevTraceCandidate(fullBlock, oracle)
+	if err != nil {
+		return err
+	}
+	if isCandidate {
+		o.traceMevReward(fullBlock)
+	}
+
+	// Check if the proposal is from a subscribed validator
+	isFromSubscriber := oracle.isSubscribed(fullBlock.GetProposerIndexUint64())
+
+	if (fetchAll || isFromSubscriber || fullBlock.isAddressRewarded(o.PoolAddress)) && fullBlock.ExecutionHeader == nil {
+		if err := o.setHeaderAndReceipts(fullBlock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *Onchain) setHeaderAndReceipts(fullBlock *FullBlock) error {
+	header, receipts, err := o.GetExecHeaderAndReceipts(fullBlock.GetBlockNumberBigInt(), fullBlock.GetBlockTransactions())
+	if err != nil {
+		return errors.Wrap(err, "failed getting header and receipts")
+	}
+	fullBlock.SetHeaderAndReceipts(header, receipts)
+	return nil
+}
+
+// Sets the requests of the block with the validators they refer to, at the slot
+// of the block. The validators are fetched at the slot before as well, to know
+// if the block initiated their exit, which means the request took effect.
+func (o *Onchain) setExecutionRequests(fullBlock *FullBlock) error {
+	withdrawals, consolidations, err := fullBlock.getExecutionRequests()
+	if err != nil {
+		return errors.Wrap(err, "could not get execution requests")
+	}
+
+	slot := fullBlock.GetSlotUint64()
+	slotStr := strconv.FormatUint(slot, 10)
+	prevSlotStr := strconv.FormatUint(slot-1, 10)
+	for _, request := range withdrawals {
+		request.Validator, err = o.GetValidatorByPubkey(request.ValidatorPubkey, slotStr)
+		if err != nil {
+			return errors.Wrap(err, "could not get validator of withdrawal request")
+		}
+		before, err := o.GetValidatorByPubkey(request.ValidatorPubkey, prevSlotStr)
+		if err != nil {
+			return errors.Wrap(err, "could not get validator of withdrawal request at previous slot")
+		}
+		request.ExitInitiated = fullBlock.isExitInitiatedByRequest(before, request.Validator)
+	}
+	for _, request := range consolidations {
+		request.SourceValidator, err = o.GetValidatorByPubkey(request.SourcePubkey, slotStr)
+		if err != nil {
+			return errors.Wrap(err, "could not get source validator of consolidation request")
+		}
+		request.TargetValidator, err = o.GetValidatorByPubkey(request.TargetPubkey, slotStr)
+		if err != nil {
+			return errors.Wrap(err, "could not get target validator of consolidation request")
+		}
+		before, err := o.GetValidatorByPubkey(request.SourcePubkey, prevSlotStr)
+		if err != nil {
+			return errors.Wrap(err, "could not get source validator of consolidation request at previous slot")
+		}
+		request.ExitInitiated = fullBlock.isExitInitiatedByRequest(before, request.SourceValidator)
+	}
+
+	fullBlock.WithdrawalRequests = withdrawals
+	fullBlock.ConsolidationRequests = consolidations
+	return nil
+}
+
+// Returns true if the mev reward of the block needs to be traced and it could
+// have reached the pool: the proposer is tracked by the pool, or the balance of
+// the pool changed in the block. The rest of blocks are not traced, since they
+// can't reward the pool.
+func (o *Onchain) isMevTraceCandidate(fullBlock *FullBlock, oracle *Oracle) (bool, error) {
+	if o.mevTracer == nil || !o.mevTracer.Enabled() {
+		return false, nil
+	}
+	if !fullBlock.needsMevTrace(o.PoolAddress) {
+		return false, nil
+	}
+	if oracle.isTracked(fullBlock.GetProposerIndexUint64()) {
+		return true, nil
+	}
+
+	blockNumber := fullBlock.GetBlockNumberBigInt()
+	balanceAfter, err := o.GetPoolEthBalance(blockNumber)
+	if err != nil {
+		return false, errors.Wrap(err, "could not get pool balance after the block")
+	}
+	balanceBefore, err := o.GetPoolEthBalance(new(big.Int).Sub(blockNumber, big.NewInt(1)))
+	if err != nil {
+		return false, errors.Wrap(err, "could not get pool balance before the block")
+	}
+	return balanceAfter.Cmp(balanceBefore) != 0, nil
+}
+
+// Traces the last tx of the block, following the mev reward through the
+// contracts that forward it. If tracing is not supported by the execution
+// client or it fails, the block is left untraced and the reward is detected
+// from the last tx, as before tracing.
+func (o *Onchain) traceMevReward(fullBlock *FullBlock) {
+	txs := fullBlock.GetBlockTransactions()
+	lastTx, err := utils.DecodeTx(txs[len(txs)-1])
+	if err != nil {
+		log.Warn("Could not decode last tx of slot ", fullBlock.GetSlotUint64(), ", mev reward not traced: ", err.Error())
+		return
+	}
+
+	trace, err := o.mevTracer.TraceTransaction(fullBlock.GetBlockNumber(), lastTx.Hash(), len(txs)-1)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Slot":   fullBlock.GetSlotUint64(),
+			"TxHash": lastTx.Hash().String(),
+			"Error":  err.Error(),
+		}).Warn("Could not trace mev reward, detecting it from the last tx")
+		return
+	}
+	fullBlock.LastTxTrace = trace
+}
+
+// Returns the pool events emitted in the given block. If the event indexer is
+// enabled they are served from it, otherwise (or if the block is not finalized
+// in the execution client yet) one call per event is done.
+func (o *Onchain) GetBlockEvents(blockNumber uint64) (*Events, error) {
+	if o.eventIndexer != nil {
+		indexed, err := o.eventIndexer.GetEvents(blockNumber)
+		if err == nil {
+			// Not all events are used, keep the same ones as the per block calls
+			return &Events{
+				EtherReceived:                indexed.EtherReceived,
+				SubscribeValidator:           indexed.SubscribeValidator,
+				UnsubscribeValidator:         indexed.UnsubscribeValidator,
+				UpdatePoolFee:                indexed.UpdatePoolFee,
+				PoolFeeRecipient:             indexed.PoolFeeRecipient,
+				CheckpointSlotSize:           indexed.CheckpointSlotSize,
+				UpdateSubscriptionCollateral: indexed.UpdateSubscriptionCollateral,
+			}, nil
+		}
+		if err != ErrBlockNotFinalized {
+			return nil, errors.Wrap(err, "could not get indexed events")
+		}
+		// Can't be cached yet, fetched with the per block calls
+		log.Debug("Block ", blockNumber, " not finalized in the execution client, not using the event indexer")
+	}
+
+	etherReceived, err := o.GetEtherReceivedEvents(blockNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting ether received events")
+	}
+
+	subscribeValidator, err := o.GetSubscribeValidatorEvents(blockNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting subscribe validator events")
+	}
+
+	unsubscribeValidator, err := o.GetUnsubscribeValidatorEvents(blockNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting unsubscribe validator events")
+	}
+
+	updatePoolFee, err := o.GetUpdatePoolFeeEvents(blockNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting update pool fee events")
+	}
+
+	poolFeeRecipient, err := o.GetPoolFeeRecipientEvents(blockNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting pool fee recipient events")
+	}
+
+	checkpointSlotSize, err := o.GetCheckpointSlotSizeEvents(blockNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting checkpoint slot size events")
+	}
+
+	updateSubscriptionCollateral, err := o.GetUpdateSubscriptionCollateralEvents(blockNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting update subscription collateral events")
+	}
+
+	// Not all events are fetched as they are not needed
+	return &Events{
+		EtherReceived:      etherReceived,
+		SubscribeValidator: subscribeValidator,
+		//ClaimRewards: claimRewards,
+		//SetRewardRecipient: setRewardRecipient,
+		UnsubscribeValidator: unsubscribeValidator,
+		//InitSmoothingPool: initSmoothingPool,
+		UpdatePoolFee:                updatePoolFee,
+		PoolFeeRecipient:             poolFeeRecipient,
+		CheckpointSlotSize:           checkpointSlotSize,
+		UpdateSubscriptionCollateral: updateSubscriptionCollateral,
+		//SubmitReport: submitReport,
+		//ReportConsolidated: reportConsolidated,
+		//UpdateQuorum: updateQuorum,
+		//AddOracleMember: addOracleMember,
+		//RemoveOracleMember: removeOracleMember,
+		//TransferGovernance: transferGovernance,
+		//AcceptGovernance: acceptGovernance,
+	}, nil
 }
 
 // TODO: This function is not wrapped with retries
@@ -1110,29 +1452,32 @@ func (onchain *Onchain) GetConfigFromContract(
 
 	log.Info("[Loaded from contract] Contract deployed in slot: ", deployedSlot)
 
-	checkPointSizeInSlots, err := onchain.GetSlotCheckpointSize()
+	// The pool parameters are read as they were when the contract was deployed,
+	// since the state starts from there. Later updates are applied when the slot
+	// of their events is processed
+	checkPointSizeInSlots, err := onchain.GetSlotCheckpointSize(deployedBlock)
 	if err != nil {
 		log.Fatal("Could not get slot checkpoint size: " + err.Error())
 	}
-	log.Info("[Loaded from contract] Checkpoints will be created every ", checkPointSizeInSlots, " slots (", utils.SlotsToTime(checkPointSizeInSlots, constants.SecondsInSlot), ")")
+	log.Info("[Loaded from contract] Initial checkpoints will be created every ", checkPointSizeInSlots, " slots (", utils.SlotsToTime(checkPointSizeInSlots, constants.SecondsInSlot), ")")
 
-	poolFeesPercentTwoDecimals, err := onchain.GetPoolFee()
+	poolFeesPercentTwoDecimals, err := onchain.GetPoolFee(deployedBlock)
 	if err != nil {
 		log.Fatal("Could not get pool fee: " + err.Error())
 	}
-	log.Info("[Loaded from contract] Pool fees percent: ", float64(poolFeesPercentTwoDecimals.Uint64())/100, "% (raw value: ", poolFeesPercentTwoDecimals, ")")
+	log.Info("[Loaded from contract] Initial pool fees percent: ", float64(poolFeesPercentTwoDecimals.Uint64())/100, "% (raw value: ", poolFeesPercentTwoDecimals, ")")
 
-	poolFeesAddress, err := onchain.GetPoolFeeAddress()
+	poolFeesAddress, err := onchain.GetPoolFeeAddress(deployedBlock)
 	if err != nil {
 		log.Fatal("Could not get pool fee address: " + err.Error())
 	}
-	log.Info("[Loaded from contract] Pool fees address: ", poolFeesAddress, " (ensure you control its private key)")
+	log.Info("[Loaded from contract] Initial pool fees address: ", poolFeesAddress, " (ensure you control its private key)")
 
-	ethCollateralInWei, err := onchain.GetContractCollateral()
+	ethCollateralInWei, err := onchain.GetContractCollateral(deployedBlock)
 	if err != nil {
 		log.Fatal("Could not get contract collateral: " + err.Error())
 	}
-	log.Info("[Loaded from contract] Required collateral to join the pool: ",
+	log.Info("[Loaded from contract] Initial collateral to join the pool: ",
 		ethCollateralInWei, " wei (", utils.WeiToEther(ethCollateralInWei), " Eth)")
 
 	if cliCfg.DryRun {
@@ -1455,18 +1800,145 @@ func (o *Onchain) GetSubmitReportEvents(
 	blockNumber uint64,
 	opts ...retry.Option) ([]*contract.ContractSubmitReport, error) {
 
+	return o.GetSubmitReportEventsInRange(blockNumber, blockNumber, opts...)
+}
+
+// Returns the reports submitted by the oracle members between startBlock and endBlock (included)
+func (o *Onchain) GetSubmitReportEventsInRange(
+	startBlock uint64,
+	endBlock uint64,
+	opts ...retry.Option) ([]*contract.ContractSubmitReport, error) {
+
+	filterOpts := &bind.FilterOpts{Context: context.Background(), Start: startBlock, End: &endBlock}
+
+	var err error
+	var itr *contract.ContractSubmitReportIterator
+
+	err = retry.Do(func() error {
+		itr, err = o.Contract.FilterSubmitReport(filterOpts)
+		if err != nil {
+			log.Warn("Failed attempt GetSubmitReportEvents for blocks ", strconv.FormatUint(startBlock, 10), "-", strconv.FormatUint(endBlock, 10), ": ", err.Error(), " Retrying...")
+			return err
+		}
+		return nil
+	}, o.GetRetryOpts(opts)...)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get SubmitReport events")
+	}
+
 	var events []*contract.ContractSubmitReport
-	log.Fatal("Not implemented: GetSubmitReportEvents it not implemented")
+	for itr.Next() {
+		events = append(events, itr.Event)
+	}
+	err = itr.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not close ContractSubmitReport iterator")
+	}
 	return events, nil
 }
 func (o *Onchain) GetReportConsolidatedEvents(
 	blockNumber uint64,
 	opts ...retry.Option) ([]*contract.ContractReportConsolidated, error) {
 
+	return o.GetReportConsolidatedEventsInRange(blockNumber, blockNumber, opts...)
+}
+
+// Returns the reports that reached quorum between startBlock and endBlock (included)
+func (o *Onchain) GetReportConsolidatedEventsInRange(
+	startBlock uint64,
+	endBlock uint64,
+	opts ...retry.Option) ([]*contract.ContractReportConsolidated, error) {
+
+	filterOpts := &bind.FilterOpts{Context: context.Background(), Start: startBlock, End: &endBlock}
+
+	var err error
+	var itr *contract.ContractReportConsolidatedIterator
+
+	err = retry.Do(func() error {
+		itr, err = o.Contract.FilterReportConsolidated(filterOpts)
+		if err != nil {
+			log.Warn("Failed attempt GetReportConsolidatedEvents for blocks ", strconv.FormatUint(startBlock, 10), "-", strconv.FormatUint(endBlock, 10), ": ", err.Error(), " Retrying...")
+			return err
+		}
+		return nil
+	}, o.GetRetryOpts(opts)...)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get ReportConsolidated events")
+	}
+
 	var events []*contract.ContractReportConsolidated
-	log.Fatal("Not implemented: GetReportConsolidatedEvents it not implemented")
+	for itr.Next() {
+		events = append(events, itr.Event)
+	}
+	err = itr.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not close ContractReportConsolidated iterator")
+	}
 	return events, nil
 }
+
+// Returns the latest finalized block of the execution client
+func (o *Onchain) GetFinalizedBlockNumber(opts ...retry.Option) (uint64, error) {
+	var header *types.Header
+	var err error
+	err = retry.Do(func() error {
+		header, err = o.ExecutionClient.HeaderByNumber(context.Background(), big.NewInt(int64(rpc.FinalizedBlockNumber)))
+		if err != nil {
+			log.Warn("Failed attempt to fetch finalized header: ", err.Error(), " Retrying...")
+			return errors.New("Error fetching finalized header: " + err.Error())
+		}
+		return nil
+	}, o.GetRetryOpts(opts)...)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get finalized header")
+	}
+	return header.Number.Uint64(), nil
+}
+
+// Returns all the submitted and consolidated reports between startBlock and endBlock
+// (included), fetched in ranges of rangeSize blocks
+func (o *Onchain) GetReportEvents(
+	startBlock uint64,
+	endBlock uint64,
+	rangeSize uint64,
+	opts ...retry.Option) ([]*contract.ContractSubmitReport, []*contract.ContractReportConsolidated, error) {
+
+	if rangeSize == 0 {
+		return nil, nil, errors.New("range size must be greater than 0")
+	}
+
+	submitted := make([]*contract.ContractSubmitReport, 0)
+	consolidated := make([]*contract.ContractReportConsolidated, 0)
+	for from := startBlock; from <= endBlock; from += rangeSize {
+		to := from + rangeSize - 1
+		if to > endBlock {
+			to = endBlock
+		}
+
+		submittedInRange, err := o.GetSubmitReportEventsInRange(from, to, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		consolidatedInRange, err := o.GetReportConsolidatedEventsInRange(from, to, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		submitted = append(submitted, submittedInRange...)
+		consolidated = append(consolidated, consolidatedInRange...)
+
+		log.WithFields(log.Fields{
+			"FromBlock":    from,
+			"ToBlock":      to,
+			"EndBlock":     endBlock,
+			"Submitted":    len(submitted),
+			"Consolidated": len(consolidated),
+		}).Debug("Fetched report events")
+	}
+	return submitted, consolidated, nil
+}
+
 func (o *Onchain) GetUpdateQuorumEvents(
 	blockNumber uint64,
 	opts ...retry.Option) ([]*contract.ContractUpdateQuorum, error) {
@@ -153,7 +153,10 @@ func Test_BlocksWithInternalTx2(t *testing.T) {
 
 	// Self destruct that does not trigger EtherReceived event
 	// https://etherscan.io/tx/0x60571ab93a187c7e8f8ae7952430a7de64b47843e716cbd53a0fa741316569c6
-	fullBlock := onchain.FetchFullBlock(ExceptionSlotMainnet1, oracle)
+	overrides, err := LoadRewardOverrides("mainnet", "")
+	require.NoError(t, err)
+	fullBlock := onchain.FetchFullBlock(overrides[0].Slot, oracle)
+	fullBlock.applyRewardOverride(overrides[0], pool)
 	donations := fullBlock.GetDonations(pool)
 	mevReward, isMev, recipient := fullBlock.MevRewardInWei()
 	require.Equal(t, big.NewInt(0).SetUint64(177043568463114308), mevReward)
@@ -483,6 +486,9 @@ func Test_Mainnet_BeaverIssue(t *testing.T) {
 	require.NoError(t, err)
 
 	cfg := onchain.GetConfigFromContract(cfgOnchain)
+	cfg.WhitelistedBuilders, err = LoadWhitelistedBuilders(cfg.Network, "")
+	require.NoError(t, err)
+	onchain.SetWhitelistedBuilders(cfg.WhitelistedBuilders)
 
 	oracleInstance := NewOracle(cfg)
 
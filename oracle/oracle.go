@@ -5,12 +5,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"strconv"
+	"strings"
 
 	"fmt"
-	"io/ioutil"
 	"math/big"
-	"os"
-	"path/filepath"
+	"sort"
 	"sync"
 
 	"github.com/avast/retry-go/v4"
@@ -35,14 +34,24 @@ type Oracle struct {
 	state              *OracleState
 	mutex              sync.RWMutex
 	getSetOfValidators GetSetOfValidatorsFunc
-}
+	store              StateStore
+	forks              []*Fork
+
+	// Keeps the tree of the latest checkpoint, so that only the leafs that changed
+	// are hashed again in the next one, and its proofs are served without rebuilding it
+	merklelizer *Merklelizer
+
+	// Tree of the latest older checkpoint a proof was requested for
+	checkpointTree      *LeafsTree
+	checkpointTreeSlot  uint64
+	checkpointTreeMutex sync.Mutex
+
+	// True while a slot is being applied to the state. If processing fails
+	// halfway the state is left inconsistent and must not be persisted
+	processingSlot bool
 
-// Fork 1 changes two things:
-// - minor fix in rewards calculation (some wei rouding)
-// - exited and slahed validators no longer get fees
-var SlotFork1 = map[string]uint64{
-	"mainnet": uint64(10188220),
-	"holesky": uint64(2720632),
+	// Cross-checks the rewards with the relays, if enabled
+	relayChecker *RelayChecker
 }
 
 func NewOracle(cfg *Config) *Oracle {
@@ -71,12 +80,16 @@ func NewOracle(cfg *Config) *Oracle {
 		DeployedBlock:            cfg.DeployedBlock,
 		DeployedSlot:             cfg.DeployedSlot,
 		CollateralInWei:          cfg.CollateralInWei,
+		RewardOverrides:          cfg.RewardOverrides,
+		WhitelistedBuilders:      cfg.WhitelistedBuilders,
 	}
 
 	oracle := &Oracle{
 		cfg:                cfg,
 		state:              state,
 		getSetOfValidators: nil,
+		forks:              Forks,
+		merklelizer:        NewMerklelizer(),
 	}
 
 	return oracle
@@ -86,6 +99,11 @@ func (or *Oracle) SetGetSetOfValidatorsFunc(oc GetSetOfValidatorsFunc) {
 	or.getSetOfValidators = oc
 }
 
+// Sets the forks whose rules the oracle follows, Forks by default
+func (or *Oracle) SetForks(forks []*Fork) {
+	or.forks = forks
+}
+
 // Returns the state of the oracle, containing all the information about the
 // validatores, with their state, balances, etc
 func (or *Oracle) State() *OracleState {
@@ -95,11 +113,16 @@ func (or *Oracle) State() *OracleState {
 }
 
 // Returns wether a checkpoint has been reached or not. A checkpoint is reached
-// when CheckPointSizeInSlots have passed from the last checkpoint
+// when CheckPointSizeInSlots have passed from the last checkpoint, using the
+// checkpoint size in effect, that can be updated by the contract
 func (or *Oracle) IsCheckpoint() (bool, error) {
 	or.mutex.RLock()
 	defer or.mutex.RUnlock()
-	latestProcSlot := or.State().LatestProcessedSlot
+	return or.isCheckpointLockFree()
+}
+
+func (or *Oracle) isCheckpointLockFree() (bool, error) {
+	latestProcSlot := or.state.LatestProcessedSlot
 
 	if latestProcSlot == 0 {
 		return false, errors.New(
@@ -107,15 +130,12 @@ func (or *Oracle) IsCheckpoint() (bool, error) {
 				latestProcSlot))
 	}
 
-	if or.cfg.DeployedSlot > latestProcSlot {
+	if or.state.DeployedSlot > latestProcSlot {
 		return false, errors.New(fmt.Sprintf("deployed slot can't be greater than latest slot. deployed=%d, latest=%d",
-			or.cfg.DeployedSlot, latestProcSlot))
+			or.state.DeployedSlot, latestProcSlot))
 	}
 
-	if (latestProcSlot-or.cfg.DeployedSlot)%or.cfg.CheckPointSizeInSlots == 0 {
-		return true, nil
-	}
-	return false, nil
+	return or.state.isCheckpointSlot(latestProcSlot), nil
 }
 
 // Returns the state of the oracle, recalculating the hash of the state for
@@ -153,10 +173,24 @@ func (or *Oracle) AdvanceStateToNextSlot(fullBlock *FullBlock) (uint64, error) {
 			or.state.NextSlotToProcess, " ", fullBlock.ConsensusDuty.Slot))
 	}
 
-	// Some misc validations
-	err := or.validateFullBlockConfig(fullBlock, or.cfg)
+	// Mev rewards that can't be detected from the block are set by hand
+	if override := or.state.rewardOverrideAt(or.state.NextSlotToProcess); override != nil {
+		fullBlock.applyRewardOverride(override, or.cfg.PoolAddress)
+	}
+
+	// The builders of the state are the ones that apply, no matter who fetched the block
+	fullBlock.WhitelistedBuilders = activeBuilders(or.state.WhitelistedBuilders, or.state.NextSlotToProcess)
+
+	// Traces only count once the rule is active. Untraced blocks are processed
+	// with the reward detected from the last tx
+	if !or.isRuleActive(TracedMevRewards, or.state.NextSlotToProcess) {
+		fullBlock.LastTxTrace = nil
+	}
+
+	// Changes of the pool parameters must contain values we can work with
+	err := validatePoolParamsEvents(fullBlock)
 	if err != nil {
-		return 0, errors.Wrap(err, "Error validating full block config")
+		return 0, errors.Wrap(err, "Error validating pool parameters events")
 	}
 
 	// Full block is too heavy to be stored in the state, so we summarize it
@@ -171,6 +205,17 @@ func (or *Oracle) AdvanceStateToNextSlot(fullBlock *FullBlock) (uint64, error) {
 	// Get donations to the pool in this block
 	blockDonations := fullBlock.GetDonations(or.cfg.PoolAddress)
 
+	// Proposals of subscribed validators, or sending the reward to the pool (that
+	// subscribes the validator), are cross-checked with the relays if enabled
+	checkRelays := or.relayChecker != nil && fullBlock.ConsensusBlock != nil &&
+		(or.isSubscribed(summarizedBlock.ValidatorIndex) || fullBlock.isAddressRewarded(or.cfg.PoolAddress))
+
+	// From now on the state is modified. Only cleared once the slot is fully processed
+	or.processingSlot = true
+
+	// Parameter changes apply to everything processed in this slot
+	or.applyPoolParamsEvents(fullBlock)
+
 	// Store all events raw for trazability
 	or.state.SubscriptionEvents = append(or.state.SubscriptionEvents, fullBlock.Events.SubscribeValidator...)
 	or.state.UnsubscriptionEvents = append(or.state.UnsubscriptionEvents, fullBlock.Events.UnsubscribeValidator...)
@@ -203,9 +248,25 @@ func (or *Oracle) AdvanceStateToNextSlot(fullBlock *FullBlock) (uint64, error) {
 	// Handle unsubscriptions the last thing after distributing rewards
 	or.handleManualUnsubscriptions(fullBlock.Events.UnsubscribeValidator, fullBlock.ValidatorsUnsubs)
 
+	// Exits and consolidations requested from the execution layer also unsubscribe validators
+	or.handleExecutionRequests(fullBlock)
+
+	// Validators with held rewards that changed their bls credentials are credited
+	or.handleBlsToExecutionChanges(fullBlock.GetBlsToExecutionChanges())
+
 	// Handle the donations from this block
 	or.handleDonations(blockDonations)
 
+	// Keep the effective balance of the validators seen in this slot, to weight their rewards
+	slotValidators := append([]*v1.Validator{fullBlock.Validator}, fullBlock.ValidatorsSubs...)
+	slotValidators = append(slotValidators, fullBlock.ValidatorsUnsubs...)
+	or.updateEffectiveBalances(append(slotValidators, fullBlock.requestsValidators()...), or.state.NextSlotToProcess)
+
+	// Relays are queried in the background, they don't affect the state
+	if checkRelays {
+		or.relayChecker.check(fullBlock.newRelayCheck(summarizedBlock))
+	}
+
 	// Handle validator cleanup: redisitribute the pending rewards of validators subscribed to the pool
 	// that are not in the beacon chain anymore (exited/slashed). We dont run this on every slot because
 	// its expensive. Runs every 4 hours.
@@ -214,6 +275,12 @@ func (or *Oracle) AdvanceStateToNextSlot(fullBlock *FullBlock) (uint64, error) {
 		if err != nil {
 			return 0, errors.Wrap(err, "could not cleanup validators")
 		}
+	} else if or.isEffectiveBalancesRefreshSlot(or.state.NextSlotToProcess) {
+		// The cleanup already refreshes them
+		err = or.refreshEffectiveBalances(or.state.NextSlotToProcess)
+		if err != nil {
+			return 0, errors.Wrap(err, "could not refresh effective balances")
+		}
 	}
 
 	processedSlot := or.state.NextSlotToProcess
@@ -222,6 +289,7 @@ func (or *Oracle) AdvanceStateToNextSlot(fullBlock *FullBlock) (uint64, error) {
 	if summarizedBlock.BlockType != MissedProposal {
 		or.state.LatestProcessedBlock = summarizedBlock.Block
 	}
+	or.processingSlot = false
 	return processedSlot, nil
 }
 
@@ -229,13 +297,19 @@ func (or *Oracle) AdvanceStateToNextSlot(fullBlock *FullBlock) (uint64, error) {
 func (or *Oracle) ValidatorCleanup(slot uint64) error {
 
 	// Only cleanup if we're past the cleanup slot fork
-	if slot >= SlotFork1[or.cfg.Network] {
+	if or.isRuleActive(CleanupInactiveValidators, slot) {
 
 		// Extract all validator indices from the oracle state
 		indices := make([]phase0.ValidatorIndex, 0)
 		for idx := range or.state.Validators {
 			indices = append(indices, phase0.ValidatorIndex(idx))
 		}
+		// And the ones with bls keys whose rewards are held
+		for _, idx := range or.unsettledBlsCredits() {
+			if !or.isTracked(idx) {
+				indices = append(indices, phase0.ValidatorIndex(idx))
+			}
+		}
 
 		// if oracle isn't tracking any validator, it means that nobody ever subscribed, nothing to cleanup
 		if len(indices) == 0 {
@@ -249,6 +323,13 @@ func (or *Oracle) ValidatorCleanup(slot uint64) error {
 			return errors.Wrap(err, "could not get validators info")
 		}
 
+		// Refresh their effective balance before sharing the rewards of the ones cleaned up
+		validators := make([]*v1.Validator, 0, len(validatorInfo))
+		for _, validator := range validatorInfo {
+			validators = append(validators, validator)
+		}
+		or.updateEffectiveBalances(validators, slot)
+
 		// Iterate over all validators. If two or more validators exit or get slashed in the same slot,
 		// this cleanup will eventually set both of their pending rewards to 0 and share them among the pool
 		rewardsToDistribute := big.NewInt(0)
@@ -274,179 +355,164 @@ func (or *Oracle) ValidatorCleanup(slot uint64) error {
 		if rewardsToDistribute.Cmp(big.NewInt(0)) != 0 {
 			or.increaseAllPendingRewards(rewardsToDistribute)
 		}
+
+		// Held rewards of validators that exited with bls keys can't be credited anymore
+		or.releaseExitedBlsCredits(validatorInfo, slot)
 		log.Info("Validator cleanup done! Redistributed a total of ", rewardsToDistribute, " wei in pending among the pool in slot ", slot)
 	}
 
 	return nil
 }
 
-// We use the following events to validate that the config has not changed. Dynamic
-// parameters are not supported.
-// UpdatePoolFee: Indicates the cut in %*100 the pool gets
-// PoolFeeRecipient: Indicates the address that receives the pool fees
-// CheckpointSlotSize: Indicates the size of the checkpoint in slots
-// UpdateSubscriptionCollateral: Indicates the amount of ETH required to subscribe
-func (or *Oracle) validateFullBlockConfig(fullBlock *FullBlock, config *Config) error {
-	if len(fullBlock.Events.UpdatePoolFee) > 1 ||
-		len(fullBlock.Events.PoolFeeRecipient) > 1 ||
-		len(fullBlock.Events.CheckpointSlotSize) > 1 ||
-		len(fullBlock.Events.UpdateSubscriptionCollateral) > 1 {
-		return errors.New("more than one event of the same type in the same block, weird")
-	}
+// Persist the state of the oracle to a JSON file. By default its stored
+// as state.json but if saveSlot is true, it will store two copies,
+// one updating the existing state.json and other as state_<slot>.json.
+// The later is to be used mainly for debugging and recovery purposes.
+// Regardless of the configured state store, this is the export format.
+func (or *Oracle) SaveToJson(saveSlot bool) error {
+	log.Info("Saving oracle state to JSON file")
+	return or.saveToStore(NewJsonStateStore(StateFolder), saveSlot)
+}
 
-	if len(fullBlock.Events.UpdatePoolFee) != 0 && big.NewInt(int64(config.PoolFeesPercentOver10000)).Cmp(fullBlock.Events.UpdatePoolFee[0].NewPoolFee) != 0 {
-		return errors.New(fmt.Sprintf("pool fee has changed. config: %d, block: %d",
-			config.PoolFeesPercentOver10000, fullBlock.Events.UpdatePoolFee[0].NewPoolFee))
-	}
+// Persist the state of the oracle in the configured state store. If checkpoint
+// is true, a copy of the state is kept so that it can be loaded later on with
+// LoadGivenState. If no store was set, it behaves as SaveToJson.
+func (or *Oracle) SaveState(checkpoint bool) error {
+	return or.saveToStore(or.stateStore(), checkpoint)
+}
 
-	if len(fullBlock.Events.PoolFeeRecipient) != 0 && !utils.Equals(config.PoolFeesAddress, fullBlock.Events.PoolFeeRecipient[0].NewPoolFeeRecipient.String()) {
-		return errors.New(fmt.Sprintf("pool fee recipient has changed. config: %s, block: %s",
-			config.PoolFeesAddress, fullBlock.Events.PoolFeeRecipient[0].NewPoolFeeRecipient.String()))
+// Persists the state in the configured state store, but only if it can be done
+// without waiting and the state is consistent. Meant to be used before exiting
+// on a fatal error, that may have happened while a slot was being processed.
+// Returns false if the state was not persisted.
+func (or *Oracle) TrySaveState() (bool, error) {
+	if !or.mutex.TryLock() {
+		return false, nil
 	}
+	defer or.mutex.Unlock()
 
-	if len(fullBlock.Events.CheckpointSlotSize) != 0 && config.CheckPointSizeInSlots != fullBlock.Events.CheckpointSlotSize[0].NewCheckpointSlotSize {
-		return errors.New(fmt.Sprintf("checkpoint size has changed. config: %d, block: %d",
-			config.CheckPointSizeInSlots, fullBlock.Events.CheckpointSlotSize[0].NewCheckpointSlotSize))
+	if or.processingSlot {
+		return false, nil
 	}
 
-	if len(fullBlock.Events.UpdateSubscriptionCollateral) != 0 && config.CollateralInWei.Cmp(fullBlock.Events.UpdateSubscriptionCollateral[0].NewSubscriptionCollateral) != 0 {
-		return errors.New(fmt.Sprintf("subscription collateral has changed. config: %d, block: %d",
-			config.CollateralInWei, fullBlock.Events.UpdateSubscriptionCollateral[0].NewSubscriptionCollateral))
+	// A checkpoint that was not frozen yet would be skipped when resuming
+	isCheckpoint, err := or.isCheckpointLockFree()
+	if err == nil && isCheckpoint {
+		if _, frozen := or.state.CommitedStates[or.state.LatestProcessedSlot]; !frozen {
+			return false, nil
+		}
 	}
 
-	return nil
+	err = or.saveLockFree(or.stateStore(), false)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-// Persist the state of the oracle to a JSON file. By default its stored
-// as state.json but if saveSlot is true, it will store two copies,
-// one updating the existing state.json and other as state_<slot>.json.
-// The later is to be used mainly for debugging and recovery purposes.
-func (or *Oracle) SaveToJson(saveSlot bool) error {
+func (or *Oracle) saveToStore(store StateStore, checkpoint bool) error {
 	// Not just read lock since we change the hash, minor thing
 	// but it cant be just a read mutex
-
 	or.mutex.Lock()
+	defer or.mutex.Unlock()
 
-	log.Info("Saving oracle state to JSON file")
+	return or.saveLockFree(store, checkpoint)
+}
 
+func (or *Oracle) saveLockFree(store StateStore, checkpoint bool) error {
 	err := or.hashStateLockFree()
 	if err != nil {
 		return errors.Wrap(err, "error hashing the oracle state")
 	}
 
-	jsonData, err := json.MarshalIndent(or.state, "", " ")
-	if err != nil {
-		return errors.Wrap(err, "could not marshal state to JSON")
-	}
-	or.mutex.Unlock()
-
-	path := filepath.Join(StateFolder, StateJsonName)
-	err = os.MkdirAll(StateFolder, os.ModePerm)
-	if err != nil {
-		return errors.Wrap(err, "could not create folder")
-	}
-
-	log.Trace("Saving state to file:", fmt.Sprintf("%s", jsonData))
+	return store.Save(or.state, checkpoint)
+}
 
-	err = ioutil.WriteFile(path, jsonData, 0644)
-	if err != nil {
-		return errors.Wrap(err, "could not write file")
-	}
+// Sets the backend used to persist and load the state. By default the state
+// is stored as json files in StateFolder
+func (or *Oracle) SetStateStore(store StateStore) {
+	or.mutex.Lock()
+	defer or.mutex.Unlock()
+	or.store = store
+}
 
-	log.WithFields(log.Fields{
-		"LatestProcessedSlot":  or.state.LatestProcessedSlot,
-		"LatestProcessedBlock": or.state.LatestProcessedBlock,
-		"NextSlotToProcess":    or.state.NextSlotToProcess,
-		"TotalValidators":      len(or.state.Validators),
-		"Network":              or.state.Network,
-		"PoolAddress":          or.state.PoolAddress,
-		"Path":                 path,
-		"Hash":                 or.state.StateHash,
-	}).Info("Saved state to file")
-
-	// If saveSlot is true, save a copy of the state with the slot number in the file
-	if saveSlot {
-		filename := fmt.Sprintf("state_%d.json", or.State().LatestProcessedSlot)
-		path = filepath.Join(StateFolder, filename)
+// Sets the checker the rewards of the processed blocks are cross-checked with,
+// and starts it. By default they are not checked
+func (or *Oracle) SetRelayChecker(checker *RelayChecker) {
+	or.mutex.Lock()
+	defer or.mutex.Unlock()
+	or.relayChecker = checker
+	checker.Start(or.addRewardDiscrepancies)
+}
 
-		log.WithFields(log.Fields{
-			"LatestProcessedSlot": or.state.LatestProcessedSlot,
-			"FileName":            filename,
-		}).Info("Storing also a copy of the state")
+// Returns the latest discrepancies found between the rewards and the payloads
+// the relays delivered, oldest first
+func (or *Oracle) RewardDiscrepancies() []*RewardDiscrepancy {
+	or.mutex.RLock()
+	defer or.mutex.RUnlock()
+	return append([]*RewardDiscrepancy{}, or.state.RewardDiscrepancies...)
+}
 
-		err = ioutil.WriteFile(path, jsonData, 0644)
-		if err != nil {
-			return errors.Wrap(err, "could not write file")
-		}
+// Stores the discrepancies in the state, dropping the oldest ones above the limit
+func (or *Oracle) addRewardDiscrepancies(discrepancies []*RewardDiscrepancy) {
+	or.mutex.Lock()
+	defer or.mutex.Unlock()
+	or.state.RewardDiscrepancies = append(or.state.RewardDiscrepancies, discrepancies...)
+	if len(or.state.RewardDiscrepancies) > MaxRewardDiscrepancies {
+		or.state.RewardDiscrepancies = or.state.RewardDiscrepancies[len(or.state.RewardDiscrepancies)-MaxRewardDiscrepancies:]
 	}
+}
 
-	return nil
+func (or *Oracle) stateStore() StateStore {
+	if or.store == nil {
+		return NewJsonStateStore(StateFolder)
+	}
+	return or.store
 }
 
 // Loads the oracle state from a human readable json file. Multiple
 // check are performed to ensure the state is valid such as checking
-// the hash of the state and ensuring the configuation has not changed
+// the hash of the state and ensuring the configuation has not changed.
+// If state.json is corrupted, the newest valid snapshot is loaded instead
 func (or *Oracle) LoadFromJson() (bool, error) {
-	path := filepath.Join(StateFolder, StateJsonName)
-	has, err := or.LoadFromPath(path)
-	return has, err
+	state, found, err := NewJsonStateStore(StateFolder).Load()
+	if err != nil || !found {
+		return false, err
+	}
+	return or.setLoadedState(state)
 }
 
 func (or *Oracle) LoadFromPath(path string) (bool, error) {
-	or.mutex.Lock()
-	defer or.mutex.Unlock()
-
-	log.Info("Loading oracle state from json file: ", path)
-
-	jsonFile, err := os.Open(path)
-	defer jsonFile.Close()
-
-	// Dont error if the file wasnt found, just return not found
-	if err != nil {
-		return false, nil
-	}
-
-	byteValue, err := ioutil.ReadAll(jsonFile)
-	if err != nil {
-		return false, errors.Wrap(err, "could not read json file")
+	state, found, err := loadStateFromPath(path)
+	if err != nil || !found {
+		return false, err
 	}
-
-	found, err := or.LoadFromBytes(byteValue)
-
-	return found, err
+	return or.setLoadedState(state)
 }
 
 func (or *Oracle) LoadFromBytes(rawBytes []byte) (bool, error) {
-	var state OracleState
-
-	err := json.Unmarshal(rawBytes, &state)
+	state, err := decodeStateJson(rawBytes)
 	if err != nil {
-		return false, errors.Wrap(err, "could not unmarshal json file")
+		return false, err
 	}
+	return or.setLoadedState(state)
+}
 
-	// Store the hash we recovered from the file
-	recoveredHash := state.StateHash
-
-	// Reset the hash since we want to hash the content without the hash
-	state.StateHash = ""
-
-	// Serialize the state without the hash
-	jsonNoHash, err := json.MarshalIndent(state, "", " ")
-	if err != nil {
-		return false, errors.Wrap(err, "could not marshal state without hash")
+// Loads the latest state from the configured state store, with the same
+// checks as LoadFromJson. If no store was set, it behaves as LoadFromJson.
+func (or *Oracle) LoadState() (bool, error) {
+	state, found, err := or.stateStore().Load()
+	if err != nil || !found {
+		return false, err
 	}
+	return or.setLoadedState(state)
+}
 
-	log.Trace("Loaded state from file: ", fmt.Sprintf("%s", jsonNoHash))
-
-	// We calculate the hash of the state we read
-	calculatedHashByte := sha256.Sum256(jsonNoHash[:])
-	calculatedHashString := hexutil.Encode(calculatedHashByte[:])
-
-	// Hashes must match
-	if !utils.Equals(recoveredHash, calculatedHashString) {
-		return false, errors.New(fmt.Sprintf("hash mismatch, recovered: %s, calculated: %s",
-			recoveredHash, calculatedHashString))
-	}
+// Sets the given state as the oracle state, after ensuring it was created
+// with the same configuration the oracle is running with
+func (or *Oracle) setLoadedState(state *OracleState) (bool, error) {
+	or.mutex.Lock()
+	defer or.mutex.Unlock()
 
 	// Sanity check to ensure the oracle config matches the loaded state
 	if state.Network != or.cfg.Network {
@@ -459,26 +525,6 @@ func (or *Oracle) LoadFromBytes(rawBytes []byte) (bool, error) {
 			state.PoolAddress, or.cfg.PoolAddress))
 	}
 
-	if state.PoolFeesAddress != or.cfg.PoolFeesAddress {
-		return false, errors.New(fmt.Sprintf("pool fees address mismatch, recovered: %s, expected: %s",
-			state.PoolFeesAddress, or.cfg.PoolFeesAddress))
-	}
-
-	if state.PoolFeesPercentOver10000 != or.cfg.PoolFeesPercentOver10000 {
-		return false, errors.New(fmt.Sprintf("pool fees percent mismatch, recovered: %d, expected: %d",
-			state.PoolFeesPercentOver10000, or.cfg.PoolFeesPercentOver10000))
-	}
-
-	if state.CollateralInWei.Cmp(or.cfg.CollateralInWei) != 0 {
-		return false, errors.New(fmt.Sprintf("collateral mismatch, recovered: %d, expected: %d",
-			state.CollateralInWei, or.cfg.CollateralInWei))
-	}
-
-	if state.CheckPointSizeInSlots != or.cfg.CheckPointSizeInSlots {
-		return false, errors.New(fmt.Sprintf("check point size mismatch, recovered: %d, expected: %d",
-			state.CheckPointSizeInSlots, or.cfg.CheckPointSizeInSlots))
-	}
-
 	if state.DeployedBlock != or.cfg.DeployedBlock {
 		return false, errors.New(fmt.Sprintf("deployed block mismatch, recovered: %d, expected: %d",
 			state.DeployedBlock, or.cfg.DeployedBlock))
@@ -489,7 +535,59 @@ func (or *Oracle) LoadFromBytes(rawBytes []byte) (bool, error) {
 			state.DeployedSlot, or.cfg.DeployedSlot))
 	}
 
-	or.state = &state
+	// The config has the pool parameters the contract was deployed with. Their
+	// updates are replayed from the events, so the state must start from them
+	initial := state.PoolParamsAt(state.DeployedSlot)
+	if !initial.equals(&PoolParams{
+		PoolFeesPercentOver10000: or.cfg.PoolFeesPercentOver10000,
+		PoolFeesAddress:          or.cfg.PoolFeesAddress,
+		CheckPointSizeInSlots:    or.cfg.CheckPointSizeInSlots,
+		CheckpointAnchorSlot:     state.DeployedSlot,
+		CollateralInWei:          or.cfg.CollateralInWei,
+	}) {
+		return false, errors.New(fmt.Sprintf("initial pool parameters mismatch, recovered: "+
+			"fee %d, address %s, checkpoint size %d, collateral %s, expected: "+
+			"fee %d, address %s, checkpoint size %d, collateral %s",
+			initial.PoolFeesPercentOver10000, initial.PoolFeesAddress, initial.CheckPointSizeInSlots, initial.CollateralInWei,
+			or.cfg.PoolFeesPercentOver10000, or.cfg.PoolFeesAddress, or.cfg.CheckPointSizeInSlots, or.cfg.CollateralInWei))
+	}
+
+	// Overrides of already processed slots are part of the roots, so they can't
+	// change. New ones only apply to the slots that are processed from now on
+	if !rewardOverridesEqual(
+		rewardOverridesUntil(state.RewardOverrides, state.LatestProcessedSlot),
+		rewardOverridesUntil(or.cfg.RewardOverrides, state.LatestProcessedSlot)) {
+		return false, errors.New(fmt.Sprintf("reward overrides mismatch for the slots up to %d, "+
+			"recovered: %d, expected: %d", state.LatestProcessedSlot,
+			len(rewardOverridesUntil(state.RewardOverrides, state.LatestProcessedSlot)),
+			len(rewardOverridesUntil(or.cfg.RewardOverrides, state.LatestProcessedSlot))))
+	}
+	if !rewardOverridesEqual(state.RewardOverrides, or.cfg.RewardOverrides) {
+		log.WithFields(log.Fields{
+			"StateRewardOverrides":  len(state.RewardOverrides),
+			"ConfigRewardOverrides": len(or.cfg.RewardOverrides),
+		}).Info("Reward overrides of the state differ from the config for future slots, using the config ones")
+		state.RewardOverrides = or.cfg.RewardOverrides
+	}
+
+	// Same for the builders, only their changes after the processed slots apply
+	if !whitelistedBuildersEqual(
+		whitelistedBuildersUntil(state.WhitelistedBuilders, state.LatestProcessedSlot),
+		whitelistedBuildersUntil(or.cfg.WhitelistedBuilders, state.LatestProcessedSlot)) {
+		return false, errors.New(fmt.Sprintf("whitelisted builders mismatch for the slots up to %d, "+
+			"recovered: %d, expected: %d", state.LatestProcessedSlot,
+			len(whitelistedBuildersUntil(state.WhitelistedBuilders, state.LatestProcessedSlot)),
+			len(whitelistedBuildersUntil(or.cfg.WhitelistedBuilders, state.LatestProcessedSlot))))
+	}
+	if !whitelistedBuildersEqual(state.WhitelistedBuilders, or.cfg.WhitelistedBuilders) {
+		log.WithFields(log.Fields{
+			"StateWhitelistedBuilders":  len(state.WhitelistedBuilders),
+			"ConfigWhitelistedBuilders": len(or.cfg.WhitelistedBuilders),
+		}).Info("Whitelisted builders of the state differ from the config for future slots, using the config ones")
+		state.WhitelistedBuilders = or.cfg.WhitelistedBuilders
+	}
+
+	or.state = state
 
 	mRoot, enoughData := or.getMerkleRootIfAny()
 	log.WithFields(log.Fields{
@@ -500,40 +598,39 @@ func (or *Oracle) LoadFromBytes(rawBytes []byte) (bool, error) {
 		"PoolAddress":          state.PoolAddress,
 		"MerkleRoot":           mRoot,
 		"EnoughData":           enoughData,
-	}).Info("Loaded state from file")
+	}).Info("Loaded state")
 	return true, nil
 }
 
+// Loads the state that was saved as a checkpoint at the given slot from the
+// configured state store. If not found, it attempts to load the previous ones.
 func (or *Oracle) LoadGivenState(slotCheckpoint uint64) (bool, error) {
-	// Try to load the given state
-	path := filepath.Join(StateFolder, fmt.Sprintf("state_%d.json", slotCheckpoint))
-	has, err := or.LoadFromPath(path)
-	if err != nil {
-		return false, err
-	}
+	store := or.stateStore()
 
 	// If not found, attemp to load previous states up to "attempts" checkpoints before
 	attempts := 3
-	if !has {
-		for i := 1; i < attempts; i++ {
-			trySlot := slotCheckpoint - or.cfg.CheckPointSizeInSlots*uint64(i)
+	for i := 0; i < attempts; i++ {
+		trySlot := slotCheckpoint - or.state.CheckPointSizeInSlots*uint64(i)
+		if i > 0 {
 			log.Info("Could not find slot for checkpoint, ", slotCheckpoint, ", trying slot: ", trySlot)
-			path = filepath.Join(StateFolder, fmt.Sprintf("state_%d.json", trySlot))
-			has, err = or.LoadFromPath(path)
-			if has {
-				break
-			}
+		}
+		state, found, err := store.LoadCheckpoint(trySlot)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return or.setLoadedState(state)
 		}
 	}
 
-	return has, err
+	return false, nil
 }
 
 // Takes the current state, creates a copy of it and freezes it, storing
-// it in a map slot->state. It also creates a set of merkle proof for each
-// withdrawal address of each validator. Each of these frozen states maps
-// to a commited onchain state, represented by a merkle root.
-// Returns false if there wasnt enough data to create a merkle tree
+// it in a map slot->state, together with the leaf of each withdrawal address.
+// Each of these frozen states maps to a commited onchain state, represented by
+// a merkle root. Proofs are not stored, they are generated on request with
+// GetMerkleProof. Returns false if there wasnt enough data to create a merkle tree
 func (or *Oracle) FreezeCheckpoint() bool {
 	or.mutex.Lock()
 	defer or.mutex.Unlock()
@@ -541,54 +638,74 @@ func (or *Oracle) FreezeCheckpoint() bool {
 	validatorsCopy := make(map[uint64]*ValidatorInfo)
 	utils.DeepCopy(or.state.Validators, &validatorsCopy)
 
-	mk := NewMerklelizer()
-	withdrawalToLeaf, withdrawalToRawLeaf, tree, enoughData := mk.GenerateTreeFromState(or.state)
+	tree, enoughData := or.stateMerklelizer().UpdateTree(or.state)
 	if !enoughData {
 		return false
 	}
-	merkleRootStr := hexutil.Encode(tree.Root[:])
+	merkleRootStr := tree.Root()
 
 	log.WithFields(log.Fields{
 		"Slot":       or.state.LatestProcessedSlot,
 		"MerkleRoot": merkleRootStr,
 	}).Info("Freezing state")
 
-	// Merkle proofs for each withdrawal address
-	proofs := make(map[string][]string)
-	leafs := make(map[string]RawLeaf)
-	for WithdrawalAddress, rawLeaf := range withdrawalToRawLeaf {
-
-		// Extra sanity check to make sure the withdrawal address is the same as the key
-		if !utils.Equals(WithdrawalAddress, rawLeaf.WithdrawalAddress) {
-			log.Fatal("withdrawal address in raw leaf doesnt match the key")
-		}
-
-		block := withdrawalToLeaf[WithdrawalAddress]
-		proof, err := tree.Proof(block)
-
-		if err != nil {
-			log.Fatal("could not generate proof for block: ", err)
-		}
-
-		// Store the proofs of the withdrawal address (to be used onchain)
-		proofs[WithdrawalAddress] = utils.ByteArrayToArray(proof.Siblings)
-
-		// Store the leafs (to be used onchain)
-		leafs[WithdrawalAddress] = rawLeaf
-	}
-
 	state := &OnchainState{
 		Validators: validatorsCopy,
 		MerkleRoot: merkleRootStr,
 		Slot:       or.state.LatestProcessedSlot,
-		Proofs:     proofs,
-		Leafs:      leafs,
+		// Store the leafs (to be used onchain)
+		Leafs: tree.Leafs(),
 	}
 
 	or.state.CommitedStates[state.Slot] = state
 	return true
 }
 
+// Returns the merklelizer that keeps the tree of the state, creating it if needed
+func (or *Oracle) stateMerklelizer() *Merklelizer {
+	if or.merklelizer == nil {
+		or.merklelizer = NewMerklelizer()
+	}
+	return or.merklelizer
+}
+
+// Returns the merkle proof of the withdrawal address at the checkpoint of the given
+// slot. The proofs of the latest checkpoint come from the tree kept by the oracle,
+// and the ones of older checkpoints from a tree created from their leafs
+func (or *Oracle) GetMerkleProof(slot uint64, withdrawalAddress string) ([]string, error) {
+	or.mutex.RLock()
+	defer or.mutex.RUnlock()
+
+	checkpoint, found := or.state.CommitedStates[slot]
+	if !found {
+		return nil, errors.New(fmt.Sprintf("could not find checkpoint at slot %d", slot))
+	}
+	if checkpoint.Proofs != nil {
+		return CheckpointMerkleProof(or.state, checkpoint, withdrawalAddress)
+	}
+
+	tree := or.stateMerklelizer().tree
+	if tree == nil || tree.Root() != checkpoint.MerkleRoot {
+		or.checkpointTreeMutex.Lock()
+		defer or.checkpointTreeMutex.Unlock()
+
+		if or.checkpointTree == nil || or.checkpointTreeSlot != slot || or.checkpointTree.Root() != checkpoint.MerkleRoot {
+			checkpointTree, err := NewCheckpointTree(or.state, checkpoint)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not create tree of checkpoint")
+			}
+			or.checkpointTree, or.checkpointTreeSlot = checkpointTree, slot
+		}
+		tree = or.checkpointTree
+	}
+
+	proofs, found := tree.Proof(withdrawalAddress)
+	if !found {
+		return nil, errors.New("could not find proof for withdrawal address: " + withdrawalAddress)
+	}
+	return proofs, nil
+}
+
 // Returns true and the latest commited slot if there is any commited state
 // false otherwise. Note that if there are checkpoints but without enough data
 // to create a tree, it will still return false
@@ -623,6 +740,74 @@ func (or *Oracle) LatestCommitedState() *OnchainState {
 	return or.State().CommitedStates[latestCommitedSlot]
 }
 
+// Same as GetCheckpointProof, but using the trees kept by the oracle
+func (or *Oracle) GetCheckpointProof(slot uint64, withdrawalAddress string) (*CheckpointProof, error) {
+	return getCheckpointProof(or.State(), slot, withdrawalAddress, func(checkpoint *OnchainState) ([]string, error) {
+		return or.GetMerkleProof(checkpoint.Slot, withdrawalAddress)
+	})
+}
+
+// Compacts the commited states older than the latest keep ones to their slot, root
+// and leafs, dropping their frozen validators and stored proofs, that can be generated
+// again from the leafs. The one at the onchain slot is always kept in full, since it's
+// the one rewards are claimed with. A keep of 0 keeps all of them in full. Returns the
+// number of commited states that were pruned
+func (or *Oracle) PruneCommitedStates(keep int, onchainSlot uint64) int {
+	or.mutex.Lock()
+	defer or.mutex.Unlock()
+
+	if keep <= 0 {
+		return 0
+	}
+
+	// Newest first
+	slots := make([]uint64, 0, len(or.state.CommitedStates))
+	for slot := range or.state.CommitedStates {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] > slots[j] })
+
+	pruned := 0
+	for i, slot := range slots {
+		commited := or.state.CommitedStates[slot]
+		if i < keep || slot == onchainSlot || commited.Pruned {
+			continue
+		}
+
+		compacted := &OnchainState{
+			Slot:       commited.Slot,
+			TxHash:     commited.TxHash,
+			MerkleRoot: commited.MerkleRoot,
+			Leafs:      commited.Leafs,
+			Pruned:     true,
+		}
+
+		// Proofs stored by older versions are only dropped if they can be generated again
+		if commited.Proofs != nil {
+			if _, err := NewCheckpointTree(or.state, commited); err != nil {
+				log.WithFields(log.Fields{
+					"Slot":  slot,
+					"Error": err,
+				}).Warn("Could not generate the proofs of the commited state from its leafs, keeping them")
+				compacted.Proofs = commited.Proofs
+			}
+		}
+
+		// Replaced and not modified, since it may be being read
+		or.state.CommitedStates[slot] = compacted
+		pruned++
+	}
+
+	if pruned != 0 {
+		log.WithFields(log.Fields{
+			"Pruned":      pruned,
+			"Keep":        keep,
+			"OnchainSlot": onchainSlot,
+		}).Info("Pruned old commited states")
+	}
+	return pruned
+}
+
 // Check if the oracle is in sync with a given root and slot. Its considered in sync
 // when the latest commited state has the same root and slot as the onchain state
 func (or *Oracle) IsOracleInSyncWithChain(onchainRoot string, onchainSlot uint64) (bool, error) {
@@ -703,6 +888,10 @@ func (or *Oracle) RunOnchainReconciliation(
 		totalCumulativeRewards.Add(totalCumulativeRewards, val.PendingRewardsWei)
 	}
 	totalCumulativeRewards.Add(totalCumulativeRewards, or.state.PoolAccumulatedFees)
+	for _, fees := range or.state.FormerPoolFees {
+		totalCumulativeRewards.Add(totalCumulativeRewards, fees)
+	}
+	totalCumulativeRewards.Add(totalCumulativeRewards, or.heldBlsRewardsWei())
 
 	log.Info("[Reconciliation] Total amount of accumulated + pending rewards: ", totalCumulativeRewards)
 
@@ -739,6 +928,10 @@ func (or *Oracle) RunOffchainReconciliation() error {
 		liabilities.Add(liabilities, val.PendingRewardsWei)
 	}
 	liabilities.Add(liabilities, or.state.PoolAccumulatedFees)
+	for _, fees := range or.state.FormerPoolFees {
+		liabilities.Add(liabilities, fees)
+	}
+	liabilities.Add(liabilities, or.heldBlsRewardsWei())
 
 	assets := big.NewInt(0)
 
@@ -765,28 +958,31 @@ func (or *Oracle) RunOffchainReconciliation() error {
 	return nil
 }
 
+// Returns the withdrawal addresses of all validators, without duplicates no matter
+// their case, plus the pool fees address and the former ones that have fees
 func (or *Oracle) GetUniqueWithdrawalAddresses() []string {
-	var uniqueWithAdd []string
-
-	// Iterate all validators
-	for _, validator := range or.State().Validators {
-		skip := false
-		// Iterate all unique deposit addresses processed before
-		for _, u := range uniqueWithAdd {
-			// If the deposit address is already in the list, skip it
-			if utils.Equals(validator.WithdrawalAddress, u) {
-				skip = true
-				break
-			}
-		}
-		// Not found, add it
-		if !skip {
+	state := or.State()
+	uniqueWithAdd := make([]string, 0)
+	seen := make(map[string]bool, len(state.Validators))
+
+	// Iterate all validators, adding the addresses not seen before
+	for _, validator := range state.Validators {
+		withdrawalAddress := strings.ToLower(validator.WithdrawalAddress)
+		if !seen[withdrawalAddress] {
+			seen[withdrawalAddress] = true
 			uniqueWithAdd = append(uniqueWithAdd, validator.WithdrawalAddress)
 		}
 	}
 
-	// Include also the pool address
-	uniqueWithAdd = append(uniqueWithAdd, or.State().PoolFeesAddress)
+	// Include also the pool address, and the former ones that have fees
+	uniqueWithAdd = append(uniqueWithAdd, state.PoolFeesAddress)
+	seen[strings.ToLower(state.PoolFeesAddress)] = true
+	for formerAddress := range state.FormerPoolFees {
+		if !seen[strings.ToLower(formerAddress)] {
+			seen[strings.ToLower(formerAddress)] = true
+			uniqueWithAdd = append(uniqueWithAdd, formerAddress)
+		}
+	}
 
 	return uniqueWithAdd
 }
@@ -923,9 +1119,14 @@ func (or *Oracle) handleBlsCorrectBlockProposal(block SummarizedBlock) {
 		"ValIndex":   block.ValidatorIndex,
 		"RewardWei":  block.Reward,
 		"RewardType": block.RewardType.String(),
-	}).Warn("[Reward] Block proposal was ok but bls keys are not supported, sending rewards to pool")
+	}).Warn("[Reward] Block proposal was ok but bls keys are not supported")
 
-	or.sendRewardToPool(block.Reward)
+	// Held until the validator changes its credentials, before it went to the pool
+	if or.isRuleActive(RetroactiveBlsCredits, block.Slot) {
+		or.holdBlsReward(block)
+	} else {
+		or.sendRewardToPool(block.Reward)
+	}
 	or.state.ProposedBlocks = append(or.state.ProposedBlocks, block)
 }
 
@@ -991,7 +1192,8 @@ func (or *Oracle) handleManualSubscriptions(
 			continue
 		}
 
-		// Subscription received for a validator that dont have eth1 withdrawal address (bls)
+		// Subscription received for a validator that dont have eth1 withdrawal address (bls). Both
+		// eth1 (0x01) and compounding (0x02) credentials have it
 		validatorWithdrawal, err := utils.GetEth1AddressByte(validator.Validator.WithdrawalCredentials)
 		if err != nil {
 			log.WithFields(log.Fields{
@@ -1254,6 +1456,16 @@ func (or *Oracle) addSubscription(valIndex uint64, withdrawalAddress string, val
 		// If subscription is new its auto
 		or.state.Validators[valIndex].SubscriptionType = Auto
 	} else {
+		// The address can't change once set, switching from eth1 to compounding credentials
+		// keeps it. If it ever does, rewards keep going to the tracked one
+		if withdrawalAddress != "" && !strings.EqualFold(validator.WithdrawalAddress, withdrawalAddress) {
+			log.WithFields(log.Fields{
+				"ValidatorIndex":           valIndex,
+				"TrackedWithdrawalAddress": validator.WithdrawalAddress,
+				"WithdrawalAddress":        withdrawalAddress,
+			}).Warn("Withdrawal address of validator changed, keeping the tracked one")
+		}
+
 		// If we found the validator and is not subscribed, advance the state machine
 		// Most likely it was subscribed before, then unsubscribed and now auto subscribes
 		if !or.isSubscribed(valIndex) {
@@ -1329,77 +1541,55 @@ func (or *Oracle) increaseAllPendingRewards(
 	// Calculate the pool cut (not taking into account the remainder)
 	poolCut := big.NewInt(0).Div(aux, over)
 
-	totalFees := big.NewInt(0)
-	perValidatorReward := big.NewInt(0)
-	if slotFork, found := SlotFork1[or.cfg.Network]; found {
-		// Fixes minor bug in rewards calculation from a given slot. It just affects a few wei nothing
-		// major, but this fixes the remainder1 not being scalled over 100.
-		if or.state.NextSlotToProcess >= slotFork {
-
-			log.WithFields(log.Fields{
-				"SlotFork":           slotFork,
-				"Slot":               or.state.NextSlotToProcess,
-				"Network":            or.cfg.Network,
-				"FeePercentOver1000": or.cfg.PoolFeesPercentOver10000,
-				"Method":             "PostFork1",
-			}).Debug("Calculating rewards")
-
-			toShareAllValidators := big.NewInt(0).Sub(reward, poolCut)
-			perValidatorReward = big.NewInt(0).Div(toShareAllValidators, numEligibleValidators)
-			remainder := big.NewInt(0).Mod(toShareAllValidators, numEligibleValidators)
-			totalFees = big.NewInt(0).Add(poolCut, remainder)
-		} else {
+	// The amount to share is the reward minus the pool cut
+	toShareAllValidators := big.NewInt(0).Sub(reward, poolCut)
+	totalFees := big.NewInt(0).Set(poolCut)
+	if !or.isRuleActive(RewardsRoundingFix, or.state.NextSlotToProcess) {
+		// Before the fix of this minor bug, the remainder of the pool cut was not
+		// scaled over 100. It just affects a few wei, nothing major.
+		remainder1 := big.NewInt(0).Mod(aux, over)
+		toShareAllValidators.Sub(toShareAllValidators, remainder1)
+		totalFees.Add(totalFees, remainder1)
+	}
 
-			log.WithFields(log.Fields{
-				"SlotFork":           slotFork,
-				"Slot":               or.state.NextSlotToProcess,
-				"Network":            or.cfg.Network,
-				"FeePercentOver1000": or.cfg.PoolFeesPercentOver10000,
-				"Method":             "PreFork1",
-			}).Debug("Calculating rewards")
-
-			// And remainder of above operation
-			remainder1 := big.NewInt(0).Mod(aux, over)
-
-			// The amount to share is the reward minus the pool cut + remainder
-			toShareAllValidators := big.NewInt(0).Sub(reward, poolCut)
-			toShareAllValidators.Sub(toShareAllValidators, remainder1)
-
-			// Each validator gets that divided by numEligibleValidators
-			perValidatorReward = big.NewInt(0).Div(toShareAllValidators, numEligibleValidators)
-			// And remainder of above operation
-			remainder2 := big.NewInt(0).Mod(toShareAllValidators, numEligibleValidators)
-
-			// Total fees for the pool are: the cut (%) + the remainders
-			totalFees = big.NewInt(0).Add(poolCut, remainder1)
-			totalFees.Add(totalFees, remainder2)
-		}
-	} else {
-		log.Fatal("Network not found in forks list: ", or.cfg.Network)
+	eligibleInfo := make([]*ValidatorInfo, len(eligibleValidators))
+	for i, eligibleIndex := range eligibleValidators {
+		eligibleInfo[i] = or.state.Validators[eligibleIndex]
 	}
 
+	// Share it among the validators, and the remainder goes to the pool
+	distribution := or.rewardsDistribution()
+	shares, remainder := distribution.Split(toShareAllValidators, eligibleInfo)
+	totalFees.Add(totalFees, remainder)
+
 	// Increase pool rewards (fees)
 	or.state.PoolAccumulatedFees.Add(or.state.PoolAccumulatedFees, totalFees)
 
 	// Extra check to ensure what we split and what we have match
-	if big.NewInt(0).Add(big.NewInt(0).Mul(perValidatorReward, numEligibleValidators), totalFees).Cmp(reward) != 0 {
+	totalShared := big.NewInt(0).Set(totalFees)
+	for _, share := range shares {
+		totalShared.Add(totalShared, share)
+	}
+	if totalShared.Cmp(reward) != 0 {
 		log.WithFields(log.Fields{
-			"perValidatorReward":    perValidatorReward,
+			"totalShared":           totalShared,
 			"totalFees":             totalFees,
 			"numEligibleValidators": numEligibleValidators,
+			"distribution":          distribution.Name(),
 		}).Fatal("Total rewards dont match the sum of the rewards per validator and the pool fees")
 	}
 
 	log.WithFields(log.Fields{
 		"AmountEligibleValidators": numEligibleValidators,
-		"RewardPerValidatorWei":    perValidatorReward,
+		"Distribution":             distribution.Name(),
+		"SharedWei":                big.NewInt(0).Sub(reward, totalFees),
 		"PoolFeesWei":              totalFees,
 		"TotalRewardWei":           reward,
 	}).Info("Increasing pending rewards of eligible validators")
 
 	// Increase eligible validators rewards
-	for _, eligibleIndex := range eligibleValidators {
-		or.state.Validators[eligibleIndex].PendingRewardsWei.Add(or.state.Validators[eligibleIndex].PendingRewardsWei, perValidatorReward)
+	for i, validator := range eligibleInfo {
+		validator.PendingRewardsWei.Add(validator.PendingRewardsWei, shares[i])
 	}
 }
 
@@ -1455,17 +1645,16 @@ func (or *Oracle) resetPendingRewards(valIndex uint64) {
 // Gets the merkle root of the state and returns if there was enough data
 // to generate it or not.
 func (or *Oracle) getMerkleRootIfAny() (string, bool) {
-	mk := NewMerklelizer()
-	_, _, tree, enoughData := mk.GenerateTreeFromState(or.state)
+	tree, enoughData := or.stateMerklelizer().UpdateTree(or.state)
 	if !enoughData {
 		return "", enoughData
 	}
-	merkleRootStr := hexutil.Encode(tree.Root[:])
-
-	return merkleRootStr, true
+	return tree.Root(), true
 }
 
-// Returns the 0x prefixed withdrawal credentials and its type: BlsWithdrawal or Eth1Withdrawal
+// Returns the 0x prefixed withdrawal credentials and its type: BlsWithdrawal, Eth1Withdrawal
+// or CompoundingWithdrawal. Switching from eth1 to compounding credentials keeps the address,
+// so subscribed validators that switch keep their leaf.
 func GetWithdrawalAndType(validator *v1.Validator) (string, WithdrawalType) {
 	withdrawalCred := hex.EncodeToString(validator.Validator.WithdrawalCredentials)
 	if len(withdrawalCred) != 64 {
@@ -1476,9 +1665,11 @@ func GetWithdrawalAndType(validator *v1.Validator) (string, WithdrawalType) {
 		return "0x" + withdrawalCred[2:], BlsWithdrawal
 	} else if utils.IsEth1Type(withdrawalCred) {
 		return "0x" + withdrawalCred[24:], Eth1Withdrawal
+	} else if utils.IsCompoundingType(withdrawalCred) {
+		return "0x" + withdrawalCred[24:], CompoundingWithdrawal
 	}
 	// can happen if a validator sets wrong withdrawal credentials (not very likely)
-	// aka not respecting the 0x00 or 0x000000000000000000000000 prefixes
+	// aka not respecting the 0x00, 0x01 or 0x02 prefixes
 	// only concerning if the validator is subscribed to the pool
 	log.WithFields(log.Fields{
 		"WithdrawalCredentials": withdrawalCred,
@@ -380,9 +380,17 @@ func Test_FreezeCheckpoint(t *testing.T) {
 	require.Equal(t, "0xd9a1eee574026532cddccbcce6320c0600f370a7c64ce30c5eafc63357449940", oracle.state.CommitedStates[commitedSlot].MerkleRoot)
 
 	// Ensure proofs and leafs are correct
-	require.Equal(t, oracle.state.CommitedStates[commitedSlot].Proofs["0xfee0000000000000000000000000000000000000"], []string{"0x8bfb8acff6772a60d6641cb854587bb2b6f2100391fbadff2c34be0b8c20a0cc", "0x27205dd4c642acd1b1352617df2c4f410e20ff3fd6f3e3efddee9cea044921f8"})
-	require.Equal(t, oracle.state.CommitedStates[commitedSlot].Proofs["0x1000000000000000000000000000000000000000"], []string{"0xaaf838df9c8d5cec6ed77fcbc2cace945e8f2078eede4a0bb7164818d425f24d", "0x27205dd4c642acd1b1352617df2c4f410e20ff3fd6f3e3efddee9cea044921f8"})
-	require.Equal(t, oracle.state.CommitedStates[commitedSlot].Proofs["0x2000000000000000000000000000000000000000"], []string{"0xd643163144dcba353b4d27c50939b3d11133bd3c6916092de059d07353b4cb5f", "0xda53f5dd3e17f66f4a35c9c9d5fd27c094fa4249e2933fb819ac724476dc9ae1"})
+	// Proofs are not stored, but generated on request
+	require.Nil(t, oracle.state.CommitedStates[commitedSlot].Proofs)
+	for withdrawalAddress, expectedProofs := range map[string][]string{
+		"0xfee0000000000000000000000000000000000000": {"0x8bfb8acff6772a60d6641cb854587bb2b6f2100391fbadff2c34be0b8c20a0cc", "0x27205dd4c642acd1b1352617df2c4f410e20ff3fd6f3e3efddee9cea044921f8"},
+		"0x1000000000000000000000000000000000000000": {"0xaaf838df9c8d5cec6ed77fcbc2cace945e8f2078eede4a0bb7164818d425f24d", "0x27205dd4c642acd1b1352617df2c4f410e20ff3fd6f3e3efddee9cea044921f8"},
+		"0x2000000000000000000000000000000000000000": {"0xd643163144dcba353b4d27c50939b3d11133bd3c6916092de059d07353b4cb5f", "0xda53f5dd3e17f66f4a35c9c9d5fd27c094fa4249e2933fb819ac724476dc9ae1"},
+	} {
+		proofs, err := oracle.GetMerkleProof(commitedSlot, withdrawalAddress)
+		require.NoError(t, err)
+		require.Equal(t, expectedProofs, proofs)
+	}
 
 	require.Equal(t, oracle.state.CommitedStates[commitedSlot].Leafs["0xfee0000000000000000000000000000000000000"], RawLeaf{"0xfee0000000000000000000000000000000000000", big.NewInt(0)})
 	require.Equal(t, oracle.state.CommitedStates[commitedSlot].Leafs["0x1000000000000000000000000000000000000000"], RawLeaf{"0x1000000000000000000000000000000000000000", big.NewInt(1000000000000000000)})
@@ -443,6 +451,52 @@ func Test_LatestCommitedSlot_LatestCommitedState(t *testing.T) {
 
 }
 
+func Test_PruneCommitedStates(t *testing.T) {
+	oracle := newVerifyTestOracle(t, []uint64{100, 200, 300, 400, 500})
+	address := "0x1000000000000000000000000000000000000000"
+
+	expected := make(map[uint64]*CheckpointProof)
+	for slot := range oracle.state.CommitedStates {
+		proof, err := oracle.GetCheckpointProof(slot, address)
+		require.NoError(t, err)
+		expected[slot] = proof
+	}
+
+	// Proofs stored by older versions are dropped too
+	oracle.state.CommitedStates[200].Proofs = map[string][]string{address: expected[200].Proofs}
+
+	// Keeps the 2 latest and the onchain one
+	require.Equal(t, 0, oracle.PruneCommitedStates(0, 100))
+	require.Equal(t, 2, oracle.PruneCommitedStates(2, 100))
+	require.Equal(t, 0, oracle.PruneCommitedStates(2, 100))
+
+	for _, slot := range []uint64{100, 400, 500} {
+		require.False(t, oracle.state.CommitedStates[slot].Pruned)
+		require.Equal(t, 1, len(oracle.state.CommitedStates[slot].Validators))
+	}
+	for _, slot := range []uint64{200, 300} {
+		commited := oracle.state.CommitedStates[slot]
+		require.True(t, commited.Pruned)
+		require.Nil(t, commited.Validators)
+		require.Nil(t, commited.Proofs)
+		require.Equal(t, expected[slot].MerkleRoot, commited.MerkleRoot)
+		require.Equal(t, 2, len(commited.Leafs))
+
+		// Proofs are generated again from the leafs
+		proof, err := oracle.GetCheckpointProof(slot, address)
+		require.NoError(t, err)
+		require.True(t, proof.Pruned)
+		require.Equal(t, expected[slot].Proofs, proof.Proofs)
+		require.Equal(t, expected[slot].LeafAccumulatedBalance, proof.LeafAccumulatedBalance)
+		require.Equal(t, 0, len(proof.RegisteredValidators))
+
+		// And its root can still be verified
+		root, enoughData := recomputeCheckpointRoot(oracle.state, commited)
+		require.True(t, enoughData)
+		require.Equal(t, commited.MerkleRoot, root)
+	}
+}
+
 func Test_IsOracleInSyncWithChain(t *testing.T) {
 
 	oracle := NewOracle(&Config{
@@ -1054,6 +1108,83 @@ func Test_SubscribeUnsubscribe_Auto(t *testing.T) {
 	require.Equal(t, Auto, oracle.state.Validators[33].SubscriptionType)
 }
 
+func Test_SubscribeUnsubscribe_CompoundingCredentials(t *testing.T) {
+	oracle := NewOracle(&Config{
+		CollateralInWei: big.NewInt(1000),
+		Network:         "mainnet",
+	})
+
+	vals := []*v1.Validator{
+		&v1.Validator{
+			Index:  33,
+			Status: v1.ValidatorStateActiveOngoing,
+			Validator: &phase0.Validator{
+				// Compounding credentials with eth1 address: 0x9427a30991170f917d7b83def6e44d26577871ed
+				WithdrawalCredentials: []byte{2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 148, 39, 163, 9, 145, 23, 15, 145, 125, 123, 131, 222, 246, 228, 77, 38, 87, 120, 113, 237},
+				PublicKey:             phase0.BLSPubKey{129, 170, 231, 9, 230, 174, 231, 237, 73, 205, 21, 185, 65, 216, 91, 150, 122, 252, 200, 184, 68, 238, 32, 188, 126, 19, 150, 46, 132, 132, 87, 44, 27, 67, 212, 190, 117, 101, 33, 25, 236, 53, 60, 26, 50, 68, 62, 13},
+			},
+		},
+		&v1.Validator{
+			Index:  34,
+			Status: v1.ValidatorStateActiveOngoing,
+			Validator: &phase0.Validator{
+				// Eth1 credentials with the same address
+				WithdrawalCredentials: []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 148, 39, 163, 9, 145, 23, 15, 145, 125, 123, 131, 222, 246, 228, 77, 38, 87, 120, 113, 237},
+				PublicKey:             phase0.BLSPubKey{129, 170, 231, 9, 230, 174, 231, 237, 73, 205, 21, 185, 65, 216, 91, 150, 122, 252, 200, 184, 68, 238, 32, 188, 126, 19, 150, 46, 132, 132, 87, 44, 27, 67, 212, 190, 117, 101, 33, 25, 236, 53, 60, 26, 50, 68, 62, 14},
+			},
+		},
+	}
+	sender := common.Address{148, 39, 163, 9, 145, 23, 15, 145, 125, 123, 131, 222, 246, 228, 77, 38, 87, 120, 113, 237}
+
+	// Compounding credentials can subscribe as eth1 ones
+	oracle.handleManualSubscriptions([]*contract.ContractSubscribeValidator{
+		&contract.ContractSubscribeValidator{
+			ValidatorID:            33,
+			SubscriptionCollateral: big.NewInt(1000),
+			Raw:                    types.Log{TxHash: [32]byte{0x1}},
+			Sender:                 sender,
+		},
+		&contract.ContractSubscribeValidator{
+			ValidatorID:            34,
+			SubscriptionCollateral: big.NewInt(1000),
+			Raw:                    types.Log{TxHash: [32]byte{0x2}},
+			Sender:                 sender,
+		},
+	}, vals)
+	require.Equal(t, Active, oracle.state.Validators[33].ValidatorStatus)
+	require.Equal(t, Active, oracle.state.Validators[34].ValidatorStatus)
+	require.Equal(t, "0x9427a30991170f917d7b83def6e44d26577871ed", oracle.state.Validators[33].WithdrawalAddress)
+
+	// The eth1 validator switches to compounding credentials while subscribed, keeping its address
+	vals[1].Validator.WithdrawalCredentials[0] = 2
+	withdrawalAddress, withdrawalType := GetWithdrawalAndType(vals[1])
+	require.Equal(t, CompoundingWithdrawal, withdrawalType)
+	require.True(t, withdrawalType.HasEth1Address())
+
+	// Its proposals are still rewarded, not sent to the pool as bls ones
+	oracle.handleCorrectBlockProposal(SummarizedBlock{
+		Slot:              0,
+		ValidatorIndex:    34,
+		ValidatorKey:      "0x",
+		Reward:            big.NewInt(90000000),
+		RewardType:        VanilaBlock,
+		WithdrawalAddress: withdrawalAddress,
+	})
+	require.Equal(t, Active, oracle.state.Validators[34].ValidatorStatus)
+	require.Equal(t, big.NewInt(45001000), oracle.state.Validators[34].AccumulatedRewardsWei)
+
+	// And it can still unsubscribe from its address
+	oracle.handleManualUnsubscriptions([]*contract.ContractUnsubscribeValidator{
+		&contract.ContractUnsubscribeValidator{
+			ValidatorID: 34,
+			Raw:         types.Log{TxHash: [32]byte{0x3}},
+			Sender:      sender,
+		},
+	}, vals[1:])
+	require.Equal(t, NotSubscribed, oracle.state.Validators[34].ValidatorStatus)
+	require.Equal(t, Active, oracle.state.Validators[33].ValidatorStatus)
+}
+
 func Test_AutoUnsubscribeThenManual(t *testing.T) { // TODO: Missing Then auto
 
 	oracle := NewOracle(&Config{
@@ -2091,6 +2222,8 @@ func Test_handleBlsCorrectBlockProposal_NotSubscribed(t *testing.T) {
 	oracle := NewOracle(&Config{
 		PoolFeesPercentOver10000: 100, // 1%
 	})
+	// Before fork2, see blscredits_test.go for the held rewards
+	oracle.SetForks([]*Fork{Fork1})
 
 	oracle.addSubscription(888, "0xa", "0xb")
 	oracle.addSubscription(999, "0xa", "0xb")
@@ -2133,6 +2266,7 @@ func Test_handleBlsCorrectBlockProposal_NotSubscribed(t *testing.T) {
 func Test_handleBlsCorrectBlockProposal_Subscribed(t *testing.T) {
 	// This should never happen
 	oracle := NewOracle(&Config{})
+	oracle.SetForks([]*Fork{Fork1})
 	oracle.addSubscription(1, "0xa", "0xb")
 
 	missed := SummarizedBlock{
@@ -2327,7 +2461,7 @@ func Test_increaseAllPendingRewards_4(t *testing.T) {
 
 func Test_increaseAllPendingRewards_5(t *testing.T) {
 
-	MainnetRewardsSlotFork := SlotFork1["mainnet"]
+	MainnetRewardsSlotFork := Fork1.ActivationSlot("mainnet")
 
 	type pendingRewardTest struct {
 		FeePercentX100   int
@@ -2418,7 +2552,7 @@ func Test_ValidatorCleanup_1(t *testing.T) {
 
 	// TODO: This can be improved with some refactor to reduce the boilerplate
 
-	mainnetFork1 := SlotFork1["mainnet"]
+	mainnetFork1 := Fork1.ActivationSlot("mainnet")
 
 	// Test1:
 	log.Info("Test1: No validators")
@@ -3186,6 +3320,18 @@ func Test_GetWithdrawalAndType(t *testing.T) {
 
 	require.Equal(t, with2, "0xed750cbdedaa39da69532eee649a5d3a202b310de2a6645af1dd7daca0fd22")
 	require.Equal(t, type2, BlsWithdrawal)
+
+	// Test compounding credentials
+	validator3 := &v1.Validator{
+		Validator: &phase0.Validator{
+			WithdrawalCredentials: []byte{2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 148, 39, 163, 9, 145, 23, 15, 145, 125, 123, 131, 222, 246, 228, 77, 38, 87, 120, 113, 237},
+		},
+	}
+	with3, type3 := GetWithdrawalAndType(validator3)
+
+	require.Equal(t, with3, "0x9427a30991170f917d7b83def6e44d26577871ed")
+	require.Equal(t, type3, CompoundingWithdrawal)
+	require.False(t, BlsWithdrawal.HasEth1Address())
 }
 
 // Not a test per se but a util to estimate how much memory the oracle will use
@@ -0,0 +1,184 @@
+package oracle
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Known reward overrides of each network, in overrides/<network>.json
+//
+//go:embed overrides/*.json
+var embeddedRewardOverrides embed.FS
+
+// Mev reward of a slot that can't be detected from the block, so it's set by
+// hand. Eg https://beaconcha.in/slot/10400574 sent the mev reward in a tx with
+// a self destruct, which doesn't trigger the EtherReceived event. It could be
+// detected with debug_traceTransaction, but that requires an archival node.
+type RewardOverride struct {
+	Slot             uint64   `json:"slot"`
+	RewardWei        *big.Int `json:"reward_wei"`
+	Recipient        string   `json:"recipient"`
+	JustificationUrl string   `json:"justification_url"`
+}
+
+// Loads the reward overrides of the network, embedded in the binary, merged
+// with the ones in the given json file, if any, which replace the embedded
+// ones of the same slot. Since they are part of the state, the overrides of its
+// processed slots can't change, so the ones of the file must be for future
+// slots. Returns them sorted by slot.
+func LoadRewardOverrides(network string, path string) ([]*RewardOverride, error) {
+	overrides := make([]*RewardOverride, 0)
+
+	rawBytes, err := embeddedRewardOverrides.ReadFile("overrides/" + network + ".json")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.Wrap(err, "could not read embedded reward overrides")
+	}
+	if err == nil {
+		overrides, err = parseRewardOverrides(rawBytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid embedded reward overrides of "+network)
+		}
+	}
+
+	if path == "" {
+		return overrides, nil
+	}
+	rawBytes, err = os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read reward overrides file")
+	}
+	external, err := parseRewardOverrides(rawBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid reward overrides file "+path)
+	}
+
+	bySlot := make(map[uint64]*RewardOverride)
+	for _, override := range overrides {
+		bySlot[override.Slot] = override
+	}
+	for _, override := range external {
+		if _, found := bySlot[override.Slot]; found {
+			log.WithFields(log.Fields{
+				"Slot": override.Slot,
+				"File": path,
+			}).Warn("Reward override of the file replaces the embedded one")
+		}
+		bySlot[override.Slot] = override
+	}
+	merged := make([]*RewardOverride, 0, len(bySlot))
+	for _, override := range bySlot {
+		merged = append(merged, override)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Slot < merged[j].Slot })
+	return merged, nil
+}
+
+func parseRewardOverrides(rawBytes []byte) ([]*RewardOverride, error) {
+	var overrides []*RewardOverride
+	err := json.Unmarshal(rawBytes, &overrides)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal reward overrides")
+	}
+
+	for _, override := range overrides {
+		if override.RewardWei == nil || override.RewardWei.Sign() <= 0 {
+			return nil, errors.New(fmt.Sprintf("reward of slot %d must be positive", override.Slot))
+		}
+		if !common.IsHexAddress(override.Recipient) {
+			return nil, errors.New(fmt.Sprintf("recipient of slot %d is not a valid address: %s",
+				override.Slot, override.Recipient))
+		}
+		if override.JustificationUrl == "" {
+			return nil, errors.New(fmt.Sprintf("reward override of slot %d must have a justification url", override.Slot))
+		}
+	}
+
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Slot < overrides[j].Slot })
+	for i := 1; i < len(overrides); i++ {
+		if overrides[i].Slot == overrides[i-1].Slot {
+			return nil, errors.New(fmt.Sprintf("more than one reward override for slot %d", overrides[i].Slot))
+		}
+	}
+	return overrides, nil
+}
+
+// Returns the reward override of the slot, nil if there is none
+func (s *OracleState) rewardOverrideAt(slot uint64) *RewardOverride {
+	for _, override := range s.RewardOverrides {
+		if override.Slot == slot {
+			return override
+		}
+	}
+	return nil
+}
+
+// Returns the overrides of the slots up to the given one, included
+func rewardOverridesUntil(overrides []*RewardOverride, slot uint64) []*RewardOverride {
+	until := make([]*RewardOverride, 0)
+	for _, override := range overrides {
+		if override.Slot <= slot {
+			until = append(until, override)
+		}
+	}
+	return until
+}
+
+func rewardOverridesEqual(a []*RewardOverride, b []*RewardOverride) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Slot != b[i].Slot || a[i].RewardWei.Cmp(b[i].RewardWei) != 0 ||
+			!strings.EqualFold(a[i].Recipient, b[i].Recipient) || a[i].JustificationUrl != b[i].JustificationUrl {
+			return false
+		}
+	}
+	return true
+}
+
+// Sets the mev reward of the block to the override. If the reward was sent to
+// the pool, the EtherReceived event that was never triggered is added as well,
+// unless it was already added (eg blocks recorded by older versions).
+func (b *FullBlock) applyRewardOverride(override *RewardOverride, poolAddress string) {
+	if b.RewardOverride != nil {
+		return
+	}
+	b.RewardOverride = override
+
+	log.WithFields(log.Fields{
+		"Slot":             override.Slot,
+		"RewardWei":        override.RewardWei,
+		"Recipient":        override.Recipient,
+		"JustificationUrl": override.JustificationUrl,
+	}).Warn("Special case: mev reward overridden")
+
+	if !strings.EqualFold(override.Recipient, poolAddress) {
+		return
+	}
+	for _, event := range b.Events.EtherReceived {
+		if event.Raw.Address == (common.Address{}) && event.DonationAmount.Cmp(override.RewardWei) == 0 {
+			return
+		}
+	}
+	b.Events.EtherReceived = append(b.Events.EtherReceived, &contract.ContractEtherReceived{
+		Sender:         common.Address{},
+		DonationAmount: new(big.Int).Set(override.RewardWei),
+		Raw: types.Log{
+			Address: common.Address{},
+			Topics:  []common.Hash{},
+			Data:    []byte{},
+		},
+	})
+}
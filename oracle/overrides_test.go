@@ -0,0 +1,157 @@
+package oracle
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadRewardOverrides(t *testing.T) {
+	// Known overrides are embedded
+	overrides, err := LoadRewardOverrides("mainnet", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(overrides))
+	require.Equal(t, uint64(10400574), overrides[0].Slot)
+	require.Equal(t, big.NewInt(0).SetUint64(177043568463114308), overrides[0].RewardWei)
+	require.Equal(t, "0xAdFb8D27671F14f297eE94135e266aAFf8752e35", overrides[0].Recipient)
+
+	overrides, err = LoadRewardOverrides("devnet", "")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(overrides))
+
+	// The ones of the file are merged over the known ones
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"slot": 10400574, "reward_wei": 5, "recipient": "0x1000000000000000000000000000000000000000", "justification_url": "https://example.com/1"},
+		{"slot": 100, "reward_wei": 7, "recipient": "0x1000000000000000000000000000000000000000", "justification_url": "https://example.com/2"}
+	]`), 0644))
+	overrides, err = LoadRewardOverrides("mainnet", path)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(overrides))
+	require.Equal(t, uint64(100), overrides[0].Slot)
+	require.Equal(t, uint64(10400574), overrides[1].Slot)
+	require.Equal(t, big.NewInt(5), overrides[1].RewardWei)
+	require.Equal(t, "https://example.com/1", overrides[1].JustificationUrl)
+
+	require.NoError(t, os.WriteFile(path, []byte(`[{"slot": 100, "reward_wei": 0}]`), 0644))
+	_, err = LoadRewardOverrides("mainnet", path)
+	require.Error(t, err)
+
+	_, err = LoadRewardOverrides("mainnet", filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err)
+}
+
+func Test_ParseRewardOverrides(t *testing.T) {
+	// Sorted by slot
+	overrides, err := parseRewardOverrides([]byte(`[
+		{"slot": 200, "reward_wei": 5, "recipient": "0x1000000000000000000000000000000000000000", "justification_url": "https://example.com/2"},
+		{"slot": 100, "reward_wei": 7, "recipient": "0x1000000000000000000000000000000000000000", "justification_url": "https://example.com/1"}
+	]`))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(overrides))
+	require.Equal(t, uint64(100), overrides[0].Slot)
+	require.Equal(t, big.NewInt(7), overrides[0].RewardWei)
+
+	for _, invalid := range []string{
+		`[{"slot": 1, "reward_wei": 5, "recipient": "0x1000000000000000000000000000000000000000", "justification_url": "https://example.com"},
+		  {"slot": 1, "reward_wei": 7, "recipient": "0x1000000000000000000000000000000000000000", "justification_url": "https://example.com"}]`,
+		`[{"slot": 1, "reward_wei": 0, "recipient": "0x1000000000000000000000000000000000000000", "justification_url": "https://example.com"}]`,
+		`[{"slot": 1, "reward_wei": 5, "recipient": "invalid", "justification_url": "https://example.com"}]`,
+		`[{"slot": 1, "reward_wei": 5, "recipient": "0x1000000000000000000000000000000000000000"}]`,
+		`{"slot": 1}`,
+	} {
+		_, err = parseRewardOverrides([]byte(invalid))
+		require.Error(t, err)
+	}
+}
+
+func Test_ApplyRewardOverride(t *testing.T) {
+	cfg := newReplayTestConfig()
+	override := &RewardOverride{
+		Slot:             1001,
+		RewardWei:        big.NewInt(1000),
+		Recipient:        cfg.PoolAddress,
+		JustificationUrl: "https://example.com",
+	}
+
+	block := newReplayTestBlock(1001)
+	block.applyRewardOverride(override, cfg.PoolAddress)
+	reward, isMev, recipient := block.MevRewardInWei()
+	require.Equal(t, big.NewInt(1000), reward)
+	require.True(t, isMev)
+	require.Equal(t, cfg.PoolAddress, recipient)
+
+	// The event that was never triggered is added only once
+	require.Equal(t, 1, len(block.Events.EtherReceived))
+	require.Equal(t, big.NewInt(1000), block.Events.EtherReceived[0].DonationAmount)
+	block.RewardOverride = nil
+	block.applyRewardOverride(override, cfg.PoolAddress)
+	require.Equal(t, 1, len(block.Events.EtherReceived))
+
+	// Rewards sent elsewhere don't trigger any event
+	block = newReplayTestBlock(1001)
+	block.applyRewardOverride(&RewardOverride{
+		Slot:      1001,
+		RewardWei: big.NewInt(1000),
+		Recipient: "0x1000000000000000000000000000000000000000",
+	}, cfg.PoolAddress)
+	require.Equal(t, 0, len(block.Events.EtherReceived))
+}
+
+func Test_RewardOverridesInState(t *testing.T) {
+	cfg := newReplayTestConfig()
+	cfg.RewardOverrides = []*RewardOverride{{
+		Slot:             1001,
+		RewardWei:        big.NewInt(1000),
+		Recipient:        cfg.PoolAddress,
+		JustificationUrl: "https://example.com",
+	}}
+	oracle := NewOracle(cfg)
+
+	// Only the block of the overridden slot is modified
+	block := newReplayTestBlock(1000)
+	_, err := oracle.AdvanceStateToNextSlot(block)
+	require.NoError(t, err)
+	require.Nil(t, block.RewardOverride)
+
+	block = newReplayTestBlock(1001)
+	_, err = oracle.AdvanceStateToNextSlot(block)
+	require.NoError(t, err)
+	require.Equal(t, cfg.RewardOverrides[0], block.RewardOverride)
+
+	// Overrides are part of the state hash
+	require.NoError(t, oracle.hashStateLockFree())
+	hashWithOverrides := oracle.state.StateHash
+	oracle.state.RewardOverrides = nil
+	require.NoError(t, oracle.hashStateLockFree())
+	require.NotEqual(t, hashWithOverrides, oracle.state.StateHash)
+
+	oracle.state.RewardOverrides = cfg.RewardOverrides
+
+	// A loaded state takes new overrides of the config for future slots
+	future := &RewardOverride{
+		Slot:             1002,
+		RewardWei:        big.NewInt(2000),
+		Recipient:        cfg.PoolAddress,
+		JustificationUrl: "https://example.com/future",
+	}
+	cfg.RewardOverrides = []*RewardOverride{cfg.RewardOverrides[0], future}
+	loaded := NewOracle(cfg)
+	_, err = loaded.LoadFromState(oracle.state)
+	require.NoError(t, err)
+	require.Equal(t, cfg.RewardOverrides, loaded.state.RewardOverrides)
+
+	// But overrides of processed slots can't be changed, added or removed
+	for _, overrides := range [][]*RewardOverride{
+		{{Slot: 1001, RewardWei: big.NewInt(1), Recipient: cfg.PoolAddress, JustificationUrl: "https://example.com"}},
+		{{Slot: 1000, RewardWei: big.NewInt(1), Recipient: cfg.PoolAddress, JustificationUrl: "https://example.com"}, oracle.state.RewardOverrides[0]},
+		{future},
+	} {
+		cfg.RewardOverrides = overrides
+		_, err = NewOracle(cfg).LoadFromState(oracle.state)
+		require.ErrorContains(t, err, "reward overrides mismatch")
+	}
+}
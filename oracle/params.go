@@ -0,0 +1,182 @@
+package oracle
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Returns the pool parameters the state is currently using
+func (s *OracleState) currentPoolParams(fromSlot uint64) *PoolParams {
+	var collateral *big.Int
+	if s.CollateralInWei != nil {
+		collateral = new(big.Int).Set(s.CollateralInWei)
+	}
+	return &PoolParams{
+		FromSlot:                 fromSlot,
+		PoolFeesPercentOver10000: s.PoolFeesPercentOver10000,
+		PoolFeesAddress:          s.PoolFeesAddress,
+		CheckPointSizeInSlots:    s.CheckPointSizeInSlots,
+		CheckpointAnchorSlot:     s.checkpointAnchorSlot(),
+		CollateralInWei:          collateral,
+	}
+}
+
+// Returns the pool parameters that were in effect at the given slot
+func (s *OracleState) PoolParamsAt(slot uint64) *PoolParams {
+	for i := len(s.PoolParamsHistory) - 1; i >= 0; i-- {
+		if s.PoolParamsHistory[i].FromSlot <= slot {
+			return s.PoolParamsHistory[i]
+		}
+	}
+	return s.currentPoolParams(s.DeployedSlot)
+}
+
+// Slot from which checkpoints are counted with the current checkpoint size
+func (s *OracleState) checkpointAnchorSlot() uint64 {
+	if s.CheckpointAnchorSlot == 0 {
+		return s.DeployedSlot
+	}
+	return s.CheckpointAnchorSlot
+}
+
+// Returns true if a checkpoint is reached at the given slot, with the current
+// checkpoint size counting from the latest checkpoint before it was changed
+func (s *OracleState) isCheckpointSlot(slot uint64) bool {
+	anchor := s.checkpointAnchorSlot()
+	if slot < anchor {
+		return false
+	}
+	return (slot-anchor)%s.CheckPointSizeInSlots == 0
+}
+
+// Ensures the events that update the pool parameters in the block contain
+// values the oracle can work with. They can differ from the current ones.
+func validatePoolParamsEvents(fullBlock *FullBlock) error {
+	for _, event := range fullBlock.Events.UpdatePoolFee {
+		if event.NewPoolFee == nil || event.NewPoolFee.Sign() < 0 || event.NewPoolFee.Cmp(big.NewInt(100*100)) > 0 {
+			return errors.New(fmt.Sprintf("pool fee must be between 0 and 10000, got: %d", event.NewPoolFee))
+		}
+	}
+
+	for _, event := range fullBlock.Events.PoolFeeRecipient {
+		if event.NewPoolFeeRecipient == (common.Address{}) {
+			return errors.New("pool fee recipient can't be the zero address")
+		}
+	}
+
+	for _, event := range fullBlock.Events.CheckpointSlotSize {
+		if event.NewCheckpointSlotSize == 0 {
+			return errors.New("checkpoint size can't be 0")
+		}
+	}
+
+	for _, event := range fullBlock.Events.UpdateSubscriptionCollateral {
+		if event.NewSubscriptionCollateral == nil || event.NewSubscriptionCollateral.Sign() < 0 {
+			return errors.New(fmt.Sprintf("subscription collateral can't be negative, got: %d",
+				event.NewSubscriptionCollateral))
+		}
+	}
+	return nil
+}
+
+// Applies the changes of the pool parameters in the block, effective from its
+// slot, before anything else in the block is processed. Events are applied in
+// the order they were emitted, so if a parameter changes twice in the same
+// block the latest value is kept. A new version of the parameters is stored
+// in the history only if any value actually changed.
+func (or *Oracle) applyPoolParamsEvents(fullBlock *FullBlock) {
+	slot := or.state.NextSlotToProcess
+	previous := or.state.currentPoolParams(slot)
+
+	for _, event := range fullBlock.Events.UpdatePoolFee {
+		or.state.PoolFeesPercentOver10000 = int(event.NewPoolFee.Int64())
+	}
+
+	for _, event := range fullBlock.Events.PoolFeeRecipient {
+		or.updatePoolFeesAddress(event.NewPoolFeeRecipient.String())
+	}
+
+	for _, event := range fullBlock.Events.CheckpointSlotSize {
+		or.updateCheckpointSize(slot, event.NewCheckpointSlotSize)
+	}
+
+	for _, event := range fullBlock.Events.UpdateSubscriptionCollateral {
+		or.state.CollateralInWei = new(big.Int).Set(event.NewSubscriptionCollateral)
+	}
+
+	current := or.state.currentPoolParams(slot)
+	if current.equals(previous) {
+		return
+	}
+
+	// The parameters the state started with are the first version
+	if len(or.state.PoolParamsHistory) == 0 {
+		initial := *previous
+		initial.FromSlot = or.state.DeployedSlot
+		or.state.PoolParamsHistory = append(or.state.PoolParamsHistory, &initial)
+	}
+	or.state.PoolParamsHistory = append(or.state.PoolParamsHistory, current)
+
+	log.WithFields(log.Fields{
+		"Slot":                     slot,
+		"PoolFeesPercentOver10000": current.PoolFeesPercentOver10000,
+		"PoolFeesAddress":          current.PoolFeesAddress,
+		"CheckPointSizeInSlots":    current.CheckPointSizeInSlots,
+		"CheckpointAnchorSlot":     current.CheckpointAnchorSlot,
+		"CollateralInWei":          current.CollateralInWei,
+	}).Info("Pool parameters updated")
+}
+
+// The fees accumulated so far belong to the former recipient, which keeps them
+// in its own leaf. If the new recipient was a former one, it gets them back.
+func (or *Oracle) updatePoolFeesAddress(newRecipient string) {
+	oldAddress := strings.ToLower(or.state.PoolFeesAddress)
+	newAddress := strings.ToLower(newRecipient)
+	if oldAddress == newAddress {
+		return
+	}
+
+	if or.state.FormerPoolFees == nil {
+		or.state.FormerPoolFees = make(map[string]*big.Int)
+	}
+	formerFees, found := or.state.FormerPoolFees[oldAddress]
+	if !found {
+		formerFees = big.NewInt(0)
+	}
+	or.state.FormerPoolFees[oldAddress] = formerFees.Add(formerFees, or.state.PoolAccumulatedFees)
+
+	newFees := big.NewInt(0)
+	if fees, found := or.state.FormerPoolFees[newAddress]; found {
+		newFees = fees
+		delete(or.state.FormerPoolFees, newAddress)
+	}
+	or.state.PoolAccumulatedFees = newFees
+	or.state.PoolFeesAddress = newRecipient
+}
+
+// The new size counts from the latest checkpoint reached with the old one
+func (or *Oracle) updateCheckpointSize(slot uint64, newSize uint64) {
+	if newSize == or.state.CheckPointSizeInSlots {
+		return
+	}
+	anchor := or.state.checkpointAnchorSlot()
+	if slot > anchor {
+		anchor += ((slot - 1 - anchor) / or.state.CheckPointSizeInSlots) * or.state.CheckPointSizeInSlots
+	}
+	or.state.CheckpointAnchorSlot = anchor
+	or.state.CheckPointSizeInSlots = newSize
+}
+
+func (p *PoolParams) equals(other *PoolParams) bool {
+	return p.PoolFeesPercentOver10000 == other.PoolFeesPercentOver10000 &&
+		strings.EqualFold(p.PoolFeesAddress, other.PoolFeesAddress) &&
+		p.CheckPointSizeInSlots == other.CheckPointSizeInSlots &&
+		p.CheckpointAnchorSlot == other.CheckpointAnchorSlot &&
+		(p.CollateralInWei == nil) == (other.CollateralInWei == nil) &&
+		(p.CollateralInWei == nil || p.CollateralInWei.Cmp(other.CollateralInWei) == 0)
+}
@@ -0,0 +1,151 @@
+package oracle
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PoolParamsChanges(t *testing.T) {
+	cfg := newReplayTestConfig()
+	oracle := NewOracle(cfg)
+	oracle.state.PoolAccumulatedFees = big.NewInt(300)
+
+	advance := func(block *FullBlock) {
+		_, err := oracle.AdvanceStateToNextSlot(block)
+		require.NoError(t, err)
+	}
+
+	for slot := uint64(1000); slot < 1006; slot++ {
+		advance(newReplayTestBlock(slot))
+	}
+	require.True(t, oracle.state.isCheckpointSlot(1004))
+	require.Equal(t, 0, len(oracle.state.PoolParamsHistory))
+
+	// Same values as the current ones, nothing changes
+	block := newReplayTestBlock(1006)
+	block.Events.UpdatePoolFee = append(block.Events.UpdatePoolFee, &contract.ContractUpdatePoolFee{NewPoolFee: big.NewInt(1000)})
+	advance(block)
+	require.Equal(t, 0, len(oracle.state.PoolParamsHistory))
+
+	// All parameters change in the same block
+	newRecipient := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	block = newReplayTestBlock(1007)
+	block.Events.UpdatePoolFee = append(block.Events.UpdatePoolFee,
+		&contract.ContractUpdatePoolFee{NewPoolFee: big.NewInt(200)},
+		&contract.ContractUpdatePoolFee{NewPoolFee: big.NewInt(500)})
+	block.Events.PoolFeeRecipient = append(block.Events.PoolFeeRecipient, &contract.ContractUpdatePoolFeeRecipient{NewPoolFeeRecipient: newRecipient})
+	block.Events.CheckpointSlotSize = append(block.Events.CheckpointSlotSize, &contract.ContractUpdateCheckpointSlotSize{NewCheckpointSlotSize: 10})
+	block.Events.UpdateSubscriptionCollateral = append(block.Events.UpdateSubscriptionCollateral, &contract.ContractUpdateSubscriptionCollateral{NewSubscriptionCollateral: big.NewInt(1)})
+	advance(block)
+
+	require.Equal(t, 500, oracle.state.PoolFeesPercentOver10000)
+	require.Equal(t, newRecipient.String(), oracle.state.PoolFeesAddress)
+	require.Equal(t, uint64(10), oracle.state.CheckPointSizeInSlots)
+	require.Equal(t, big.NewInt(1), oracle.state.CollateralInWei)
+	require.True(t, oracle.isCollateralEnough(big.NewInt(1)))
+
+	// The new checkpoint size counts from the latest checkpoint
+	require.Equal(t, uint64(1004), oracle.state.CheckpointAnchorSlot)
+	require.False(t, oracle.state.isCheckpointSlot(1008))
+	require.True(t, oracle.state.isCheckpointSlot(1014))
+
+	// Fees accumulated so far stay with the former recipient
+	oldAddress := strings.ToLower(cfg.PoolFeesAddress)
+	require.Equal(t, big.NewInt(0), oracle.state.PoolAccumulatedFees)
+	require.Equal(t, big.NewInt(300), oracle.state.FormerPoolFees[oldAddress])
+
+	require.Equal(t, 2, len(oracle.state.PoolParamsHistory))
+	require.Equal(t, cfg.DeployedSlot, oracle.state.PoolParamsHistory[0].FromSlot)
+	require.Equal(t, 1000, oracle.state.PoolParamsAt(1006).PoolFeesPercentOver10000)
+	require.Equal(t, cfg.PoolFeesAddress, oracle.state.PoolParamsAt(1006).PoolFeesAddress)
+	require.Equal(t, 500, oracle.state.PoolParamsAt(1007).PoolFeesPercentOver10000)
+	require.Equal(t, uint64(10), oracle.state.PoolParamsAt(2000).CheckPointSizeInSlots)
+
+	// Back to the former recipient, which gets its fees back
+	oracle.state.PoolAccumulatedFees = big.NewInt(50)
+	block = newReplayTestBlock(1008)
+	block.Events.PoolFeeRecipient = append(block.Events.PoolFeeRecipient,
+		&contract.ContractUpdatePoolFeeRecipient{NewPoolFeeRecipient: common.HexToAddress(cfg.PoolFeesAddress)})
+	advance(block)
+	require.Equal(t, big.NewInt(300), oracle.state.PoolAccumulatedFees)
+	require.Equal(t, big.NewInt(50), oracle.state.FormerPoolFees[strings.ToLower(newRecipient.String())])
+	require.Equal(t, 1, len(oracle.state.FormerPoolFees))
+	require.Equal(t, 3, len(oracle.state.PoolParamsHistory))
+
+	// Inconsistent values are rejected without modifying the state
+	for _, invalid := range []func(*FullBlock){
+		func(b *FullBlock) {
+			b.Events.UpdatePoolFee = append(b.Events.UpdatePoolFee, &contract.ContractUpdatePoolFee{NewPoolFee: big.NewInt(10001)})
+		},
+		func(b *FullBlock) {
+			b.Events.PoolFeeRecipient = append(b.Events.PoolFeeRecipient, &contract.ContractUpdatePoolFeeRecipient{})
+		},
+		func(b *FullBlock) {
+			b.Events.CheckpointSlotSize = append(b.Events.CheckpointSlotSize, &contract.ContractUpdateCheckpointSlotSize{NewCheckpointSlotSize: 0})
+		},
+		func(b *FullBlock) {
+			b.Events.UpdateSubscriptionCollateral = append(b.Events.UpdateSubscriptionCollateral, &contract.ContractUpdateSubscriptionCollateral{NewSubscriptionCollateral: big.NewInt(-1)})
+		},
+	} {
+		block = newReplayTestBlock(1009)
+		invalid(block)
+		_, err := oracle.AdvanceStateToNextSlot(block)
+		require.Error(t, err)
+		require.Equal(t, uint64(1009), oracle.state.NextSlotToProcess)
+		require.Equal(t, 3, len(oracle.state.PoolParamsHistory))
+	}
+
+	// The state is loaded with the parameters the contract was deployed with
+	_, err := NewOracle(cfg).LoadFromState(oracle.state)
+	require.NoError(t, err)
+
+	// But not with the current ones nor any other
+	current := *cfg
+	current.PoolFeesPercentOver10000 = 500
+	current.CheckPointSizeInSlots = 10
+	current.CollateralInWei = big.NewInt(1)
+	_, err = NewOracle(&current).LoadFromState(oracle.state)
+	require.ErrorContains(t, err, "initial pool parameters mismatch")
+
+	other := *cfg
+	other.PoolFeesAddress = newRecipient.String()
+	_, err = NewOracle(&other).LoadFromState(oracle.state)
+	require.ErrorContains(t, err, "initial pool parameters mismatch")
+}
+
+func Test_FormerPoolFeesLeafs(t *testing.T) {
+	oracle := newVerifyTestOracle(t, []uint64{100})
+	state := oracle.state
+	state.LatestProcessedSlot = 150
+	state.NextSlotToProcess = 151
+
+	// The recipient changes to the withdrawal address of a validator
+	block := newReplayTestBlock(151)
+	block.Events.PoolFeeRecipient = append(block.Events.PoolFeeRecipient,
+		&contract.ContractUpdatePoolFeeRecipient{NewPoolFeeRecipient: common.HexToAddress("0x2000000000000000000000000000000000000000")})
+	oracle.applyPoolParamsEvents(block)
+	state.PoolAccumulatedFees = big.NewInt(20)
+	state.LatestProcessedSlot = 200
+	require.True(t, oracle.FreezeCheckpoint())
+
+	// The former recipient keeps its fees in its own leaf
+	leafs := state.CommitedStates[200].Leafs
+	require.Equal(t, big.NewInt(300), leafs["0xfee0000000000000000000000000000000000000"].AccumulatedBalanceWei)
+	require.Equal(t, big.NewInt(20), leafs["0x2000000000000000000000000000000000000000"].AccumulatedBalanceWei)
+	require.Equal(t, big.NewInt(1000), leafs["0x1000000000000000000000000000000000000000"].AccumulatedBalanceWei)
+
+	// Both checkpoints can be recomputed with the parameters of their slot
+	verifications := VerifyCheckpoints(state, nil, nil)
+	require.Equal(t, 2, len(verifications))
+	for _, verification := range verifications {
+		require.Equal(t, CheckpointNotConsolidated, verification.Status)
+	}
+
+	// Fees of the former recipients are liabilities too
+	require.Contains(t, oracle.GetUniqueWithdrawalAddresses(), "0xfee0000000000000000000000000000000000000")
+}
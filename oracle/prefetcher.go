@@ -0,0 +1,131 @@
+package oracle
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Fetches a block at a given slot. Must be safe to be called concurrently
+type FetchBlockFunc func(slot uint64) (*FullBlock, error)
+
+// A block being fetched, done receives it once its ready
+type pendingBlock struct {
+	slot uint64
+	done chan fetchedBlock
+}
+
+type fetchedBlock struct {
+	block *FullBlock
+	err   error
+}
+
+// Fetches the blocks of the slots ahead of the one being processed with a
+// bounded pool of workers, while delivering them strictly in order. At most
+// maxAhead blocks are fetched or waiting to be processed, so a slow consumer
+// stops the fetching. Blocks are never fetched beyond the limit, which is
+// meant to be the latest finalized slot.
+type BlockPrefetcher struct {
+	fetch    FetchBlockFunc
+	workers  chan struct{}
+	queue    chan *pendingBlock
+	nextSlot uint64
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	limit    uint64
+	hasLimit bool
+	stop     bool
+}
+
+// Creates a new prefetcher that fetches blocks from startSlot onwards using
+// at most numWorkers concurrent fetches and keeping at most maxAhead blocks
+func NewBlockPrefetcher(fetch FetchBlockFunc, startSlot uint64, numWorkers int, maxAhead int) *BlockPrefetcher {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	if maxAhead < numWorkers {
+		maxAhead = numWorkers
+	}
+	prefetcher := &BlockPrefetcher{
+		fetch:    fetch,
+		workers:  make(chan struct{}, numWorkers),
+		queue:    make(chan *pendingBlock, maxAhead),
+		nextSlot: startSlot,
+	}
+	prefetcher.cond = sync.NewCond(&prefetcher.mutex)
+	go prefetcher.run()
+	return prefetcher
+}
+
+// Sets the highest slot that can be fetched. It can only increase
+func (p *BlockPrefetcher) SetLimit(slot uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.hasLimit || slot > p.limit {
+		p.limit = slot
+		p.hasLimit = true
+		p.cond.Broadcast()
+	}
+}
+
+// Stops fetching new blocks. Blocks being fetched are finished in background
+func (p *BlockPrefetcher) Stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stop = true
+	p.cond.Broadcast()
+}
+
+// Returns the block at the given slot, waiting until its fetched, or the error
+// fetching it. Slots must be requested in order, starting from the one the
+// prefetcher was created with
+func (p *BlockPrefetcher) Next(slot uint64) (*FullBlock, error) {
+	pending := <-p.queue
+	if pending.slot != slot {
+		return nil, errors.New(fmt.Sprintf("prefetched block slot does not match requested slot: %d vs %d", pending.slot, slot))
+	}
+	fetched := <-pending.done
+	if fetched.err != nil {
+		return nil, errors.Wrap(fetched.err, fmt.Sprintf("could not prefetch block at slot %d", slot))
+	}
+	return fetched.block, nil
+}
+
+func (p *BlockPrefetcher) run() {
+	for {
+		slot := p.nextSlot
+
+		// Wait until the slot can be fetched
+		p.mutex.Lock()
+		for !p.stop && (!p.hasLimit || slot > p.limit) {
+			p.cond.Wait()
+		}
+		stopped := p.stop
+		p.mutex.Unlock()
+		if stopped {
+			return
+		}
+
+		pending := &pendingBlock{
+			slot: slot,
+			done: make(chan fetchedBlock, 1),
+		}
+
+		// Blocks when maxAhead blocks are pending to be processed
+		p.queue <- pending
+
+		// Blocks when all workers are busy
+		p.workers <- struct{}{}
+		go func() {
+			defer func() { <-p.workers }()
+			log.Debug("Prefetching block at slot: ", pending.slot)
+			block, err := p.fetch(pending.slot)
+			pending.done <- fetchedBlock{block, err}
+		}()
+
+		p.nextSlot++
+	}
+}
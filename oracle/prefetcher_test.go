@@ -0,0 +1,138 @@
+package oracle
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BlockPrefetcher_Ordered(t *testing.T) {
+	var running, maxRunning int32
+	fetch := func(slot uint64) (*FullBlock, error) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return &FullBlock{ConsensusDuty: &v1.ProposerDuty{Slot: phase0.Slot(slot)}}, nil
+	}
+
+	prefetcher := NewBlockPrefetcher(fetch, 100, 4, 8)
+	defer prefetcher.Stop()
+	prefetcher.SetLimit(300)
+
+	for slot := uint64(100); slot <= 300; slot++ {
+		block, err := prefetcher.Next(slot)
+		require.NoError(t, err)
+		require.Equal(t, phase0.Slot(slot), block.ConsensusDuty.Slot)
+	}
+	require.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(4))
+}
+
+func Test_BlockPrefetcher_LimitAndBackPressure(t *testing.T) {
+	var mutex sync.Mutex
+	fetched := make(map[uint64]bool)
+	fetch := func(slot uint64) (*FullBlock, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fetched[slot] = true
+		return &FullBlock{ConsensusDuty: &v1.ProposerDuty{Slot: phase0.Slot(slot)}}, nil
+	}
+	numFetched := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(fetched)
+	}
+
+	prefetcher := NewBlockPrefetcher(fetch, 10, 2, 5)
+	defer prefetcher.Stop()
+
+	// Nothing is fetched without a limit
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, 0, numFetched())
+
+	// Nothing beyond the limit is fetched
+	prefetcher.SetLimit(12)
+	require.Eventually(t, func() bool { return numFetched() == 3 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, 3, numFetched())
+
+	// The limit can't go back
+	prefetcher.SetLimit(5)
+
+	// At most maxAhead blocks plus the one waiting to be queued are fetched
+	// if no one consumes them
+	prefetcher.SetLimit(1000)
+	time.Sleep(50 * time.Millisecond)
+	require.LessOrEqual(t, numFetched(), 5+2)
+
+	for slot := uint64(10); slot < 40; slot++ {
+		block, err := prefetcher.Next(slot)
+		require.NoError(t, err)
+		require.Equal(t, phase0.Slot(slot), block.ConsensusDuty.Slot)
+	}
+}
+
+func Test_BlockPrefetcher_Error(t *testing.T) {
+	fetch := func(slot uint64) (*FullBlock, error) {
+		if slot == 12 {
+			return nil, errors.New("node unavailable")
+		}
+		return &FullBlock{ConsensusDuty: &v1.ProposerDuty{Slot: phase0.Slot(slot)}}, nil
+	}
+
+	prefetcher := NewBlockPrefetcher(fetch, 10, 2, 5)
+	defer prefetcher.Stop()
+	prefetcher.SetLimit(20)
+
+	for slot := uint64(10); slot < 12; slot++ {
+		_, err := prefetcher.Next(slot)
+		require.NoError(t, err)
+	}
+
+	// Returned to the caller instead of exiting from the worker
+	_, err := prefetcher.Next(12)
+	require.ErrorContains(t, err, "node unavailable")
+
+	// Requested out of order
+	_, err = prefetcher.Next(20)
+	require.Error(t, err)
+}
+
+func Test_EpochDutiesCache(t *testing.T) {
+	cache := newEpochDutiesCache(2)
+	duties := func(epoch uint64) []*v1.ProposerDuty {
+		return []*v1.ProposerDuty{{Slot: phase0.Slot(epoch * 32)}}
+	}
+
+	// Workers fetching two epochs concurrently don't evict each other
+	cache.add(10, duties(10))
+	cache.add(11, duties(11))
+	cached, found := cache.get(10)
+	require.True(t, found)
+	require.Equal(t, duties(10), cached)
+	_, found = cache.get(11)
+	require.True(t, found)
+
+	// The least recently used is evicted
+	_, found = cache.get(10)
+	require.True(t, found)
+	cache.add(12, duties(12))
+	_, found = cache.get(11)
+	require.False(t, found)
+	_, found = cache.get(10)
+	require.True(t, found)
+	_, found = cache.get(12)
+	require.True(t, found)
+}
@@ -0,0 +1,102 @@
+package oracle
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	v1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/avast/retry-go/v4"
+	"github.com/dappnode/mev-sp-oracle/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Persists every processed block, the validators used in the validator cleanup
+// and the config, as gzip compressed json files with the same names the replay reads.
+// A folder recorded while syncing can be replayed later on with DirBlockSource.
+type BlockRecorder struct {
+	dir string
+}
+
+func NewBlockRecorder(dir string) (*BlockRecorder, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create record folder "+dir)
+	}
+	return &BlockRecorder{
+		dir: dir,
+	}, nil
+}
+
+// Records the block as fullblock_slot_<slot>_chainid_<id>[_withheaders].json.gz
+func (r *BlockRecorder) Record(fullBlock *FullBlock) error {
+	headers := ""
+	if fullBlock.ExecutionHeader != nil {
+		headers = recordedHeadersTag
+	}
+	name := fmt.Sprintf("fullblock_slot_%d_chainid_%d%s.json.gz",
+		uint64(fullBlock.ConsensusDuty.Slot), fullBlock.ChainId, headers)
+	return r.write(name, fullBlock)
+}
+
+// Records the validators as validators_slot_<slot>.json.gz
+func (r *BlockRecorder) RecordValidators(slot uint64, validators map[phase0.ValidatorIndex]*v1.Validator) error {
+	return r.write(fmt.Sprintf("validators_slot_%d.json.gz", slot), validators)
+}
+
+// Records the config the oracle was started with as oracle_config.json.gz, so
+// that the blocks can be replayed from the deployment of the pool without a
+// state. Endpoints are not recorded, since they may contain credentials
+func (r *BlockRecorder) RecordConfig(cfg *Config) error {
+	recorded := *cfg
+	recorded.ConsensusEndpoint = ""
+	recorded.ExecutionEndpoint = ""
+	return r.write("oracle_config.json.gz", &recorded)
+}
+
+// Returns a function that records the validators returned by getSetOfValidators,
+// so that the validator cleanup can be replayed without a beacon node
+func (r *BlockRecorder) ValidatorsFunc(getSetOfValidators GetSetOfValidatorsFunc) GetSetOfValidatorsFunc {
+	return func(valIndices []phase0.ValidatorIndex, slot string, opts ...retry.Option) (map[phase0.ValidatorIndex]*v1.Validator, error) {
+		validators, err := getSetOfValidators(valIndices, slot, opts...)
+		if err != nil {
+			return nil, err
+		}
+		slotUint, err := strconv.ParseUint(slot, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid slot "+slot)
+		}
+		err = r.RecordValidators(slotUint, validators)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not record validators")
+		}
+		return validators, nil
+	}
+}
+
+func (r *BlockRecorder) write(name string, value interface{}) error {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	err := json.NewEncoder(gzipWriter).Encode(value)
+	if err != nil {
+		return errors.Wrap(err, "could not encode "+name)
+	}
+	err = gzipWriter.Close()
+	if err != nil {
+		return errors.Wrap(err, "could not compress "+name)
+	}
+
+	path := filepath.Join(r.dir, name)
+	err = utils.WriteFileAtomic(path, buffer.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not write "+path)
+	}
+	log.Trace("Recorded ", path)
+	return nil
+}
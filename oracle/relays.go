@@ -0,0 +1,320 @@
+package oracle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dappnode/mev-sp-oracle/metrics"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of discrepancies between what a relay reports it delivered and what
+// the oracle computed from the block
+const (
+	DiscrepancyBlockHash    = "block_hash"
+	DiscrepancyRewardType   = "reward_type"
+	DiscrepancyValue        = "value"
+	DiscrepancyFeeRecipient = "fee_recipient"
+)
+
+// Payload that a relay reports as delivered to the proposer of a slot, as
+// returned by /relay/v1/data/bidtraces/proposer_payload_delivered
+type RelayBidTrace struct {
+	Relay                string   `json:"relay"`
+	Slot                 uint64   `json:"slot"`
+	BlockHash            string   `json:"block_hash"`
+	BuilderPubkey        string   `json:"builder_pubkey"`
+	ProposerPubkey       string   `json:"proposer_pubkey"`
+	ProposerFeeRecipient string   `json:"proposer_fee_recipient"`
+	Value                *big.Int `json:"value"`
+}
+
+// Bid trace as served by the relay data api, with all fields as strings
+type relayBidTraceResp struct {
+	Slot                 string `json:"slot"`
+	BlockHash            string `json:"block_hash"`
+	BuilderPubkey        string `json:"builder_pubkey"`
+	ProposerPubkey       string `json:"proposer_pubkey"`
+	ProposerFeeRecipient string `json:"proposer_fee_recipient"`
+	Value                string `json:"value"`
+}
+
+// Mismatch between a payload delivered by a relay and the reward the oracle
+// computed for the same slot
+type RewardDiscrepancy struct {
+	Slot               uint64   `json:"slot"`
+	ValidatorIndex     uint64   `json:"validator_index"`
+	Relay              string   `json:"relay"`
+	Kind               string   `json:"kind"`
+	RelayBlockHash     string   `json:"relay_block_hash"`
+	RelayValueWei      *big.Int `json:"relay_value_wei"`
+	OracleRewardWei    *big.Int `json:"oracle_reward_wei"`
+	RelayFeeRecipient  string   `json:"relay_fee_recipient"`
+	OracleFeeRecipient string   `json:"oracle_fee_recipient"`
+}
+
+// Most recent discrepancies that are kept in the state, older ones are dropped
+var MaxRewardDiscrepancies = 1000
+
+// Blocks that can wait to be checked, while the queue is full new ones are skipped
+const relayCheckQueueSize = 256
+
+// Blocks further than this from the finalized slot are being caught up with,
+// so they are not checked. Relays would be queried for thousands of old slots
+const relayCheckMaxDistance = 64
+
+// What the oracle computed for a block, to be cross-checked with the relays
+type relayCheck struct {
+	summarizedBlock    SummarizedBlock
+	blockHash          string
+	oracleFeeRecipient string
+}
+
+// Queries the data api of the relays for the payloads they delivered, so that
+// the rewards the oracle computes can be cross-checked. Blocks are checked in
+// the background, so relays never slow down nor stop the oracle, and a relay
+// that can't be reached is skipped. Blocks processed while catching up with the
+// finalized slot are not checked.
+type RelayChecker struct {
+	endpoints []string
+	client    *http.Client
+	retryOpts []retry.Option
+	queue     chan *relayCheck
+
+	finalizedSlot atomic.Uint64
+	skipped       int
+	dropped       int
+}
+
+func NewRelayChecker(endpoints []string, retryOpts ...retry.Option) *RelayChecker {
+	return &RelayChecker{
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		retryOpts: retryOpts,
+		queue:     make(chan *relayCheck, relayCheckQueueSize),
+	}
+}
+
+// Sets the latest finalized slot, to know if the processed blocks are being
+// caught up with. Until set, all blocks are checked
+func (r *RelayChecker) SetFinalizedSlot(slot uint64) {
+	r.finalizedSlot.Store(slot)
+}
+
+// Checks the queued blocks in the background, calling found with the
+// discrepancies of every block that has any
+func (r *RelayChecker) Start(found func([]*RewardDiscrepancy)) {
+	go func() {
+		for check := range r.queue {
+			payloads := r.GetDeliveredPayloads(check.summarizedBlock.Slot)
+			discrepancies := check.discrepancies(payloads)
+			if len(discrepancies) == 0 {
+				continue
+			}
+			reportDiscrepancies(discrepancies)
+			found(discrepancies)
+		}
+	}()
+}
+
+// Queues the block to be checked, never blocking the caller. Blocks that are
+// being caught up with or that don't fit in the queue are skipped, and only
+// counted once the checker is back to checking them
+func (r *RelayChecker) check(check *relayCheck) {
+	slot := check.summarizedBlock.Slot
+	if r.finalizedSlot.Load() > slot+relayCheckMaxDistance {
+		r.skipped++
+		log.WithFields(log.Fields{
+			"Slot":          slot,
+			"FinalizedSlot": r.finalizedSlot.Load(),
+		}).Debug("Catching up with the finalized slot, not checking the block with the relays")
+		return
+	}
+
+	select {
+	case r.queue <- check:
+	default:
+		r.dropped++
+		log.WithFields(log.Fields{
+			"Slot":      slot,
+			"QueueSize": relayCheckQueueSize,
+		}).Debug("Too many blocks waiting to be checked with the relays, skipping it")
+		return
+	}
+
+	if r.skipped > 0 || r.dropped > 0 {
+		log.WithFields(log.Fields{
+			"CatchingUp": r.skipped,
+			"QueueFull":  r.dropped,
+		}).Info("Some blocks were not checked with the relays")
+		r.skipped = 0
+		r.dropped = 0
+	}
+}
+
+func reportDiscrepancies(discrepancies []*RewardDiscrepancy) {
+	for _, discrepancy := range discrepancies {
+		metrics.RelayRewardDiscrepancies.WithLabelValues(discrepancy.Relay, discrepancy.Kind).Inc()
+		log.WithFields(log.Fields{
+			"Slot":               discrepancy.Slot,
+			"ValidatorIndex":     discrepancy.ValidatorIndex,
+			"Relay":              discrepancy.Relay,
+			"Kind":               discrepancy.Kind,
+			"RelayBlockHash":     discrepancy.RelayBlockHash,
+			"RelayValueWei":      discrepancy.RelayValueWei,
+			"OracleRewardWei":    discrepancy.OracleRewardWei,
+			"RelayFeeRecipient":  discrepancy.RelayFeeRecipient,
+			"OracleFeeRecipient": discrepancy.OracleFeeRecipient,
+		}).Warn("Reward does not match the payload delivered by the relay")
+	}
+}
+
+// Returns the payloads that the relays delivered for the slot, from all the
+// relays that could be reached
+func (r *RelayChecker) GetDeliveredPayloads(slot uint64) []*RelayBidTrace {
+	payloads := make([]*RelayBidTrace, 0)
+	for _, endpoint := range r.endpoints {
+		var relayPayloads []*RelayBidTrace
+		err := retry.Do(func() error {
+			var err error
+			relayPayloads, err = r.getDeliveredPayloads(endpoint, slot)
+			return err
+		}, r.retryOpts...)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Slot":  slot,
+				"Relay": relayName(endpoint),
+				"Error": err.Error(),
+			}).Warn("Could not get the delivered payloads from relay, skipping it")
+			continue
+		}
+		payloads = append(payloads, relayPayloads...)
+	}
+	return payloads
+}
+
+func (r *RelayChecker) getDeliveredPayloads(endpoint string, slot uint64) ([]*RelayBidTrace, error) {
+	payloadsUrl := fmt.Sprintf("%s/relay/v1/data/bidtraces/proposer_payload_delivered?slot=%d",
+		strings.TrimSuffix(endpoint, "/"), slot)
+	resp, err := r.client.Get(payloadsUrl)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not call relay")
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read relay response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("relay returned status %d: %s", resp.StatusCode, string(bodyBytes)))
+	}
+
+	var traces []*relayBidTraceResp
+	err = json.Unmarshal(bodyBytes, &traces)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal relay response")
+	}
+
+	payloads := make([]*RelayBidTrace, 0)
+	for _, trace := range traces {
+		traceSlot, err := strconv.ParseUint(trace.Slot, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid slot in relay response: "+trace.Slot)
+		}
+		// Relays may ignore the filter and return the latest payloads
+		if traceSlot != slot {
+			continue
+		}
+		value, ok := new(big.Int).SetString(trace.Value, 10)
+		if !ok {
+			return nil, errors.New("invalid value in relay response: " + trace.Value)
+		}
+		payloads = append(payloads, &RelayBidTrace{
+			Relay:                relayName(endpoint),
+			Slot:                 traceSlot,
+			BlockHash:            trace.BlockHash,
+			BuilderPubkey:        trace.BuilderPubkey,
+			ProposerPubkey:       trace.ProposerPubkey,
+			ProposerFeeRecipient: trace.ProposerFeeRecipient,
+			Value:                value,
+		})
+	}
+	return payloads, nil
+}
+
+// Relay endpoints may contain the relay pubkey as user, so only the host is
+// used to identify them
+func relayName(endpoint string) string {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" {
+		return endpoint
+	}
+	return parsed.Host
+}
+
+// Takes what the oracle computed for the block, so that it can be checked once
+// the block is processed without accessing it anymore
+func (b *FullBlock) newRelayCheck(summarizedBlock SummarizedBlock) *relayCheck {
+	oracleFeeRecipient := strings.ToLower(b.GetFeeRecipient())
+	if summarizedBlock.RewardType == MevBlock {
+		_, _, oracleFeeRecipient = b.MevRewardInWei()
+	}
+	return &relayCheck{
+		summarizedBlock:    summarizedBlock,
+		blockHash:          b.GetBlockHash(),
+		oracleFeeRecipient: oracleFeeRecipient,
+	}
+}
+
+// Compares the payloads delivered by the relays with the reward computed for
+// the block. A payload whose block was not the proposed one, a vanila reward
+// where a relay delivered a payload, or a different value or fee recipient are
+// reported.
+func (c *relayCheck) discrepancies(payloads []*RelayBidTrace) []*RewardDiscrepancy {
+	discrepancies := make([]*RewardDiscrepancy, 0)
+	summarizedBlock := c.summarizedBlock
+	oracleFeeRecipient := c.oracleFeeRecipient
+
+	for _, payload := range payloads {
+		newDiscrepancy := func(kind string) *RewardDiscrepancy {
+			return &RewardDiscrepancy{
+				Slot:               summarizedBlock.Slot,
+				ValidatorIndex:     summarizedBlock.ValidatorIndex,
+				Relay:              payload.Relay,
+				Kind:               kind,
+				RelayBlockHash:     payload.BlockHash,
+				RelayValueWei:      payload.Value,
+				OracleRewardWei:    summarizedBlock.Reward,
+				RelayFeeRecipient:  payload.ProposerFeeRecipient,
+				OracleFeeRecipient: oracleFeeRecipient,
+			}
+		}
+
+		if !strings.EqualFold(payload.BlockHash, c.blockHash) {
+			discrepancies = append(discrepancies, newDiscrepancy(DiscrepancyBlockHash))
+			continue
+		}
+		if summarizedBlock.RewardType != MevBlock {
+			discrepancies = append(discrepancies, newDiscrepancy(DiscrepancyRewardType))
+			continue
+		}
+		if payload.Value.Cmp(summarizedBlock.Reward) != 0 {
+			discrepancies = append(discrepancies, newDiscrepancy(DiscrepancyValue))
+		}
+		if !strings.EqualFold(payload.ProposerFeeRecipient, oracleFeeRecipient) {
+			discrepancies = append(discrepancies, newDiscrepancy(DiscrepancyFeeRecipient))
+		}
+	}
+	return discrepancies
+}
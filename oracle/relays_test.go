@@ -0,0 +1,168 @@
+package oracle
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+var relayTestBlockHash = "0x0000000000000000000000000000000000000000000000000000000000000000"
+
+func newRelayTestServer(t *testing.T, response string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "/relay/v1/data/bidtraces/proposer_payload_delivered", req.URL.Path)
+		require.Equal(t, "1001", req.URL.Query().Get("slot"))
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_RelayChecker_GetDeliveredPayloads(t *testing.T) {
+	relay := newRelayTestServer(t, fmt.Sprintf(`[
+		{"slot": "1001", "block_hash": "%s", "builder_pubkey": "0xaa", "proposer_pubkey": "0xbb",
+		 "proposer_fee_recipient": "%s", "value": "177043568463114308"},
+		{"slot": "1000", "block_hash": "%s", "value": "5"}
+	]`, relayTestBlockHash, tracerTestPool, relayTestBlockHash))
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	invalid := newRelayTestServer(t, `[{"slot": "1001", "value": "not a number"}]`)
+
+	checker := NewRelayChecker([]string{failing.URL, relay.URL + "/", invalid.URL}, retry.Attempts(1))
+	payloads := checker.GetDeliveredPayloads(1001)
+
+	// Failing relays are skipped, and payloads of other slots ignored
+	require.Equal(t, 1, len(payloads))
+	require.Equal(t, strings.TrimPrefix(relay.URL, "http://"), payloads[0].Relay)
+	require.Equal(t, uint64(1001), payloads[0].Slot)
+	require.Equal(t, relayTestBlockHash, payloads[0].BlockHash)
+	require.Equal(t, tracerTestPool, payloads[0].ProposerFeeRecipient)
+	require.Equal(t, tracerTestReward, payloads[0].Value)
+}
+
+func Test_RelayName(t *testing.T) {
+	require.Equal(t, "boost-relay.flashbots.net",
+		relayName("https://0xac6e77dfe25ecd6110b8e780608cce0dab71fdd5ebea22a16c0205200f2f8e2e3ad3b71d3499c54ad14d6c21b41a37ae@boost-relay.flashbots.net"))
+	require.Equal(t, "relay.example.com", relayName("https://relay.example.com/"))
+	require.Equal(t, "invalid", relayName("invalid"))
+}
+
+func Test_RelayRewardDiscrepancies(t *testing.T) {
+	payloadResponse := func(blockHash string, feeRecipient string, value *big.Int) string {
+		return fmt.Sprintf(`[{"slot": "1001", "block_hash": "%s", "proposer_fee_recipient": "%s", "value": "%s"}]`,
+			blockHash, feeRecipient, value.String())
+	}
+	// Matches the block
+	matching := newRelayTestServer(t, payloadResponse(relayTestBlockHash, tracerTestPool, tracerTestReward))
+	// Different value and fee recipient
+	different := newRelayTestServer(t, payloadResponse(relayTestBlockHash, "0x1000000000000000000000000000000000000000", big.NewInt(5)))
+	// Different block
+	otherBlock := newRelayTestServer(t, payloadResponse("0x01", tracerTestPool, tracerTestReward))
+
+	checker := NewRelayChecker([]string{matching.URL, different.URL, otherBlock.URL}, retry.Attempts(1))
+	oracle := NewOracle(newReplayTestConfig())
+	oracle.SetRelayChecker(checker)
+	oracle.state.NextSlotToProcess = 1001
+	oracle.state.LatestProcessedSlot = 1000
+
+	block := newTracerTestBlock(t, common.HexToAddress(tracerTestPool), tracerTestReward)
+	block.Events.EtherReceived = append(block.Events.EtherReceived,
+		&contract.ContractEtherReceived{
+			DonationAmount: tracerTestReward,
+			Raw:            types.Log{Address: common.HexToAddress(tracerTestPool)},
+		})
+	_, err := oracle.AdvanceStateToNextSlot(block)
+	require.NoError(t, err)
+
+	// Relays are checked in the background
+	require.Eventually(t, func() bool { return len(oracle.RewardDiscrepancies()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	discrepancies := oracle.RewardDiscrepancies()
+	require.Equal(t, strings.TrimPrefix(different.URL, "http://"), discrepancies[0].Relay)
+	require.Equal(t, DiscrepancyValue, discrepancies[0].Kind)
+	require.Equal(t, big.NewInt(5), discrepancies[0].RelayValueWei)
+	require.Equal(t, tracerTestReward, discrepancies[0].OracleRewardWei)
+	require.Equal(t, DiscrepancyFeeRecipient, discrepancies[1].Kind)
+	require.Equal(t, strings.ToLower(tracerTestPool), discrepancies[1].OracleFeeRecipient)
+	require.Equal(t, strings.TrimPrefix(otherBlock.URL, "http://"), discrepancies[2].Relay)
+	require.Equal(t, DiscrepancyBlockHash, discrepancies[2].Kind)
+	require.Equal(t, uint64(1001), discrepancies[2].Slot)
+	require.Equal(t, uint64(12), discrepancies[2].ValidatorIndex)
+
+	// They are kept in the state, so they are persisted with it
+	jsonData, err := json.Marshal(oracle.state)
+	require.NoError(t, err)
+	var recovered struct {
+		RewardDiscrepancies []*RewardDiscrepancy `json:"reward_discrepancies"`
+	}
+	require.NoError(t, json.Unmarshal(jsonData, &recovered))
+	require.Equal(t, discrepancies, recovered.RewardDiscrepancies)
+
+	// A payload delivered for a block without mev reward
+	block = newTracerTestBlock(t, common.HexToAddress(tracerTestPool), tracerTestReward)
+	block.ConsensusBlock.Capella.Message.Body.ExecutionPayload.Transactions = nil
+	check := block.newRelayCheck(SummarizedBlock{RewardType: VanilaBlock, Reward: big.NewInt(0)})
+	require.Equal(t, 0, len(check.discrepancies(nil)))
+	discrepancies = check.discrepancies([]*RelayBidTrace{
+		{Relay: "a", Slot: 1001, BlockHash: relayTestBlockHash, ProposerFeeRecipient: tracerTestPool, Value: tracerTestReward},
+	})
+	require.Equal(t, 1, len(discrepancies))
+	require.Equal(t, DiscrepancyRewardType, discrepancies[0].Kind)
+}
+
+func Test_RelayChecker_MaxDiscrepancies(t *testing.T) {
+	defaultMax := MaxRewardDiscrepancies
+	defer func() { MaxRewardDiscrepancies = defaultMax }()
+	MaxRewardDiscrepancies = 2
+
+	oracle := NewOracle(newReplayTestConfig())
+	for slot := uint64(1); slot <= 3; slot++ {
+		oracle.addRewardDiscrepancies([]*RewardDiscrepancy{{Slot: slot, Kind: DiscrepancyValue}})
+	}
+
+	// Only the latest ones are kept
+	discrepancies := oracle.RewardDiscrepancies()
+	require.Equal(t, 2, len(discrepancies))
+	require.Equal(t, uint64(2), discrepancies[0].Slot)
+	require.Equal(t, uint64(3), discrepancies[1].Slot)
+}
+
+func Test_RelayChecker_SkippedBlocks(t *testing.T) {
+	// Not started, so the queued blocks are not consumed
+	checker := NewRelayChecker(nil)
+	newCheck := func(slot uint64) *relayCheck {
+		return &relayCheck{summarizedBlock: SummarizedBlock{Slot: slot}}
+	}
+
+	// Blocks far from the finalized slot are being caught up with
+	checker.SetFinalizedSlot(1000)
+	checker.check(newCheck(100))
+	require.Equal(t, 0, len(checker.queue))
+	require.Equal(t, 1, checker.skipped)
+
+	// Close to it they are queued, and the skipped ones are reported
+	checker.check(newCheck(1000 - relayCheckMaxDistance))
+	require.Equal(t, 1, len(checker.queue))
+	require.Equal(t, 0, checker.skipped)
+
+	// Without blocking when the queue is full
+	for slot := uint64(1); slot < relayCheckQueueSize+10; slot++ {
+		checker.check(newCheck(1000 + slot))
+	}
+	require.Equal(t, relayCheckQueueSize, len(checker.queue))
+	require.Equal(t, 10, checker.dropped)
+}
@@ -0,0 +1,448 @@
+package oracle
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/avast/retry-go/v4"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Recorded blocks are stored as fullblock_slot_<slot>[_anything].json, the
+// validators used in the cleanup as validators_slot_<slot>.json and the config
+// the oracle was started with as oracle_config.json, optionally gzip compressed
+// with a .gz extension. If a block was recorded both with and without execution
+// headers, the one with headers is used.
+var (
+	recordedBlockRegex      = regexp.MustCompile(`^fullblock_slot_(\d+)[^/]*\.json(\.gz)?$`)
+	recordedValidatorsRegex = regexp.MustCompile(`^validators_slot_(\d+)[^/]*\.json(\.gz)?$`)
+	recordedConfigRegex     = regexp.MustCompile(`^oracle_config\.json(\.gz)?$`)
+	recordedHeadersTag      = "_withheaders"
+)
+
+// Source of recorded blocks to replay the oracle state without any endpoint
+type BlockSource interface {
+	// Returns the recorded block at the given slot, false if there is none
+	Block(slot uint64) (*FullBlock, bool, error)
+
+	// Returns the validators recorded at the given slot, false if there are none
+	Validators(slot uint64) (map[phase0.ValidatorIndex]*v1.Validator, bool, error)
+
+	// Returns the config the oracle was started with, false if it wasn't recorded
+	Config() (*Config, bool, error)
+
+	// Returns all the recorded block slots, sorted
+	Slots() []uint64
+
+	Close() error
+}
+
+// Opens a folder or a zip archive of recorded blocks
+func NewBlockSource(path string) (BlockSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open block source")
+	}
+	if info.IsDir() {
+		return NewDirBlockSource(path)
+	}
+	if strings.HasSuffix(path, ".zip") {
+		return NewZipBlockSource(path)
+	}
+	return nil, errors.New("block source must be a folder or a .zip archive: " + path)
+}
+
+// Index of the recorded files, by slot
+type recordedIndex struct {
+	blocks     map[uint64]string
+	validators map[uint64]string
+	config     string
+}
+
+func newRecordedIndex() *recordedIndex {
+	return &recordedIndex{
+		blocks:     make(map[uint64]string),
+		validators: make(map[uint64]string),
+	}
+}
+
+// Adds the file to the index if it matches the recorded names
+func (i *recordedIndex) add(name string) error {
+	base := filepath.Base(name)
+	if recordedConfigRegex.MatchString(base) {
+		if i.config != "" {
+			return errors.New(fmt.Sprintf("config recorded twice: %s and %s", i.config, name))
+		}
+		i.config = name
+		return nil
+	}
+	for _, entry := range []struct {
+		regex *regexp.Regexp
+		index map[uint64]string
+	}{
+		{recordedBlockRegex, i.blocks},
+		{recordedValidatorsRegex, i.validators},
+	} {
+		match := entry.regex.FindStringSubmatch(base)
+		if match == nil {
+			continue
+		}
+		slot, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "invalid slot in file name "+base)
+		}
+		if existing, found := entry.index[slot]; found {
+			existingHeaders := strings.Contains(filepath.Base(existing), recordedHeadersTag)
+			newHeaders := strings.Contains(base, recordedHeadersTag)
+			if existingHeaders == newHeaders {
+				return errors.New(fmt.Sprintf("slot %d recorded twice: %s and %s", slot, existing, name))
+			}
+			if existingHeaders {
+				continue
+			}
+		}
+		entry.index[slot] = name
+	}
+	return nil
+}
+
+func (i *recordedIndex) slots() []uint64 {
+	slots := make([]uint64, 0, len(i.blocks))
+	for slot := range i.blocks {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(a, b int) bool { return slots[a] < slots[b] })
+	return slots
+}
+
+// Decodes a recorded json file, decompressing it if needed
+func decodeRecorded(name string, reader io.Reader, value interface{}) error {
+	if strings.HasSuffix(name, ".gz") {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return errors.Wrap(err, "could not decompress "+name)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+	err := json.NewDecoder(reader).Decode(value)
+	if err != nil {
+		return errors.Wrap(err, "could not decode "+name)
+	}
+	return nil
+}
+
+// Recorded blocks in a folder
+type DirBlockSource struct {
+	dir   string
+	index *recordedIndex
+}
+
+func NewDirBlockSource(dir string) (*DirBlockSource, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read folder "+dir)
+	}
+	index := newRecordedIndex()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		err := index.add(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &DirBlockSource{
+		dir:   dir,
+		index: index,
+	}, nil
+}
+
+func (s *DirBlockSource) decode(name string, value interface{}) error {
+	file, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return errors.Wrap(err, "could not open "+name)
+	}
+	defer file.Close()
+	return decodeRecorded(name, file, value)
+}
+
+func (s *DirBlockSource) Block(slot uint64) (*FullBlock, bool, error) {
+	name, found := s.index.blocks[slot]
+	if !found {
+		return nil, false, nil
+	}
+	var fullBlock FullBlock
+	err := s.decode(name, &fullBlock)
+	if err != nil {
+		return nil, false, err
+	}
+	return &fullBlock, true, nil
+}
+
+func (s *DirBlockSource) Validators(slot uint64) (map[phase0.ValidatorIndex]*v1.Validator, bool, error) {
+	name, found := s.index.validators[slot]
+	if !found {
+		return nil, false, nil
+	}
+	var validators map[phase0.ValidatorIndex]*v1.Validator
+	err := s.decode(name, &validators)
+	if err != nil {
+		return nil, false, err
+	}
+	return validators, true, nil
+}
+
+func (s *DirBlockSource) Config() (*Config, bool, error) {
+	return recordedConfig(s.index, s.decode)
+}
+
+func (s *DirBlockSource) Slots() []uint64 {
+	return s.index.slots()
+}
+
+func (s *DirBlockSource) Close() error {
+	return nil
+}
+
+// Recorded blocks in a zip archive
+type ZipBlockSource struct {
+	reader *zip.ReadCloser
+	files  map[string]*zip.File
+	index  *recordedIndex
+}
+
+func NewZipBlockSource(path string) (*ZipBlockSource, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open archive "+path)
+	}
+	index := newRecordedIndex()
+	files := make(map[string]*zip.File)
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		err := index.add(file.Name)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		files[file.Name] = file
+	}
+	return &ZipBlockSource{
+		reader: reader,
+		files:  files,
+		index:  index,
+	}, nil
+}
+
+func (s *ZipBlockSource) decode(name string, value interface{}) error {
+	file, err := s.files[name].Open()
+	if err != nil {
+		return errors.Wrap(err, "could not open "+name)
+	}
+	defer file.Close()
+	return decodeRecorded(name, file, value)
+}
+
+func (s *ZipBlockSource) Block(slot uint64) (*FullBlock, bool, error) {
+	name, found := s.index.blocks[slot]
+	if !found {
+		return nil, false, nil
+	}
+	var fullBlock FullBlock
+	err := s.decode(name, &fullBlock)
+	if err != nil {
+		return nil, false, err
+	}
+	return &fullBlock, true, nil
+}
+
+func (s *ZipBlockSource) Validators(slot uint64) (map[phase0.ValidatorIndex]*v1.Validator, bool, error) {
+	name, found := s.index.validators[slot]
+	if !found {
+		return nil, false, nil
+	}
+	var validators map[phase0.ValidatorIndex]*v1.Validator
+	err := s.decode(name, &validators)
+	if err != nil {
+		return nil, false, err
+	}
+	return validators, true, nil
+}
+
+func (s *ZipBlockSource) Config() (*Config, bool, error) {
+	return recordedConfig(s.index, s.decode)
+}
+
+func (s *ZipBlockSource) Slots() []uint64 {
+	return s.index.slots()
+}
+
+func (s *ZipBlockSource) Close() error {
+	return s.reader.Close()
+}
+
+func recordedConfig(index *recordedIndex, decode func(name string, value interface{}) error) (*Config, bool, error) {
+	if index.config == "" {
+		return nil, false, nil
+	}
+	var cfg Config
+	err := decode(index.config, &cfg)
+	if err != nil {
+		return nil, false, err
+	}
+	cfg.DryRun = true
+	return &cfg, true, nil
+}
+
+// Returns a function to get the validators from the recorded ones, to be used
+// in the validator cleanup instead of the beacon node
+func RecordedValidatorsFunc(source BlockSource) GetSetOfValidatorsFunc {
+	return func(valIndices []phase0.ValidatorIndex, slot string, opts ...retry.Option) (map[phase0.ValidatorIndex]*v1.Validator, error) {
+		slotUint, err := strconv.ParseUint(slot, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid slot "+slot)
+		}
+		recorded, found, err := source.Validators(slotUint)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, errors.New("no validators were recorded at slot " + slot)
+		}
+		validators := make(map[phase0.ValidatorIndex]*v1.Validator, len(valIndices))
+		for _, valIndex := range valIndices {
+			validator, found := recorded[valIndex]
+			if !found {
+				return nil, errors.New(fmt.Sprintf("validator %d was not recorded at slot %s", valIndex, slot))
+			}
+			validators[valIndex] = validator
+		}
+		return validators, nil
+	}
+}
+
+// Returns the oracle config that was used to create the given state
+func ConfigFromState(state *OracleState) *Config {
+	return &Config{
+		Network:                  state.Network,
+		PoolAddress:              state.PoolAddress,
+		DeployedSlot:             state.DeployedSlot,
+		DeployedBlock:            state.DeployedBlock,
+		CheckPointSizeInSlots:    state.CheckPointSizeInSlots,
+		PoolFeesPercentOver10000: state.PoolFeesPercentOver10000,
+		PoolFeesAddress:          state.PoolFeesAddress,
+		CollateralInWei:          state.CollateralInWei,
+		RewardOverrides:          state.RewardOverrides,
+		WhitelistedBuilders:      state.WhitelistedBuilders,
+		DryRun:                   true,
+	}
+}
+
+// Loads a state json file, verifying its hash, without any oracle
+func LoadStateFile(path string) (*OracleState, error) {
+	state, found, err := loadStateFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("state file not found: " + path)
+	}
+	return state, nil
+}
+
+// Loads the given state in the oracle, with the same checks as LoadFromJson
+func (or *Oracle) LoadFromState(state *OracleState) (bool, error) {
+	return or.setLoadedState(state)
+}
+
+// Applies the recorded blocks to the oracle state from NextSlotToProcess until
+// toSlot (included), freezing the state at every checkpoint exactly as the
+// running oracle does. onCheckpoint is called after each checkpoint is frozen.
+// If a slot was not recorded it stops with an error, unless skipGaps is set,
+// in which case it jumps to the next recorded slot. Skipping slots produces a
+// different state than the chain, and is only meant for sparse fixtures.
+func (or *Oracle) Replay(source BlockSource, toSlot uint64, skipGaps bool, onCheckpoint func(*OnchainState) error) error {
+	slots := source.Slots()
+	for or.State().NextSlotToProcess <= toSlot {
+		slot := or.State().NextSlotToProcess
+		fullBlock, found, err := source.Block(slot)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("could not read recorded block at slot %d", slot))
+		}
+		if !found {
+			if !skipGaps {
+				return errors.New(fmt.Sprintf("slot %d was not recorded, can't replay beyond slot %d",
+					slot, or.State().LatestProcessedSlot))
+			}
+			next := sort.Search(len(slots), func(i int) bool { return slots[i] > slot })
+			if next == len(slots) || slots[next] > toSlot {
+				log.Warn("No more recorded slots to replay after slot ", or.State().LatestProcessedSlot)
+				return nil
+			}
+			log.Warn("Skipping not recorded slots from ", slot, " to ", slots[next]-1)
+			or.skipToSlot(slots[next])
+			continue
+		}
+
+		_, err = or.AdvanceStateToNextSlot(fullBlock)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("could not process slot %d", slot))
+		}
+
+		isCheckpoint, err := or.IsCheckpoint()
+		if err != nil {
+			return errors.Wrap(err, "could not check if checkpoint was reached")
+		}
+		if !isCheckpoint {
+			continue
+		}
+
+		err = or.RunOffchainReconciliation()
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("offchain reconciliation failed at slot %d", slot))
+		}
+
+		if !or.FreezeCheckpoint() {
+			log.Warn("Not enough data to create a merkle tree at checkpoint ", slot)
+			continue
+		}
+
+		commited := or.LatestCommitedState()
+		log.WithFields(log.Fields{
+			"Slot":       commited.Slot,
+			"MerkleRoot": commited.MerkleRoot,
+		}).Info("Replayed checkpoint")
+
+		if onCheckpoint != nil {
+			err = onCheckpoint(commited)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Moves the state to the given slot without processing the ones in between
+func (or *Oracle) skipToSlot(slot uint64) {
+	or.mutex.Lock()
+	defer or.mutex.Unlock()
+	or.state.LatestProcessedSlot = slot - 1
+	or.state.NextSlotToProcess = slot
+}
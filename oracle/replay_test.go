@@ -0,0 +1,208 @@
+package oracle
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/stretchr/testify/require"
+)
+
+func newReplayTestConfig() *Config {
+	return &Config{
+		Network:                  "mainnet",
+		PoolAddress:              "0xAdFb8D27671F14f297eE94135e266aAFf8752e35",
+		CheckPointSizeInSlots:    4,
+		PoolFeesPercentOver10000: 1000,
+		PoolFeesAddress:          "0xE46F9bE81f9a3ACA1808Bb8c36D353436bb96091",
+		CollateralInWei:          big.NewInt(80000000000000000),
+		DeployedSlot:             uint64(1000),
+		DryRun:                   true,
+	}
+}
+
+// Missed proposal at the given slot, the simplest block that can be replayed
+func newReplayTestBlock(slot uint64) *FullBlock {
+	validator := &v1.Validator{
+		Index:  12,
+		Status: v1.ValidatorStateActiveOngoing,
+		Validator: &phase0.Validator{
+			PublicKey:             phase0.BLSPubKey{1, 2, 3},
+			WithdrawalCredentials: []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3, 39, 163, 9, 145, 23, 15, 145, 125, 123, 131, 222, 246, 228, 77, 38, 87, 120, 113, 237},
+		},
+	}
+	duty := &v1.ProposerDuty{
+		Slot:           phase0.Slot(slot),
+		ValidatorIndex: 12,
+	}
+	return NewFullBlock(duty, validator, 1)
+}
+
+// Records the given blocks in the folder, compressing some of them
+func recordTestBlocks(t *testing.T, dir string, slots []uint64, compressed bool) {
+	for _, slot := range slots {
+		jsonData, err := json.Marshal(newReplayTestBlock(slot))
+		require.NoError(t, err)
+		name := filepath.Join(dir, fmt.Sprintf("fullblock_slot_%d_chainid_1.json", slot))
+		if !compressed {
+			require.NoError(t, os.WriteFile(name, jsonData, 0644))
+			continue
+		}
+		file, err := os.Create(name + ".gz")
+		require.NoError(t, err)
+		writer := gzip.NewWriter(file)
+		_, err = writer.Write(jsonData)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		require.NoError(t, file.Close())
+	}
+}
+
+func Test_RecordedIndex(t *testing.T) {
+	index := newRecordedIndex()
+	require.NoError(t, index.add("fullblock_slot_10_chainid_5.json"))
+	require.NoError(t, index.add("fullblock_slot_10_chainid_5_withheaders.json"))
+	require.NoError(t, index.add("fullblock_slot_9_chainid_5_withheaders.json"))
+	require.NoError(t, index.add("fullblock_slot_9_chainid_5.json"))
+	require.NoError(t, index.add("fullblock_slot_11.json.gz"))
+	require.NoError(t, index.add("validators_slot_10.json"))
+	require.NoError(t, index.add("validators.json"))
+	require.NoError(t, index.add("state.json"))
+	require.NoError(t, index.add("oracle_config.json.gz"))
+
+	// Blocks with headers are preferred
+	require.Equal(t, "fullblock_slot_10_chainid_5_withheaders.json", index.blocks[10])
+	require.Equal(t, "fullblock_slot_9_chainid_5_withheaders.json", index.blocks[9])
+	require.Equal(t, "validators_slot_10.json", index.validators[10])
+	require.Equal(t, []uint64{9, 10, 11}, index.slots())
+
+	require.Equal(t, "oracle_config.json.gz", index.config)
+
+	// Same slot recorded twice the same way
+	require.Error(t, index.add("fullblock_slot_11_chainid_5.json"))
+	require.Error(t, index.add("oracle_config.json"))
+}
+
+func Test_DirBlockSource(t *testing.T) {
+	dir := t.TempDir()
+	recordTestBlocks(t, dir, []uint64{1000, 1001}, false)
+	recordTestBlocks(t, dir, []uint64{1002}, true)
+
+	validators := map[phase0.ValidatorIndex]*v1.Validator{
+		12: newReplayTestBlock(1000).Validator,
+	}
+	jsonData, err := json.Marshal(validators)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "validators_slot_1200.json"), jsonData, 0644))
+
+	source, err := NewBlockSource(dir)
+	require.NoError(t, err)
+	defer source.Close()
+
+	require.Equal(t, []uint64{1000, 1001, 1002}, source.Slots())
+
+	fullBlock, found, err := source.Block(1002)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, phase0.Slot(1002), fullBlock.ConsensusDuty.Slot)
+
+	_, found, err = source.Block(1003)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	getValidators := RecordedValidatorsFunc(source)
+	recorded, err := getValidators([]phase0.ValidatorIndex{12}, "1200")
+	require.NoError(t, err)
+	require.Equal(t, phase0.ValidatorIndex(12), recorded[12].Index)
+
+	_, err = getValidators([]phase0.ValidatorIndex{13}, "1200")
+	require.Error(t, err)
+	_, err = getValidators([]phase0.ValidatorIndex{12}, "2400")
+	require.Error(t, err)
+}
+
+func Test_ZipBlockSource(t *testing.T) {
+	dir := t.TempDir()
+	recordTestBlocks(t, dir, []uint64{1000}, false)
+	recordTestBlocks(t, dir, []uint64{1001}, true)
+
+	path := filepath.Join(t.TempDir(), "recorded.zip")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	writer := zip.NewWriter(file)
+	for _, name := range []string{"fullblock_slot_1000_chainid_1.json", "fullblock_slot_1001_chainid_1.json.gz"} {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		entry, err := writer.Create("recorded/" + name)
+		require.NoError(t, err)
+		_, err = entry.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	require.NoError(t, file.Close())
+
+	source, err := NewBlockSource(path)
+	require.NoError(t, err)
+	defer source.Close()
+
+	require.Equal(t, []uint64{1000, 1001}, source.Slots())
+	for _, slot := range []uint64{1000, 1001} {
+		fullBlock, found, err := source.Block(slot)
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, phase0.Slot(slot), fullBlock.ConsensusDuty.Slot)
+	}
+
+	_, err = NewBlockSource(filepath.Join(dir, "fullblock_slot_1000_chainid_1.json"))
+	require.Error(t, err)
+}
+
+func Test_Replay(t *testing.T) {
+	dir := t.TempDir()
+	recordTestBlocks(t, dir, []uint64{1000, 1001, 1002, 1003, 1004, 1006, 1007}, false)
+
+	source, err := NewDirBlockSource(dir)
+	require.NoError(t, err)
+
+	// Stops at the first slot that was not recorded
+	oracleInstance := NewOracle(newReplayTestConfig())
+	err = oracleInstance.Replay(source, 1007, false, nil)
+	require.Error(t, err)
+	require.Equal(t, uint64(1004), oracleInstance.State().LatestProcessedSlot)
+	require.Equal(t, uint64(1005), oracleInstance.State().NextSlotToProcess)
+
+	// Loading the state where it stopped, both replay to the same state
+	state, err := oracleInstance.StateWithHash()
+	require.NoError(t, err)
+	stateCopy, err := decodeStateJson(mustMarshal(t, state))
+	require.NoError(t, err)
+	replayed := NewOracle(ConfigFromState(stateCopy))
+	_, err = replayed.LoadFromState(stateCopy)
+	require.NoError(t, err)
+
+	// Continues from where it stopped, jumping over the gap
+	for _, instance := range []*Oracle{oracleInstance, replayed} {
+		err = instance.Replay(source, 1007, true, nil)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1007), instance.State().LatestProcessedSlot)
+	}
+
+	original, err := oracleInstance.StateWithHash()
+	require.NoError(t, err)
+	fromLoaded, err := replayed.StateWithHash()
+	require.NoError(t, err)
+	require.Equal(t, original.StateHash, fromLoaded.StateHash)
+}
+
+func mustMarshal(t *testing.T, value interface{}) []byte {
+	jsonData, err := json.Marshal(value)
+	require.NoError(t, err)
+	return jsonData
+}
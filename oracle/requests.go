@@ -0,0 +1,367 @@
+package oracle
+
+import (
+	"math"
+	"math/big"
+
+	v1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/dappnode/mev-sp-oracle/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// A withdrawal request of zero gwei exits the validator, any other amount is a
+// partial withdrawal that keeps it active
+const FullExitRequestAmount = uint64(0)
+
+// Exit epoch of the validators that are not exiting
+const FarFutureEpoch = phase0.Epoch(math.MaxUint64)
+
+// Withdrawal request (EIP-7002) included in the block. The validator is nil if
+// the pubkey is not of any validator. ExitInitiated is set if the beacon state
+// shows that the request initiated the exit of the validator.
+type WithdrawalRequest struct {
+	SourceAddress   string           `json:"source_address"`
+	ValidatorPubkey phase0.BLSPubKey `json:"validator_pubkey"`
+	AmountGwei      uint64           `json:"amount_gwei"`
+	Validator       *v1.Validator    `json:"validator"`
+	ExitInitiated   bool             `json:"exit_initiated"`
+}
+
+// Consolidation request (EIP-7251) included in the block. The validators are
+// nil if the pubkey is not of any validator. ExitInitiated is set if the beacon
+// state shows that the request initiated the exit of the source, which happens
+// once the consolidation is accepted.
+type ConsolidationRequest struct {
+	SourceAddress   string           `json:"source_address"`
+	SourcePubkey    phase0.BLSPubKey `json:"source_pubkey"`
+	TargetPubkey    phase0.BLSPubKey `json:"target_pubkey"`
+	SourceValidator *v1.Validator    `json:"source_validator"`
+	TargetValidator *v1.Validator    `json:"target_validator"`
+	ExitInitiated   bool             `json:"exit_initiated"`
+}
+
+type TransitionKind string
+
+const (
+	// The validator requested its exit from the execution layer
+	ExitTransition TransitionKind = "exit"
+	// The validator requested to be consolidated into another one
+	ConsolidationTransition TransitionKind = "consolidation"
+)
+
+// Subscribed validator that left the pool due to a request from the execution
+// layer. If its pending rewards were moved to the validator it was consolidated
+// into, the target is set and nothing was redistributed.
+type ValidatorTransition struct {
+	Slot             uint64         `json:"slot"`
+	Block            uint64         `json:"block"`
+	Kind             TransitionKind `json:"kind"`
+	SourceIndex      uint64         `json:"source_index"`
+	TargetIndex      *uint64        `json:"target_index,omitempty"`
+	MovedPendingWei  *big.Int       `json:"moved_pending_wei,omitempty"`
+	RedistributedWei *big.Int       `json:"redistributed_wei,omitempty"`
+}
+
+// Returns true if the block has withdrawal or consolidation requests
+func (b *FullBlock) hasExecutionRequests() bool {
+	if b.ConsensusBlock == nil {
+		return false
+	}
+	versioned, err := getVersionedBlock(b.ConsensusBlock)
+	if err != nil || versioned.ExecutionRequests == nil {
+		return false
+	}
+	return len(versioned.ExecutionRequests.Withdrawals) > 0 || len(versioned.ExecutionRequests.Consolidations) > 0
+}
+
+// Returns the withdrawal and consolidation requests of the block, without the
+// validators. They are read from the block body, where the consensus layer
+// gets them from the system contracts, so reverted txs are not there and the
+// ones sent by other contracts are.
+func (b *FullBlock) getExecutionRequests() ([]*WithdrawalRequest, []*ConsolidationRequest, error) {
+	withdrawals := make([]*WithdrawalRequest, 0)
+	consolidations := make([]*ConsolidationRequest, 0)
+
+	if b.ConsensusBlock == nil {
+		return withdrawals, consolidations, nil
+	}
+	versioned, err := getVersionedBlock(b.ConsensusBlock)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not get versioned block")
+	}
+	// Blocks before electra have no requests
+	if versioned.ExecutionRequests == nil {
+		return withdrawals, consolidations, nil
+	}
+
+	for _, request := range versioned.ExecutionRequests.Withdrawals {
+		withdrawals = append(withdrawals, &WithdrawalRequest{
+			SourceAddress:   common.Address(request.SourceAddress).String(),
+			ValidatorPubkey: request.ValidatorPubkey,
+			AmountGwei:      uint64(request.Amount),
+		})
+	}
+	for _, request := range versioned.ExecutionRequests.Consolidations {
+		consolidations = append(consolidations, &ConsolidationRequest{
+			SourceAddress: common.Address(request.SourceAddress).String(),
+			SourcePubkey:  request.SourcePubkey,
+			TargetPubkey:  request.TargetPubkey,
+		})
+	}
+	return withdrawals, consolidations, nil
+}
+
+// Returns the validators the requests of the block refer to
+func (b *FullBlock) requestsValidators() []*v1.Validator {
+	validators := make([]*v1.Validator, 0)
+	for _, request := range b.WithdrawalRequests {
+		validators = append(validators, request.Validator)
+	}
+	for _, request := range b.ConsolidationRequests {
+		validators = append(validators, request.SourceValidator, request.TargetValidator)
+	}
+	return validators
+}
+
+// Returns true if the block initiated the exit of the validator: it was not
+// exiting at the slot before the block and it is at the slot of the block.
+// Accepted full exits and consolidations both initiate the exit.
+func isExitInitiated(before *v1.Validator, after *v1.Validator) bool {
+	if before == nil || before.Validator == nil || after == nil || after.Validator == nil {
+		return false
+	}
+	return before.Validator.ExitEpoch == FarFutureEpoch && after.Validator.ExitEpoch != FarFutureEpoch
+}
+
+// Returns true if the exit of the validator was initiated by a request of the
+// block. Voluntary exits and slashings are processed before the requests, so if
+// the block has any for the validator, its requests were ignored.
+func (b *FullBlock) isExitInitiatedByRequest(before *v1.Validator, after *v1.Validator) bool {
+	if !isExitInitiated(before, after) {
+		return false
+	}
+	if after.Validator.Slashed {
+		return false
+	}
+	return !b.hasVoluntaryExit(after.Index)
+}
+
+// Returns true if the block has a voluntary exit of the validator
+func (b *FullBlock) hasVoluntaryExit(index phase0.ValidatorIndex) bool {
+	if b.ConsensusBlock == nil {
+		return false
+	}
+	versioned, err := getVersionedBlock(b.ConsensusBlock)
+	if err != nil {
+		return false
+	}
+	for _, exit := range versioned.VoluntaryExits {
+		if exit != nil && exit.Message != nil && exit.Message.ValidatorIndex == index {
+			return true
+		}
+	}
+	return false
+}
+
+// Handles the exits and consolidations requested from the execution layer for
+// subscribed validators, so that they don't have to wait for the validator
+// cleanup. Only requests that the beacon state shows took effect are handled,
+// the ones rejected by the consensus layer are skipped.
+func (or *Oracle) handleExecutionRequests(fullBlock *FullBlock) {
+	if len(fullBlock.WithdrawalRequests) == 0 && len(fullBlock.ConsolidationRequests) == 0 {
+		return
+	}
+	if !or.isRuleActive(ExecutionLayerRequests, or.state.NextSlotToProcess) {
+		return
+	}
+
+	blockNumber := fullBlock.GetBlockNumber()
+	for _, request := range fullBlock.WithdrawalRequests {
+		or.handleWithdrawalRequest(request, blockNumber)
+	}
+	for _, request := range fullBlock.ConsolidationRequests {
+		or.handleConsolidationRequest(request, blockNumber)
+	}
+}
+
+// Full exits unsubscribe the validator and share its pending rewards among the
+// pool, as the cleanup would do. Partial withdrawals are ignored.
+func (or *Oracle) handleWithdrawalRequest(request *WithdrawalRequest, blockNumber uint64) {
+	if request.AmountGwei != FullExitRequestAmount || request.Validator == nil {
+		return
+	}
+	valIndex := uint64(request.Validator.Index)
+	if !or.isSubscribed(valIndex) {
+		return
+	}
+	if !or.isRequestFromOwner(request.SourceAddress, request.Validator) {
+		log.WithFields(log.Fields{
+			"BlockNumber":    blockNumber,
+			"ValidatorIndex": valIndex,
+			"SourceAddress":  request.SourceAddress,
+		}).Warn("[Exit request] but sender does not match withdrawal address, skipping")
+		return
+	}
+	if !request.ExitInitiated {
+		log.WithFields(log.Fields{
+			"BlockNumber":    blockNumber,
+			"ValidatorIndex": valIndex,
+		}).Warn("[Exit request] but the exit was not initiated in the beacon state, skipping")
+		return
+	}
+
+	redistributed := or.exitFromExecutionRequest(valIndex)
+	or.state.ValidatorTransitions = append(or.state.ValidatorTransitions, &ValidatorTransition{
+		Slot:             or.state.NextSlotToProcess,
+		Block:            blockNumber,
+		Kind:             ExitTransition,
+		SourceIndex:      valIndex,
+		RedistributedWei: redistributed,
+	})
+	log.WithFields(log.Fields{
+		"BlockNumber":      blockNumber,
+		"ValidatorIndex":   valIndex,
+		"RedistributedWei": redistributed,
+	}).Info("[Exit request] Validator unsubscribed ok")
+}
+
+// Consolidations move the pending rewards, the collateral and the subscription
+// of the source validator to the target, if both have the same withdrawal
+// address. Otherwise the source just leaves the pool, as in an exit.
+func (or *Oracle) handleConsolidationRequest(request *ConsolidationRequest, blockNumber uint64) {
+	source := request.SourceValidator
+	if source == nil {
+		return
+	}
+	sourceIndex := uint64(source.Index)
+	if !or.isSubscribed(sourceIndex) {
+		return
+	}
+	if !or.isRequestFromOwner(request.SourceAddress, source) {
+		log.WithFields(log.Fields{
+			"BlockNumber":    blockNumber,
+			"ValidatorIndex": sourceIndex,
+			"SourceAddress":  request.SourceAddress,
+		}).Warn("[Consolidation request] but sender does not match withdrawal address, skipping")
+		return
+	}
+
+	// Same source and target switches the validator to compounding credentials, it stays
+	target := request.TargetValidator
+	if target != nil && target.Index == source.Index {
+		return
+	}
+	if !request.ExitInitiated {
+		log.WithFields(log.Fields{
+			"BlockNumber":    blockNumber,
+			"ValidatorIndex": sourceIndex,
+		}).Warn("[Consolidation request] but the exit of the source was not initiated in the beacon state, skipping")
+		return
+	}
+
+	transition := &ValidatorTransition{
+		Slot:        or.state.NextSlotToProcess,
+		Block:       blockNumber,
+		Kind:        ConsolidationTransition,
+		SourceIndex: sourceIndex,
+	}
+	if target != nil {
+		targetIndex := uint64(target.Index)
+		transition.TargetIndex = &targetIndex
+	}
+
+	if target == nil || !or.canTakeOverSubscription(sourceIndex, target) {
+		transition.RedistributedWei = or.exitFromExecutionRequest(sourceIndex)
+		or.state.ValidatorTransitions = append(or.state.ValidatorTransitions, transition)
+		log.WithFields(log.Fields{
+			"BlockNumber":      blockNumber,
+			"ValidatorIndex":   sourceIndex,
+			"TargetPubkey":     request.TargetPubkey.String(),
+			"RedistributedWei": transition.RedistributedWei,
+		}).Info("[Consolidation request] Target can't take over, validator unsubscribed ok")
+		return
+	}
+
+	targetIndex := *transition.TargetIndex
+	sourceInfo := or.state.Validators[sourceIndex]
+	if !or.isTracked(targetIndex) {
+		or.state.Validators[targetIndex] = &ValidatorInfo{
+			ValidatorStatus:       NotSubscribed,
+			AccumulatedRewardsWei: big.NewInt(0),
+			PendingRewardsWei:     big.NewInt(0),
+			CollateralWei:         big.NewInt(0),
+			WithdrawalAddress:     sourceInfo.WithdrawalAddress,
+			ValidatorIndex:        targetIndex,
+			ValidatorKey:          target.Validator.PublicKey.String(),
+		}
+	}
+
+	// The target keeps its own status if it was already subscribed. Otherwise it
+	// starts as active, the missed proposals of the source are not carried over
+	targetInfo := or.state.Validators[targetIndex]
+	if !or.isSubscribed(targetIndex) {
+		targetInfo.ValidatorStatus = Active
+		targetInfo.SubscriptionType = sourceInfo.SubscriptionType
+	}
+
+	// The collateral of the source was paid for the subscription it hands over
+	if sourceInfo.CollateralWei != nil && sourceInfo.CollateralWei.Sign() > 0 {
+		if targetInfo.CollateralWei == nil {
+			targetInfo.CollateralWei = big.NewInt(0)
+		}
+		targetInfo.CollateralWei = new(big.Int).Add(targetInfo.CollateralWei, sourceInfo.CollateralWei)
+		sourceInfo.CollateralWei = big.NewInt(0)
+	}
+
+	transition.MovedPendingWei = new(big.Int).Set(sourceInfo.PendingRewardsWei)
+	or.increaseValidatorPendingRewards(targetIndex, transition.MovedPendingWei)
+	or.advanceStateMachine(sourceIndex, Unsubscribe)
+	or.resetPendingRewards(sourceIndex)
+	or.state.ValidatorTransitions = append(or.state.ValidatorTransitions, transition)
+
+	log.WithFields(log.Fields{
+		"BlockNumber":     blockNumber,
+		"SourceIndex":     sourceIndex,
+		"TargetIndex":     targetIndex,
+		"MovedPendingWei": transition.MovedPendingWei,
+	}).Info("[Consolidation request] Subscription moved to target validator ok")
+}
+
+// Unsubscribes the validator and shares its pending rewards among the pool,
+// returning them
+func (or *Oracle) exitFromExecutionRequest(valIndex uint64) *big.Int {
+	pending := new(big.Int).Set(or.state.Validators[valIndex].PendingRewardsWei)
+	or.advanceStateMachine(valIndex, Unsubscribe)
+	or.resetPendingRewards(valIndex)
+	or.increaseAllPendingRewards(pending)
+	return pending
+}
+
+// Returns true if the target validator can take over the subscription of the
+// source: it has the same withdrawal address and it is not banned
+func (or *Oracle) canTakeOverSubscription(sourceIndex uint64, target *v1.Validator) bool {
+	if target.Validator == nil || or.isBanned(uint64(target.Index)) {
+		return false
+	}
+	targetAddress, withdrawalType := GetWithdrawalAndType(target)
+	if !withdrawalType.HasEth1Address() {
+		return false
+	}
+	return utils.Equals(targetAddress, or.state.Validators[sourceIndex].WithdrawalAddress)
+}
+
+// Returns true if the request was sent by the withdrawal address of the
+// validator, otherwise the consensus layer ignores it
+func (or *Oracle) isRequestFromOwner(sourceAddress string, validator *v1.Validator) bool {
+	if validator.Validator == nil {
+		return false
+	}
+	withdrawalAddress, err := utils.GetEth1AddressByte(validator.Validator.WithdrawalCredentials)
+	if err != nil {
+		return false
+	}
+	return utils.Equals(sourceAddress, withdrawalAddress)
+}
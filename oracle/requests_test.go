@@ -0,0 +1,313 @@
+package oracle
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	v1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/bellatrix"
+	"github.com/attestantio/go-eth2-client/spec/capella"
+	"github.com/attestantio/go-eth2-client/spec/deneb"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+var requestsTestOwner = "0x2000000000000000000000000000000000000000"
+var requestsTestOther = "0x3000000000000000000000000000000000000000"
+
+// Validator with eth1 withdrawal credentials of the given address
+func newRequestsTestValidator(index uint64, address string, compounding bool) *v1.Validator {
+	credentials := make([]byte, 32)
+	credentials[0] = 1
+	if compounding {
+		credentials[0] = 2
+	}
+	copy(credentials[12:], common.HexToAddress(address).Bytes())
+	return &v1.Validator{
+		Index:  phase0.ValidatorIndex(index),
+		Status: v1.ValidatorStateActiveOngoing,
+		Validator: &phase0.Validator{
+			PublicKey:             phase0.BLSPubKey{byte(index)},
+			WithdrawalCredentials: credentials,
+		},
+	}
+}
+
+func newRequestsTestOracle(t *testing.T) *Oracle {
+	oracle := newOracleWithRules(t, 100, ExecutionLayerRequests)
+
+	// Three subscribed validators with some pending rewards
+	oracle.addSubscription(1, requestsTestOwner, "0x01")
+	oracle.addSubscription(2, requestsTestOwner, "0x02")
+	oracle.addSubscription(3, requestsTestOther, "0x03")
+	oracle.increaseAllPendingRewards(big.NewInt(3000))
+	require.Equal(t, big.NewInt(900), oracle.state.Validators[1].PendingRewardsWei)
+	return oracle
+}
+
+// Electra block at slot 100 with the given execution requests
+func newRequestsTestBlock(requests *electra.ExecutionRequests) *FullBlock {
+	block := newReplayTestBlock(100)
+	block.SetConsensusBlock(&spec.VersionedSignedBeaconBlock{
+		Version: spec.DataVersionElectra,
+		Electra: &electra.SignedBeaconBlock{
+			Message: &electra.BeaconBlock{
+				Slot:          100,
+				ProposerIndex: 12,
+				Body: &electra.BeaconBlockBody{
+					ExecutionPayload: &deneb.ExecutionPayload{
+						BlockNumber: 1000,
+					},
+					ExecutionRequests: requests,
+				},
+			},
+		},
+	})
+	return block
+}
+
+func Test_GetExecutionRequests(t *testing.T) {
+	source := phase0.BLSPubKey{1}
+	target := phase0.BLSPubKey{2}
+	owner := bellatrix.ExecutionAddress(common.HexToAddress(requestsTestOwner))
+	block := newRequestsTestBlock(&electra.ExecutionRequests{
+		Deposits: []*electra.DepositRequest{{Pubkey: source}},
+		Withdrawals: []*electra.WithdrawalRequest{
+			{SourceAddress: owner, ValidatorPubkey: source, Amount: 5},
+		},
+		Consolidations: []*electra.ConsolidationRequest{
+			{SourceAddress: owner, SourcePubkey: source, TargetPubkey: target},
+		},
+	})
+	require.True(t, block.hasExecutionRequests())
+
+	withdrawals, consolidations, err := block.getExecutionRequests()
+	require.NoError(t, err)
+	require.Equal(t, []*WithdrawalRequest{{
+		SourceAddress:   common.HexToAddress(requestsTestOwner).String(),
+		ValidatorPubkey: source,
+		AmountGwei:      5,
+	}}, withdrawals)
+	require.Equal(t, []*ConsolidationRequest{{
+		SourceAddress: common.HexToAddress(requestsTestOwner).String(),
+		SourcePubkey:  source,
+		TargetPubkey:  target,
+	}}, consolidations)
+
+	// Deposits are not handled here
+	block = newRequestsTestBlock(&electra.ExecutionRequests{
+		Deposits: []*electra.DepositRequest{{Pubkey: source}},
+	})
+	require.False(t, block.hasExecutionRequests())
+
+	// Blocks before electra have no requests
+	block = newReplayTestBlock(100)
+	block.SetConsensusBlock(&spec.VersionedSignedBeaconBlock{
+		Version: spec.DataVersionCapella,
+		Capella: &capella.SignedBeaconBlock{
+			Message: &capella.BeaconBlock{
+				Slot:          100,
+				ProposerIndex: 12,
+				Body:          &capella.BeaconBlockBody{ExecutionPayload: &capella.ExecutionPayload{BlockNumber: 1000}},
+			},
+		},
+	})
+	require.False(t, block.hasExecutionRequests())
+	withdrawals, consolidations, err = block.getExecutionRequests()
+	require.NoError(t, err)
+	require.Equal(t, 0, len(withdrawals))
+	require.Equal(t, 0, len(consolidations))
+
+	// Missed blocks neither
+	block = newReplayTestBlock(100)
+	require.False(t, block.hasExecutionRequests())
+}
+
+func Test_HandleExecutionRequests_Exit(t *testing.T) {
+	oracle := newRequestsTestOracle(t)
+	block := newRequestsTestBlock(nil)
+	block.WithdrawalRequests = []*WithdrawalRequest{
+		// Partial withdrawal, stays
+		{SourceAddress: requestsTestOwner, AmountGwei: 1, Validator: newRequestsTestValidator(1, requestsTestOwner, false)},
+		// Not sent by the withdrawal address, ignored by the consensus layer
+		{SourceAddress: requestsTestOther, Validator: newRequestsTestValidator(1, requestsTestOwner, false)},
+		// Not a validator
+		{SourceAddress: requestsTestOwner},
+		// Not subscribed
+		{SourceAddress: requestsTestOwner, Validator: newRequestsTestValidator(9, requestsTestOwner, false), ExitInitiated: true},
+		// Rejected by the consensus layer, the exit was not initiated
+		{SourceAddress: requestsTestOwner, Validator: newRequestsTestValidator(2, requestsTestOwner, false)},
+		// Full exit
+		{SourceAddress: requestsTestOwner, Validator: newRequestsTestValidator(1, requestsTestOwner, false), ExitInitiated: true},
+	}
+
+	// Not active before the fork
+	oracle.state.NextSlotToProcess = 99
+	oracle.handleExecutionRequests(block)
+	require.Equal(t, Active, oracle.state.Validators[1].ValidatorStatus)
+	require.Equal(t, 0, len(oracle.state.ValidatorTransitions))
+
+	oracle.state.NextSlotToProcess = 100
+	oracle.handleExecutionRequests(block)
+	require.Equal(t, NotSubscribed, oracle.state.Validators[1].ValidatorStatus)
+	require.Equal(t, big.NewInt(0), oracle.state.Validators[1].PendingRewardsWei)
+	require.Equal(t, Active, oracle.state.Validators[2].ValidatorStatus)
+
+	// Pending rewards are shared among the rest, with the pool cut
+	require.Equal(t, big.NewInt(900+405), oracle.state.Validators[2].PendingRewardsWei)
+	require.Equal(t, big.NewInt(900+405), oracle.state.Validators[3].PendingRewardsWei)
+	require.Equal(t, []*ValidatorTransition{{
+		Slot:             100,
+		Block:            1000,
+		Kind:             ExitTransition,
+		SourceIndex:      1,
+		RedistributedWei: big.NewInt(900),
+	}}, oracle.state.ValidatorTransitions)
+}
+
+func Test_HandleExecutionRequests_Consolidation(t *testing.T) {
+	oracle := newRequestsTestOracle(t)
+	oracle.state.Validators[2].ValidatorStatus = YellowCard
+	oracle.state.Validators[2].SubscriptionType = Manual
+	oracle.state.Validators[1].CollateralWei = big.NewInt(10)
+	oracle.state.Validators[2].CollateralWei = big.NewInt(20)
+	block := newRequestsTestBlock(nil)
+	block.ConsolidationRequests = []*ConsolidationRequest{
+		// Switch to compounding credentials, stays
+		{SourceAddress: requestsTestOwner,
+			SourceValidator: newRequestsTestValidator(1, requestsTestOwner, false),
+			TargetValidator: newRequestsTestValidator(1, requestsTestOwner, true)},
+		// Into a validator of the same address, that was not tracked
+		{SourceAddress: requestsTestOwner,
+			SourceValidator: newRequestsTestValidator(2, requestsTestOwner, false),
+			TargetValidator: newRequestsTestValidator(20, requestsTestOwner, true), ExitInitiated: true},
+		// Into a validator of the same address, that was subscribed
+		{SourceAddress: requestsTestOwner,
+			SourceValidator: newRequestsTestValidator(1, requestsTestOwner, false),
+			TargetValidator: newRequestsTestValidator(20, requestsTestOwner, true), ExitInitiated: true},
+		// Rejected by the consensus layer, the exit of the source was not initiated
+		{SourceAddress: requestsTestOther,
+			SourceValidator: newRequestsTestValidator(3, requestsTestOther, false),
+			TargetValidator: newRequestsTestValidator(21, requestsTestOwner, true)},
+	}
+	oracle.handleExecutionRequests(block)
+
+	require.Equal(t, NotSubscribed, oracle.state.Validators[1].ValidatorStatus)
+	require.Equal(t, NotSubscribed, oracle.state.Validators[2].ValidatorStatus)
+	require.Equal(t, Active, oracle.state.Validators[3].ValidatorStatus)
+
+	// Into a validator of another address, the source leaves the pool
+	block.ConsolidationRequests[3].ExitInitiated = true
+	block.ConsolidationRequests = block.ConsolidationRequests[3:]
+	oracle.handleExecutionRequests(block)
+	require.Equal(t, NotSubscribed, oracle.state.Validators[3].ValidatorStatus)
+
+	// The target took over the subscription, collateral and pending rewards of
+	// both, but not the yellow card of the source
+	target := oracle.state.Validators[20]
+	require.Equal(t, Active, target.ValidatorStatus)
+	require.Equal(t, Manual, target.SubscriptionType)
+	require.Equal(t, big.NewInt(30), target.CollateralWei)
+	require.Equal(t, big.NewInt(0), oracle.state.Validators[1].CollateralWei)
+	require.Equal(t, big.NewInt(0), oracle.state.Validators[2].CollateralWei)
+	require.Equal(t, requestsTestOwner, target.WithdrawalAddress)
+	require.Equal(t, "0x14"+strings.Repeat("00", 47), target.ValidatorKey)
+
+	// Validator 3 pending is shared with the target, the only one eligible left
+	require.Equal(t, big.NewInt(900+900+810), target.PendingRewardsWei)
+	require.False(t, oracle.isTracked(21))
+
+	targetIndex := uint64(20)
+	otherIndex := uint64(21)
+	require.Equal(t, []*ValidatorTransition{
+		{Slot: 100, Block: 1000, Kind: ConsolidationTransition, SourceIndex: 2, TargetIndex: &targetIndex, MovedPendingWei: big.NewInt(900)},
+		{Slot: 100, Block: 1000, Kind: ConsolidationTransition, SourceIndex: 1, TargetIndex: &targetIndex, MovedPendingWei: big.NewInt(900)},
+		{Slot: 100, Block: 1000, Kind: ConsolidationTransition, SourceIndex: 3, TargetIndex: &otherIndex, RedistributedWei: big.NewInt(900)},
+	}, oracle.state.ValidatorTransitions)
+}
+
+func Test_HandleExecutionRequests_ConsolidationIntoSubscribed(t *testing.T) {
+	oracle := newRequestsTestOracle(t)
+	oracle.state.Validators[1].ValidatorStatus = RedCard
+	oracle.state.Validators[1].CollateralWei = big.NewInt(10)
+	oracle.state.Validators[2].ValidatorStatus = YellowCard
+	oracle.state.Validators[2].CollateralWei = big.NewInt(20)
+	block := newRequestsTestBlock(nil)
+	block.ConsolidationRequests = []*ConsolidationRequest{
+		{SourceAddress: requestsTestOwner,
+			SourceValidator: newRequestsTestValidator(1, requestsTestOwner, false),
+			TargetValidator: newRequestsTestValidator(2, requestsTestOwner, true), ExitInitiated: true},
+	}
+	oracle.handleExecutionRequests(block)
+
+	// The target keeps its own status, not the red card of the source
+	require.Equal(t, NotSubscribed, oracle.state.Validators[1].ValidatorStatus)
+	require.Equal(t, YellowCard, oracle.state.Validators[2].ValidatorStatus)
+	require.Equal(t, big.NewInt(30), oracle.state.Validators[2].CollateralWei)
+	require.Equal(t, big.NewInt(0), oracle.state.Validators[1].CollateralWei)
+	require.Equal(t, big.NewInt(900+900), oracle.state.Validators[2].PendingRewardsWei)
+}
+
+func Test_HandleExecutionRequests_ConsolidationIntoBanned(t *testing.T) {
+	oracle := newRequestsTestOracle(t)
+	oracle.state.Validators[2].ValidatorStatus = Banned
+	block := newRequestsTestBlock(nil)
+	block.ConsolidationRequests = []*ConsolidationRequest{
+		{SourceAddress: requestsTestOwner,
+			SourceValidator: newRequestsTestValidator(1, requestsTestOwner, false),
+			TargetValidator: newRequestsTestValidator(2, requestsTestOwner, true), ExitInitiated: true},
+	}
+	oracle.handleExecutionRequests(block)
+
+	// Banned validators can't get the subscription back, so it is an exit
+	require.Equal(t, NotSubscribed, oracle.state.Validators[1].ValidatorStatus)
+	require.Equal(t, Banned, oracle.state.Validators[2].ValidatorStatus)
+	require.Equal(t, big.NewInt(900), oracle.state.Validators[2].PendingRewardsWei)
+	require.Equal(t, big.NewInt(900+810), oracle.state.Validators[3].PendingRewardsWei)
+	require.Equal(t, big.NewInt(900), oracle.state.ValidatorTransitions[0].RedistributedWei)
+	require.Nil(t, oracle.state.ValidatorTransitions[0].MovedPendingWei)
+}
+
+func Test_IsExitInitiated(t *testing.T) {
+	notExiting := newRequestsTestValidator(1, requestsTestOwner, false)
+	notExiting.Validator.ExitEpoch = FarFutureEpoch
+	exiting := newRequestsTestValidator(1, requestsTestOwner, false)
+	exiting.Validator.ExitEpoch = 300
+
+	require.True(t, isExitInitiated(notExiting, exiting))
+	require.False(t, isExitInitiated(notExiting, notExiting))
+	// Already exiting before the block
+	require.False(t, isExitInitiated(exiting, exiting))
+	// Not a validator
+	require.False(t, isExitInitiated(nil, nil))
+	require.False(t, isExitInitiated(nil, exiting))
+}
+
+func Test_IsExitInitiatedByRequest(t *testing.T) {
+	notExiting := newRequestsTestValidator(1, requestsTestOwner, false)
+	notExiting.Validator.ExitEpoch = FarFutureEpoch
+	exiting := newRequestsTestValidator(1, requestsTestOwner, false)
+	exiting.Validator.ExitEpoch = 300
+
+	block := newRequestsTestBlock(nil)
+	require.True(t, block.isExitInitiatedByRequest(notExiting, exiting))
+	require.False(t, block.isExitInitiatedByRequest(exiting, exiting))
+
+	// A voluntary exit of the same block is what initiated the exit
+	block.ConsensusBlock.Electra.Message.Body.VoluntaryExits = []*phase0.SignedVoluntaryExit{
+		{Message: &phase0.VoluntaryExit{ValidatorIndex: 2}},
+	}
+	require.True(t, block.isExitInitiatedByRequest(notExiting, exiting))
+	block.ConsensusBlock.Electra.Message.Body.VoluntaryExits = append(block.ConsensusBlock.Electra.Message.Body.VoluntaryExits,
+		&phase0.SignedVoluntaryExit{Message: &phase0.VoluntaryExit{ValidatorIndex: 1}})
+	require.False(t, block.isExitInitiatedByRequest(notExiting, exiting))
+
+	// And so is a slashing
+	block = newRequestsTestBlock(nil)
+	exiting.Validator.Slashed = true
+	require.False(t, block.isExitInitiatedByRequest(notExiting, exiting))
+}
@@ -0,0 +1,330 @@
+package oracle
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dappnode/mev-sp-oracle/utils"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Supported backends to persist the oracle state
+const (
+	JsonBackend = "json"
+	BoltBackend = "bolt"
+)
+
+// Backend used to persist the oracle state. Save is called with the hash of
+// the state already calculated, and implementations must either persist all
+// the changes of the state or none of them. Load and LoadCheckpoint return
+// false with no error if there is nothing stored.
+type StateStore interface {
+	// Persists the latest state. If checkpoint is true a copy of the state is
+	// also kept, so that it can be recovered later on with LoadCheckpoint
+	Save(state *OracleState, checkpoint bool) error
+
+	// Loads the latest persisted state, verifying its hash
+	Load() (*OracleState, bool, error)
+
+	// Loads the copy of the state that was kept at the given checkpoint slot
+	LoadCheckpoint(slot uint64) (*OracleState, bool, error)
+
+	Close() error
+}
+
+// Decides which checkpoint snapshots are kept every time a new one is stored.
+// The latest KeepLatest snapshots are always kept, and older ones are thinned
+// keeping only one every ThinEvery checkpoints since the pool was deployed.
+// With KeepLatest set to 0 all snapshots are kept.
+type SnapshotRetention struct {
+	KeepLatest int
+	ThinEvery  uint64
+}
+
+// Returns the slots of the snapshots that have to be removed according to the
+// retention policy. Slots are expected to be sorted from newest to oldest.
+func (r SnapshotRetention) toRemove(slots []uint64, deployedSlot uint64, checkPointSize uint64) []uint64 {
+	remove := make([]uint64, 0)
+	if r.KeepLatest <= 0 {
+		return remove
+	}
+	for i, slot := range slots {
+		if i < r.KeepLatest {
+			continue
+		}
+		if r.ThinEvery != 0 && checkPointSize != 0 && slot >= deployedSlot &&
+			((slot-deployedSlot)/checkPointSize)%r.ThinEvery == 0 {
+			continue
+		}
+		remove = append(remove, slot)
+	}
+	return remove
+}
+
+// Returns a new store of the given backend, persisting its data in folder
+func NewStateStore(backend string, folder string, retention SnapshotRetention) (StateStore, error) {
+	switch backend {
+	case JsonBackend:
+		store := NewJsonStateStore(folder)
+		store.SetRetention(retention)
+		return store, nil
+	case BoltBackend:
+		store, err := NewBoltStateStore(folder)
+		if err != nil {
+			return nil, err
+		}
+		store.SetRetention(retention)
+		return store, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown state backend: %s, expected %s or %s",
+		backend, JsonBackend, BoltBackend))
+}
+
+// Stores the whole oracle state as a human readable json file, rewriting it
+// every time its saved. Checkpoints are stored as state_<slot>.json. Files are
+// written atomically, so a crash never leaves a half written state behind.
+type JsonStateStore struct {
+	folder    string
+	retention SnapshotRetention
+}
+
+func NewJsonStateStore(folder string) *JsonStateStore {
+	return &JsonStateStore{
+		folder: folder,
+	}
+}
+
+// Sets the policy used to remove old state_<slot>.json snapshots
+func (s *JsonStateStore) SetRetention(retention SnapshotRetention) {
+	s.retention = retention
+}
+
+func (s *JsonStateStore) Save(state *OracleState, checkpoint bool) error {
+	jsonData, err := json.MarshalIndent(state, "", " ")
+	if err != nil {
+		return errors.Wrap(err, "could not marshal state to JSON")
+	}
+
+	err = os.MkdirAll(s.folder, os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "could not create folder")
+	}
+
+	log.Trace("Saving state to file:", fmt.Sprintf("%s", jsonData))
+
+	path := filepath.Join(s.folder, StateJsonName)
+	err = utils.WriteFileAtomic(path, jsonData, 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not write file")
+	}
+
+	log.WithFields(log.Fields{
+		"LatestProcessedSlot":  state.LatestProcessedSlot,
+		"LatestProcessedBlock": state.LatestProcessedBlock,
+		"NextSlotToProcess":    state.NextSlotToProcess,
+		"TotalValidators":      len(state.Validators),
+		"Network":              state.Network,
+		"PoolAddress":          state.PoolAddress,
+		"Path":                 path,
+		"Hash":                 state.StateHash,
+	}).Info("Saved state to file")
+
+	// If checkpoint is true, save a copy of the state with the slot number in the file
+	if checkpoint {
+		filename := fmt.Sprintf("state_%d.json", state.LatestProcessedSlot)
+		path = filepath.Join(s.folder, filename)
+
+		log.WithFields(log.Fields{
+			"LatestProcessedSlot": state.LatestProcessedSlot,
+			"FileName":            filename,
+		}).Info("Storing also a copy of the state")
+
+		err = utils.WriteFileAtomic(path, jsonData, 0644)
+		if err != nil {
+			return errors.Wrap(err, "could not write file")
+		}
+
+		err = s.pruneSnapshots(state)
+		if err != nil {
+			return errors.Wrap(err, "could not prune old snapshots")
+		}
+	}
+
+	return nil
+}
+
+func (s *JsonStateStore) pruneSnapshots(state *OracleState) error {
+	slots, err := s.snapshotSlots()
+	if err != nil {
+		return err
+	}
+	for _, slot := range s.retention.toRemove(slots, state.DeployedSlot, state.CheckPointSizeInSlots) {
+		log.WithFields(log.Fields{
+			"Slot":       slot,
+			"KeepLatest": s.retention.KeepLatest,
+			"ThinEvery":  s.retention.ThinEvery,
+		}).Info("Removing old state snapshot")
+		err := os.Remove(filepath.Join(s.folder, fmt.Sprintf("state_%d.json", slot)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the slots of the stored state_<slot>.json snapshots, newest first
+func (s *JsonStateStore) snapshotSlots() ([]uint64, error) {
+	paths, err := filepath.Glob(filepath.Join(s.folder, "state_*.json"))
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]uint64, 0, len(paths))
+	for _, path := range paths {
+		slotStr := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "state_"), ".json")
+		slot, err := strconv.ParseUint(slotStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] > slots[j] })
+	return slots, nil
+}
+
+// Loads state.json. If its corrupted, eg it does not match its hash, falls
+// back to the newest valid state_<slot>.json snapshot
+func (s *JsonStateStore) Load() (*OracleState, bool, error) {
+	state, found, err := loadStateFromPath(filepath.Join(s.folder, StateJsonName))
+	if err == nil {
+		return state, found, nil
+	}
+
+	log.WithFields(log.Fields{
+		"Error": err,
+	}).Error("Could not load state, trying with the latest valid snapshot")
+
+	slots, listErr := s.snapshotSlots()
+	if listErr != nil {
+		return nil, false, errors.Wrap(listErr, "could not list snapshots")
+	}
+	for _, slot := range slots {
+		snapshot, found, snapshotErr := s.LoadCheckpoint(slot)
+		if snapshotErr != nil {
+			log.WithFields(log.Fields{
+				"Slot":  slot,
+				"Error": snapshotErr,
+			}).Warn("Snapshot is not valid, trying an older one")
+			continue
+		}
+		if found {
+			log.WithFields(log.Fields{
+				"Slot": slot,
+			}).Warn("Loaded state from snapshot, the slots after it will be processed again")
+			return snapshot, true, nil
+		}
+	}
+
+	// No valid snapshot, report the original error
+	return nil, false, err
+}
+
+func (s *JsonStateStore) LoadCheckpoint(slot uint64) (*OracleState, bool, error) {
+	return loadStateFromPath(filepath.Join(s.folder, fmt.Sprintf("state_%d.json", slot)))
+}
+
+func (s *JsonStateStore) Close() error {
+	return nil
+}
+
+func loadStateFromPath(path string) (*OracleState, bool, error) {
+	log.Info("Loading oracle state from json file: ", path)
+
+	jsonFile, err := os.Open(path)
+	// Dont error if the file wasnt found, just return not found
+	if err != nil {
+		return nil, false, nil
+	}
+	defer jsonFile.Close()
+
+	byteValue, err := ioutil.ReadAll(jsonFile)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "could not read json file")
+	}
+
+	state, err := decodeStateJson(byteValue)
+	if err != nil {
+		return nil, false, err
+	}
+	return state, true, nil
+}
+
+// Writes the state as a json file that can be loaded with LoadStateFile. The
+// state is written as is, so its hash must be the one of its content.
+func WriteStateFile(state *OracleState, path string) error {
+	jsonData, err := json.MarshalIndent(state, "", " ")
+	if err != nil {
+		return errors.Wrap(err, "could not marshal state to JSON")
+	}
+	err = utils.WriteFileAtomic(path, jsonData, 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not write file")
+	}
+	return nil
+}
+
+// Decodes a json serialized state and verifies its hash
+func decodeStateJson(rawBytes []byte) (*OracleState, error) {
+	var state OracleState
+
+	err := json.Unmarshal(rawBytes, &state)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal json file")
+	}
+
+	err = verifyStateHash(&state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Recalculates the hash of the state and ensures it matches the one it contains
+func verifyStateHash(state *OracleState) error {
+	// Store the hash we recovered from the file
+	recoveredHash := state.StateHash
+
+	// Reset the hash since we want to hash the content without the hash
+	state.StateHash = ""
+
+	// Serialize the state without the hash
+	jsonNoHash, err := json.MarshalIndent(state, "", " ")
+	if err != nil {
+		return errors.Wrap(err, "could not marshal state without hash")
+	}
+
+	log.Trace("Loaded state from file: ", fmt.Sprintf("%s", jsonNoHash))
+
+	// We calculate the hash of the state we read
+	calculatedHashByte := sha256.Sum256(jsonNoHash[:])
+	calculatedHashString := hexutil.Encode(calculatedHashByte[:])
+
+	// Hashes must match. Length is checked first since a corrupted file can
+	// contain anything as its hash
+	if len(recoveredHash) != len(calculatedHashString) ||
+		!utils.Equals(recoveredHash, calculatedHashString) {
+		return errors.New(fmt.Sprintf("hash mismatch, recovered: %s, calculated: %s",
+			recoveredHash, calculatedHashString))
+	}
+
+	state.StateHash = recoveredHash
+	return nil
+}
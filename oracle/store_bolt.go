@@ -0,0 +1,714 @@
+package oracle
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Name of the database file inside the state folder
+var StateDbName = "state.db"
+
+// Each part of the state is stored in its own bucket, so that saving the
+// state only writes what changed since the last save. Validators and
+// commited states are keyed by index/slot and the lists by their position,
+// all of them as big endian uint64.
+var (
+	bucketMeta                 = []byte("meta")
+	bucketValidators           = []byte("validators")
+	bucketCommitedStates       = []byte("commited_states")
+	bucketCheckpoints          = []byte("checkpoints")
+	bucketSubscriptionEvents   = []byte("subscription_events")
+	bucketUnsubscriptionEvents = []byte("unsubscription_events")
+	bucketEtherReceivedEvents  = []byte("ether_received_events")
+	bucketDonations            = []byte("donations")
+	bucketProposedBlocks       = []byte("proposed_blocks")
+	bucketMissedBlocks         = []byte("missed_blocks")
+	bucketWrongFeeBlocks       = []byte("wrong_fee_blocks")
+
+	keyState = []byte("state")
+)
+
+var allBuckets = [][]byte{
+	bucketMeta,
+	bucketValidators,
+	bucketCommitedStates,
+	bucketCheckpoints,
+	bucketSubscriptionEvents,
+	bucketUnsubscriptionEvents,
+	bucketEtherReceivedEvents,
+	bucketDonations,
+	bucketProposedBlocks,
+	bucketMissedBlocks,
+	bucketWrongFeeBlocks,
+}
+
+// Stores the oracle state in an embedded key-value database. Every save is
+// a single transaction containing only the delta since the previous save:
+// the records of the state (events, blocks, validators and commited states)
+// whose content changed. Checkpoints are stored as the records that changed
+// since the previous checkpoint.
+type BoltStateStore struct {
+	db        *bolt.DB
+	retention SnapshotRetention
+
+	// Hash of each record as it was last written, by bucket. Nil when its
+	// unknown if the database matches the state being saved (eg nothing was
+	// loaded yet or a checkpoint was loaded), which forces a full rewrite on
+	// the next save
+	written map[string]map[uint64][32]byte
+
+	// Hash of each record at the latest checkpoint saved, to store the next
+	// one as a delta. Rebuilt from the database when nil
+	checkpointed     map[string]map[uint64][32]byte
+	checkpointedSlot uint64
+}
+
+func NewBoltStateStore(folder string) (*BoltStateStore, error) {
+	err := os.MkdirAll(folder, os.ModePerm)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create folder")
+	}
+
+	path := filepath.Join(folder, StateDbName)
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open state database "+path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range allBuckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "could not create state buckets")
+	}
+
+	return &BoltStateStore{
+		db: db,
+	}, nil
+}
+
+// Sets the policy used to remove old checkpoint snapshots
+func (s *BoltStateStore) SetRetention(retention SnapshotRetention) {
+	s.retention = retention
+}
+
+func (s *BoltStateStore) Save(state *OracleState, checkpoint bool) error {
+	head, records, err := splitState(state)
+	if err != nil {
+		return err
+	}
+	written := records.hashes()
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketMeta).Put(keyState, head); err != nil {
+			return err
+		}
+
+		for _, name := range recordBuckets {
+			if err := s.saveRecords(tx, name, records[string(name)]); err != nil {
+				return err
+			}
+		}
+
+		if checkpoint {
+			if err := s.saveCheckpoint(tx, state.LatestProcessedSlot, head, records); err != nil {
+				return err
+			}
+			if err := s.pruneCheckpoints(tx, state); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Unknown what was written, force a full rewrite next time
+		s.written = nil
+		s.checkpointed = nil
+		return errors.Wrap(err, "could not save state to database")
+	}
+	s.written = written
+	if checkpoint {
+		s.checkpointed = written
+		s.checkpointedSlot = state.LatestProcessedSlot
+	}
+
+	log.WithFields(log.Fields{
+		"LatestProcessedSlot":  state.LatestProcessedSlot,
+		"LatestProcessedBlock": state.LatestProcessedBlock,
+		"NextSlotToProcess":    state.NextSlotToProcess,
+		"TotalValidators":      len(state.Validators),
+		"Network":              state.Network,
+		"PoolAddress":          state.PoolAddress,
+		"Path":                 s.db.Path(),
+		"Hash":                 state.StateHash,
+		"Checkpoint":           checkpoint,
+	}).Info("Saved state to database")
+
+	return nil
+}
+
+// Writes only the records of the bucket whose content changed since the last
+// save, and removes the ones that are no longer in the state. If its unknown
+// what is stored, the bucket is fully rewritten
+func (s *BoltStateStore) saveRecords(tx *bolt.Tx, name []byte, records map[uint64][]byte) error {
+	bucket := tx.Bucket(name)
+
+	written := s.written[string(name)]
+	if s.written == nil {
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+		var err error
+		bucket, err = tx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	for key, value := range records {
+		if prev, found := written[key]; found && prev == sha256.Sum256(value) {
+			continue
+		}
+		if err := bucket.Put(uint64Key(key), value); err != nil {
+			return err
+		}
+	}
+
+	for key := range written {
+		if _, found := records[key]; !found {
+			if err := bucket.Delete(uint64Key(key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stores the checkpoint as the records that changed since the previous one. If
+// newer checkpoints exist (eg an older checkpoint was loaded and processed
+// again), the next one is stored in full first, since its delta was taken
+// against the checkpoint being replaced
+func (s *BoltStateStore) saveCheckpoint(tx *bolt.Tx, slot uint64, head []byte, records stateRecords) error {
+	bucket := tx.Bucket(bucketCheckpoints)
+
+	if next, _ := bucket.Cursor().Seek(uint64Key(slot + 1)); next != nil {
+		nextSlot := binary.BigEndian.Uint64(next)
+		nextHead, nextRecords, _, err := readCheckpoint(tx, nextSlot)
+		if err != nil {
+			return err
+		}
+		full := &checkpointDelta{Full: true, Head: nextHead, Changed: nextRecords.raw()}
+		if err := putJson(bucket, uint64Key(nextSlot), full); err != nil {
+			return err
+		}
+	}
+
+	// Hashes of the records at the previous checkpoint, cached from the last save
+	previous := s.checkpointed
+	cursor := bucket.Cursor()
+	prevKey, _ := cursor.Seek(uint64Key(slot))
+	if prevKey == nil {
+		prevKey, _ = cursor.Last()
+	} else {
+		prevKey, _ = cursor.Prev()
+	}
+	if prevKey == nil {
+		previous = nil
+	} else if previous == nil || s.checkpointedSlot != binary.BigEndian.Uint64(prevKey) {
+		_, prevRecords, _, err := readCheckpoint(tx, binary.BigEndian.Uint64(prevKey))
+		if err != nil {
+			return err
+		}
+		previous = prevRecords.hashes()
+	}
+
+	delta := &checkpointDelta{Full: prevKey == nil, Head: head}
+	if delta.Full {
+		delta.Changed = records.raw()
+	} else {
+		delta.Changed, delta.Removed = records.diff(previous)
+	}
+	return putJson(bucket, uint64Key(slot), delta)
+}
+
+// Removes the old checkpoints according to the retention policy. The delta of
+// a removed checkpoint is merged into the next one, so that it can still be
+// rebuilt
+func (s *BoltStateStore) pruneCheckpoints(tx *bolt.Tx, state *OracleState) error {
+	bucket := tx.Bucket(bucketCheckpoints)
+
+	// Newest first
+	slots := make([]uint64, 0)
+	cursor := bucket.Cursor()
+	for k, _ := cursor.Last(); k != nil; k, _ = cursor.Prev() {
+		slots = append(slots, binary.BigEndian.Uint64(k))
+	}
+
+	remove := s.retention.toRemove(slots, state.DeployedSlot, state.CheckPointSizeInSlots)
+	sort.Slice(remove, func(i, j int) bool { return remove[i] < remove[j] })
+	for _, slot := range remove {
+		log.WithFields(log.Fields{
+			"Slot":       slot,
+			"KeepLatest": s.retention.KeepLatest,
+			"ThinEvery":  s.retention.ThinEvery,
+		}).Info("Removing old state snapshot")
+
+		if next, nextValue := bucket.Cursor().Seek(uint64Key(slot + 1)); next != nil {
+			removed, err := decodeCheckpointDelta(bucket.Get(uint64Key(slot)))
+			if err != nil {
+				return err
+			}
+			delta, err := decodeCheckpointDelta(nextValue)
+			if err != nil {
+				return err
+			}
+			if err := putJson(bucket, next, removed.merge(delta)); err != nil {
+				return err
+			}
+		}
+		if err := bucket.Delete(uint64Key(slot)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltStateStore) Load() (*OracleState, bool, error) {
+	log.Info("Loading oracle state from database: ", s.db.Path())
+
+	var head []byte
+	records := make(stateRecords)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(bucketMeta).Get(keyState)
+		if value == nil {
+			return nil
+		}
+		// Values are only valid during the transaction
+		head = append([]byte{}, value...)
+
+		for _, name := range recordBuckets {
+			bucketRecords := make(map[uint64][]byte)
+			err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+				bucketRecords[binary.BigEndian.Uint64(k)] = append([]byte{}, v...)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			records[string(name)] = bucketRecords
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, errors.Wrap(err, "could not load state from database")
+	}
+	if head == nil {
+		return nil, false, nil
+	}
+
+	state, err := joinState(head, records)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "could not load state from database")
+	}
+
+	err = verifyStateHash(state)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.written = records.hashes()
+	return state, true, nil
+}
+
+func (s *BoltStateStore) LoadCheckpoint(slot uint64) (*OracleState, bool, error) {
+	log.Info("Loading oracle state from database checkpoint: ", slot)
+
+	var head []byte
+	var records stateRecords
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		head, records, found, err = readCheckpoint(tx, slot)
+		return err
+	})
+	if err != nil {
+		return nil, false, errors.Wrap(err, "could not read checkpoint from database")
+	}
+	if !found {
+		return nil, false, nil
+	}
+
+	state, err := joinState(head, records)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "could not decode checkpoint")
+	}
+	err = verifyStateHash(state)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The database no longer matches the state that will be saved next
+	s.written = nil
+	return state, true, nil
+}
+
+// Rebuilds the records of the state at the given checkpoint, applying the
+// deltas of all the checkpoints up to it
+func readCheckpoint(tx *bolt.Tx, slot uint64) ([]byte, stateRecords, bool, error) {
+	var head []byte
+	records := make(stateRecords)
+	if tx.Bucket(bucketCheckpoints).Get(uint64Key(slot)) == nil {
+		return nil, nil, false, nil
+	}
+
+	cursor := tx.Bucket(bucketCheckpoints).Cursor()
+	for k, v := cursor.First(); k != nil && binary.BigEndian.Uint64(k) <= slot; k, v = cursor.Next() {
+		delta, err := decodeCheckpointDelta(v)
+		if err != nil {
+			return nil, nil, false, errors.Wrap(err, fmt.Sprintf("could not decode checkpoint %d", binary.BigEndian.Uint64(k)))
+		}
+		if delta.Full {
+			records = make(stateRecords)
+		}
+		records.apply(delta)
+		head = delta.Head
+	}
+	return head, records, true, nil
+}
+
+// Checkpoint as stored in the database. Only the first checkpoint, or the
+// ones stored by older versions, contain the whole state. The rest contain
+// the records that changed or were removed since the previous checkpoint.
+type checkpointDelta struct {
+	Full    bool                                  `json:"full,omitempty"`
+	Head    json.RawMessage                       `json:"head"`
+	Changed map[string]map[uint64]json.RawMessage `json:"changed,omitempty"`
+	Removed map[string][]uint64                   `json:"removed,omitempty"`
+}
+
+func decodeCheckpointDelta(value []byte) (*checkpointDelta, error) {
+	var delta checkpointDelta
+	if err := json.Unmarshal(value, &delta); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal checkpoint")
+	}
+	if delta.Head != nil {
+		return &delta, nil
+	}
+
+	// Older versions stored the full state as json
+	var state OracleState
+	if err := json.Unmarshal(value, &state); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal checkpoint state")
+	}
+	head, records, err := splitState(&state)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpointDelta{Full: true, Head: head, Changed: records.raw()}, nil
+}
+
+// Returns the delta of applying this one and then next
+func (d *checkpointDelta) merge(next *checkpointDelta) *checkpointDelta {
+	if next.Full {
+		return next
+	}
+	merged := &checkpointDelta{
+		Full:    d.Full,
+		Head:    next.Head,
+		Changed: make(map[string]map[uint64]json.RawMessage),
+		Removed: make(map[string][]uint64),
+	}
+	for _, delta := range []*checkpointDelta{d, next} {
+		for name, keys := range delta.Removed {
+			for _, key := range keys {
+				delete(merged.Changed[name], key)
+				// A full checkpoint has nothing to remove
+				if !merged.Full {
+					merged.Removed[name] = append(merged.Removed[name], key)
+				}
+			}
+		}
+		for name, changed := range delta.Changed {
+			if merged.Changed[name] == nil {
+				merged.Changed[name] = make(map[uint64]json.RawMessage)
+			}
+			for key, value := range changed {
+				merged.Changed[name][key] = value
+			}
+		}
+	}
+	return merged
+}
+
+// Each record of the state as its json serialization, by bucket and key
+type stateRecords map[string]map[uint64][]byte
+
+// Buckets that store the records of the state, one record per key
+var recordBuckets = [][]byte{
+	bucketValidators,
+	bucketCommitedStates,
+	bucketSubscriptionEvents,
+	bucketUnsubscriptionEvents,
+	bucketEtherReceivedEvents,
+	bucketDonations,
+	bucketProposedBlocks,
+	bucketMissedBlocks,
+	bucketWrongFeeBlocks,
+}
+
+func (r stateRecords) hashes() map[string]map[uint64][32]byte {
+	hashes := make(map[string]map[uint64][32]byte, len(r))
+	for name, records := range r {
+		hashes[name] = make(map[uint64][32]byte, len(records))
+		for key, value := range records {
+			hashes[name][key] = sha256.Sum256(value)
+		}
+	}
+	return hashes
+}
+
+func (r stateRecords) raw() map[string]map[uint64]json.RawMessage {
+	raw := make(map[string]map[uint64]json.RawMessage, len(r))
+	for name, records := range r {
+		raw[name] = make(map[uint64]json.RawMessage, len(records))
+		for key, value := range records {
+			raw[name][key] = value
+		}
+	}
+	return raw
+}
+
+// Returns the records that changed and the keys that were removed since the
+// records with the given hashes
+func (r stateRecords) diff(previous map[string]map[uint64][32]byte) (map[string]map[uint64]json.RawMessage, map[string][]uint64) {
+	changed := make(map[string]map[uint64]json.RawMessage)
+	removed := make(map[string][]uint64)
+	for name, records := range r {
+		for key, value := range records {
+			if prev, found := previous[name][key]; found && prev == sha256.Sum256(value) {
+				continue
+			}
+			if changed[name] == nil {
+				changed[name] = make(map[uint64]json.RawMessage)
+			}
+			changed[name][key] = value
+		}
+	}
+	for name, hashes := range previous {
+		for key := range hashes {
+			if _, found := r[name][key]; !found {
+				removed[name] = append(removed[name], key)
+			}
+		}
+	}
+	return changed, removed
+}
+
+func (r stateRecords) apply(delta *checkpointDelta) {
+	for name, keys := range delta.Removed {
+		for _, key := range keys {
+			delete(r[name], key)
+		}
+	}
+	for name, changed := range delta.Changed {
+		if r[name] == nil {
+			r[name] = make(map[uint64][]byte)
+		}
+		for key, value := range changed {
+			r[name][key] = value
+		}
+	}
+}
+
+// Splits the state into its head, everything but the validators, lists and
+// commited states, and the records of each of them. Validators and commited
+// states are keyed by index/slot and the lists by their position
+func splitState(state *OracleState) ([]byte, stateRecords, error) {
+	head := *state
+	head.Validators = nil
+	head.CommitedStates = nil
+	head.SubscriptionEvents = nil
+	head.UnsubscriptionEvents = nil
+	head.EtherReceivedEvents = nil
+	head.Donations = nil
+	head.ProposedBlocks = nil
+	head.MissedBlocks = nil
+	head.WrongFeeBlocks = nil
+	headJson, err := json.Marshal(&head)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not marshal state")
+	}
+
+	records := make(stateRecords, len(recordBuckets))
+	for _, name := range recordBuckets {
+		records[string(name)] = make(map[uint64][]byte)
+	}
+	put := func(name []byte, key uint64, value interface{}) error {
+		jsonData, err := json.Marshal(value)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("could not marshal item of %s", name))
+		}
+		records[string(name)][key] = jsonData
+		return nil
+	}
+
+	for valIndex, validator := range state.Validators {
+		if err := put(bucketValidators, valIndex, validator); err != nil {
+			return nil, nil, err
+		}
+	}
+	for slot, commited := range state.CommitedStates {
+		if err := put(bucketCommitedStates, slot, commited); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	lists := []struct {
+		bucket []byte
+		len    int
+		item   func(i int) interface{}
+	}{
+		{bucketSubscriptionEvents, len(state.SubscriptionEvents), func(i int) interface{} { return state.SubscriptionEvents[i] }},
+		{bucketUnsubscriptionEvents, len(state.UnsubscriptionEvents), func(i int) interface{} { return state.UnsubscriptionEvents[i] }},
+		{bucketEtherReceivedEvents, len(state.EtherReceivedEvents), func(i int) interface{} { return state.EtherReceivedEvents[i] }},
+		{bucketDonations, len(state.Donations), func(i int) interface{} { return state.Donations[i] }},
+		{bucketProposedBlocks, len(state.ProposedBlocks), func(i int) interface{} { return &state.ProposedBlocks[i] }},
+		{bucketMissedBlocks, len(state.MissedBlocks), func(i int) interface{} { return &state.MissedBlocks[i] }},
+		{bucketWrongFeeBlocks, len(state.WrongFeeBlocks), func(i int) interface{} { return &state.WrongFeeBlocks[i] }},
+	}
+	for _, list := range lists {
+		for i := 0; i < list.len; i++ {
+			if err := put(list.bucket, uint64(i), list.item(i)); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return headJson, records, nil
+}
+
+// Builds the state back from its head and records
+func joinState(head []byte, records stateRecords) (*OracleState, error) {
+	var state OracleState
+	if err := json.Unmarshal(head, &state); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal state")
+	}
+
+	state.Validators = make(map[uint64]*ValidatorInfo)
+	for valIndex, value := range records[string(bucketValidators)] {
+		var validator ValidatorInfo
+		if err := json.Unmarshal(value, &validator); err != nil {
+			return nil, errors.Wrap(err, "could not unmarshal validator")
+		}
+		state.Validators[valIndex] = &validator
+	}
+
+	state.CommitedStates = make(map[uint64]*OnchainState)
+	for slot, value := range records[string(bucketCommitedStates)] {
+		var commited OnchainState
+		if err := json.Unmarshal(value, &commited); err != nil {
+			return nil, errors.Wrap(err, "could not unmarshal commited state")
+		}
+		state.CommitedStates[slot] = &commited
+	}
+
+	state.SubscriptionEvents = make([]*contract.ContractSubscribeValidator, 0)
+	state.UnsubscriptionEvents = make([]*contract.ContractUnsubscribeValidator, 0)
+	state.EtherReceivedEvents = make([]*contract.ContractEtherReceived, 0)
+	state.Donations = make([]*contract.ContractEtherReceived, 0)
+	state.ProposedBlocks = make([]SummarizedBlock, 0)
+	state.MissedBlocks = make([]SummarizedBlock, 0)
+	state.WrongFeeBlocks = make([]SummarizedBlock, 0)
+
+	lists := []struct {
+		bucket []byte
+		decode func(v []byte) error
+	}{
+		{bucketSubscriptionEvents, func(v []byte) error {
+			var event contract.ContractSubscribeValidator
+			state.SubscriptionEvents = append(state.SubscriptionEvents, &event)
+			return json.Unmarshal(v, &event)
+		}},
+		{bucketUnsubscriptionEvents, func(v []byte) error {
+			var event contract.ContractUnsubscribeValidator
+			state.UnsubscriptionEvents = append(state.UnsubscriptionEvents, &event)
+			return json.Unmarshal(v, &event)
+		}},
+		{bucketEtherReceivedEvents, func(v []byte) error {
+			var event contract.ContractEtherReceived
+			state.EtherReceivedEvents = append(state.EtherReceivedEvents, &event)
+			return json.Unmarshal(v, &event)
+		}},
+		{bucketDonations, func(v []byte) error {
+			var event contract.ContractEtherReceived
+			state.Donations = append(state.Donations, &event)
+			return json.Unmarshal(v, &event)
+		}},
+		{bucketProposedBlocks, func(v []byte) error {
+			var block SummarizedBlock
+			err := json.Unmarshal(v, &block)
+			state.ProposedBlocks = append(state.ProposedBlocks, block)
+			return err
+		}},
+		{bucketMissedBlocks, func(v []byte) error {
+			var block SummarizedBlock
+			err := json.Unmarshal(v, &block)
+			state.MissedBlocks = append(state.MissedBlocks, block)
+			return err
+		}},
+		{bucketWrongFeeBlocks, func(v []byte) error {
+			var block SummarizedBlock
+			err := json.Unmarshal(v, &block)
+			state.WrongFeeBlocks = append(state.WrongFeeBlocks, block)
+			return err
+		}},
+	}
+	for _, list := range lists {
+		items := records[string(list.bucket)]
+		// Keyed by position, so they are decoded in order
+		for i := uint64(0); i < uint64(len(items)); i++ {
+			value, found := items[i]
+			if !found {
+				return nil, errors.New(fmt.Sprintf("missing item %d of %s", i, list.bucket))
+			}
+			if err := list.decode(value); err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("could not unmarshal item from %s", list.bucket))
+			}
+		}
+	}
+	return &state, nil
+}
+
+func (s *BoltStateStore) Close() error {
+	return s.db.Close()
+}
+
+func putJson(bucket *bolt.Bucket, key []byte, value interface{}) error {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal value")
+	}
+	return bucket.Put(key, jsonData)
+}
+
+func uint64Key(value uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, value)
+	return key
+}
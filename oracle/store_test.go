@@ -0,0 +1,471 @@
+package oracle
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newStoreTestOracle() *Oracle {
+	config := &Config{
+		Network:                  "mainnet",
+		PoolAddress:              "0x0000000000000000000000000000000000000000",
+		DeployedSlot:             uint64(50000),
+		PoolFeesPercentOver10000: 0,
+		PoolFeesAddress:          "0x1000000000000000000000000000000000000000",
+		CheckPointSizeInSlots:    100,
+		CollateralInWei:          big.NewInt(1000),
+	}
+	oracle := NewOracle(config)
+
+	oracle.addSubscription(uint64(3), "0x1000000000000000000000000000000000000000", "0x1000000000000000000000000000000000000000")
+	oracle.addSubscription(uint64(6434), "0x2000000000000000000000000000000000000000", "0x2000000000000000000000000000000000000000")
+	oracle.increaseAllPendingRewards(big.NewInt(5000))
+	oracle.FreezeCheckpoint()
+
+	oracle.state.SubscriptionEvents = []*contract.ContractSubscribeValidator{
+		{
+			ValidatorID:            3,
+			SubscriptionCollateral: big.NewInt(1000),
+			Raw: types.Log{
+				TxHash:      [32]byte{0x1},
+				Topics:      []common.Hash{{0x2}},
+				Data:        []byte{0x3},
+				BlockNumber: 124,
+				BlockHash:   [32]byte{0x4},
+				Index:       1,
+			},
+			Sender: common.Address{148, 39, 163, 9, 145, 23, 15, 145, 125, 123, 131, 222, 246, 228, 77, 38, 87, 120, 113, 237},
+		},
+	}
+	oracle.state.ProposedBlocks = []SummarizedBlock{
+		{Slot: 50001, Block: 100, ValidatorIndex: 3, Reward: big.NewInt(5000), RewardType: MevBlock, BlockType: OkPoolProposal},
+	}
+	oracle.state.LatestProcessedSlot = 50001
+	oracle.state.NextSlotToProcess = 50002
+	return oracle
+}
+
+func requireSameState(t *testing.T, expected *OracleState, actual *OracleState) {
+	json1, err := json.MarshalIndent(expected, "", " ")
+	require.NoError(t, err)
+	json2, err := json.MarshalIndent(actual, "", " ")
+	require.NoError(t, err)
+	require.Equal(t, string(json1), string(json2))
+}
+
+func Test_BoltStateStore_SaveLoad(t *testing.T) {
+	folder := t.TempDir()
+	store, err := NewBoltStateStore(folder)
+	require.NoError(t, err)
+
+	oracle := newStoreTestOracle()
+	oracle.SetStateStore(store)
+
+	// Nothing stored yet
+	found, err := oracle.LoadState()
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, oracle.SaveState(false))
+
+	// Apply some deltas and save again: new blocks, a changed validator, a removed one
+	oracle.state.ProposedBlocks = append(oracle.state.ProposedBlocks,
+		SummarizedBlock{Slot: 50010, Block: 110, ValidatorIndex: 6434, Reward: big.NewInt(7000), RewardType: VanilaBlock, BlockType: OkPoolProposal})
+	oracle.state.MissedBlocks = append(oracle.state.MissedBlocks,
+		SummarizedBlock{Slot: 50011, ValidatorIndex: 3, Reward: big.NewInt(0), BlockType: MissedProposal})
+	oracle.increaseAllPendingRewards(big.NewInt(7000))
+	oracle.addSubscription(uint64(9000), "0x3000000000000000000000000000000000000000", "0x3000000000000000000000000000000000000000")
+	delete(oracle.state.Validators, 6434)
+	oracle.state.LatestProcessedSlot = 50011
+	oracle.state.NextSlotToProcess = 50012
+	require.NoError(t, oracle.SaveState(false))
+	require.NoError(t, store.Close())
+
+	// Reopen and load it in a new oracle
+	store, err = NewBoltStateStore(folder)
+	require.NoError(t, err)
+	defer store.Close()
+
+	newOracle := NewOracle(oracle.cfg)
+	newOracle.SetStateStore(store)
+	found, err = newOracle.LoadState()
+	require.NoError(t, err)
+	require.True(t, found)
+	requireSameState(t, oracle.state, newOracle.state)
+	require.Equal(t, 2, len(newOracle.state.ProposedBlocks))
+	require.Equal(t, 2, len(newOracle.state.Validators))
+}
+
+func Test_BoltStateStore_Checkpoint(t *testing.T) {
+	store, err := NewBoltStateStore(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	oracle := newStoreTestOracle()
+	oracle.cfg.CheckPointSizeInSlots = 10
+	oracle.state.CheckPointSizeInSlots = 10
+	oracle.SetStateStore(store)
+	require.NoError(t, oracle.SaveState(true))
+
+	checkpointJson, err := json.Marshal(oracle.state)
+	require.NoError(t, err)
+
+	// Advance and save more data, removing some of the events
+	oracle.state.LatestProcessedSlot = 50021
+	oracle.state.SubscriptionEvents = oracle.state.SubscriptionEvents[:0]
+	oracle.increaseAllPendingRewards(big.NewInt(100))
+	require.NoError(t, oracle.SaveState(false))
+
+	// No checkpoint at 50021, 50011 but there is one at 50001
+	found, err := oracle.LoadGivenState(50021)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(50001), oracle.state.LatestProcessedSlot)
+	require.Equal(t, 1, len(oracle.state.SubscriptionEvents))
+
+	var expected OracleState
+	require.NoError(t, json.Unmarshal(checkpointJson, &expected))
+	requireSameState(t, &expected, oracle.state)
+
+	// Not found beyond the attempts
+	found, err = oracle.LoadGivenState(50031)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// Saving after loading the checkpoint rewrites the stored state
+	require.NoError(t, oracle.SaveState(false))
+	loaded, found, err := store.Load()
+	require.NoError(t, err)
+	require.True(t, found)
+	requireSameState(t, oracle.state, loaded)
+}
+
+func Test_JsonStateStore_SaveLoad(t *testing.T) {
+	store := NewJsonStateStore(t.TempDir())
+
+	oracle := newStoreTestOracle()
+	oracle.SetStateStore(store)
+	require.NoError(t, oracle.SaveState(true))
+
+	newOracle := NewOracle(oracle.cfg)
+	newOracle.SetStateStore(store)
+	found, err := newOracle.LoadState()
+	require.NoError(t, err)
+	require.True(t, found)
+	requireSameState(t, oracle.state, newOracle.state)
+
+	found, err = newOracle.LoadGivenState(50001)
+	require.NoError(t, err)
+	require.True(t, found)
+	requireSameState(t, oracle.state, newOracle.state)
+}
+
+func Test_NewStateStore(t *testing.T) {
+	_, err := NewStateStore("unknown", t.TempDir(), SnapshotRetention{})
+	require.Error(t, err)
+
+	store, err := NewStateStore(JsonBackend, t.TempDir(), SnapshotRetention{})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	store, err = NewStateStore(BoltBackend, t.TempDir(), SnapshotRetention{KeepLatest: 2})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+}
+
+func Test_SnapshotRetention(t *testing.T) {
+	// Checkpoints every 10 slots since slot 1000, newest first
+	slots := []uint64{1100, 1090, 1080, 1070, 1060, 1050, 1040, 1030, 1020, 1010, 1000}
+
+	// Keep all
+	require.Equal(t, []uint64{}, SnapshotRetention{}.toRemove(slots, 1000, 10))
+
+	// Keep latest 3, remove the rest
+	require.Equal(t, []uint64{1070, 1060, 1050, 1040, 1030, 1020, 1010, 1000},
+		SnapshotRetention{KeepLatest: 3}.toRemove(slots, 1000, 10))
+
+	// Keep latest 3, and older ones every 4 checkpoints (1000, 1040, 1080)
+	require.Equal(t, []uint64{1070, 1060, 1050, 1030, 1020, 1010},
+		SnapshotRetention{KeepLatest: 3, ThinEvery: 4}.toRemove(slots, 1000, 10))
+}
+
+func Test_JsonStateStore_Retention(t *testing.T) {
+	folder := t.TempDir()
+	store := NewJsonStateStore(folder)
+	store.SetRetention(SnapshotRetention{KeepLatest: 2, ThinEvery: 3})
+
+	oracle := newStoreTestOracle()
+	oracle.SetStateStore(store)
+
+	// Checkpoints at 50000, 50100, ... 50500
+	for i := uint64(0); i <= 5; i++ {
+		oracle.state.LatestProcessedSlot = 50000 + i*100
+		require.NoError(t, oracle.SaveState(true))
+	}
+
+	slots, err := store.snapshotSlots()
+	require.NoError(t, err)
+	require.Equal(t, []uint64{50500, 50400, 50300, 50000}, slots)
+}
+
+func Test_BoltStateStore_Retention(t *testing.T) {
+	store, err := NewBoltStateStore(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+	store.SetRetention(SnapshotRetention{KeepLatest: 2})
+
+	oracle := newStoreTestOracle()
+	oracle.SetStateStore(store)
+	for i := uint64(0); i <= 3; i++ {
+		oracle.state.LatestProcessedSlot = 50000 + i*100
+		require.NoError(t, oracle.SaveState(true))
+	}
+
+	for slot, expected := range map[uint64]bool{50000: false, 50100: false, 50200: true, 50300: true} {
+		_, found, err := store.LoadCheckpoint(slot)
+		require.NoError(t, err)
+		require.Equal(t, expected, found, "slot %d", slot)
+	}
+}
+
+func Test_JsonStateStore_FallbackToSnapshot(t *testing.T) {
+	folder := t.TempDir()
+	store := NewJsonStateStore(folder)
+
+	oracle := newStoreTestOracle()
+	oracle.SetStateStore(store)
+	require.NoError(t, oracle.SaveState(true))
+
+	// Corrupt the latest state after the checkpoint
+	oracle.state.LatestProcessedSlot = 50050
+	require.NoError(t, oracle.SaveState(false))
+	path := filepath.Join(folder, StateJsonName)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, content[:len(content)/2], 0644))
+
+	// A corrupted newer snapshot is skipped too
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "state_50040.json"), []byte("{}"), 0644))
+
+	newOracle := NewOracle(oracle.cfg)
+	newOracle.SetStateStore(store)
+	found, err := newOracle.LoadState()
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(50001), newOracle.state.LatestProcessedSlot)
+
+	// Without any valid snapshot, the error is returned
+	require.NoError(t, os.Remove(filepath.Join(folder, "state_50001.json")))
+	_, err = newOracle.LoadState()
+	require.Error(t, err)
+}
+
+func Test_TrySaveState(t *testing.T) {
+	store := NewJsonStateStore(t.TempDir())
+	oracle := newStoreTestOracle()
+	oracle.SetStateStore(store)
+
+	// Not saved while a slot is being processed
+	oracle.processingSlot = true
+	saved, err := oracle.TrySaveState()
+	require.NoError(t, err)
+	require.False(t, saved)
+	oracle.processingSlot = false
+
+	// Not saved if the lock is taken
+	oracle.mutex.Lock()
+	saved, err = oracle.TrySaveState()
+	require.NoError(t, err)
+	require.False(t, saved)
+	oracle.mutex.Unlock()
+
+	// Not saved at a checkpoint that was not frozen yet
+	oracle.state.LatestProcessedSlot = 50100
+	saved, err = oracle.TrySaveState()
+	require.NoError(t, err)
+	require.False(t, saved)
+
+	oracle.FreezeCheckpoint()
+	saved, err = oracle.TrySaveState()
+	require.NoError(t, err)
+	require.True(t, saved)
+
+	loaded, found, err := store.Load()
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(50100), loaded.LatestProcessedSlot)
+}
+
+func Test_BoltStateStore_PrunedCommitedStates(t *testing.T) {
+	folder := t.TempDir()
+	store, err := NewBoltStateStore(folder)
+	require.NoError(t, err)
+
+	oracle := newStoreTestOracle()
+	oracle.SetStateStore(store)
+	oracle.state.LatestProcessedSlot = 50101
+	oracle.increaseAllPendingRewards(big.NewInt(100))
+	oracle.FreezeCheckpoint()
+	require.NoError(t, oracle.SaveState(true))
+
+	// The full commited state stored is replaced by the pruned one
+	require.Equal(t, 1, oracle.PruneCommitedStates(1, 0))
+	require.NoError(t, oracle.SaveState(false))
+	require.NoError(t, store.Close())
+
+	store, err = NewBoltStateStore(folder)
+	require.NoError(t, err)
+	defer store.Close()
+
+	newOracle := NewOracle(oracle.cfg)
+	newOracle.SetStateStore(store)
+	found, err := newOracle.LoadState()
+	require.NoError(t, err)
+	require.True(t, found)
+	requireSameState(t, oracle.state, newOracle.state)
+	require.True(t, newOracle.state.CommitedStates[49999].Pruned)
+	require.Nil(t, newOracle.state.CommitedStates[49999].Validators)
+	require.False(t, newOracle.state.CommitedStates[50101].Pruned)
+}
+
+func Test_BoltStateStore_InPlaceEdit(t *testing.T) {
+	folder := t.TempDir()
+	store, err := NewBoltStateStore(folder)
+	require.NoError(t, err)
+
+	oracle := newStoreTestOracle()
+	oracle.SetStateStore(store)
+	require.NoError(t, oracle.SaveState(false))
+
+	// Same length, but an item changed
+	oracle.state.ProposedBlocks[0].Reward = big.NewInt(6000)
+	require.NoError(t, oracle.SaveState(false))
+	require.NoError(t, store.Close())
+
+	store, err = NewBoltStateStore(folder)
+	require.NoError(t, err)
+	defer store.Close()
+	loaded, found, err := store.Load()
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, big.NewInt(6000), loaded.ProposedBlocks[0].Reward)
+	requireSameState(t, oracle.state, loaded)
+}
+
+func Test_BoltStateStore_CheckpointDeltas(t *testing.T) {
+	folder := t.TempDir()
+	store, err := NewBoltStateStore(folder)
+	require.NoError(t, err)
+	store.SetRetention(SnapshotRetention{KeepLatest: 2})
+
+	oracle := newStoreTestOracle()
+	oracle.SetStateStore(store)
+
+	// Checkpoints at 50000, 50100, ... 50300, each one changing a few records
+	expected := make(map[uint64][]byte)
+	for i := uint64(0); i <= 3; i++ {
+		oracle.state.LatestProcessedSlot = 50000 + i*100
+		oracle.state.ProposedBlocks = append(oracle.state.ProposedBlocks,
+			SummarizedBlock{Slot: 50000 + i*100, ValidatorIndex: 3, Reward: big.NewInt(100), BlockType: OkPoolProposal})
+		oracle.state.Validators[3].PendingRewardsWei = big.NewInt(int64(i))
+		if i == 2 {
+			delete(oracle.state.Validators, 6434)
+		}
+		require.NoError(t, oracle.SaveState(true))
+		jsonData, err := json.Marshal(oracle.state)
+		require.NoError(t, err)
+		expected[oracle.state.LatestProcessedSlot] = jsonData
+	}
+
+	// Only the oldest one kept is stored in full, the rest contain the changes
+	var first, last *checkpointDelta
+	err = store.db.View(func(tx *bolt.Tx) error {
+		first, err = decodeCheckpointDelta(tx.Bucket(bucketCheckpoints).Get(uint64Key(50200)))
+		require.NoError(t, err)
+		last, err = decodeCheckpointDelta(tx.Bucket(bucketCheckpoints).Get(uint64Key(50300)))
+		require.NoError(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+	require.True(t, first.Full)
+	require.False(t, last.Full)
+	require.Equal(t, 1, len(last.Changed[string(bucketProposedBlocks)]))
+	require.Equal(t, 1, len(last.Changed[string(bucketValidators)]))
+	require.Equal(t, 0, len(last.Removed))
+
+	// The removed checkpoints were merged into the kept ones
+	require.NoError(t, store.Close())
+	store, err = NewBoltStateStore(folder)
+	require.NoError(t, err)
+	defer store.Close()
+	for slot, found := range map[uint64]bool{50000: false, 50100: false, 50200: true, 50300: true} {
+		loaded, ok, err := store.LoadCheckpoint(slot)
+		require.NoError(t, err)
+		require.Equal(t, found, ok, "slot %d", slot)
+		if found {
+			var state OracleState
+			require.NoError(t, json.Unmarshal(expected[slot], &state))
+			requireSameState(t, &state, loaded)
+		}
+	}
+	loaded, _, err := store.LoadCheckpoint(50300)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(loaded.Validators))
+
+	// Processing again from an older checkpoint replaces the newer ones, keeping
+	// the ones after it valid
+	newOracle := NewOracle(oracle.cfg)
+	newOracle.SetStateStore(store)
+	found, err := newOracle.LoadGivenState(50200)
+	require.NoError(t, err)
+	require.True(t, found)
+	newOracle.state.Validators[3].PendingRewardsWei = big.NewInt(1234)
+	require.NoError(t, newOracle.SaveState(true))
+	loaded, _, err = store.LoadCheckpoint(50300)
+	require.NoError(t, err)
+	var state OracleState
+	require.NoError(t, json.Unmarshal(expected[50300], &state))
+	requireSameState(t, &state, loaded)
+	loaded, _, err = store.LoadCheckpoint(50200)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(1234), loaded.Validators[3].PendingRewardsWei)
+}
+
+func Test_BoltStateStore_LegacyCheckpoint(t *testing.T) {
+	store, err := NewBoltStateStore(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	// Older versions stored the full state
+	oracle := newStoreTestOracle()
+	oracle.SetStateStore(store)
+	require.NoError(t, oracle.SaveState(false))
+	legacy, err := json.Marshal(oracle.state)
+	require.NoError(t, err)
+	require.NoError(t, store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketCheckpoints).Put(uint64Key(50001), legacy)
+	}))
+
+	// New checkpoints are stored as deltas on top of it
+	oracle.state.LatestProcessedSlot = 50101
+	oracle.increaseAllPendingRewards(big.NewInt(100))
+	require.NoError(t, oracle.SaveState(true))
+
+	loaded, found, err := store.LoadCheckpoint(50001)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(50001), loaded.LatestProcessedSlot)
+	loaded, found, err = store.LoadCheckpoint(50101)
+	require.NoError(t, err)
+	require.True(t, found)
+	requireSameState(t, oracle.state, loaded)
+}
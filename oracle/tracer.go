@@ -0,0 +1,273 @@
+package oracle
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TraceMethodDebug  = "debug_traceTransaction"
+	TraceMethodParity = "trace_block"
+)
+
+// Json rpc error code returned when a method is not supported or not enabled
+const rpcMethodNotFound = -32601
+
+// Execution client calls needed by the mev tracer, implemented by rpc.Client
+type TraceBackend interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+}
+
+// Call of a transaction with its internal calls, as returned by the callTracer
+// of debug_traceTransaction. Self destructs are calls of type SELFDESTRUCT that
+// send the balance of the contract to the beneficiary.
+type CallFrame struct {
+	Type  string       `json:"type"`
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+	Value *hexutil.Big `json:"value,omitempty"`
+	Error string       `json:"error,omitempty"`
+	Calls []*CallFrame `json:"calls,omitempty"`
+}
+
+// Trace as returned by trace_block, one per call of every transaction
+type parityTrace struct {
+	Action struct {
+		CallType      string       `json:"callType"`
+		From          string       `json:"from"`
+		To            string       `json:"to"`
+		Value         *hexutil.Big `json:"value"`
+		Address       string       `json:"address"`
+		RefundAddress string       `json:"refundAddress"`
+		Balance       *hexutil.Big `json:"balance"`
+	} `json:"action"`
+	Result *struct {
+		Address string `json:"address"`
+	} `json:"result"`
+	Error               string `json:"error"`
+	TraceAddress        []int  `json:"traceAddress"`
+	TransactionPosition *int   `json:"transactionPosition"`
+	Type                string `json:"type"`
+}
+
+// Traces the internal value transfers of transactions, to detect mev rewards
+// that are forwarded to the pool by a contract instead of being sent directly.
+// Uses debug_traceTransaction if the execution client supports it, otherwise
+// trace_block. If neither is supported tracing is disabled, and the rewards
+// are detected from the last tx of the block.
+type MevTracer struct {
+	backend   TraceBackend
+	retryOpts []retry.Option
+
+	mutex   sync.Mutex
+	methods []string
+}
+
+func NewMevTracer(backend TraceBackend, retryOpts ...retry.Option) *MevTracer {
+	return &MevTracer{
+		backend:   backend,
+		retryOpts: retryOpts,
+		methods:   []string{TraceMethodDebug, TraceMethodParity},
+	}
+}
+
+// Returns true while the execution client supports any trace method
+func (t *MevTracer) Enabled() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.methods) != 0
+}
+
+// Returns the call of the transaction at the given position of the block, with
+// all its internal calls. Returns nil if the execution client doesn't support
+// tracing. Safe to be called concurrently.
+func (t *MevTracer) TraceTransaction(blockNumber uint64, txHash common.Hash, txPosition int) (*CallFrame, error) {
+	for {
+		t.mutex.Lock()
+		if len(t.methods) == 0 {
+			t.mutex.Unlock()
+			return nil, nil
+		}
+		method := t.methods[0]
+		t.mutex.Unlock()
+
+		var frame *CallFrame
+		err := retry.Do(func() error {
+			var err error
+			if method == TraceMethodDebug {
+				frame, err = t.debugTrace(txHash)
+			} else {
+				frame, err = t.parityTrace(blockNumber, txPosition)
+			}
+			if err != nil && !isMethodNotFound(err) {
+				log.Warn("Failed attempt to trace tx ", txHash.String(), " with ", method, ": ", err.Error(), " Retrying...")
+			}
+			return err
+		}, append([]retry.Option{retry.RetryIf(func(err error) bool { return !isMethodNotFound(err) })}, t.retryOpts...)...)
+
+		if err == nil {
+			return frame, nil
+		}
+		if !isMethodNotFound(err) {
+			return nil, errors.Wrap(err, "could not trace tx "+txHash.String())
+		}
+
+		t.mutex.Lock()
+		if len(t.methods) != 0 && t.methods[0] == method {
+			t.methods = t.methods[1:]
+			if len(t.methods) == 0 {
+				log.Warn("Execution client does not support ", TraceMethodDebug, " nor ", TraceMethodParity,
+					", mev rewards can't be traced and are detected from the last tx of the block")
+			} else {
+				log.Info("Execution client does not support ", method, ", using ", t.methods[0])
+			}
+		}
+		t.mutex.Unlock()
+	}
+}
+
+func (t *MevTracer) debugTrace(txHash common.Hash) (*CallFrame, error) {
+	var frame *CallFrame
+	err := t.backend.CallContext(context.Background(), &frame, TraceMethodDebug, txHash,
+		map[string]interface{}{"tracer": "callTracer"})
+	if err != nil {
+		return nil, err
+	}
+	if frame == nil {
+		return nil, errors.New("empty trace for tx " + txHash.String())
+	}
+	return frame, nil
+}
+
+func (t *MevTracer) parityTrace(blockNumber uint64, txPosition int) (*CallFrame, error) {
+	var traces []*parityTrace
+	err := t.backend.CallContext(context.Background(), &traces, TraceMethodParity,
+		hexutil.EncodeUint64(blockNumber))
+	if err != nil {
+		return nil, err
+	}
+	return callFrameFromParityTraces(traces, txPosition)
+}
+
+func isMethodNotFound(err error) bool {
+	var rpcErr rpc.Error
+	return errors.As(err, &rpcErr) && rpcErr.ErrorCode() == rpcMethodNotFound
+}
+
+// Builds the call of the transaction at the given position from the flat list
+// of traces of the block, where each trace has the path to its parent call
+func callFrameFromParityTraces(traces []*parityTrace, txPosition int) (*CallFrame, error) {
+	frames := make(map[string]*CallFrame)
+	var root *CallFrame
+
+	for _, trace := range traces {
+		if trace.TransactionPosition == nil || *trace.TransactionPosition != txPosition {
+			continue
+		}
+
+		frame := &CallFrame{
+			Type:  strings.ToUpper(trace.Action.CallType),
+			From:  trace.Action.From,
+			To:    trace.Action.To,
+			Value: trace.Action.Value,
+			Error: trace.Error,
+		}
+		switch trace.Type {
+		case "suicide":
+			frame.Type = "SELFDESTRUCT"
+			frame.From = trace.Action.Address
+			frame.To = trace.Action.RefundAddress
+			frame.Value = trace.Action.Balance
+		case "create":
+			frame.Type = "CREATE"
+			if trace.Result != nil {
+				frame.To = trace.Result.Address
+			}
+		}
+
+		path := fmt.Sprint(trace.TraceAddress)
+		frames[path] = frame
+		if len(trace.TraceAddress) == 0 {
+			root = frame
+			continue
+		}
+
+		// Traces are sorted so that calls come after the call that made them
+		parent, found := frames[fmt.Sprint(trace.TraceAddress[:len(trace.TraceAddress)-1])]
+		if !found {
+			return nil, errors.New(fmt.Sprintf("trace %s of tx %d has no parent", path, txPosition))
+		}
+		parent.Calls = append(parent.Calls, frame)
+	}
+
+	if root == nil {
+		return nil, errors.New(fmt.Sprintf("no traces found for tx %d", txPosition))
+	}
+	return root, nil
+}
+
+func (c *CallFrame) value() *big.Int {
+	if c.Value == nil {
+		return big.NewInt(0)
+	}
+	return c.Value.ToInt()
+}
+
+// Follows the value sent in the call through the internal calls that forward
+// it, returning the call that delivered it to its final recipient. If a
+// contract forwards its balance to several addresses, the largest transfer is
+// followed. Reverted calls move no value, so nil is returned if the call was
+// reverted.
+func (c *CallFrame) valueDestination() *CallFrame {
+	if c.Error != "" {
+		return nil
+	}
+
+	current := c
+	for {
+		var next *CallFrame
+		for _, call := range current.transfers() {
+			if call.value().Sign() <= 0 || !strings.EqualFold(call.From, current.To) {
+				continue
+			}
+			if next == nil || call.value().Cmp(next.value()) > 0 {
+				next = call
+			}
+		}
+		if next == nil {
+			return current
+		}
+		current = next
+	}
+}
+
+// Returns the internal calls that can move value out of the call. Delegate
+// calls and call codes run other code with the balance of the caller, so they
+// move nothing themselves, but the calls they make are sent by the caller.
+// Static calls can't move value. Reverted calls are skipped.
+func (c *CallFrame) transfers() []*CallFrame {
+	transfers := make([]*CallFrame, 0)
+	for _, call := range c.Calls {
+		if call.Error != "" {
+			continue
+		}
+		switch call.Type {
+		case "DELEGATECALL", "CALLCODE":
+			transfers = append(transfers, call.transfers()...)
+		case "STATICCALL":
+		default:
+			transfers = append(transfers, call)
+		}
+	}
+	return transfers
+}
@@ -0,0 +1,369 @@
+package oracle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/bellatrix"
+	"github.com/attestantio/go-eth2-client/spec/capella"
+	"github.com/avast/retry-go/v4"
+	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+var tracerTestPool = "0xAdFb8D27671F14f297eE94135e266aAFf8752e35"
+var tracerTestContract = common.HexToAddress("0xc0ffee0000000000000000000000000000000001")
+var tracerTestReward = big.NewInt(0).SetUint64(177043568463114308)
+
+type mockRpcError struct {
+	code int
+}
+
+func (e *mockRpcError) Error() string  { return "the method does not exist/is not available" }
+func (e *mockRpcError) ErrorCode() int { return e.code }
+
+// Execution client serving the recorded traces of the mock folder
+type mockTraceBackend struct {
+	mutex       sync.Mutex
+	fixtures    map[string]string
+	unsupported map[string]bool
+	failing     bool
+	calls       []string
+}
+
+func (m *mockTraceBackend) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.calls = append(m.calls, method)
+	if m.unsupported[method] {
+		return &mockRpcError{code: rpcMethodNotFound}
+	}
+	if m.failing {
+		return errors.New("connection refused")
+	}
+	rawBytes, err := os.ReadFile(filepath.Join("../mock", m.fixtures[method]))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(rawBytes, result)
+}
+
+// Block whose last tx sends the reward from the fee recipient to the given address
+func newTracerTestBlock(t *testing.T, to common.Address, value *big.Int) *FullBlock {
+	key, err := crypto.HexToECDSA("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	require.NoError(t, err)
+	chainId := big.NewInt(1)
+	tx, err := types.SignTx(types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainId,
+		To:        &to,
+		Value:     value,
+		Gas:       100000,
+		GasFeeCap: big.NewInt(1),
+	}), types.LatestSignerForChainID(chainId), key)
+	require.NoError(t, err)
+	rawTx, err := tx.MarshalBinary()
+	require.NoError(t, err)
+
+	block := newReplayTestBlock(1001)
+	block.SetConsensusBlock(&spec.VersionedSignedBeaconBlock{
+		Version: spec.DataVersionCapella,
+		Capella: &capella.SignedBeaconBlock{
+			Message: &capella.BeaconBlock{
+				Slot:          1001,
+				ProposerIndex: 12,
+				Body: &capella.BeaconBlockBody{
+					ExecutionPayload: &capella.ExecutionPayload{
+						FeeRecipient: bellatrix.ExecutionAddress(crypto.PubkeyToAddress(key.PublicKey)),
+						BlockNumber:  100,
+						Transactions: []bellatrix.Transaction{rawTx},
+					},
+				},
+			},
+		},
+	})
+	return block
+}
+
+func Test_MevTracer_Fallback(t *testing.T) {
+	backend := &mockTraceBackend{
+		fixtures: map[string]string{
+			TraceMethodDebug:  "trace_debug_selfdestruct.json",
+			TraceMethodParity: "trace_parity_selfdestruct.json",
+		},
+		unsupported: map[string]bool{},
+	}
+	tracer := NewMevTracer(backend, retry.Attempts(1))
+
+	// Debug is used when supported
+	frame, err := tracer.TraceTransaction(100, common.Hash{}, 1)
+	require.NoError(t, err)
+	require.Equal(t, "CALL", frame.Type)
+	require.Equal(t, []string{TraceMethodDebug}, backend.calls)
+
+	// Otherwise trace_block, which is used from then on
+	backend.unsupported[TraceMethodDebug] = true
+	frame, err = tracer.TraceTransaction(100, common.Hash{}, 1)
+	require.NoError(t, err)
+	require.Equal(t, "SELFDESTRUCT", frame.Calls[0].Type)
+	_, err = tracer.TraceTransaction(100, common.Hash{}, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{TraceMethodDebug, TraceMethodDebug, TraceMethodParity, TraceMethodParity}, backend.calls)
+	require.True(t, tracer.Enabled())
+
+	// Other errors are not a lack of support
+	backend.failing = true
+	_, err = tracer.TraceTransaction(100, common.Hash{}, 1)
+	require.Error(t, err)
+	require.True(t, tracer.Enabled())
+
+	// Without any method, tracing is disabled
+	backend.failing = false
+	backend.unsupported[TraceMethodParity] = true
+	frame, err = tracer.TraceTransaction(100, common.Hash{}, 1)
+	require.NoError(t, err)
+	require.Nil(t, frame)
+	require.False(t, tracer.Enabled())
+}
+
+func Test_CallFrameFromParityTraces(t *testing.T) {
+	rawBytes, err := os.ReadFile("../mock/trace_parity_selfdestruct.json")
+	require.NoError(t, err)
+	var traces []*parityTrace
+	require.NoError(t, json.Unmarshal(rawBytes, &traces))
+
+	// Both formats result in the same call
+	frame, err := callFrameFromParityTraces(traces, 1)
+	require.NoError(t, err)
+	rawBytes, err = os.ReadFile("../mock/trace_debug_selfdestruct.json")
+	require.NoError(t, err)
+	var debugFrame *CallFrame
+	require.NoError(t, json.Unmarshal(rawBytes, &debugFrame))
+	require.Equal(t, debugFrame, frame)
+
+	frame, err = callFrameFromParityTraces(traces, 0)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(frame.Calls))
+
+	_, err = callFrameFromParityTraces(traces, 2)
+	require.Error(t, err)
+}
+
+func Test_MevRewardInWei_Traced(t *testing.T) {
+	backend := &mockTraceBackend{
+		fixtures: map[string]string{
+			TraceMethodDebug: "trace_debug_selfdestruct.json",
+		},
+		unsupported: map[string]bool{},
+	}
+	onchain := &Onchain{
+		PoolAddress: tracerTestPool,
+		mevTracer:   NewMevTracer(backend, retry.Attempts(1)),
+	}
+
+	// Without tracing the contract looks like the recipient
+	block := newTracerTestBlock(t, tracerTestContract, tracerTestReward)
+	reward, isMev, recipient := block.MevRewardInWei()
+	require.True(t, isMev)
+	require.Equal(t, tracerTestReward, reward)
+	require.Equal(t, "0xc0ffee0000000000000000000000000000000001", recipient)
+
+	// The contract self destructs sending the reward to the pool, which
+	// doesn't trigger the EtherReceived event
+	onchain.traceMevReward(block)
+	require.NotNil(t, block.LastTxTrace)
+	reward, isMev, recipient = block.MevRewardInWei()
+	require.True(t, isMev)
+	require.Equal(t, tracerTestReward, reward)
+	require.Equal(t, "0xadfb8d27671f14f297ee94135e266aaff8752e35", recipient)
+	require.True(t, block.isAddressRewarded(tracerTestPool))
+	require.Equal(t, 0, len(block.GetDonations(tracerTestPool)))
+
+	// A contract forwarding the reward with a call triggers the event, while
+	// reverted calls don't move any value
+	backend.fixtures[TraceMethodDebug] = "trace_debug_forwarded.json"
+	block = newTracerTestBlock(t, tracerTestContract, tracerTestReward)
+	block.Events.EtherReceived = append(block.Events.EtherReceived,
+		&contract.ContractEtherReceived{DonationAmount: tracerTestReward},
+		&contract.ContractEtherReceived{DonationAmount: big.NewInt(5)})
+	onchain.traceMevReward(block)
+	reward, isMev, recipient = block.MevRewardInWei()
+	require.True(t, isMev)
+	require.Equal(t, tracerTestReward, reward)
+	require.Equal(t, "0xadfb8d27671f14f297ee94135e266aaff8752e35", recipient)
+	donations := block.GetDonations(tracerTestPool)
+	require.Equal(t, 1, len(donations))
+	require.Equal(t, big.NewInt(5), donations[0].DonationAmount)
+
+	// A proxy forwarding the reward with a delegate call sends it from its own
+	// address, the implementation never holds it
+	backend.fixtures[TraceMethodDebug] = "trace_debug_delegatecall.json"
+	block = newTracerTestBlock(t, tracerTestContract, tracerTestReward)
+	onchain.traceMevReward(block)
+	require.Equal(t, "DELEGATECALL", block.LastTxTrace.Calls[0].Type)
+	reward, isMev, recipient = block.MevRewardInWei()
+	require.True(t, isMev)
+	require.Equal(t, tracerTestReward, reward)
+	require.Equal(t, "0xadfb8d27671f14f297ee94135e266aaff8752e35", recipient)
+
+	// Traces are kept when the block is recorded
+	block = newTracerTestBlock(t, tracerTestContract, tracerTestReward)
+	backend.fixtures[TraceMethodDebug] = "trace_debug_selfdestruct.json"
+	onchain.traceMevReward(block)
+	jsonData, err := json.Marshal(block)
+	require.NoError(t, err)
+	var recorded map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(jsonData, &recorded))
+	var recoveredTrace *CallFrame
+	require.NoError(t, json.Unmarshal(recorded["last_tx_trace"], &recoveredTrace))
+	require.Equal(t, block.LastTxTrace, recoveredTrace)
+}
+
+func Test_TracedMevRewardsRule(t *testing.T) {
+	newTracedOracle := func(forkSlot uint64) *Oracle {
+		oracle := NewOracle(newReplayTestConfig())
+		oracle.SetForks([]*Fork{Fork1, {
+			Name:            "test",
+			ActivationSlots: map[string]uint64{"mainnet": forkSlot},
+			Rules:           []ForkRule{TracedMevRewards},
+		}})
+		oracle.state.NextSlotToProcess = 1001
+		oracle.state.LatestProcessedSlot = 1000
+		return oracle
+	}
+	onchain := &Onchain{
+		PoolAddress: tracerTestPool,
+		mevTracer: NewMevTracer(&mockTraceBackend{
+			fixtures:    map[string]string{TraceMethodDebug: "trace_debug_selfdestruct.json"},
+			unsupported: map[string]bool{},
+		}, retry.Attempts(1)),
+	}
+
+	// Before the rule traces are ignored, the contract is the recipient
+	block := newTracerTestBlock(t, tracerTestContract, tracerTestReward)
+	onchain.traceMevReward(block)
+	require.NotNil(t, block.LastTxTrace)
+	_, err := newTracedOracle(1002).AdvanceStateToNextSlot(block)
+	require.NoError(t, err)
+	require.Nil(t, block.LastTxTrace)
+
+	// Once active, untraced rewards are detected from the last tx
+	block = newTracerTestBlock(t, tracerTestContract, tracerTestReward)
+	_, err = newTracedOracle(1001).AdvanceStateToNextSlot(block)
+	require.NoError(t, err)
+	_, _, recipient := block.MevRewardInWei()
+	require.Equal(t, "0xc0ffee0000000000000000000000000000000001", recipient)
+
+	block = newTracerTestBlock(t, tracerTestContract, tracerTestReward)
+	onchain.traceMevReward(block)
+	_, err = newTracedOracle(1001).AdvanceStateToNextSlot(block)
+	require.NoError(t, err)
+	require.NotNil(t, block.LastTxTrace)
+
+	// Rewards sent directly to the pool don't need it
+	block = newTracerTestBlock(t, common.HexToAddress(tracerTestPool), tracerTestReward)
+	block.Events.EtherReceived = append(block.Events.EtherReceived, &contract.ContractEtherReceived{
+		DonationAmount: tracerTestReward,
+		Raw:            types.Log{Address: common.HexToAddress(tracerTestPool)},
+	})
+	_, err = newTracedOracle(1001).AdvanceStateToNextSlot(block)
+	require.NoError(t, err)
+}
+
+func Test_TraceMevReward_FailureFallsBack(t *testing.T) {
+	onchain := &Onchain{
+		PoolAddress: tracerTestPool,
+		mevTracer: NewMevTracer(&mockTraceBackend{
+			fixtures:    map[string]string{},
+			unsupported: map[string]bool{},
+			failing:     true,
+		}, retry.Attempts(1)),
+	}
+
+	// The block is left untraced, the reward goes to the contract as before tracing
+	block := newTracerTestBlock(t, tracerTestContract, tracerTestReward)
+	onchain.traceMevReward(block)
+	require.Nil(t, block.LastTxTrace)
+	_, isMev, recipient := block.MevRewardInWei()
+	require.True(t, isMev)
+	require.Equal(t, "0xc0ffee0000000000000000000000000000000001", recipient)
+}
+
+// Execution client that serves the balance of the pool, which changes at the given block
+func newTracerTestExecutionClient(t *testing.T, changedAtBlock uint64) *ethclient.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var request struct {
+			Id     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params []string        `json:"params"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&request))
+		require.Equal(t, "eth_getBalance", request.Method)
+		blockNumber, err := hexutil.DecodeUint64(request.Params[1])
+		require.NoError(t, err)
+		balance := "0x1"
+		if blockNumber >= changedAtBlock {
+			balance = "0x2"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"%s"}`, request.Id, balance)
+	}))
+	t.Cleanup(server.Close)
+	client, err := ethclient.Dial(server.URL)
+	require.NoError(t, err)
+	return client
+}
+
+func Test_IsMevTraceCandidate(t *testing.T) {
+	onchain := &Onchain{
+		PoolAddress: tracerTestPool,
+		NumRetries:  1,
+		mevTracer: NewMevTracer(&mockTraceBackend{
+			fixtures:    map[string]string{TraceMethodDebug: "trace_debug_selfdestruct.json"},
+			unsupported: map[string]bool{},
+		}, retry.Attempts(1)),
+	}
+	oracle := NewOracle(newReplayTestConfig())
+
+	// Not tracked and the pool balance didn't change in the block, can't reward the pool
+	onchain.ExecutionClient = newTracerTestExecutionClient(t, 101)
+	block := newTracerTestBlock(t, tracerTestContract, tracerTestReward)
+	isCandidate, err := onchain.isMevTraceCandidate(block, oracle)
+	require.NoError(t, err)
+	require.False(t, isCandidate)
+	require.NoError(t, onchain.CompleteFullBlock(block, oracle, false))
+	require.Nil(t, block.LastTxTrace)
+
+	// The pool balance changed, eg a contract self destructed into it
+	onchain.ExecutionClient = newTracerTestExecutionClient(t, 100)
+	isCandidate, err = onchain.isMevTraceCandidate(block, oracle)
+	require.NoError(t, err)
+	require.True(t, isCandidate)
+
+	// Tracked proposers are traced without checking the balance
+	onchain.ExecutionClient = nil
+	oracle.addSubscription(12, "0x2000000000000000000000000000000000000000", "0x0c")
+	isCandidate, err = onchain.isMevTraceCandidate(block, oracle)
+	require.NoError(t, err)
+	require.True(t, isCandidate)
+
+	// Rewards sent directly to the pool are not traced
+	block = newTracerTestBlock(t, common.HexToAddress(tracerTestPool), tracerTestReward)
+	isCandidate, err = onchain.isMevTraceCandidate(block, oracle)
+	require.NoError(t, err)
+	require.False(t, isCandidate)
+}
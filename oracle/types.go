@@ -67,8 +67,16 @@ type WithdrawalType uint8
 const (
 	BlsWithdrawal  WithdrawalType = 0
 	Eth1Withdrawal WithdrawalType = 1
+	// Compounding credentials (EIP-7251) with an eth1 address, same as Eth1Withdrawal
+	CompoundingWithdrawal WithdrawalType = 2
 )
 
+// Returns true if the withdrawal credentials have an eth1 address, that can
+// subscribe, unsubscribe and claim rewards
+func (w WithdrawalType) HasEth1Address() bool {
+	return w == Eth1Withdrawal || w == CompoundingWithdrawal
+}
+
 // Type of validator subscription
 type SubscriptionType uint8
 
@@ -78,20 +86,22 @@ const (
 )
 
 type Config struct {
-	ConsensusEndpoint        string   `json:"consensus_endpoint"`
-	ExecutionEndpoint        string   `json:"execution_endpoint"`
-	Network                  string   `json:"network"`
-	PoolAddress              string   `json:"pool_address"`
-	DeployedSlot             uint64   `json:"deployed_slot"`
-	DeployedBlock            uint64   `json:"deployed_block"`
-	CheckPointSizeInSlots    uint64   `json:"checkpoint_size"`
-	PoolFeesPercentOver10000 int      `json:"pool_fees_percent"` // With 2 decimals (eg 1.5% = 150)
-	PoolFeesAddress          string   `json:"pool_fees_address"`
-	DryRun                   bool     `json:"dry_run"`
-	NumRetries               int      `json:"num_retries"`
-	CollateralInWei          *big.Int `json:"collateral_in_wei"`
-	UpdaterKeyPass           string   `json:"-"`
-	UpdaterKeyFile           string   `json:"-"`
+	ConsensusEndpoint        string                `json:"consensus_endpoint"`
+	ExecutionEndpoint        string                `json:"execution_endpoint"`
+	Network                  string                `json:"network"`
+	PoolAddress              string                `json:"pool_address"`
+	DeployedSlot             uint64                `json:"deployed_slot"`
+	DeployedBlock            uint64                `json:"deployed_block"`
+	CheckPointSizeInSlots    uint64                `json:"checkpoint_size"`
+	PoolFeesPercentOver10000 int                   `json:"pool_fees_percent"` // With 2 decimals (eg 1.5% = 150)
+	PoolFeesAddress          string                `json:"pool_fees_address"`
+	DryRun                   bool                  `json:"dry_run"`
+	NumRetries               int                   `json:"num_retries"`
+	CollateralInWei          *big.Int              `json:"collateral_in_wei"`
+	UpdaterKeyPass           string                `json:"-"`
+	UpdaterKeyFile           string                `json:"-"`
+	RewardOverrides          []*RewardOverride     `json:"reward_overrides"`
+	WhitelistedBuilders      []*WhitelistedBuilder `json:"whitelisted_builders"`
 }
 
 // All the events that the contract can emit
@@ -140,6 +150,21 @@ type FullBlock struct {
 	ValidatorsUnsubs []*v1.Validator `json:"validators_unsubs"`
 
 	ChainId uint64 `json:"chain_id"`
+
+	// execution data: trace of the last tx (optional, only when it sends an mev
+	// reward that doesn't go directly to the pool and tracing is enabled)
+	LastTxTrace *CallFrame `json:"last_tx_trace,omitempty"`
+
+	// execution data: exits and consolidations requested from the execution layer,
+	// with the validators they refer to (optional, only when the block has any)
+	WithdrawalRequests    []*WithdrawalRequest    `json:"withdrawal_requests,omitempty"`
+	ConsolidationRequests []*ConsolidationRequest `json:"consolidation_requests,omitempty"`
+
+	// Set by the oracle if the mev reward of the slot is overridden
+	RewardOverride *RewardOverride `json:"-"`
+
+	// Set by the oracle with the builders whitelisted at the slot of the block
+	WhitelistedBuilders []string `json:"-"`
 }
 
 // Represents a block with information relevant for the pool, uses Fullblock
@@ -154,6 +179,7 @@ type SummarizedBlock struct {
 	Reward            *big.Int   `json:"reward_wei"`
 	RewardType        RewardType `json:"reward_type"`
 	WithdrawalAddress string     `json:"withdrawal_address"`
+	Builder           string     `json:"builder,omitempty"`
 }
 
 // Represents all the information that is stored of a validator
@@ -166,6 +192,8 @@ type ValidatorInfo struct {
 	ValidatorIndex        uint64           `json:"validator_index"`
 	ValidatorKey          string           `json:"validator_key"`
 	SubscriptionType      SubscriptionType `json:"subscription_type"`
+	// Only known once rewards are split by effective balance
+	EffectiveBalanceGwei uint64 `json:"effective_balance_gwei,omitempty"`
 }
 
 // Represents the latest commited state onchain
@@ -175,7 +203,10 @@ type OnchainState struct {
 	MerkleRoot string                    `json:"merkle_root"`
 	Validators map[uint64]*ValidatorInfo `json:"validators"`
 	Leafs      map[string]RawLeaf        `json:"leafs"`
-	Proofs     map[string][]string       `json:"proofs"`
+	// Only stored by older versions, proofs are now generated from the leafs
+	Proofs map[string][]string `json:"proofs,omitempty"`
+	// Pruned commited states only keep their slot, root and leafs
+	Pruned bool `json:"pruned,omitempty"`
 }
 
 type OracleState struct {
@@ -215,6 +246,49 @@ type OracleState struct {
 	DeployedBlock            uint64   `json:"deployed_block"`
 	DeployedSlot             uint64   `json:"deployed_slot"`
 	CollateralInWei          *big.Int `json:"collateral_in_wei"`
+
+	// The pool parameters above are the ones currently in effect, and can be
+	// updated by the contract. Checkpoints are counted from CheckpointAnchorSlot,
+	// 0 meaning DeployedSlot. The history is empty until a parameter changes,
+	// and then contains every version, the first one being the initial values.
+	CheckpointAnchorSlot uint64        `json:"checkpoint_anchor_slot,omitempty"`
+	PoolParamsHistory    []*PoolParams `json:"pool_params_history,omitempty"`
+
+	// Fees accumulated by former pool fee recipients, by lowercase address. They
+	// are kept in their own leaf, so that they can still be claimed
+	FormerPoolFees map[string]*big.Int `json:"former_pool_fees,omitempty"`
+
+	// Mev rewards set by hand for the slots where they can't be detected. Part
+	// of the state so that all oracles can audit they use the same ones
+	RewardOverrides []*RewardOverride `json:"reward_overrides,omitempty"`
+
+	// Builders whose payments are mev rewards even if they are not the fee
+	// recipient of the block. Part of the state so that replays use the same ones
+	WhitelistedBuilders []*WhitelistedBuilder `json:"whitelisted_builders,omitempty"`
+
+	// Subscribed validators that left the pool or were consolidated into another
+	// validator, due to a request from the execution layer
+	ValidatorTransitions []*ValidatorTransition `json:"validator_transitions,omitempty"`
+
+	// Rewards of the proposals to the pool of validators with bls withdrawal
+	// credentials, by validator index. Held until the credentials change, then
+	// the penalty of the fork (see Fork2) goes to the pool and the rest is credited
+	BlsCredits map[uint64]*BlsCredit `json:"bls_credits,omitempty"`
+
+	// Latest mismatches between the rewards and the payloads the relays report
+	// as delivered. Just for monitoring, they don't affect any balance
+	RewardDiscrepancies []*RewardDiscrepancy `json:"reward_discrepancies,omitempty"`
+}
+
+// Version of the pool parameters that the contract can update, in effect from
+// FromSlot until the next version
+type PoolParams struct {
+	FromSlot                 uint64   `json:"from_slot"`
+	PoolFeesPercentOver10000 int      `json:"pool_fees_percent_over_10000"`
+	PoolFeesAddress          string   `json:"pool_fees_address"`
+	CheckPointSizeInSlots    uint64   `json:"check_point_size_in_slots"`
+	CheckpointAnchorSlot     uint64   `json:"checkpoint_anchor_slot"`
+	CollateralInWei          *big.Int `json:"collateral_in_wei"`
 }
 
 type RawLeaf struct {
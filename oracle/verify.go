@@ -0,0 +1,202 @@
+package oracle
+
+import (
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Result of verifying a checkpoint against the contract
+const (
+	// The recomputed root is the one consolidated onchain
+	CheckpointMatch = "match"
+	// The recomputed root differs from the one consolidated onchain
+	CheckpointMismatch = "mismatch"
+	// The root stored in the state can't be recomputed from its validators
+	CheckpointCorrupted = "corrupted"
+	// There is a local checkpoint, but nothing was consolidated onchain for it
+	CheckpointNotConsolidated = "not-consolidated"
+	// Consolidated onchain, but the state has no checkpoint for a processed slot
+	CheckpointMissingLocally = "missing-locally"
+	// Consolidated onchain, but beyond the slots processed by the state
+	CheckpointNotProcessed = "not-processed"
+)
+
+// Root that an oracle member submitted for a checkpoint
+type CheckpointVote struct {
+	OracleMember string
+	MerkleRoot   string
+	TxHash       string
+	Block        uint64
+	Matches      bool
+}
+
+// Local and onchain roots of a checkpoint, and who voted what
+type CheckpointVerification struct {
+	Slot             uint64
+	StoredRoot       string
+	RecomputedRoot   string
+	ConsolidatedRoot string
+	Votes            []CheckpointVote
+	Status           string
+}
+
+// Returns true if the checkpoint shows the state diverged from the contract
+func (v *CheckpointVerification) Diverges() bool {
+	return v.Status == CheckpointMismatch ||
+		v.Status == CheckpointCorrupted ||
+		v.Status == CheckpointMissingLocally
+}
+
+// Recomputes the merkle root of every checkpoint of the state from its frozen
+// validators, and compares it with the roots submitted and consolidated in the
+// contract. Returns one verification per checkpoint either in the state or
+// onchain, sorted by slot.
+func VerifyCheckpoints(
+	state *OracleState,
+	submitted []*contract.ContractSubmitReport,
+	consolidated []*contract.ContractReportConsolidated) []*CheckpointVerification {
+
+	verifications := make(map[uint64]*CheckpointVerification)
+	getVerification := func(slot uint64) *CheckpointVerification {
+		verification, found := verifications[slot]
+		if !found {
+			verification = &CheckpointVerification{
+				Slot:  slot,
+				Votes: make([]CheckpointVote, 0),
+			}
+			verifications[slot] = verification
+		}
+		return verification
+	}
+
+	for slot, checkpoint := range state.CommitedStates {
+		verification := getVerification(slot)
+		verification.StoredRoot = checkpoint.MerkleRoot
+		verification.RecomputedRoot, _ = recomputeCheckpointRoot(state, checkpoint)
+	}
+
+	// If a checkpoint was consolidated more than once, the latest one is kept
+	for _, event := range consolidated {
+		verification := getVerification(event.SlotNumber.Uint64())
+		verification.ConsolidatedRoot = hexutil.Encode(event.NewRewardsRoot[:])
+	}
+
+	for _, event := range submitted {
+		verification := getVerification(event.SlotNumber.Uint64())
+		verification.Votes = append(verification.Votes, CheckpointVote{
+			OracleMember: event.OracleMember.String(),
+			MerkleRoot:   hexutil.Encode(event.NewRewardsRoot[:]),
+			TxHash:       event.Raw.TxHash.String(),
+			Block:        event.Raw.BlockNumber,
+		})
+	}
+
+	sorted := make([]*CheckpointVerification, 0, len(verifications))
+	for _, verification := range verifications {
+		for i := range verification.Votes {
+			vote := &verification.Votes[i]
+			vote.Matches = verification.RecomputedRoot != "" && strings.EqualFold(vote.MerkleRoot, verification.RecomputedRoot)
+		}
+		verification.Status = checkpointStatus(state, verification)
+		sorted = append(sorted, verification)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Slot < sorted[j].Slot })
+	return sorted
+}
+
+// Returns the first checkpoint where the state diverged from the contract
+func FirstDivergence(verifications []*CheckpointVerification) (*CheckpointVerification, bool) {
+	for _, verification := range verifications {
+		if verification.Diverges() {
+			return verification, true
+		}
+	}
+	return nil, false
+}
+
+func checkpointStatus(state *OracleState, verification *CheckpointVerification) string {
+	if verification.StoredRoot != "" {
+		if verification.RecomputedRoot == "" || !strings.EqualFold(verification.StoredRoot, verification.RecomputedRoot) {
+			return CheckpointCorrupted
+		}
+		if verification.ConsolidatedRoot == "" {
+			return CheckpointNotConsolidated
+		}
+		if strings.EqualFold(verification.RecomputedRoot, verification.ConsolidatedRoot) {
+			return CheckpointMatch
+		}
+		return CheckpointMismatch
+	}
+	if verification.ConsolidatedRoot != "" && verification.Slot <= state.LatestProcessedSlot {
+		return CheckpointMissingLocally
+	}
+	if verification.ConsolidatedRoot != "" {
+		return CheckpointNotProcessed
+	}
+
+	// Only votes, that never reached quorum
+	if verification.Slot <= state.LatestProcessedSlot {
+		return CheckpointMissingLocally
+	}
+	return CheckpointNotProcessed
+}
+
+// Recomputes the merkle root of a checkpoint from its frozen validators. The pool
+// fees are not frozen with the validators, but they are the first leaf, and the
+// fees of former recipients are what their leaf holds beyond their validators.
+// Pruned checkpoints have no validators, so their root is recomputed from their leafs.
+// Returns false if there was not enough data to create a tree.
+func recomputeCheckpointRoot(state *OracleState, checkpoint *OnchainState) (string, bool) {
+	if checkpoint.Pruned {
+		tree, err := checkpointLeafsTree(state, checkpoint)
+		if err != nil {
+			return "", false
+		}
+		return tree.Root(), true
+	}
+
+	params := state.PoolParamsAt(checkpoint.Slot)
+	poolFeesAddress := strings.ToLower(params.PoolFeesAddress)
+	poolFees := big.NewInt(0)
+	if leaf, found := checkpoint.Leafs[poolFeesAddress]; found {
+		poolFees = leaf.AccumulatedBalanceWei
+	}
+
+	formerPoolFees := make(map[string]*big.Int)
+	for _, version := range state.PoolParamsHistory {
+		formerAddress := strings.ToLower(version.PoolFeesAddress)
+		if version.FromSlot > checkpoint.Slot || formerAddress == poolFeesAddress {
+			continue
+		}
+		leaf, found := checkpoint.Leafs[formerAddress]
+		if !found {
+			continue
+		}
+		fees := new(big.Int).Set(leaf.AccumulatedBalanceWei)
+		for _, validator := range checkpoint.Validators {
+			if strings.EqualFold(validator.WithdrawalAddress, formerAddress) {
+				fees.Sub(fees, validator.AccumulatedRewardsWei)
+			}
+		}
+		formerPoolFees[formerAddress] = fees
+	}
+
+	checkpointState := &OracleState{
+		Validators:          checkpoint.Validators,
+		PoolAccumulatedFees: poolFees,
+		PoolFeesAddress:     params.PoolFeesAddress,
+		PoolAddress:         state.PoolAddress,
+		FormerPoolFees:      formerPoolFees,
+	}
+
+	mk := NewMerklelizer()
+	_, _, tree, enoughData := mk.GenerateTreeFromState(checkpointState)
+	if !enoughData {
+		return "", false
+	}
+	return hexutil.Encode(tree.Root[:]), true
+}
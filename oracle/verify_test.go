@@ -0,0 +1,95 @@
+package oracle
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/dappnode/mev-sp-oracle/contract"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/require"
+)
+
+func newVerifyTestOracle(t *testing.T, checkpoints []uint64) *Oracle {
+	oracle := NewOracle(&Config{
+		PoolFeesPercentOver10000: 0,
+		PoolFeesAddress:          "0xfee0000000000000000000000000000000000000",
+		PoolAddress:              "0xAdFb8D27671F14f297eE94135e266aAFf8752e35",
+	})
+	oracle.state.PoolAccumulatedFees = big.NewInt(300)
+	for i, slot := range checkpoints {
+		oracle.state.Validators[1] = &ValidatorInfo{
+			ValidatorStatus:       Active,
+			ValidatorIndex:        1,
+			AccumulatedRewardsWei: big.NewInt(int64(1000 * (i + 1))),
+			PendingRewardsWei:     big.NewInt(0),
+			WithdrawalAddress:     "0x1000000000000000000000000000000000000000",
+		}
+		oracle.state.LatestProcessedSlot = slot
+		require.True(t, oracle.FreezeCheckpoint())
+	}
+	return oracle
+}
+
+func rootBytes(t *testing.T, root string) [32]byte {
+	decoded, err := hexutil.Decode(root)
+	require.NoError(t, err)
+	var rootBytes [32]byte
+	copy(rootBytes[:], decoded)
+	return rootBytes
+}
+
+func Test_VerifyCheckpoints(t *testing.T) {
+	oracle := newVerifyTestOracle(t, []uint64{100, 200, 300, 400})
+	state := oracle.state
+	state.LatestProcessedSlot = 600
+
+	wrongRoot := "0x1111111111111111111111111111111111111111111111111111111111111111"
+	member1 := common.HexToAddress("0x0100000000000000000000000000000000000000")
+	member2 := common.HexToAddress("0x0200000000000000000000000000000000000000")
+
+	submitted := []*contract.ContractSubmitReport{
+		{SlotNumber: big.NewInt(100), NewRewardsRoot: rootBytes(t, state.CommitedStates[100].MerkleRoot), OracleMember: member1},
+		{SlotNumber: big.NewInt(100), NewRewardsRoot: rootBytes(t, state.CommitedStates[100].MerkleRoot), OracleMember: member2},
+		{SlotNumber: big.NewInt(200), NewRewardsRoot: rootBytes(t, state.CommitedStates[200].MerkleRoot), OracleMember: member1},
+		{SlotNumber: big.NewInt(200), NewRewardsRoot: rootBytes(t, wrongRoot), OracleMember: member2},
+	}
+	consolidated := []*contract.ContractReportConsolidated{
+		{SlotNumber: big.NewInt(100), NewRewardsRoot: rootBytes(t, state.CommitedStates[100].MerkleRoot)},
+		{SlotNumber: big.NewInt(200), NewRewardsRoot: rootBytes(t, wrongRoot)},
+		{SlotNumber: big.NewInt(500), NewRewardsRoot: rootBytes(t, wrongRoot)},
+		{SlotNumber: big.NewInt(700), NewRewardsRoot: rootBytes(t, wrongRoot)},
+	}
+
+	// Tamper the validators frozen at slot 300, so that its root can't be recomputed
+	state.CommitedStates[300].Validators[1].AccumulatedRewardsWei = big.NewInt(1)
+
+	verifications := VerifyCheckpoints(state, submitted, consolidated)
+	require.Equal(t, 6, len(verifications))
+
+	require.Equal(t, uint64(100), verifications[0].Slot)
+	require.Equal(t, CheckpointMatch, verifications[0].Status)
+	require.Equal(t, state.CommitedStates[100].MerkleRoot, verifications[0].RecomputedRoot)
+	require.Equal(t, 2, len(verifications[0].Votes))
+	require.True(t, verifications[0].Votes[0].Matches)
+	require.True(t, verifications[0].Votes[1].Matches)
+
+	require.Equal(t, uint64(200), verifications[1].Slot)
+	require.Equal(t, CheckpointMismatch, verifications[1].Status)
+	require.Equal(t, wrongRoot, verifications[1].ConsolidatedRoot)
+	require.True(t, verifications[1].Votes[0].Matches)
+	require.False(t, verifications[1].Votes[1].Matches)
+	require.Equal(t, member2.String(), verifications[1].Votes[1].OracleMember)
+
+	require.Equal(t, CheckpointCorrupted, verifications[2].Status)
+	require.Equal(t, CheckpointNotConsolidated, verifications[3].Status)
+	require.Equal(t, CheckpointMissingLocally, verifications[4].Status)
+	require.Equal(t, CheckpointNotProcessed, verifications[5].Status)
+
+	divergence, found := FirstDivergence(verifications)
+	require.True(t, found)
+	require.Equal(t, uint64(200), divergence.Slot)
+
+	_, found = FirstDivergence(verifications[:1])
+	require.False(t, found)
+}
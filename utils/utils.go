@@ -6,12 +6,13 @@ import (
 	"encoding/hex"
 	"io/ioutil"
 	"math/big"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	v1 "github.com/attestantio/go-eth2-client/api/v1"
 	"github.com/attestantio/go-eth2-client/spec/phase0"
-	"github.com/dappnode/mev-sp-oracle/config"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -144,30 +145,37 @@ func IsEth1Type(withdrawalCred string) bool {
 	return false
 }
 
+// See: https://github.com/ethereum/consensus-specs/blob/dev/specs/electra/validator.md#compounding-withdrawal-credentials
+// Input example: 020000000000000000000000dc62f9e8c34be08501cdef4ebde0a280f576d762 (true)
+func IsCompoundingType(withdrawalCred string) bool {
+	if len(withdrawalCred) != 64 {
+		return false
+	}
+
+	// COMPOUNDING_WITHDRAWAL_PREFIX
+	if strings.HasPrefix(withdrawalCred, "020000000000000000000000") {
+		return true
+	}
+	return false
+}
+
 // See: https://github.com/ethereum/eth2.0-specs/blob/dev/specs/phase0/validator.md#withdrawal-credentials
+// Returns the execution address of both eth1 (0x01) and compounding (0x02) credentials
 // Input example: 01000000000000000000000059b0d71688da01057c08e4c1baa8faa629819c2a
 // Output example: 0x59b0d71688da01057c08e4c1baa8faa629819c2a
 func GetEth1Address(withdrawalCred string) (string, error) {
 	if len(withdrawalCred) != 64 {
 		return "", errors.New("Withdrawal credentials are not a valid length")
 	}
-	// ETH1_ADDRESS_WITHDRAWAL_PREFIX
-	if !strings.HasPrefix(withdrawalCred, "010000000000000000000000") {
+	// ETH1_ADDRESS_WITHDRAWAL_PREFIX or COMPOUNDING_WITHDRAWAL_PREFIX
+	if !IsEth1Type(withdrawalCred) && !IsCompoundingType(withdrawalCred) {
 		return "", errors.New("Withdrawal credentials prefix does not match the spec")
 	}
 	return "0x" + withdrawalCred[24:], nil
 }
 
 func GetEth1AddressByte(withdrawalCredByte []byte) (string, error) {
-	withdrawalCred := hex.EncodeToString(withdrawalCredByte)
-	if len(withdrawalCred) != 64 {
-		return "", errors.New("Withdrawal credentials are not a valid length")
-	}
-	// ETH1_ADDRESS_WITHDRAWAL_PREFIX
-	if !strings.HasPrefix(withdrawalCred, "010000000000000000000000") {
-		return "", errors.New("Withdrawal credentials prefix does not match the spec")
-	}
-	return "0x" + withdrawalCred[24:], nil
+	return GetEth1Address(hex.EncodeToString(withdrawalCredByte))
 }
 
 func Equals(a string, b string) bool {
@@ -181,21 +189,17 @@ func Equals(a string, b string) bool {
 	return false
 }
 
-func DecryptKey(cfg *config.CliConfig) (*keystore.Key, error) {
-	// Only parse it not in dry run mode
-	if !cfg.DryRun {
-		jsonBytes, err := ioutil.ReadFile(cfg.UpdaterKeyFile)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to read updater key file")
-		}
+func DecryptKey(keyFile string, keyPass string) (*keystore.Key, error) {
+	jsonBytes, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read updater key file")
+	}
 
-		account, err := keystore.DecryptKey(jsonBytes, cfg.UpdaterKeyPass)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to decrypt updater key")
-		}
-		return account, nil
+	account, err := keystore.DecryptKey(jsonBytes, keyPass)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt updater key")
 	}
-	return nil, errors.New("running in dry run mode, key is not needed")
+	return account, nil
 }
 
 // Not the most efficient way of deep coping, if performance
@@ -250,3 +254,47 @@ func IsIn(a string, list []string) bool {
 	}
 	return false
 }
+
+// Writes the data to a temporary file in the same folder, flushes it to disk
+// and then renames it to path. A crash during the write never leaves a
+// truncated file behind, either the old or the new content is kept.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmpFile, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary file")
+	}
+	tmpPath := tmpFile.Name()
+
+	// Only cleans up if something went wrong, after the rename its gone
+	defer os.Remove(tmpPath)
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return errors.Wrap(err, "could not write temporary file")
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return errors.Wrap(err, "could not sync temporary file")
+	}
+	if err := tmpFile.Close(); err != nil {
+		return errors.Wrap(err, "could not close temporary file")
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return errors.Wrap(err, "could not set file permissions")
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return errors.Wrap(err, "could not rename temporary file")
+	}
+
+	// Persist the rename itself
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "could not open folder")
+	}
+	defer dirFile.Close()
+	if err := dirFile.Sync(); err != nil {
+		return errors.Wrap(err, "could not sync folder")
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package utils
 import (
 	"fmt"
 	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/attestantio/go-eth2-client/spec/bellatrix"
@@ -160,6 +162,7 @@ func Test_WithdrawalCredentials(t *testing.T) {
 	blsKey2 := "00b9f30bfce35138f7638d68c1473d1d45693dae775166022a493f38d942deb5"
 	eth1Key1 := "010000000000000000000000dc62f9e8c34be08501cdef4ebde0a280f576d762"
 	eth1Key2 := "01000000000000000000000059b0d71688da01057c08e4c1baa8faa629819c2a"
+	compoundingKey1 := "020000000000000000000000dc62f9e8c34be08501cdef4ebde0a280f576d762"
 
 	wrongKey1 := "098765"
 
@@ -176,6 +179,13 @@ func Test_WithdrawalCredentials(t *testing.T) {
 	require.Equal(t, false, IsEth1Type(wrongKey1))
 	require.Equal(t, false, IsBlsType(wrongKey1))
 
+	require.Equal(t, true, IsCompoundingType(compoundingKey1))
+	require.Equal(t, false, IsCompoundingType(eth1Key1))
+	require.Equal(t, false, IsCompoundingType(blsKey1))
+	require.Equal(t, false, IsEth1Type(compoundingKey1))
+	require.Equal(t, false, IsBlsType(compoundingKey1))
+	require.Equal(t, false, IsCompoundingType("020000000000000000000001dc62f9e8c34be08501cdef4ebde0a280f576d762"))
+
 	_, err := GetEth1Address(blsKey1)
 	require.Error(t, err)
 
@@ -190,6 +200,18 @@ func Test_WithdrawalCredentials(t *testing.T) {
 	b1, err := GetEth1AddressByte([]byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 148, 39, 163, 9, 145, 23, 15, 145, 125, 123, 131, 222, 246, 228, 77, 38, 87, 120, 113, 237})
 	require.NoError(t, err)
 	require.Equal(t, b1, "0x9427a30991170f917d7b83def6e44d26577871ed")
+
+	// Compounding credentials have an eth1 address too
+	rec3, err := GetEth1Address(compoundingKey1)
+	require.NoError(t, err)
+	require.Equal(t, rec3, "0xdc62f9e8c34be08501cdef4ebde0a280f576d762")
+
+	b2, err := GetEth1AddressByte([]byte{2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 148, 39, 163, 9, 145, 23, 15, 145, 125, 123, 131, 222, 246, 228, 77, 38, 87, 120, 113, 237})
+	require.NoError(t, err)
+	require.Equal(t, b2, "0x9427a30991170f917d7b83def6e44d26577871ed")
+
+	_, err = GetEth1AddressByte([]byte{3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 148, 39, 163, 9, 145, 23, 15, 145, 125, 123, 131, 222, 246, 228, 77, 38, 87, 120, 113, 237})
+	require.Error(t, err)
 }
 
 func Test_AreAddressEqual(t *testing.T) {
@@ -215,3 +237,27 @@ func Test_IsIn(t *testing.T) {
 	require.Equal(t, true, IsIn("0x000A", []string{"0x000a"}))
 	require.Equal(t, false, IsIn("a", []string{"c", "d"}))
 }
+
+func Test_WriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	require.NoError(t, WriteFileAtomic(path, []byte("first"), 0644))
+	require.NoError(t, WriteFileAtomic(path, []byte("second"), 0644))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "second", string(content))
+
+	// No temporary files are left behind
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	// Fails if the folder does not exist
+	require.Error(t, WriteFileAtomic(filepath.Join(dir, "missing", "state.json"), []byte("x"), 0644))
+}